@@ -0,0 +1,65 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package v1alpha3
+
+// FindDAGCycle returns the names of the steps forming a dependency cycle, starting and ending
+// with the same step, or nil when the steps of the DAG node have no cycle.
+// Dependencies on unknown steps are ignored.
+func FindDAGCycle(steps []Step) []string {
+	indexes := make(map[string]int, len(steps))
+	for i, step := range steps {
+		indexes[step.StepName] = i
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(steps))
+	// the steps on the current path of the depth first search
+	var path []int
+
+	var visit func(i int) []string
+	visit = func(i int) []string {
+		state[i] = visiting
+		path = append(path, i)
+		for _, dep := range steps[i].DependsOn {
+			j, ok := indexes[dep]
+			if !ok {
+				continue
+			}
+			switch state[j] {
+			case visiting:
+				start := len(path) - 1
+				for path[start] != j {
+					start--
+				}
+				var cycle []string
+				for _, k := range path[start:] {
+					cycle = append(cycle, steps[k].StepName)
+				}
+				return append(cycle, dep)
+			case unvisited:
+				if cycle := visit(j); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return nil
+	}
+
+	for i := range steps {
+		if state[i] == unvisited {
+			if cycle := visit(i); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package v1alpha3
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+const (
+	// DefaultRouterName is the name of the router when the routerConfig does not set one
+	DefaultRouterName = "router"
+	// DefaultRouterServiceName is the service name of the router when the routerConfig does not set one
+	DefaultRouterServiceName = "router-service"
+	// endpointConfigKey is the key of the step config with the path of the service the router calls
+	endpointConfigKey = "endpoint"
+)
+
+// downstreamWire is a config key of a step naming the service of another step the service calls
+type downstreamWire struct {
+	key string
+	// the step names of the services the key can name
+	steps []string
+}
+
+var (
+	dlog = logf.Log.WithName("defaulting-webhook")
+
+	// ManifestServiceNames are the names of the Services in the manifests of the steps, the controller deploys
+	// the steps without serviceName under these names, so the defaulted names keep the existing services
+	ManifestServiceNames = map[string]string{
+		"TeiEmbedding":      "tei",
+		"TeiEmbeddingGaudi": "tei",
+		"Embedding":         "embedding-usvc",
+		"VectorDB":          "redis-vector-db",
+		"Retriever":         "retriever-usvc",
+		"Reranking":         "reranking-usvc",
+		"TeiReranking":      "teirerank",
+		"Tgi":               "tgi",
+		"TgiGaudi":          "tgi",
+		"TgiNvidia":         "tgi",
+		"Llm":               "llm-uservice",
+		"DocSum":            "docsum-llm-uservice",
+		"WebRetriever":      "web-retriever",
+		"Asr":               "asr",
+		"Tts":               "tts",
+		"SpeechT5":          "speecht5",
+		"SpeechT5Gaudi":     "speecht5",
+		"Whisper":           "whisper",
+		"WhisperGaudi":      "whisper",
+		"DataPrep":          "data-prep",
+		"UI":                "ui",
+	}
+
+	// defaultEndpoints are the paths the router calls on the services of the steps
+	defaultEndpoints = map[string]string{
+		"Embedding":     "/v1/embeddings",
+		"Retriever":     "/v1/retrieval",
+		"Reranking":     "/v1/reranking",
+		"TeiReranking":  "/rerank",
+		"Tgi":           "/generate",
+		"TgiGaudi":      "/generate",
+		"TgiNvidia":     "/generate",
+		"Llm":           "/v1/chat/completions",
+		"DocSum":        "/v1/chat/docsum",
+		"WebRetriever":  "/v1/web_retrieval",
+		"Asr":           "/v1/audio/transcriptions",
+		"Tts":           "/v1/audio/speech",
+		"SpeechT5":      "/v1/tts",
+		"SpeechT5Gaudi": "/v1/tts",
+		"Whisper":       "/v1/asr",
+		"WhisperGaudi":  "/v1/asr",
+		"DataPrep":      "/v1/dataprep",
+		"UI":            "/",
+	}
+
+	teiEmbeddingSteps = []string{"TeiEmbedding", "TeiEmbeddingGaudi"}
+	tgiSteps          = []string{"Tgi", "TgiGaudi", "TgiNvidia"}
+	// downstreamWires are the services the service of a step calls, the router does not call them
+	downstreamWires = map[string][]downstreamWire{
+		"Embedding":    {{key: "TEI_EMBEDDING_ENDPOINT", steps: teiEmbeddingSteps}},
+		"Retriever":    {{key: "TEI_EMBEDDING_ENDPOINT", steps: teiEmbeddingSteps}, {key: "REDIS_URL", steps: []string{"VectorDB"}}},
+		"WebRetriever": {{key: "TEI_EMBEDDING_ENDPOINT", steps: teiEmbeddingSteps}},
+		"Reranking":    {{key: "TEI_RERANKING_ENDPOINT", steps: []string{"TeiReranking"}}},
+		"Llm":          {{key: "TGI_LLM_ENDPOINT", steps: tgiSteps}},
+		"DocSum":       {{key: "TGI_LLM_ENDPOINT", steps: tgiSteps}},
+		"DataPrep":     {{key: "REDIS_URL", steps: []string{"VectorDB"}}, {key: "TEI_ENDPOINT", steps: teiEmbeddingSteps}},
+		"Asr":          {{key: "ASR_ENDPOINT", steps: []string{"Whisper", "WhisperGaudi"}}},
+		"Tts":          {{key: "TTS_ENDPOINT", steps: []string{"SpeechT5", "SpeechT5Gaudi"}}},
+	}
+	// the router serves these steps on its own routes instead of calling them in the pipeline
+	routerServedSteps = []string{"DataPrep", "UI"}
+)
+
+// +kubebuilder:webhook:verbs=create;update,path=/mutate-gmc-opea-io-v1alpha3-gmconnector,mutating=true,failurePolicy=fail,groups=gmc.opea.io,resources=gmconnectors,versions=v1alpha3,name=mgmcconnector.gmc.opea.io,sideEffects=None,admissionReviewVersions=v1
+
+var _ webhook.Defaulter = &GMConnector{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *GMConnector) Default() {
+	dlog.Info("default", "name", r.Name)
+
+	if r.Spec.RouterConfig.Name == "" {
+		r.Spec.RouterConfig.Name = DefaultRouterName
+	}
+	if r.Spec.RouterConfig.ServiceName == "" {
+		r.Spec.RouterConfig.ServiceName = DefaultRouterServiceName
+	}
+
+	nodeNames := getKeys(r.Spec.Nodes)
+	slices.Sort(nodeNames)
+	// the service names given in the spec are kept, the defaulted ones are unique in the graph
+	serviceNames := map[string]bool{}
+	for _, name := range nodeNames {
+		for _, step := range r.Spec.Nodes[name].Steps {
+			serviceNames[step.InternalService.ServiceName] = true
+		}
+	}
+	for _, name := range nodeNames {
+		defaultSteps(r.Spec.Nodes[name].Steps, name, serviceNames)
+	}
+}
+
+// hasService returns whether the controller deploys a service for the step
+func hasService(step *Step) bool {
+	return step.NodeName == "" && step.ExternalService == "" &&
+		step.StepName != SemanticCacheStep && slices.Contains(stepNames, step.StepName)
+}
+
+// defaultSteps sets the defaults of the steps of a node and wires the services of the steps
+// to the services of the other steps of the node they call
+func defaultSteps(steps []Step, nodeName string, serviceNames map[string]bool) {
+	for i := range steps {
+		step := &steps[i]
+		if step.Dependency == "" {
+			step.Dependency = Soft
+		}
+		if !hasService(step) {
+			continue
+		}
+		if step.InternalService.ServiceName == "" {
+			step.InternalService.ServiceName = defaultServiceName(step.StepName, nodeName, serviceNames)
+		}
+		if step.InternalService.Config == nil {
+			step.InternalService.Config = map[string]string{}
+		}
+		if _, ok := step.InternalService.Config[endpointConfigKey]; !ok && defaultEndpoints[step.StepName] != "" {
+			step.InternalService.Config[endpointConfigKey] = defaultEndpoints[step.StepName]
+		}
+		if slices.Contains(routerServedSteps, step.StepName) {
+			step.InternalService.IsDownstreamService = true
+		}
+	}
+
+	for i := range steps {
+		if !hasService(&steps[i]) {
+			continue
+		}
+		config := steps[i].InternalService.Config
+		for _, wire := range downstreamWires[steps[i].StepName] {
+			var downstream *Step
+			if serviceName, ok := config[wire.key]; ok {
+				downstream = findStepByService(steps, serviceName)
+			} else if downstream = nearestStep(steps, i, wire.steps); downstream != nil {
+				config[wire.key] = downstream.InternalService.ServiceName
+			}
+			if downstream != nil {
+				downstream.InternalService.IsDownstreamService = true
+			}
+		}
+	}
+}
+
+// defaultServiceName returns the service name of a step, the name of the Service in its manifest,
+// i.e. tei for TeiEmbedding, the node name is added when another step of the graph already has the name
+func defaultServiceName(stepName, nodeName string, serviceNames map[string]bool) string {
+	base := ManifestServiceNames[stepName]
+	if base == "" {
+		base = kebabCase(stepName)
+	}
+	name := base
+	if serviceNames[name] {
+		name = fmt.Sprintf("%s-%s", base, strings.ToLower(nodeName))
+	}
+	for i := 2; serviceNames[name]; i++ {
+		name = fmt.Sprintf("%s-%s-%d", base, strings.ToLower(nodeName), i)
+	}
+	serviceNames[name] = true
+	return name
+}
+
+// kebabCase splits a step name into lower case words, i.e. VectorDB is vector-db and SpeechT5Gaudi is speech-t5-gaudi
+func kebabCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, c := range runes {
+		if i > 0 && unicode.IsUpper(c) {
+			prev := runes[i-1]
+			endOfAcronym := unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || endOfAcronym {
+				b.WriteByte('-')
+			}
+		}
+		b.WriteRune(unicode.ToLower(c))
+	}
+	return b.String()
+}
+
+func findStepByService(steps []Step, serviceName string) *Step {
+	for i := range steps {
+		if hasService(&steps[i]) && steps[i].InternalService.ServiceName == serviceName {
+			return &steps[i]
+		}
+	}
+	return nil
+}
+
+// nearestStep returns the step with one of the names closest to the step at idx, the following steps first
+func nearestStep(steps []Step, idx int, names []string) *Step {
+	for distance := 1; distance < len(steps); distance++ {
+		for _, i := range []int{idx + distance, idx - distance} {
+			if i >= 0 && i < len(steps) && hasService(&steps[i]) && slices.Contains(names, steps[i].StepName) {
+				return &steps[i]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package v1alpha3
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_kebabCase(t *testing.T) {
+	tests := map[string]string{
+		"Llm":               "llm",
+		"TeiEmbedding":      "tei-embedding",
+		"TeiEmbeddingGaudi": "tei-embedding-gaudi",
+		"VectorDB":          "vector-db",
+		"SpeechT5Gaudi":     "speech-t5-gaudi",
+		"UI":                "ui",
+	}
+	for name, want := range tests {
+		if got := kebabCase(name); got != want {
+			t.Errorf("kebabCase(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestGMConnector_Default(t *testing.T) {
+	graph := &GMConnector{
+		Spec: GMConnectorSpec{
+			Nodes: map[string]Router{
+				"root": {
+					RouterType: Sequence,
+					Steps: []Step{
+						{StepName: "Embedding"},
+						{StepName: "TeiEmbedding"},
+						{StepName: "Retriever", Data: "$response"},
+						{StepName: "VectorDB"},
+						{StepName: "Reranking", Dependency: Hard},
+						{StepName: "TeiReranking"},
+						{StepName: "Llm", Executor: Executor{InternalService: GMCTarget{
+							ServiceName: "llm-uservice",
+							Config:      map[string]string{"endpoint": "/v1/chat", "TGI_LLM_ENDPOINT": "tgi-service-m"},
+						}}},
+						{StepName: "Tgi", Executor: Executor{InternalService: GMCTarget{ServiceName: "tgi-service-m"}}},
+						{StepName: "DataPrep"},
+					},
+				},
+				"node1": {
+					RouterType: Switch,
+					Steps: []Step{
+						{StepName: "Llm", Condition: "model-id==llama"},
+						{StepName: "Tgi"},
+						{StepName: "Llm", Condition: "model-id==mistral"},
+						{StepName: "Tgi"},
+						{StepName: "Embedding", Executor: Executor{ExternalService: "https://api.example.com/v1/embeddings"}},
+						{StepName: SemanticCacheStep},
+					},
+				},
+			},
+		},
+	}
+	graph.Default()
+
+	if graph.Spec.RouterConfig.Name != "router" || graph.Spec.RouterConfig.ServiceName != "router-service" {
+		t.Errorf("Default() router = %v, want router and router-service", graph.Spec.RouterConfig)
+	}
+
+	want := []GMCTarget{
+		{ServiceName: "embedding-usvc", Config: map[string]string{"endpoint": "/v1/embeddings", "TEI_EMBEDDING_ENDPOINT": "tei"}},
+		{ServiceName: "tei", Config: map[string]string{}, IsDownstreamService: true},
+		{ServiceName: "retriever-usvc", Config: map[string]string{
+			"endpoint": "/v1/retrieval", "TEI_EMBEDDING_ENDPOINT": "tei", "REDIS_URL": "redis-vector-db",
+		}},
+		{ServiceName: "redis-vector-db", Config: map[string]string{}, IsDownstreamService: true},
+		{ServiceName: "reranking-usvc", Config: map[string]string{"endpoint": "/v1/reranking", "TEI_RERANKING_ENDPOINT": "teirerank"}},
+		{ServiceName: "teirerank", Config: map[string]string{"endpoint": "/rerank"}, IsDownstreamService: true},
+		{ServiceName: "llm-uservice", Config: map[string]string{"endpoint": "/v1/chat", "TGI_LLM_ENDPOINT": "tgi-service-m"}},
+		{ServiceName: "tgi-service-m", Config: map[string]string{"endpoint": "/generate"}, IsDownstreamService: true},
+		{ServiceName: "data-prep", Config: map[string]string{
+			"endpoint": "/v1/dataprep", "REDIS_URL": "redis-vector-db", "TEI_ENDPOINT": "tei",
+		}, IsDownstreamService: true},
+	}
+	for i, step := range graph.Spec.Nodes["root"].Steps {
+		if !reflect.DeepEqual(step.InternalService, want[i]) {
+			t.Errorf("Default() root step %s = %v, want %v", step.StepName, step.InternalService, want[i])
+		}
+	}
+	if got := graph.Spec.Nodes["root"].Steps[4].Dependency; got != Hard {
+		t.Errorf("Default() dependency = %v, want the Hard dependency of the spec", got)
+	}
+
+	// each Llm step calls the Tgi step next to it, the names already in the graph get the node name
+	want = []GMCTarget{
+		{ServiceName: "llm-uservice-node1", Config: map[string]string{"endpoint": "/v1/chat/completions", "TGI_LLM_ENDPOINT": "tgi"}},
+		{ServiceName: "tgi", Config: map[string]string{"endpoint": "/generate"}, IsDownstreamService: true},
+		{ServiceName: "llm-uservice-node1-2", Config: map[string]string{"endpoint": "/v1/chat/completions", "TGI_LLM_ENDPOINT": "tgi-node1"}},
+		{ServiceName: "tgi-node1", Config: map[string]string{"endpoint": "/generate"}, IsDownstreamService: true},
+		{},
+		{},
+	}
+	for i, step := range graph.Spec.Nodes["node1"].Steps {
+		if !reflect.DeepEqual(step.InternalService, want[i]) {
+			t.Errorf("Default() node1 step %s = %v, want %v", step.StepName, step.InternalService, want[i])
+		}
+		if step.Dependency != Soft {
+			t.Errorf("Default() dependency = %v, want %v", step.Dependency, Soft)
+		}
+	}
+}
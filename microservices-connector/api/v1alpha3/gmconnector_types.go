@@ -11,6 +11,10 @@
 package v1alpha3
 
 import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -93,15 +97,40 @@ type Step struct {
 	Executor `json:",inline"`
 
 	// request data sent to the next route with input/output from the previous step
-	// $request
-	// $response.predictions
+	// $response sends the previous response merged with the parameters of the request,
+	// otherwise a JSON template or a single reference built from $request, $response
+	// and $steps.<name>.response with gjson paths, e.g.
+	// {"query": "$request.messages.0.content", "docs": "$response.retrieved_docs.#.text"}
 	// +optional
 	Data string `json:"data,omitempty"`
 
-	// routing based on the condition
+	// routing based on the condition, either a gjson path or an expression
+	// referring to $request, $response and $headers, e.g.
+	// len($request.messages.0.content) > 2000 && $headers.X-Tier in ["gold", "silver"].
+	// Not supported in Splitter nodes, which pick their step by its weight.
 	// +optional
 	Condition string `json:"condition,omitempty"`
 
+	// names of the steps in the same DAG node which have to finish before this step starts,
+	// the Data of the step can refer to their responses
+	// +optional
+	DependsOn []string `json:"dependsOn,omitempty"`
+
+	// share of the requests a Splitter node sends to this step, relative to the weights of the other steps
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Weight int32 `json:"weight,omitempty"`
+
+	// send a copy of the request of this step to its service in the background and discard the response,
+	// the next step gets the response of the step before. Supported in Sequence and Switch nodes.
+	// +optional
+	Mirror bool `json:"mirror,omitempty"`
+
+	// the token of a request has to match all the rules to take this branch of a Switch node,
+	// the other requests skip it as if its condition was false. Needs the oidc router config.
+	// +optional
+	Claims []ClaimRule `json:"claims,omitempty"`
+
 	// to decide whether a step is a hard or a soft dependency in the Graph
 	// +optional
 	Dependency StepDependencyType `json:"dependency,omitempty"`
@@ -110,11 +139,242 @@ type Step struct {
 	// when the service is ready, save the URL here for router to call
 	// +optional
 	ServiceURL string `json:"serviceUrl,omitempty"`
+
+	// timeout of a single call to the service of this step, i.e. "30s" or "5m"
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// number of times the call is retried after the first attempt failed
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Retries int32 `json:"retries,omitempty"`
+
+	// wait time before the first retry, it is doubled for each further retry
+	// up to 30s
+	// +optional
+	RetryBackoff *metav1.Duration `json:"retryBackoff,omitempty"`
+
+	// the failures to retry on, either an HTTP status code like "503",
+	// a status class like "5xx" or "connect-failure" for the calls failing to
+	// connect to the service, timeouts are not retried.
+	// When it is empty, connection errors, 502, 503 and 504 are retried.
+	// +optional
+	RetryOn []string `json:"retryOn,omitempty"`
+
+	// executor to use while the circuit breaker of this step is open
+	// +optional
+	Fallback *Fallback `json:"fallback,omitempty"`
+
+	// cache the successful responses of this step by its request
+	// +optional
+	Cache *ResponseCache `json:"cache,omitempty"`
+
+	// lookup settings of a SemanticCache step, the step embeds its request with the Embedding step
+	// of the graph and answers with the response of the node to a similar earlier request
+	// +optional
+	SemanticCache *SemanticCache `json:"semanticCache,omitempty"`
+
+	// headers sent to the service of the step, none of the headers of the request is sent when it is not set
+	// +optional
+	Headers *HeaderPolicy `json:"headers,omitempty"`
+
+	// route of a DataPrep step on the router, the ingest requests are proxied to the service
+	// of the step without buffering the uploaded files
+	// +optional
+	DataPrep *DataPrep `json:"dataPrep,omitempty"`
+
+	// connections of the router to the service of the step, the steps without it share the
+	// transport of the router using the proxy of its environment
+	// +optional
+	Transport *Transport `json:"transport,omitempty"`
+}
+
+// ResponseCache defines how the router caches the responses of a step, the responses are only served
+// to the requests with the same values of the headers forwarded to the service
+type ResponseCache struct {
+	// time a response is cached, 5 minutes when it is not set
+	// +optional
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+
+	// maximum number of responses cached for the step, 1000 when it is not set
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxEntries int32 `json:"maxEntries,omitempty"`
+
+	// dotted paths of the request fields which do not change the response,
+	// i.e. "streaming" or "parameters.seed", they are left out of the cache key
+	// +optional
+	IgnoreFields []string `json:"ignoreFields,omitempty"`
 }
 
+// SemanticCache defines how a SemanticCache step matches a request with the earlier requests of its node,
+// the responses are only served to the requests with the same values of the headers the steps of the node forward
+type SemanticCache struct {
+	// minimum cosine similarity of the embeddings of two requests to reuse the response,
+	// a decimal between 0 and 1, "0.95" when it is not set
+	// +kubebuilder:validation:Pattern=`^(0(\.[0-9]+)?|1(\.0+)?)$`
+	// +optional
+	Threshold string `json:"threshold,omitempty"`
+
+	// time a response is cached, 5 minutes when it is not set
+	// +optional
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+
+	// maximum number of responses cached for the node, 1000 when it is not set
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxEntries int32 `json:"maxEntries,omitempty"`
+}
+
+const (
+	// SemanticCacheStep is the name of the steps looking up the semantic cache of the router,
+	// they are executed by the router and have no service
+	SemanticCacheStep = "SemanticCache"
+	// EmbeddingStep is the name of the steps the semantic cache embeds the requests with
+	EmbeddingStep = "Embedding"
+)
+
+// HeaderPolicy defines the headers the router sends to the service of a step
+type HeaderPolicy struct {
+	// names of the headers of the incoming request forwarded to the service, i.e. "Authorization"
+	// +optional
+	Forward []string `json:"forward,omitempty"`
+
+	// headers set on the calls to the service, they replace the forwarded ones with the same name
+	// +optional
+	Set []HeaderValue `json:"set,omitempty"`
+}
+
+// HeaderValue is a header with either a static value or a value read from a Secret
+type HeaderValue struct {
+	// name of the header
+	Name string `json:"name"`
+
+	// +optional
+	Value string `json:"value,omitempty"`
+
+	// key of a Secret in the namespace of the router holding the value, i.e. the API key of an
+	// external service. The Secret is mounted into the router, so the value never enters the graph.
+	// +optional
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+// Transport defines how the router connects to the service of a step
+type Transport struct {
+	// proxy of the calls to the service, i.e. "http://proxy.example.com:912",
+	// the proxy of the environment of the router is used when it is not set
+	// +kubebuilder:validation:Pattern=`^https?://`
+	// +optional
+	ProxyURL string `json:"proxyURL,omitempty"`
+
+	// hosts reached without the proxy, in the format of the no_proxy environment variable,
+	// i.e. ".svc.cluster.local,10.0.0.0/8". It replaces the no_proxy key of the step config.
+	// +optional
+	NoProxy string `json:"noProxy,omitempty"`
+
+	// TLS settings of the calls to an https service
+	// +optional
+	TLS *TransportTLS `json:"tls,omitempty"`
+
+	// use HTTP/1.1 only, the router negotiates HTTP/2 with the https services otherwise
+	// +optional
+	DisableHTTP2 bool `json:"disableHTTP2,omitempty"`
+
+	// maximum number of idle connections kept open to the service, 100 when it is not set
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxIdleConns int32 `json:"maxIdleConns,omitempty"`
+
+	// maximum number of connections to the service including the ones in use, no limit when it is not set
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxConns int32 `json:"maxConns,omitempty"`
+
+	// time an idle connection is kept open, 2 minutes when it is not set
+	// +optional
+	IdleConnTimeout *metav1.Duration `json:"idleConnTimeout,omitempty"`
+}
+
+// TransportTLS defines how the router verifies an https service and authenticates to it.
+// The Secrets are in the namespace of the router and are mounted into it.
+type TransportTLS struct {
+	// key of a Secret holding the PEM bundle of the CAs verifying the certificate of the service,
+	// the system CAs are used when it is not set
+	// +optional
+	CASecretRef *corev1.SecretKeySelector `json:"caSecretRef,omitempty"`
+
+	// name of a Secret of type kubernetes.io/tls holding the client certificate
+	// presented to the service for mutual TLS in its tls.crt and tls.key keys
+	// +optional
+	ClientCertSecretName string `json:"clientCertSecretName,omitempty"`
+
+	// name verified in the certificate of the service, the host of the service URL when it is not set
+	// +optional
+	ServerName string `json:"serverName,omitempty"`
+}
+
+// DataPrep defines the route the router proxies to the service of a DataPrep step
+type DataPrep struct {
+	// path on the router proxied to the service URL of the step, the paths of the other
+	// operations below it are proxied to the same paths below the service URL,
+	// e.g. /dataprep/get_file. "/dataprep" when it is not set.
+	// +kubebuilder:validation:Pattern=`^/`
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// largest request body accepted by the route, e.g. "100Mi", no limit when it is not set
+	// +optional
+	MaxRequestSize *resource.Quantity `json:"maxRequestSize,omitempty"`
+
+	// settings of the single operations, overriding the ones of the route
+	// +optional
+	Operations []DataPrepOperation `json:"operations,omitempty"`
+}
+
+// DataPrepOperation overrides the settings of a DataPrep route for one of its operations
+type DataPrepOperation struct {
+	// ingest is the path of the route itself, the other operations are the paths below it
+	// +kubebuilder:validation:Enum=ingest;get_file;delete_file
+	Name string `json:"name"`
+
+	// time the proxied request may take, the timeout of the step
+	// or of the router config is used when it is not set
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// largest request body accepted by the operation
+	// +optional
+	MaxRequestSize *resource.Quantity `json:"maxRequestSize,omitempty"`
+}
+
+const (
+	// DataPrepStep is the name of the steps ingesting documents
+	DataPrepStep = "DataPrep"
+	// DefaultDataPrepPath is the path of the DataPrep steps without declared route
+	DefaultDataPrepPath = "/dataprep"
+	// the operations of the DataPrep services
+	DataPrepIngest     = "ingest"
+	DataPrepGetFile    = "get_file"
+	DataPrepDeleteFile = "delete_file"
+)
+
+// Fallback defines the executor which replaces a step while the service of the step is unhealthy
+type Fallback struct {
+	// Node or service used instead of the step
+	Executor `json:",inline"`
+
+	// this is not for the users to set
+	// when the fallback service is ready, save the URL here for router to call
+	// +optional
+	ServiceURL string `json:"serviceUrl,omitempty"`
+}
+
+// RetryOnConnectFailure retries the step when the service cannot be reached
+const RetryOnConnectFailure = "connect-failure"
+
 // RouterType constant for routing types
 // +k8s:openapi-gen=true
-// +kubebuilder:validation:Enum=Sequence;Ensemble;Switch
+// +kubebuilder:validation:Enum=Sequence;Ensemble;Switch;DAG;Splitter
 type RouterType string
 
 // GMCRouterType Enum
@@ -127,6 +387,12 @@ const (
 
 	// Switch routes the request to the destination based on certain condition
 	Switch RouterType = "Switch"
+
+	// DAG runs every step once the steps it depends on finished, independent steps run concurrently
+	DAG RouterType = "DAG"
+
+	// Splitter routes each request to one of the steps picked by their weights
+	Splitter RouterType = "Splitter"
 )
 
 type Router struct {
@@ -138,28 +404,200 @@ type Router struct {
 	//
 	// - `Switch:` routes the request to one of the steps based on condition
 	//
+	// - `DAG:` runs the steps after the steps listed in their dependsOn
+	//
+	// - `Splitter:` routes the request to one of the steps based on their weights
+	//
 	RouterType RouterType `json:"routerType"`
 
 	// Steps defines destinations for the current router node
 	// +optional
 	Steps []Step `json:"steps,omitempty"`
+
+	// MergeStrategy defines how an Ensemble node merges the responses of its steps
+	// +optional
+	MergeStrategy *MergeStrategy `json:"mergeStrategy,omitempty"`
+
+	// Sticky keeps the requests with the same key on the same step of a Splitter node
+	// +optional
+	Sticky *Sticky `json:"sticky,omitempty"`
+}
+
+// Sticky defines where the key of a sticky assignment is read from, exactly one of the fields is set
+type Sticky struct {
+	// Header is the name of the request header holding the key, i.e. "X-User-Id"
+	// +optional
+	Header string `json:"header,omitempty"`
+
+	// Field is the gjson path of the key in the request body, i.e. "user"
+	// +optional
+	Field string `json:"field,omitempty"`
+}
+
+// MergeStrategyType constant for the ways to merge the responses of an Ensemble node
+// +kubebuilder:validation:Enum=map;firstSuccess;quorum;concatArray;scoreMax
+type MergeStrategyType string
+
+const (
+	// MergeMap builds an object with the response of every step by step name, it is the default
+	MergeMap MergeStrategyType = "map"
+
+	// MergeFirstSuccess returns the first successful response and drops the other steps
+	MergeFirstSuccess MergeStrategyType = "firstSuccess"
+
+	// MergeQuorum returns the responses by step name once enough steps succeeded
+	MergeQuorum MergeStrategyType = "quorum"
+
+	// MergeConcatArray concatenates the arrays found at the path of the successful responses
+	MergeConcatArray MergeStrategyType = "concatArray"
+
+	// MergeScoreMax returns the successful response with the highest score at the path
+	MergeScoreMax MergeStrategyType = "scoreMax"
+)
+
+type MergeStrategy struct {
+	// Type of the merge, map when it is empty
+	// +optional
+	Type MergeStrategyType `json:"type,omitempty"`
+
+	// Path is the dotted path of the array to concatenate for concatArray, i.e. "retrieved_docs",
+	// or the gjson path of the score for scoreMax
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// Quorum is the number of steps which have to succeed, a majority of the steps when it is not set
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Quorum int32 `json:"quorum,omitempty"`
+
+	// Deadline after which the steps which did not respond yet are dropped
+	// and the responses received so far are merged, i.e. "10s"
+	// +optional
+	Deadline *metav1.Duration `json:"deadline,omitempty"`
 }
 
 type RouterConfig struct {
-	Name        string `json:"name"`
+	// "router" when it is not set
+	// +optional
+	Name string `json:"name"`
+	// "router-service" when it is not set
+	// +optional
 	ServiceName string `json:"serviceName"`
 	// +optional
 	NameSpace string `json:"nameSpace"`
 	// +optional
 	Config map[string]string `json:"config"`
+
+	// validation of the bearer tokens of the requests, the router accepts
+	// the requests without token when it is not set
+	// +optional
+	OIDC *OIDC `json:"oidc,omitempty"`
+}
+
+// OIDC defines how the router validates the JWT bearer tokens of the requests
+type OIDC struct {
+	// issuer of the tokens, the signing keys are fetched from the jwks_uri
+	// of its /.well-known/openid-configuration document
+	// +kubebuilder:validation:Pattern=`^https?://`
+	Issuer string `json:"issuer"`
+
+	// URL of the JSON Web Key Set of the issuer, used instead of the discovery document
+	// +kubebuilder:validation:Pattern=`^https?://`
+	// +optional
+	JWKSURL string `json:"jwksUrl,omitempty"`
+
+	// the tokens need one of these audiences, the audience is not checked when it is empty
+	// +optional
+	Audiences []string `json:"audiences,omitempty"`
+
+	// claims of the token sent to the steps as request headers
+	// +optional
+	ForwardClaims []ClaimHeader `json:"forwardClaims,omitempty"`
 }
 
+// ClaimHeader forwards a claim of the token as a request header
+type ClaimHeader struct {
+	// name of the claim, i.e. "sub"
+	Claim string `json:"claim"`
+
+	// header the value of the claim is sent in, the header of the incoming request is replaced
+	Header string `json:"header"`
+}
+
+// ClaimRule accepts the tokens with a claim set to one of the values,
+// for a list claim like "groups" one of its items has to be one of the values
+type ClaimRule struct {
+	// name of the claim, i.e. "groups"
+	Claim string `json:"claim"`
+
+	// +kubebuilder:validation:MinItems=1
+	Values []string `json:"values"`
+}
+
+// Entrypoint maps the requests to an HTTP path and method of the router to the node they start from
+type Entrypoint struct {
+	// Unique name of the entrypoint
+	Name string `json:"name"`
+
+	// HTTP path served by the router, i.e. "/v1/retrieval"
+	// +kubebuilder:validation:Pattern=`^/`
+	Path string `json:"path"`
+
+	// HTTP method of the requests, POST when it is not set
+	// +kubebuilder:validation:Enum=GET;POST;PUT;PATCH;DELETE
+	// +optional
+	Method string `json:"method,omitempty"`
+
+	// name of the node the requests start from
+	NodeName string `json:"nodeName"`
+
+	// the token of a request has to match all the rules to use the entrypoint, needs the oidc router config
+	// +optional
+	Claims []ClaimRule `json:"claims,omitempty"`
+}
+
+// DefaultEntrypointMethod is the method of the entrypoints which do not set one
+const DefaultEntrypointMethod = "POST"
+
 // GMConnectorSpec defines the desired state of GMConnector
 type GMConnectorSpec struct {
-	Nodes        map[string]Router `json:"nodes"`
-	RouterConfig RouterConfig      `json:"routerConfig"`
+	Nodes map[string]Router `json:"nodes"`
+	// +optional
+	RouterConfig RouterConfig `json:"routerConfig"`
+
+	// the paths served by the router and their start nodes, the requests to the other paths start
+	// from the root node, which is only required when there is no entrypoint
+	// +optional
+	Entrypoints []Entrypoint `json:"entrypoints,omitempty"`
+
+	// mutual TLS between the router and the internal services it calls, with certificates
+	// issued and rotated by the controller. A NetworkPolicy only admits the connections to the
+	// HTTPS port of the services. The calls are plain HTTP when it is not set.
+	// +optional
+	MTLS *MTLS `json:"mtls,omitempty"`
+}
+
+// MTLS defines the certificates the controller issues to the router and the internal services of a GMConnector.
+// The services serve HTTPS through a proxy sidecar which only accepts the certificate of the router,
+// a NetworkPolicy closes their plain port, it needs a network plugin enforcing the NetworkPolicies.
+type MTLS struct {
+	// lifetime of the certificates, "2160h" (90 days) when it is not set
+	// +optional
+	CertDuration *metav1.Duration `json:"certDuration,omitempty"`
+
+	// the certificates are issued again when they expire within this time,
+	// a third of the lifetime when it is not set
+	// +optional
+	RenewBefore *metav1.Duration `json:"renewBefore,omitempty"`
 }
 
+const (
+	// DefaultMTLSCertDuration is the lifetime of the certificates when the mtls spec does not set one
+	DefaultMTLSCertDuration = 90 * 24 * time.Hour
+	// MinMTLSCertDuration is the shortest lifetime of the certificates, leaving time to roll them out
+	MinMTLSCertDuration = time.Hour
+)
+
 type ConditionType string
 
 // Well-known condition types for GMConnector status.
@@ -7,11 +7,18 @@ package v1alpha3
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
 	"slices"
+	"strconv"
+	"strings"
 
+	"github.com/opea-project/GenAIInfra/microservices-connector/internal/condition"
+	"github.com/opea-project/GenAIInfra/microservices-connector/internal/payload"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -47,6 +54,30 @@ var (
 		"WhisperGaudi",
 		"DataPrep",
 		"UI",
+		SemanticCacheStep,
+	}
+	// reservedPaths are served by the router itself, they cannot be the path of an entrypoint,
+	// a path ending with / reserves the paths below it
+	reservedPaths = []string{
+		"/assets/",
+		"/dataprep",
+		"/dataprep/",
+		"/ui",
+		"/metrics",
+		"/debug/circuitbreakers",
+	}
+	// headers managed by the router and the HTTP client, a step cannot forward or set them
+	managedHeaders = []string{
+		"Connection",
+		"Content-Length",
+		"Content-Type",
+		"Host",
+		"Keep-Alive",
+		"Proxy-Connection",
+		"Te",
+		"Trailer",
+		"Transfer-Encoding",
+		"Upgrade",
 	}
 )
 
@@ -102,8 +133,53 @@ func (r *GMConnector) checkfields() field.ErrorList {
 	if errs := validateNames(r.Spec.Nodes, field.NewPath("spec").Child("nodes")); len(errs) > 0 {
 		allErrs = errs
 	}
-	if err := validateRootExistance(r.Spec.Nodes, field.NewPath("spec").Child("nodes")); err != nil {
-		allErrs = append(allErrs, err)
+	// the root node serves the requests when no entrypoint is declared
+	if len(r.Spec.Entrypoints) == 0 {
+		if err := validateRootExistance(r.Spec.Nodes, field.NewPath("spec").Child("nodes")); err != nil {
+			allErrs = append(allErrs, err)
+		}
+	}
+	if errs := validateEntrypoints(r.Spec.Entrypoints, r.Spec.Nodes, field.NewPath("spec").Child("entrypoints")); len(errs) > 0 {
+		allErrs = append(allErrs, errs...)
+	}
+	if errs := validateStepPolicies(r.Spec.Nodes, field.NewPath("spec").Child("nodes")); len(errs) > 0 {
+		allErrs = append(allErrs, errs...)
+	}
+	if errs := validateConditions(r.Spec.Nodes, field.NewPath("spec").Child("nodes")); len(errs) > 0 {
+		allErrs = append(allErrs, errs...)
+	}
+	if errs := validateStepData(r.Spec.Nodes, field.NewPath("spec").Child("nodes")); len(errs) > 0 {
+		allErrs = append(allErrs, errs...)
+	}
+	if errs := validateDependencies(r.Spec.Nodes, field.NewPath("spec").Child("nodes")); len(errs) > 0 {
+		allErrs = append(allErrs, errs...)
+	}
+	if errs := validateMergeStrategies(r.Spec.Nodes, field.NewPath("spec").Child("nodes")); len(errs) > 0 {
+		allErrs = append(allErrs, errs...)
+	}
+	if errs := validateSplitters(r.Spec.Nodes, field.NewPath("spec").Child("nodes")); len(errs) > 0 {
+		allErrs = append(allErrs, errs...)
+	}
+	if errs := validateMirrors(r.Spec.Nodes, field.NewPath("spec").Child("nodes")); len(errs) > 0 {
+		allErrs = append(allErrs, errs...)
+	}
+	if errs := validateSemanticCaches(r.Spec.Nodes, field.NewPath("spec").Child("nodes")); len(errs) > 0 {
+		allErrs = append(allErrs, errs...)
+	}
+	if errs := validateDataPreps(r.Spec.Nodes, r.Spec.Entrypoints, field.NewPath("spec").Child("nodes")); len(errs) > 0 {
+		allErrs = append(allErrs, errs...)
+	}
+	if errs := validateAuthentication(&r.Spec, field.NewPath("spec")); len(errs) > 0 {
+		allErrs = append(allErrs, errs...)
+	}
+	if errs := validateHeaderPolicies(r.Spec.Nodes, field.NewPath("spec").Child("nodes")); len(errs) > 0 {
+		allErrs = append(allErrs, errs...)
+	}
+	if errs := validateTransports(r.Spec.Nodes, field.NewPath("spec").Child("nodes")); len(errs) > 0 {
+		allErrs = append(allErrs, errs...)
+	}
+	if errs := validateMTLS(r.Spec.MTLS, field.NewPath("spec").Child("mtls")); len(errs) > 0 {
+		allErrs = append(allErrs, errs...)
 	}
 
 	if len(allErrs) == 0 {
@@ -183,4 +259,793 @@ func validateRootExistance(nodes map[string]Router, fldPath *field.Path) *field.
 	return nil
 }
 
+// check the entrypoints have unique names and paths, and start from existing nodes
+func validateEntrypoints(entrypoints []Entrypoint, nodes map[string]Router, fldPath *field.Path) field.ErrorList {
+	names := map[string]bool{}
+	// the entrypoints by method and path
+	routes := map[string]string{}
+	var errs field.ErrorList
+
+	for idx, entrypoint := range entrypoints {
+		entrypointPath := fldPath.Index(idx)
+		if entrypoint.Name == "" {
+			errs = append(errs, field.Invalid(entrypointPath.Child("name"),
+				entrypoint.Name,
+				"the entrypoint name cannot be empty"))
+		} else if names[entrypoint.Name] {
+			errs = append(errs, field.Invalid(entrypointPath.Child("name"),
+				entrypoint.Name,
+				fmt.Sprintf("entrypoint name: %v already exists", entrypoint.Name)))
+		}
+		names[entrypoint.Name] = true
+
+		method := entrypoint.Method
+		if method == "" {
+			method = DefaultEntrypointMethod
+		}
+		if !strings.HasPrefix(entrypoint.Path, "/") {
+			errs = append(errs, field.Invalid(entrypointPath.Child("path"),
+				entrypoint.Path,
+				fmt.Sprintf("the path of entrypoint %v must start with /", entrypoint.Name)))
+		} else if isReservedPath(entrypoint.Path) {
+			errs = append(errs, field.Invalid(entrypointPath.Child("path"),
+				entrypoint.Path,
+				fmt.Sprintf("the path of entrypoint %v is reserved by the router", entrypoint.Name)))
+		} else if other, ok := routes[method+" "+entrypoint.Path]; ok {
+			errs = append(errs, field.Invalid(entrypointPath.Child("path"),
+				entrypoint.Path,
+				fmt.Sprintf("%v %v of entrypoint %v is already served by entrypoint %v", method, entrypoint.Path, entrypoint.Name, other)))
+		} else {
+			routes[method+" "+entrypoint.Path] = entrypoint.Name
+		}
+
+		if _, ok := nodes[entrypoint.NodeName]; !ok {
+			errs = append(errs, field.Invalid(entrypointPath.Child("nodeName"),
+				entrypoint.NodeName,
+				fmt.Sprintf("node name: %v of entrypoint %v does not exist", entrypoint.NodeName, entrypoint.Name)))
+		}
+	}
+	return errs
+}
+
+func isReservedPath(path string) bool {
+	for _, reserved := range reservedPaths {
+		if path == reserved || strings.HasSuffix(reserved, "/") && strings.HasPrefix(path, reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+// validate the timeout, retry, fallback and cache settings of the steps
+func validateStepPolicies(nodes map[string]Router, fldPath *field.Path) field.ErrorList {
+	nodeNames := getKeys(nodes)
+	var errs field.ErrorList
+
+	for name, router := range nodes {
+		for idx, step := range router.Steps {
+			stepPath := fldPath.Child(name).Child(fmt.Sprintf("steps[%d]", idx))
+			if step.Timeout != nil && step.Timeout.Duration <= 0 {
+				errs = append(errs, field.Invalid(stepPath.Child("timeout"),
+					step.Timeout,
+					fmt.Sprintf("the timeout of step %v must be positive", step.StepName)))
+			}
+			if step.Retries < 0 {
+				errs = append(errs, field.Invalid(stepPath.Child("retries"),
+					step.Retries,
+					fmt.Sprintf("the retries of step %v cannot be negative", step.StepName)))
+			}
+			if step.RetryBackoff != nil && step.RetryBackoff.Duration < 0 {
+				errs = append(errs, field.Invalid(stepPath.Child("retryBackoff"),
+					step.RetryBackoff,
+					fmt.Sprintf("the retry backoff of step %v cannot be negative", step.StepName)))
+			}
+			for i, retryOn := range step.RetryOn {
+				if !isValidRetryOn(retryOn) {
+					errs = append(errs, field.Invalid(stepPath.Child(fmt.Sprintf("retryOn[%d]", i)),
+						retryOn,
+						fmt.Sprintf("invalid retry condition: %s in step %v", retryOn, step.StepName)))
+				}
+			}
+			if step.Fallback != nil {
+				if err := validateFallback(step, stepPath.Child("fallback"), nodeNames); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			if step.Cache != nil {
+				errs = append(errs, validateResponseCache(step, stepPath.Child("cache"))...)
+			}
+		}
+	}
+	return errs
+}
+
+// parse and type-check the step conditions written in the expression language,
+// plain gjson path conditions are left as they are
+func validateConditions(nodes map[string]Router, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	for name, router := range nodes {
+		for idx, step := range router.Steps {
+			if !condition.IsExpression(step.Condition) {
+				continue
+			}
+			condPath := fldPath.Child(name).Child(fmt.Sprintf("steps[%d]", idx)).Child("condition")
+			cond, err := condition.Parse(step.Condition)
+			if err != nil {
+				errs = append(errs, field.Invalid(condPath,
+					step.Condition,
+					fmt.Sprintf("invalid condition of step %v: %v", step.StepName, err)))
+				continue
+			}
+			// a Switch node picks its route before any step responded
+			if router.RouterType == Switch && cond.References(condition.ResponseRef) {
+				errs = append(errs, field.Invalid(condPath,
+					step.Condition,
+					fmt.Sprintf("the condition of step %v in Switch node %v cannot refer to %s",
+						step.StepName, name, condition.ResponseRef)))
+			}
+		}
+	}
+	return errs
+}
+
+// parse the Data templates of the steps and check the steps they refer to exist
+func validateStepData(nodes map[string]Router, fldPath *field.Path) field.ErrorList {
+	var stepNames []string
+	for _, router := range nodes {
+		for _, step := range router.Steps {
+			stepNames = append(stepNames, step.StepName)
+		}
+	}
+	var errs field.ErrorList
+
+	for name, router := range nodes {
+		for idx, step := range router.Steps {
+			// $response is the legacy Data, merging the previous response with the request parameters
+			if step.Data == "" || step.Data == "$response" {
+				continue
+			}
+			dataPath := fldPath.Child(name).Child(fmt.Sprintf("steps[%d]", idx)).Child("data")
+			tmpl, err := payload.Parse(step.Data)
+			if err != nil {
+				errs = append(errs, field.Invalid(dataPath,
+					step.Data,
+					fmt.Sprintf("invalid data template of step %v: %v", step.StepName, err)))
+				continue
+			}
+			for _, ref := range tmpl.StepNames() {
+				if !slices.Contains(stepNames, ref) {
+					errs = append(errs, field.Invalid(dataPath,
+						step.Data,
+						fmt.Sprintf("the data template of step %v refers to step %v which does not exist", step.StepName, ref)))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// check the steps of the DAG nodes have unique names and depend on steps
+// of the same node without forming a cycle
+func validateDependencies(nodes map[string]Router, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	for name, router := range nodes {
+		nodePath := fldPath.Child(name)
+		if router.RouterType != DAG {
+			for idx, step := range router.Steps {
+				if len(step.DependsOn) > 0 {
+					errs = append(errs, field.Invalid(nodePath.Child(fmt.Sprintf("steps[%d]", idx)).Child("dependsOn"),
+						step.DependsOn,
+						fmt.Sprintf("step %v in %v node %v cannot depend on other steps, only DAG nodes can",
+							step.StepName, router.RouterType, name)))
+				}
+			}
+			continue
+		}
+
+		names := make([]string, 0, len(router.Steps))
+		for _, step := range router.Steps {
+			names = append(names, step.StepName)
+		}
+		for idx, step := range router.Steps {
+			stepPath := nodePath.Child(fmt.Sprintf("steps[%d]", idx))
+			if slices.Index(names, step.StepName) != idx {
+				errs = append(errs, field.Invalid(stepPath.Child("name"),
+					step.StepName,
+					fmt.Sprintf("step name: %v in DAG node %v already exists", step.StepName, name)))
+			}
+			for i, dep := range step.DependsOn {
+				if !slices.Contains(names, dep) {
+					errs = append(errs, field.Invalid(stepPath.Child(fmt.Sprintf("dependsOn[%d]", i)),
+						dep,
+						fmt.Sprintf("step %v depends on step %v which does not exist in node %v", step.StepName, dep, name)))
+				}
+			}
+		}
+		if cycle := FindDAGCycle(router.Steps); cycle != nil {
+			errs = append(errs, field.Invalid(nodePath.Child("steps"),
+				strings.Join(cycle, " -> "),
+				fmt.Sprintf("the dependencies of the steps in DAG node %v form a cycle", name)))
+		}
+	}
+	return errs
+}
+
+// check the merge strategies are set on Ensemble nodes with the settings their type needs
+func validateMergeStrategies(nodes map[string]Router, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	for name, router := range nodes {
+		strategy := router.MergeStrategy
+		if strategy == nil {
+			continue
+		}
+		strategyPath := fldPath.Child(name).Child("mergeStrategy")
+		if router.RouterType != Ensemble {
+			errs = append(errs, field.Invalid(strategyPath,
+				strategy,
+				fmt.Sprintf("the merge strategy of %v node %v is only supported in Ensemble nodes", router.RouterType, name)))
+			continue
+		}
+		switch strategy.Type {
+		case MergeConcatArray:
+			if strategy.Path == "" || strings.ContainsAny(strategy.Path, "#*?|@\\") {
+				errs = append(errs, field.Invalid(strategyPath.Child("path"),
+					strategy.Path,
+					fmt.Sprintf("the %v merge strategy of node %v needs a dotted path of the array to concatenate", strategy.Type, name)))
+			}
+		case MergeScoreMax:
+			if strategy.Path == "" {
+				errs = append(errs, field.Invalid(strategyPath.Child("path"),
+					strategy.Path,
+					fmt.Sprintf("the %v merge strategy of node %v needs the path of the score", strategy.Type, name)))
+			}
+		}
+		if strategy.Quorum != 0 {
+			if strategy.Type != MergeQuorum {
+				errs = append(errs, field.Invalid(strategyPath.Child("quorum"),
+					strategy.Quorum,
+					fmt.Sprintf("the quorum of node %v is only used by the %v merge strategy", name, MergeQuorum)))
+			} else if strategy.Quorum < 0 || int(strategy.Quorum) > len(router.Steps) {
+				errs = append(errs, field.Invalid(strategyPath.Child("quorum"),
+					strategy.Quorum,
+					fmt.Sprintf("the quorum of node %v must be between 1 and the number of steps", name)))
+			}
+		}
+		if strategy.Deadline != nil && strategy.Deadline.Duration <= 0 {
+			errs = append(errs, field.Invalid(strategyPath.Child("deadline"),
+				strategy.Deadline,
+				fmt.Sprintf("the deadline of the merge strategy of node %v must be positive", name)))
+		}
+	}
+	return errs
+}
+
+// check the weights and the sticky assignment are only set on Splitter nodes,
+// and a Splitter node has a step to send the requests to and no step condition
+func validateSplitters(nodes map[string]Router, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	for name, router := range nodes {
+		nodePath := fldPath.Child(name)
+		if router.RouterType != Splitter {
+			for idx, step := range router.Steps {
+				if step.Weight != 0 {
+					errs = append(errs, field.Invalid(nodePath.Child(fmt.Sprintf("steps[%d]", idx)).Child("weight"),
+						step.Weight,
+						fmt.Sprintf("the weight of step %v is only used in Splitter nodes", step.StepName)))
+				}
+			}
+			if router.Sticky != nil {
+				errs = append(errs, field.Invalid(nodePath.Child("sticky"),
+					router.Sticky,
+					fmt.Sprintf("the sticky assignment of %v node %v is only supported in Splitter nodes", router.RouterType, name)))
+			}
+			continue
+		}
+
+		// summed as int64 so the int32 weights cannot overflow
+		var total int64
+		for idx, step := range router.Steps {
+			if step.Condition != "" {
+				errs = append(errs, field.Invalid(nodePath.Child(fmt.Sprintf("steps[%d]", idx)).Child("condition"),
+					step.Condition,
+					fmt.Sprintf("the steps of Splitter node %v are picked by their weights, step %v cannot have a condition", name, step.StepName)))
+			}
+			if step.Weight < 0 {
+				errs = append(errs, field.Invalid(nodePath.Child(fmt.Sprintf("steps[%d]", idx)).Child("weight"),
+					step.Weight,
+					fmt.Sprintf("the weight of step %v cannot be negative", step.StepName)))
+			} else if !step.InternalService.IsDownstreamService {
+				total += int64(step.Weight)
+			}
+		}
+		if total <= 0 {
+			errs = append(errs, field.Invalid(nodePath.Child("steps"),
+				total,
+				fmt.Sprintf("at least one step of Splitter node %v needs a positive weight", name)))
+		}
+		if sticky := router.Sticky; sticky != nil && (sticky.Header == "") == (sticky.Field == "") {
+			errs = append(errs, field.Invalid(nodePath.Child("sticky"),
+				sticky,
+				fmt.Sprintf("the sticky assignment of node %v must set exactly one of header or field", name)))
+		}
+	}
+	return errs
+}
+
+// check the mirror steps are in Sequence or Switch nodes, where the response of the step before is passed on
+func validateMirrors(nodes map[string]Router, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+
+	for name, router := range nodes {
+		if router.RouterType == Sequence || router.RouterType == Switch {
+			continue
+		}
+		for idx, step := range router.Steps {
+			if step.Mirror {
+				errs = append(errs, field.Invalid(fldPath.Child(name).Child(fmt.Sprintf("steps[%d]", idx)).Child("mirror"),
+					step.Mirror,
+					fmt.Sprintf("step %v in %v node %v cannot be a mirror, only steps of Sequence and Switch nodes can",
+						step.StepName, router.RouterType, name)))
+			}
+		}
+	}
+	return errs
+}
+
+// check the SemanticCache steps are in Sequence nodes of a graph with an Embedding step,
+// and only they have semantic cache settings
+func validateSemanticCaches(nodes map[string]Router, fldPath *field.Path) field.ErrorList {
+	hasEmbedding := false
+	for _, router := range nodes {
+		for _, step := range router.Steps {
+			if step.StepName == EmbeddingStep {
+				hasEmbedding = true
+			}
+		}
+	}
+	var errs field.ErrorList
+
+	for name, router := range nodes {
+		for idx, step := range router.Steps {
+			stepPath := fldPath.Child(name).Child(fmt.Sprintf("steps[%d]", idx))
+			if step.StepName != SemanticCacheStep {
+				if step.SemanticCache != nil {
+					errs = append(errs, field.Invalid(stepPath.Child("semanticCache"),
+						step.SemanticCache,
+						fmt.Sprintf("step %v cannot have semantic cache settings, only %v steps can", step.StepName, SemanticCacheStep)))
+				}
+				continue
+			}
+			if router.RouterType != Sequence {
+				errs = append(errs, field.Invalid(stepPath.Child("name"),
+					step.StepName,
+					fmt.Sprintf("%v steps are only supported in Sequence nodes, not in %v node %v", SemanticCacheStep, router.RouterType, name)))
+			}
+			if !hasEmbedding {
+				errs = append(errs, field.Invalid(stepPath.Child("name"),
+					step.StepName,
+					fmt.Sprintf("%v step in node %v needs an %v step in the graph", SemanticCacheStep, name, EmbeddingStep)))
+			}
+			if step.NodeName != "" || step.InternalService.ServiceName != "" || step.ExternalService != "" {
+				errs = append(errs, field.Invalid(stepPath,
+					step,
+					fmt.Sprintf("%v step in node %v is executed by the router, it cannot have a node or a service", SemanticCacheStep, name)))
+			}
+			if step.SemanticCache != nil {
+				errs = append(errs, validateSemanticCache(step.SemanticCache, stepPath.Child("semanticCache"))...)
+			}
+		}
+	}
+	return errs
+}
+
+// check the DataPrep routes have unique paths which are not served otherwise by the router
+func validateDataPreps(nodes map[string]Router, entrypoints []Entrypoint, fldPath *field.Path) field.ErrorList {
+	nodeNames := getKeys(nodes)
+	slices.Sort(nodeNames)
+	// the DataPrep steps by path
+	routes := map[string]string{}
+	var errs field.ErrorList
+
+	for _, name := range nodeNames {
+		for idx, step := range nodes[name].Steps {
+			stepPath := fldPath.Child(name).Child(fmt.Sprintf("steps[%d]", idx))
+			if step.StepName != DataPrepStep {
+				if step.DataPrep != nil {
+					errs = append(errs, field.Invalid(stepPath.Child("dataPrep"),
+						step.DataPrep,
+						fmt.Sprintf("step %v cannot have a dataprep route, only %v steps can", step.StepName, DataPrepStep)))
+				}
+				continue
+			}
+			routePath := DefaultDataPrepPath
+			if step.DataPrep != nil {
+				errs = append(errs, validateDataPrep(step.DataPrep, stepPath.Child("dataPrep"))...)
+				if step.DataPrep.Path != "" {
+					routePath = step.DataPrep.Path
+				}
+			}
+			if !strings.HasPrefix(routePath, "/") || routePath == "/" {
+				// reported by validateDataPrep
+				continue
+			}
+			if routePath != DefaultDataPrepPath && isReservedPath(routePath) {
+				errs = append(errs, field.Invalid(stepPath.Child("dataPrep").Child("path"),
+					routePath,
+					fmt.Sprintf("the dataprep path of step %v in node %v is reserved by the router", step.StepName, name)))
+			}
+			if other, ok := routes[routePath]; ok {
+				errs = append(errs, field.Invalid(stepPath.Child("dataPrep").Child("path"),
+					routePath,
+					fmt.Sprintf("the dataprep path %v of node %v is already served by node %v", routePath, name, other)))
+			}
+			routes[routePath] = name
+			for _, entrypoint := range entrypoints {
+				if entrypoint.Path == routePath || strings.HasPrefix(entrypoint.Path, routePath+"/") {
+					errs = append(errs, field.Invalid(stepPath.Child("dataPrep").Child("path"),
+						routePath,
+						fmt.Sprintf("the dataprep path %v of node %v conflicts with entrypoint %v", routePath, name, entrypoint.Name)))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// a dataprep route needs a path below / and positive size limits for distinct operations
+func validateDataPrep(dataPrep *DataPrep, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if dataPrep.Path != "" && (!strings.HasPrefix(dataPrep.Path, "/") || dataPrep.Path == "/") {
+		errs = append(errs, field.Invalid(fldPath.Child("path"),
+			dataPrep.Path,
+			"the dataprep path must start with / and cannot be the root path"))
+	}
+	if dataPrep.MaxRequestSize != nil && dataPrep.MaxRequestSize.Sign() <= 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("maxRequestSize"),
+			dataPrep.MaxRequestSize.String(),
+			"the maximum request size must be positive"))
+	}
+	operations := map[string]bool{}
+	for idx, operation := range dataPrep.Operations {
+		operationPath := fldPath.Child("operations").Index(idx)
+		switch operation.Name {
+		case DataPrepIngest, DataPrepGetFile, DataPrepDeleteFile:
+		default:
+			errs = append(errs, field.Invalid(operationPath.Child("name"),
+				operation.Name,
+				fmt.Sprintf("the dataprep operation must be one of %v, %v or %v", DataPrepIngest, DataPrepGetFile, DataPrepDeleteFile)))
+		}
+		if operations[operation.Name] {
+			errs = append(errs, field.Invalid(operationPath.Child("name"),
+				operation.Name,
+				fmt.Sprintf("dataprep operation %v is already configured", operation.Name)))
+		}
+		operations[operation.Name] = true
+		if operation.Timeout != nil && operation.Timeout.Duration <= 0 {
+			errs = append(errs, field.Invalid(operationPath.Child("timeout"),
+				operation.Timeout.Duration.String(),
+				"the timeout must be positive"))
+		}
+		if operation.MaxRequestSize != nil && operation.MaxRequestSize.Sign() <= 0 {
+			errs = append(errs, field.Invalid(operationPath.Child("maxRequestSize"),
+				operation.MaxRequestSize.String(),
+				"the maximum request size must be positive"))
+		}
+	}
+	return errs
+}
+
+// check the oidc settings of the router, and the claim rules of the entrypoints and the Switch branches
+func validateAuthentication(spec *GMConnectorSpec, fldPath *field.Path) field.ErrorList {
+	oidc := spec.RouterConfig.OIDC
+	var errs field.ErrorList
+	if oidc != nil {
+		oidcPath := fldPath.Child("routerConfig").Child("oidc")
+		if err := validateHTTPURL(oidc.Issuer); err != nil {
+			errs = append(errs, field.Invalid(oidcPath.Child("issuer"), oidc.Issuer, err.Error()))
+		}
+		if oidc.JWKSURL != "" {
+			if err := validateHTTPURL(oidc.JWKSURL); err != nil {
+				errs = append(errs, field.Invalid(oidcPath.Child("jwksUrl"), oidc.JWKSURL, err.Error()))
+			}
+		}
+		headers := map[string]bool{}
+		for idx, forward := range oidc.ForwardClaims {
+			forwardPath := oidcPath.Child("forwardClaims").Index(idx)
+			if forward.Claim == "" {
+				errs = append(errs, field.Invalid(forwardPath.Child("claim"), forward.Claim, "the claim cannot be empty"))
+			}
+			header := http.CanonicalHeaderKey(forward.Header)
+			if !isHeaderName(forward.Header) {
+				errs = append(errs, field.Invalid(forwardPath.Child("header"), forward.Header, "the header is not a valid HTTP header name"))
+			} else if headers[header] {
+				errs = append(errs, field.Invalid(forwardPath.Child("header"), forward.Header,
+					fmt.Sprintf("header %v already forwards a claim", forward.Header)))
+			}
+			headers[header] = true
+		}
+	}
+
+	for idx, entrypoint := range spec.Entrypoints {
+		errs = append(errs, validateClaimRules(entrypoint.Claims, oidc != nil, fldPath.Child("entrypoints").Index(idx).Child("claims"))...)
+	}
+	nodeNames := getKeys(spec.Nodes)
+	slices.Sort(nodeNames)
+	for _, name := range nodeNames {
+		router := spec.Nodes[name]
+		for idx, step := range router.Steps {
+			claimsPath := fldPath.Child("nodes").Child(name).Child(fmt.Sprintf("steps[%d]", idx)).Child("claims")
+			if len(step.Claims) > 0 && router.RouterType != Switch {
+				errs = append(errs, field.Invalid(claimsPath,
+					step.Claims,
+					fmt.Sprintf("the claims of step %v are only supported in Switch nodes, not in %v node %v", step.StepName, router.RouterType, name)))
+				continue
+			}
+			errs = append(errs, validateClaimRules(step.Claims, oidc != nil, claimsPath)...)
+		}
+	}
+	return errs
+}
+
+// claim rules need a claim and values, and the router has to validate the tokens
+func validateClaimRules(rules []ClaimRule, hasOIDC bool, fldPath *field.Path) field.ErrorList {
+	if len(rules) == 0 {
+		return nil
+	}
+	if !hasOIDC {
+		return field.ErrorList{field.Invalid(fldPath, rules, "the claim rules need the oidc settings of the router config")}
+	}
+	var errs field.ErrorList
+	for idx, rule := range rules {
+		if rule.Claim == "" {
+			errs = append(errs, field.Invalid(fldPath.Index(idx).Child("claim"), rule.Claim, "the claim cannot be empty"))
+		}
+		if len(rule.Values) == 0 {
+			errs = append(errs, field.Invalid(fldPath.Index(idx).Child("values"), rule.Values,
+				fmt.Sprintf("the rule of claim %v needs at least one value", rule.Claim)))
+		}
+	}
+	return errs
+}
+
+func validateHTTPURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%v is not an http or https URL", value)
+	}
+	return nil
+}
+
+// isHeaderName checks the name is an HTTP token
+func isHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", c) {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// check the header policies of the steps name valid headers with a single source of value
+func validateHeaderPolicies(nodes map[string]Router, fldPath *field.Path) field.ErrorList {
+	nodeNames := getKeys(nodes)
+	slices.Sort(nodeNames)
+	var errs field.ErrorList
+
+	for _, name := range nodeNames {
+		for idx, step := range nodes[name].Steps {
+			if step.Headers == nil {
+				continue
+			}
+			headersPath := fldPath.Child(name).Child(fmt.Sprintf("steps[%d]", idx)).Child("headers")
+			for i, header := range step.Headers.Forward {
+				if err := validateHeaderName(header); err != "" {
+					errs = append(errs, field.Invalid(headersPath.Child("forward").Index(i), header, err))
+				}
+			}
+			set := map[string]bool{}
+			for i, header := range step.Headers.Set {
+				headerPath := headersPath.Child("set").Index(i)
+				if err := validateHeaderName(header.Name); err != "" {
+					errs = append(errs, field.Invalid(headerPath.Child("name"), header.Name, err))
+				} else if set[http.CanonicalHeaderKey(header.Name)] {
+					errs = append(errs, field.Invalid(headerPath.Child("name"), header.Name,
+						fmt.Sprintf("header %v is already set", header.Name)))
+				}
+				set[http.CanonicalHeaderKey(header.Name)] = true
+
+				switch {
+				case (header.Value != "") == (header.SecretKeyRef != nil):
+					errs = append(errs, field.Invalid(headerPath,
+						header.Name,
+						fmt.Sprintf("header %v must set exactly one of value or secretKeyRef", header.Name)))
+				case header.SecretKeyRef != nil:
+					ref := header.SecretKeyRef
+					if len(validation.IsDNS1123Subdomain(ref.Name)) > 0 || len(validation.IsConfigMapKey(ref.Key)) > 0 {
+						errs = append(errs, field.Invalid(headerPath.Child("secretKeyRef"),
+							ref,
+							fmt.Sprintf("the secret of header %v needs a valid secret name and key", header.Name)))
+					}
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// validateHeaderName returns why the header cannot be forwarded or set, "" when it can
+func validateHeaderName(name string) string {
+	if !isHeaderName(name) {
+		return "the header is not a valid HTTP header name"
+	}
+	if slices.Contains(managedHeaders, http.CanonicalHeaderKey(name)) {
+		return fmt.Sprintf("header %v is managed by the router", name)
+	}
+	return ""
+}
+
+// check the transports of the steps are set on service steps with a valid proxy and valid Secrets
+func validateTransports(nodes map[string]Router, fldPath *field.Path) field.ErrorList {
+	nodeNames := getKeys(nodes)
+	slices.Sort(nodeNames)
+	var errs field.ErrorList
+
+	for _, name := range nodeNames {
+		for idx, step := range nodes[name].Steps {
+			if step.Transport == nil {
+				continue
+			}
+			transportPath := fldPath.Child(name).Child(fmt.Sprintf("steps[%d]", idx)).Child("transport")
+			if step.NodeName != "" {
+				errs = append(errs, field.Invalid(transportPath,
+					step.StepName,
+					fmt.Sprintf("step %v routes to node %v and calls no service", step.StepName, step.NodeName)))
+				continue
+			}
+			if proxyURL := step.Transport.ProxyURL; proxyURL != "" {
+				if err := validateHTTPURL(proxyURL); err != nil {
+					errs = append(errs, field.Invalid(transportPath.Child("proxyURL"), proxyURL, err.Error()))
+				}
+			}
+			tls := step.Transport.TLS
+			if tls == nil {
+				continue
+			}
+			if ref := tls.CASecretRef; ref != nil {
+				if len(validation.IsDNS1123Subdomain(ref.Name)) > 0 || len(validation.IsConfigMapKey(ref.Key)) > 0 {
+					errs = append(errs, field.Invalid(transportPath.Child("tls").Child("caSecretRef"),
+						ref,
+						fmt.Sprintf("the CA bundle of step %v needs a valid secret name and key", step.StepName)))
+				}
+			}
+			if secret := tls.ClientCertSecretName; secret != "" && len(validation.IsDNS1123Subdomain(secret)) > 0 {
+				errs = append(errs, field.Invalid(transportPath.Child("tls").Child("clientCertSecretName"),
+					secret,
+					"the client certificate needs a valid secret name"))
+			}
+		}
+	}
+	return errs
+}
+
+// check the certificates live long enough to be renewed before they expire
+func validateMTLS(mtls *MTLS, fldPath *field.Path) field.ErrorList {
+	if mtls == nil {
+		return nil
+	}
+	var errs field.ErrorList
+	certDuration := DefaultMTLSCertDuration
+	if mtls.CertDuration != nil {
+		certDuration = mtls.CertDuration.Duration
+		if certDuration < MinMTLSCertDuration {
+			errs = append(errs, field.Invalid(fldPath.Child("certDuration"),
+				mtls.CertDuration.Duration.String(),
+				fmt.Sprintf("the certificates need a lifetime of at least %v", MinMTLSCertDuration)))
+		}
+	}
+	if mtls.RenewBefore != nil && (mtls.RenewBefore.Duration <= 0 || mtls.RenewBefore.Duration >= certDuration) {
+		errs = append(errs, field.Invalid(fldPath.Child("renewBefore"),
+			mtls.RenewBefore.Duration.String(),
+			"the certificates have to be renewed within their lifetime"))
+	}
+	return errs
+}
+
+// a fallback names exactly one existing node, internal service or external service
+func validateFallback(step Step, fldPath *field.Path, nodeNames []string) *field.Error {
+	fallback := step.Fallback
+	executors := 0
+	for _, set := range []bool{
+		fallback.NodeName != "",
+		fallback.InternalService.ServiceName != "",
+		fallback.ExternalService != "",
+	} {
+		if set {
+			executors++
+		}
+	}
+	if executors != 1 {
+		return field.Invalid(fldPath,
+			fallback,
+			fmt.Sprintf("the fallback of step %v must set exactly one of nodeName, internalService or externalService", step.StepName))
+	}
+	if !nodeNameExists(fallback.NodeName, nodeNames) {
+		return field.Invalid(fldPath.Child("nodeName"),
+			fallback,
+			fmt.Sprintf("node name: %v in the fallback of step %v does not exist", fallback.NodeName, step.StepName))
+	}
+	return nil
+}
+
+// the cache of a step needs a positive ttl and size, and plain dotted paths of the ignored fields
+func validateResponseCache(step Step, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	cache := step.Cache
+	if cache.TTL != nil && cache.TTL.Duration <= 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("ttl"),
+			cache.TTL,
+			fmt.Sprintf("the cache ttl of step %v must be positive", step.StepName)))
+	}
+	if cache.MaxEntries < 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("maxEntries"),
+			cache.MaxEntries,
+			fmt.Sprintf("the cache size of step %v cannot be negative", step.StepName)))
+	}
+	for i, path := range cache.IgnoreFields {
+		if path == "" || strings.ContainsAny(path, "#*?|@\\") || slices.Contains(strings.Split(path, "."), "") {
+			errs = append(errs, field.Invalid(fldPath.Child(fmt.Sprintf("ignoreFields[%d]", i)),
+				path,
+				fmt.Sprintf("the ignored field of the cache of step %v must be a dotted path", step.StepName)))
+		}
+	}
+	return errs
+}
+
+// the semantic cache needs a similarity threshold between 0 and 1, and a positive ttl and size
+func validateSemanticCache(semanticCache *SemanticCache, fldPath *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if semanticCache.Threshold != "" {
+		threshold, err := strconv.ParseFloat(semanticCache.Threshold, 64)
+		if err != nil || threshold < 0 || threshold > 1 {
+			errs = append(errs, field.Invalid(fldPath.Child("threshold"),
+				semanticCache.Threshold,
+				"the similarity threshold must be a decimal between 0 and 1"))
+		}
+	}
+	if semanticCache.TTL != nil && semanticCache.TTL.Duration <= 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("ttl"),
+			semanticCache.TTL,
+			"the semantic cache ttl must be positive"))
+	}
+	if semanticCache.MaxEntries < 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("maxEntries"),
+			semanticCache.MaxEntries,
+			"the semantic cache size cannot be negative"))
+	}
+	return errs
+}
+
+// a retry condition is a status code, a status class like 5xx or a connection failure
+func isValidRetryOn(retryOn string) bool {
+	if retryOn == RetryOnConnectFailure {
+		return true
+	}
+	if len(retryOn) == 3 && retryOn[1:] == "xx" {
+		return retryOn[0] >= '1' && retryOn[0] <= '5'
+	}
+	code, err := strconv.Atoi(retryOn)
+	return err == nil && code >= 100 && code <= 599
+}
+
 // +kubebuilder:docs-gen:collapse=Existing Validation
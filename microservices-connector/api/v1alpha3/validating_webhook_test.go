@@ -7,12 +7,17 @@ package v1alpha3
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	"testing"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	rt "k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/validation/field"
@@ -372,3 +377,1084 @@ func Test_validateNames(t *testing.T) {
 		})
 	}
 }
+
+func Test_validateStepPolicies(t *testing.T) {
+	var errs field.ErrorList
+	type args struct {
+		nodes   map[string]Router
+		fldPath *field.Path
+	}
+	tests := []struct {
+		name string
+		args args
+		want field.ErrorList
+	}{
+		{
+			name: "invalid retry condition",
+			args: args{
+				nodes: map[string]Router{
+					"root": {
+						Steps: []Step{
+							{
+								StepName: "Llm",
+								Retries:  2,
+								RetryOn:  []string{"503", "timeout"},
+							},
+						},
+					},
+				},
+				fldPath: field.NewPath("spec").Child("nodes"),
+			},
+			want: append(errs, field.Invalid(
+				field.NewPath("spec").Child("nodes").Child("root").Child("steps[0]").Child("retryOn[1]"),
+				"timeout",
+				"invalid retry condition: timeout in step Llm")),
+		},
+		{
+			name: "zero timeout",
+			args: args{
+				nodes: map[string]Router{
+					"root": {
+						Steps: []Step{
+							{
+								StepName: "Llm",
+								Timeout:  &metav1.Duration{},
+							},
+						},
+					},
+				},
+				fldPath: field.NewPath("spec").Child("nodes"),
+			},
+			want: append(errs, field.Invalid(
+				field.NewPath("spec").Child("nodes").Child("root").Child("steps[0]").Child("timeout"),
+				&metav1.Duration{},
+				"the timeout of step Llm must be positive")),
+		},
+		{
+			name: "fallback with two executors",
+			args: args{
+				nodes: map[string]Router{
+					"root": {
+						Steps: []Step{
+							{
+								StepName: "Llm",
+								Fallback: &Fallback{
+									Executor: Executor{
+										NodeName:        "root",
+										ExternalService: "http://llm.example.com",
+									},
+								},
+							},
+						},
+					},
+				},
+				fldPath: field.NewPath("spec").Child("nodes"),
+			},
+			want: append(errs, field.Invalid(
+				field.NewPath("spec").Child("nodes").Child("root").Child("steps[0]").Child("fallback"),
+				&Fallback{
+					Executor: Executor{
+						NodeName:        "root",
+						ExternalService: "http://llm.example.com",
+					},
+				},
+				"the fallback of step Llm must set exactly one of nodeName, internalService or externalService")),
+		},
+		{
+			name: "fallback to unknown node",
+			args: args{
+				nodes: map[string]Router{
+					"root": {
+						Steps: []Step{
+							{
+								StepName: "Llm",
+								Fallback: &Fallback{Executor: Executor{NodeName: "unknown"}},
+							},
+						},
+					},
+				},
+				fldPath: field.NewPath("spec").Child("nodes"),
+			},
+			want: append(errs, field.Invalid(
+				field.NewPath("spec").Child("nodes").Child("root").Child("steps[0]").Child("fallback").Child("nodeName"),
+				&Fallback{Executor: Executor{NodeName: "unknown"}},
+				"node name: unknown in the fallback of step Llm does not exist")),
+		},
+		{
+			name: "cache with an invalid ignored field",
+			args: args{
+				nodes: map[string]Router{
+					"root": {
+						Steps: []Step{
+							{
+								StepName: "Llm",
+								Cache:    &ResponseCache{IgnoreFields: []string{"streaming", "messages.#.id"}},
+							},
+						},
+					},
+				},
+				fldPath: field.NewPath("spec").Child("nodes"),
+			},
+			want: append(errs, field.Invalid(
+				field.NewPath("spec").Child("nodes").Child("root").Child("steps[0]").Child("cache").Child("ignoreFields[1]"),
+				"messages.#.id",
+				"the ignored field of the cache of step Llm must be a dotted path")),
+		},
+		{
+			name: "cache with zero ttl",
+			args: args{
+				nodes: map[string]Router{
+					"root": {
+						Steps: []Step{
+							{
+								StepName: "Llm",
+								Cache:    &ResponseCache{TTL: &metav1.Duration{}},
+							},
+						},
+					},
+				},
+				fldPath: field.NewPath("spec").Child("nodes"),
+			},
+			want: append(errs, field.Invalid(
+				field.NewPath("spec").Child("nodes").Child("root").Child("steps[0]").Child("cache").Child("ttl"),
+				&metav1.Duration{},
+				"the cache ttl of step Llm must be positive")),
+		},
+		{
+			name: "no error",
+			args: args{
+				nodes: map[string]Router{
+					"root": {
+						Steps: []Step{
+							{
+								StepName:     "Llm",
+								Timeout:      &metav1.Duration{Duration: 5 * time.Minute},
+								Retries:      3,
+								RetryBackoff: &metav1.Duration{Duration: time.Second},
+								RetryOn:      []string{"5xx", "429", RetryOnConnectFailure},
+								Cache: &ResponseCache{
+									TTL:          &metav1.Duration{Duration: time.Hour},
+									MaxEntries:   100,
+									IgnoreFields: []string{"streaming", "parameters.seed"},
+								},
+								Fallback: &Fallback{
+									Executor: Executor{
+										InternalService: GMCTarget{ServiceName: "llm-fallback"},
+									},
+								},
+							},
+						},
+					},
+				},
+				fldPath: field.NewPath("spec").Child("nodes"),
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateStepPolicies(tt.args.nodes, tt.args.fldPath); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateStepPolicies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateConditions(t *testing.T) {
+	nodesPath := field.NewPath("spec").Child("nodes")
+	tests := []struct {
+		name       string
+		nodes      map[string]Router
+		wantFields []string
+	}{
+		{
+			name: "gjson path conditions are not checked",
+			nodes: map[string]Router{
+				"root": {
+					RouterType: Switch,
+					Steps: []Step{
+						{StepName: "Llm", Condition: `instances.#(modelId=="1")`},
+						{StepName: "Llm", Condition: `modelId=="2"`},
+					},
+				},
+			},
+		},
+		{
+			name: "valid expressions",
+			nodes: map[string]Router{
+				"root": {
+					RouterType: Switch,
+					Steps: []Step{
+						{StepName: "Llm", Condition: `len($request.messages.0.content) > 2000 || $headers.X-Tier == "gold"`},
+					},
+				},
+				"node1": {
+					RouterType: Sequence,
+					Steps: []Step{
+						{StepName: "Llm", Condition: `$response.score >= 0.5 && $request.model in ["a", "b"]`},
+					},
+				},
+			},
+		},
+		{
+			name: "invalid expression",
+			nodes: map[string]Router{
+				"root": {
+					RouterType: Sequence,
+					Steps: []Step{
+						{StepName: "Embedding"},
+						{StepName: "Llm", Condition: `$request.model > "llama"`},
+					},
+				},
+			},
+			wantFields: []string{"spec.nodes.root.steps[1].condition"},
+		},
+		{
+			name: "response in a switch node",
+			nodes: map[string]Router{
+				"root": {
+					RouterType: Switch,
+					Steps: []Step{
+						{StepName: "Llm", Condition: `$response.label == "cat"`},
+					},
+				},
+			},
+			wantFields: []string{"spec.nodes.root.steps[0].condition"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateConditions(tt.nodes, nodesPath)
+			var fields []string
+			for _, err := range errs {
+				fields = append(fields, err.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("validateConditions() = %v, want errors on %v", errs, tt.wantFields)
+			}
+		})
+	}
+}
+
+func Test_validateStepData(t *testing.T) {
+	nodesPath := field.NewPath("spec").Child("nodes")
+	tests := []struct {
+		name       string
+		nodes      map[string]Router
+		wantFields []string
+	}{
+		{
+			name: "legacy and valid templates",
+			nodes: map[string]Router{
+				"root": {
+					RouterType: Sequence,
+					Steps: []Step{
+						{StepName: "Embedding", Data: `{"text":"$request.query"}`},
+						{StepName: "Retriever", Data: "$response"},
+						{StepName: "Llm", Data: `{"docs":"$response.docs","embedding":"$steps.Embedding.response.embedding"}`},
+					},
+				},
+			},
+		},
+		{
+			name: "invalid template",
+			nodes: map[string]Router{
+				"root": {
+					RouterType: Sequence,
+					Steps: []Step{
+						{StepName: "Llm", Data: `{"query":"$body.query"}`},
+					},
+				},
+			},
+			wantFields: []string{"spec.nodes.root.steps[0].data"},
+		},
+		{
+			name: "unknown step",
+			nodes: map[string]Router{
+				"root": {
+					RouterType: Sequence,
+					Steps: []Step{
+						{StepName: "Embedding"},
+						{StepName: "Llm", Data: `{"docs":"$steps.Retriever.response.docs"}`},
+					},
+				},
+			},
+			wantFields: []string{"spec.nodes.root.steps[1].data"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateStepData(tt.nodes, nodesPath)
+			var fields []string
+			for _, err := range errs {
+				fields = append(fields, err.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("validateStepData() = %v, want errors on %v", errs, tt.wantFields)
+			}
+		})
+	}
+}
+
+func Test_validateDependencies(t *testing.T) {
+	nodesPath := field.NewPath("spec").Child("nodes")
+	tests := []struct {
+		name       string
+		nodes      map[string]Router
+		wantFields []string
+	}{
+		{
+			name: "valid DAG",
+			nodes: map[string]Router{
+				"root": {
+					RouterType: DAG,
+					Steps: []Step{
+						{StepName: "Embedding"},
+						{StepName: "Retriever", DependsOn: []string{"Embedding"}},
+						{StepName: "WebRetriever"},
+						{StepName: "Reranking", DependsOn: []string{"Retriever", "WebRetriever"}},
+						{StepName: "Llm", DependsOn: []string{"Reranking"}},
+					},
+				},
+			},
+		},
+		{
+			name: "dependsOn outside of a DAG node",
+			nodes: map[string]Router{
+				"root": {
+					RouterType: Sequence,
+					Steps: []Step{
+						{StepName: "Embedding"},
+						{StepName: "Retriever", DependsOn: []string{"Embedding"}},
+					},
+				},
+			},
+			wantFields: []string{"spec.nodes.root.steps[1].dependsOn"},
+		},
+		{
+			name: "duplicated step name and unknown dependency",
+			nodes: map[string]Router{
+				"root": {
+					RouterType: DAG,
+					Steps: []Step{
+						{StepName: "Retriever"},
+						{StepName: "Retriever", DependsOn: []string{"Embedding"}},
+					},
+				},
+			},
+			wantFields: []string{"spec.nodes.root.steps[1].name", "spec.nodes.root.steps[1].dependsOn[0]"},
+		},
+		{
+			name: "cycle",
+			nodes: map[string]Router{
+				"root": {
+					RouterType: DAG,
+					Steps: []Step{
+						{StepName: "Embedding", DependsOn: []string{"Llm"}},
+						{StepName: "Retriever", DependsOn: []string{"Embedding"}},
+						{StepName: "Llm", DependsOn: []string{"Retriever"}},
+					},
+				},
+			},
+			wantFields: []string{"spec.nodes.root.steps"},
+		},
+		{
+			name: "self dependency",
+			nodes: map[string]Router{
+				"root": {
+					RouterType: DAG,
+					Steps: []Step{
+						{StepName: "Llm", DependsOn: []string{"Llm"}},
+					},
+				},
+			},
+			wantFields: []string{"spec.nodes.root.steps"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateDependencies(tt.nodes, nodesPath)
+			var fields []string
+			for _, err := range errs {
+				fields = append(fields, err.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("validateDependencies() = %v, want errors on %v", errs, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestFindDAGCycle(t *testing.T) {
+	steps := []Step{
+		{StepName: "Embedding"},
+		{StepName: "Retriever", DependsOn: []string{"Embedding", "Llm"}},
+		{StepName: "Reranking", DependsOn: []string{"Retriever", "Unknown"}},
+		{StepName: "Llm", DependsOn: []string{"Reranking"}},
+	}
+	want := []string{"Retriever", "Llm", "Reranking", "Retriever"}
+	if cycle := FindDAGCycle(steps); !reflect.DeepEqual(cycle, want) {
+		t.Errorf("FindDAGCycle() = %v, want %v", cycle, want)
+	}
+
+	steps[1].DependsOn = []string{"Embedding"}
+	if cycle := FindDAGCycle(steps); cycle != nil {
+		t.Errorf("FindDAGCycle() = %v, want no cycle", cycle)
+	}
+}
+
+func Test_validateMergeStrategies(t *testing.T) {
+	nodesPath := field.NewPath("spec").Child("nodes")
+	steps := []Step{{StepName: "Retriever"}, {StepName: "WebRetriever"}, {StepName: "Llm"}}
+	tests := []struct {
+		name       string
+		router     Router
+		wantFields []string
+	}{
+		{
+			name:   "no merge strategy",
+			router: Router{RouterType: Ensemble, Steps: steps},
+		},
+		{
+			name: "valid strategies",
+			router: Router{RouterType: Ensemble, Steps: steps, MergeStrategy: &MergeStrategy{
+				Type:     MergeQuorum,
+				Quorum:   2,
+				Deadline: &metav1.Duration{Duration: 5 * time.Second},
+			}},
+		},
+		{
+			name:       "not an Ensemble node",
+			router:     Router{RouterType: Sequence, Steps: steps, MergeStrategy: &MergeStrategy{Type: MergeFirstSuccess}},
+			wantFields: []string{"spec.nodes.root.mergeStrategy"},
+		},
+		{
+			name:       "concatArray without path",
+			router:     Router{RouterType: Ensemble, Steps: steps, MergeStrategy: &MergeStrategy{Type: MergeConcatArray}},
+			wantFields: []string{"spec.nodes.root.mergeStrategy.path"},
+		},
+		{
+			name: "concatArray with a gjson query",
+			router: Router{RouterType: Ensemble, Steps: steps, MergeStrategy: &MergeStrategy{
+				Type: MergeConcatArray,
+				Path: "docs.#.text",
+			}},
+			wantFields: []string{"spec.nodes.root.mergeStrategy.path"},
+		},
+		{
+			name:       "scoreMax without path",
+			router:     Router{RouterType: Ensemble, Steps: steps, MergeStrategy: &MergeStrategy{Type: MergeScoreMax}},
+			wantFields: []string{"spec.nodes.root.mergeStrategy.path"},
+		},
+		{
+			name:       "quorum larger than the steps",
+			router:     Router{RouterType: Ensemble, Steps: steps, MergeStrategy: &MergeStrategy{Type: MergeQuorum, Quorum: 4}},
+			wantFields: []string{"spec.nodes.root.mergeStrategy.quorum"},
+		},
+		{
+			name:       "quorum of another strategy",
+			router:     Router{RouterType: Ensemble, Steps: steps, MergeStrategy: &MergeStrategy{Type: MergeMap, Quorum: 2}},
+			wantFields: []string{"spec.nodes.root.mergeStrategy.quorum"},
+		},
+		{
+			name: "negative deadline",
+			router: Router{RouterType: Ensemble, Steps: steps, MergeStrategy: &MergeStrategy{
+				Deadline: &metav1.Duration{Duration: -time.Second},
+			}},
+			wantFields: []string{"spec.nodes.root.mergeStrategy.deadline"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateMergeStrategies(map[string]Router{"root": tt.router}, nodesPath)
+			var fields []string
+			for _, err := range errs {
+				fields = append(fields, err.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("validateMergeStrategies() = %v, want errors on %v", errs, tt.wantFields)
+			}
+		})
+	}
+}
+
+func Test_validateSplitters(t *testing.T) {
+	nodesPath := field.NewPath("spec").Child("nodes")
+	tests := []struct {
+		name       string
+		router     Router
+		wantFields []string
+	}{
+		{
+			name: "valid splitter",
+			router: Router{
+				RouterType: Splitter,
+				Steps:      []Step{{StepName: "Llm", Weight: 90}, {StepName: "Llm", Weight: 10}},
+				Sticky:     &Sticky{Header: "X-User-Id"},
+			},
+		},
+		{
+			name: "weight and sticky outside of a Splitter node",
+			router: Router{
+				RouterType: Sequence,
+				Steps:      []Step{{StepName: "Llm", Weight: 10}},
+				Sticky:     &Sticky{Field: "user"},
+			},
+			wantFields: []string{"spec.nodes.root.steps[0].weight", "spec.nodes.root.sticky"},
+		},
+		{
+			name: "no positive weight",
+			router: Router{
+				RouterType: Splitter,
+				Steps:      []Step{{StepName: "Llm"}, {StepName: "Llm", Weight: -1}},
+			},
+			wantFields: []string{"spec.nodes.root.steps[1].weight", "spec.nodes.root.steps"},
+		},
+		{
+			name: "weights over the int32 range",
+			router: Router{
+				RouterType: Splitter,
+				Steps:      []Step{{StepName: "Llm", Weight: math.MaxInt32}, {StepName: "Llm", Weight: math.MaxInt32}},
+			},
+		},
+		{
+			name: "condition in a splitter step",
+			router: Router{
+				RouterType: Splitter,
+				Steps:      []Step{{StepName: "Llm", Weight: 1, Condition: "$headers.X-Tier == \"gold\""}},
+			},
+			wantFields: []string{"spec.nodes.root.steps[0].condition"},
+		},
+		{
+			name: "sticky with header and field",
+			router: Router{
+				RouterType: Splitter,
+				Steps:      []Step{{StepName: "Llm", Weight: 1}},
+				Sticky:     &Sticky{Header: "X-User-Id", Field: "user"},
+			},
+			wantFields: []string{"spec.nodes.root.sticky"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateSplitters(map[string]Router{"root": tt.router}, nodesPath)
+			var fields []string
+			for _, err := range errs {
+				fields = append(fields, err.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("validateSplitters() = %v, want errors on %v", errs, tt.wantFields)
+			}
+		})
+	}
+}
+
+func Test_validateMirrors(t *testing.T) {
+	nodesPath := field.NewPath("spec").Child("nodes")
+	tests := []struct {
+		name       string
+		router     Router
+		wantFields []string
+	}{
+		{
+			name:   "mirror in a Sequence node",
+			router: Router{RouterType: Sequence, Steps: []Step{{StepName: "Reranking", Mirror: true}, {StepName: "Reranking"}}},
+		},
+		{
+			name:   "mirror in a Switch node",
+			router: Router{RouterType: Switch, Steps: []Step{{StepName: "Llm", Mirror: true}, {StepName: "Llm"}}},
+		},
+		{
+			name:       "mirror in an Ensemble node",
+			router:     Router{RouterType: Ensemble, Steps: []Step{{StepName: "Llm"}, {StepName: "Llm", Mirror: true}}},
+			wantFields: []string{"spec.nodes.root.steps[1].mirror"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateMirrors(map[string]Router{"root": tt.router}, nodesPath)
+			var fields []string
+			for _, err := range errs {
+				fields = append(fields, err.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("validateMirrors() = %v, want errors on %v", errs, tt.wantFields)
+			}
+		})
+	}
+}
+
+func Test_validateSemanticCaches(t *testing.T) {
+	nodesPath := field.NewPath("spec").Child("nodes")
+	tests := []struct {
+		name       string
+		nodes      map[string]Router
+		wantFields []string
+	}{
+		{
+			name: "semantic cache before the embedding",
+			nodes: map[string]Router{"root": {RouterType: Sequence, Steps: []Step{
+				{StepName: "SemanticCache", SemanticCache: &SemanticCache{
+					Threshold:  "0.9",
+					TTL:        &metav1.Duration{Duration: time.Hour},
+					MaxEntries: 100,
+				}},
+				{StepName: "Embedding"},
+				{StepName: "Llm"},
+			}}},
+		},
+		{
+			name: "embedding in another node",
+			nodes: map[string]Router{
+				"root":      {RouterType: Sequence, Steps: []Step{{StepName: "SemanticCache"}, {StepName: "Llm", Executor: Executor{NodeName: "embedding"}}}},
+				"embedding": {RouterType: Sequence, Steps: []Step{{StepName: "Embedding"}}},
+			},
+		},
+		{
+			name:       "no embedding step",
+			nodes:      map[string]Router{"root": {RouterType: Sequence, Steps: []Step{{StepName: "SemanticCache"}, {StepName: "Llm"}}}},
+			wantFields: []string{"spec.nodes.root.steps[0].name"},
+		},
+		{
+			name: "semantic cache in a Switch node",
+			nodes: map[string]Router{"root": {RouterType: Switch, Steps: []Step{
+				{StepName: "Embedding"},
+				{StepName: "SemanticCache"},
+			}}},
+			wantFields: []string{"spec.nodes.root.steps[1].name"},
+		},
+		{
+			name: "semantic cache with a service",
+			nodes: map[string]Router{"root": {RouterType: Sequence, Steps: []Step{
+				{StepName: "SemanticCache", Executor: Executor{ExternalService: "http://cache"}},
+				{StepName: "Embedding"},
+			}}},
+			wantFields: []string{"spec.nodes.root.steps[0]"},
+		},
+		{
+			name: "semantic cache settings of another step",
+			nodes: map[string]Router{"root": {RouterType: Sequence, Steps: []Step{
+				{StepName: "Embedding", SemanticCache: &SemanticCache{}},
+			}}},
+			wantFields: []string{"spec.nodes.root.steps[0].semanticCache"},
+		},
+		{
+			name: "invalid semantic cache settings",
+			nodes: map[string]Router{"root": {RouterType: Sequence, Steps: []Step{
+				{StepName: "SemanticCache", SemanticCache: &SemanticCache{
+					Threshold:  "1.5",
+					TTL:        &metav1.Duration{},
+					MaxEntries: -1,
+				}},
+				{StepName: "Embedding"},
+			}}},
+			wantFields: []string{
+				"spec.nodes.root.steps[0].semanticCache.threshold",
+				"spec.nodes.root.steps[0].semanticCache.ttl",
+				"spec.nodes.root.steps[0].semanticCache.maxEntries",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateSemanticCaches(tt.nodes, nodesPath)
+			var fields []string
+			for _, err := range errs {
+				fields = append(fields, err.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("validateSemanticCaches() = %v, want errors on %v", errs, tt.wantFields)
+			}
+		})
+	}
+}
+
+func Test_validateDataPreps(t *testing.T) {
+	nodesPath := field.NewPath("spec").Child("nodes")
+	size := resource.MustParse("100Mi")
+	zero := resource.MustParse("0")
+	tests := []struct {
+		name        string
+		nodes       map[string]Router
+		entrypoints []Entrypoint
+		wantFields  []string
+	}{
+		{
+			name: "default and declared routes",
+			nodes: map[string]Router{
+				"root": {RouterType: Sequence, Steps: []Step{{StepName: "DataPrep"}}},
+				"docs": {RouterType: Sequence, Steps: []Step{{StepName: "DataPrep", DataPrep: &DataPrep{
+					Path:           "/v1/dataprep",
+					MaxRequestSize: &size,
+					Operations: []DataPrepOperation{
+						{Name: "ingest", Timeout: &metav1.Duration{Duration: 10 * time.Minute}},
+						{Name: "get_file", Timeout: &metav1.Duration{Duration: 30 * time.Second}},
+					},
+				}}}},
+			},
+			entrypoints: []Entrypoint{{Name: "chat", Path: "/v1/chatqna", NodeName: "root"}},
+		},
+		{
+			name: "two default routes",
+			nodes: map[string]Router{
+				"a": {RouterType: Sequence, Steps: []Step{{StepName: "DataPrep"}}},
+				"b": {RouterType: Sequence, Steps: []Step{{StepName: "DataPrep", DataPrep: &DataPrep{}}}},
+			},
+			wantFields: []string{"spec.nodes.b.steps[0].dataPrep.path"},
+		},
+		{
+			name: "reserved path",
+			nodes: map[string]Router{"root": {RouterType: Sequence, Steps: []Step{
+				{StepName: "DataPrep", DataPrep: &DataPrep{Path: "/metrics"}},
+			}}},
+			wantFields: []string{"spec.nodes.root.steps[0].dataPrep.path"},
+		},
+		{
+			name: "root path",
+			nodes: map[string]Router{"root": {RouterType: Sequence, Steps: []Step{
+				{StepName: "DataPrep", DataPrep: &DataPrep{Path: "/"}},
+			}}},
+			wantFields: []string{"spec.nodes.root.steps[0].dataPrep.path"},
+		},
+		{
+			name: "path of an entrypoint",
+			nodes: map[string]Router{"root": {RouterType: Sequence, Steps: []Step{
+				{StepName: "DataPrep", DataPrep: &DataPrep{Path: "/v1"}},
+			}}},
+			entrypoints: []Entrypoint{{Name: "chat", Path: "/v1/chatqna", NodeName: "root"}},
+			wantFields:  []string{"spec.nodes.root.steps[0].dataPrep.path"},
+		},
+		{
+			name: "dataprep route of another step",
+			nodes: map[string]Router{"root": {RouterType: Sequence, Steps: []Step{
+				{StepName: "Retriever", DataPrep: &DataPrep{}},
+			}}},
+			wantFields: []string{"spec.nodes.root.steps[0].dataPrep"},
+		},
+		{
+			name: "invalid dataprep settings",
+			nodes: map[string]Router{"root": {RouterType: Sequence, Steps: []Step{
+				{StepName: "DataPrep", DataPrep: &DataPrep{
+					MaxRequestSize: &zero,
+					Operations: []DataPrepOperation{
+						{Name: "upload"},
+						{Name: "get_file", Timeout: &metav1.Duration{}},
+						{Name: "get_file", MaxRequestSize: &zero},
+					},
+				}},
+			}}},
+			wantFields: []string{
+				"spec.nodes.root.steps[0].dataPrep.maxRequestSize",
+				"spec.nodes.root.steps[0].dataPrep.operations[0].name",
+				"spec.nodes.root.steps[0].dataPrep.operations[1].timeout",
+				"spec.nodes.root.steps[0].dataPrep.operations[2].name",
+				"spec.nodes.root.steps[0].dataPrep.operations[2].maxRequestSize",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateDataPreps(tt.nodes, tt.entrypoints, nodesPath)
+			var fields []string
+			for _, err := range errs {
+				fields = append(fields, err.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("validateDataPreps() = %v, want errors on %v", errs, tt.wantFields)
+			}
+		})
+	}
+}
+
+func Test_validateAuthentication(t *testing.T) {
+	oidc := &OIDC{
+		Issuer:        "https://keycloak.example.com/realms/opea",
+		Audiences:     []string{"chatqna"},
+		ForwardClaims: []ClaimHeader{{Claim: "sub", Header: "X-User"}},
+	}
+	groups := []ClaimRule{{Claim: "groups", Values: []string{"admin"}}}
+	tests := []struct {
+		name       string
+		spec       GMConnectorSpec
+		wantFields []string
+	}{
+		{
+			name: "claim rules with oidc",
+			spec: GMConnectorSpec{
+				RouterConfig: RouterConfig{OIDC: oidc},
+				Entrypoints:  []Entrypoint{{Name: "admin", Path: "/v1/admin", NodeName: "root", Claims: groups}},
+				Nodes: map[string]Router{"root": {RouterType: Switch, Steps: []Step{
+					{StepName: "Llm", Claims: groups},
+					{StepName: "Retriever"},
+				}}},
+			},
+		},
+		{
+			name: "claim rules without oidc",
+			spec: GMConnectorSpec{
+				Entrypoints: []Entrypoint{{Name: "admin", Path: "/v1/admin", NodeName: "root", Claims: groups}},
+				Nodes:       map[string]Router{"root": {RouterType: Switch, Steps: []Step{{StepName: "Llm", Claims: groups}}}},
+			},
+			wantFields: []string{"spec.entrypoints[0].claims", "spec.nodes.root.steps[0].claims"},
+		},
+		{
+			name: "claim rules in a Sequence node",
+			spec: GMConnectorSpec{
+				RouterConfig: RouterConfig{OIDC: oidc},
+				Nodes:        map[string]Router{"root": {RouterType: Sequence, Steps: []Step{{StepName: "Llm", Claims: groups}}}},
+			},
+			wantFields: []string{"spec.nodes.root.steps[0].claims"},
+		},
+		{
+			name: "invalid claim rule",
+			spec: GMConnectorSpec{
+				RouterConfig: RouterConfig{OIDC: oidc},
+				Entrypoints:  []Entrypoint{{Name: "admin", Path: "/v1/admin", NodeName: "root", Claims: []ClaimRule{{}}}},
+			},
+			wantFields: []string{"spec.entrypoints[0].claims[0].claim", "spec.entrypoints[0].claims[0].values"},
+		},
+		{
+			name: "invalid oidc settings",
+			spec: GMConnectorSpec{
+				RouterConfig: RouterConfig{OIDC: &OIDC{
+					Issuer:  "keycloak",
+					JWKSURL: "ftp://keycloak/certs",
+					ForwardClaims: []ClaimHeader{
+						{Claim: "sub", Header: "X-User"},
+						{Claim: "email", Header: "x-user"},
+						{Header: "X User"},
+					},
+				}},
+			},
+			wantFields: []string{
+				"spec.routerConfig.oidc.issuer",
+				"spec.routerConfig.oidc.jwksUrl",
+				"spec.routerConfig.oidc.forwardClaims[1].header",
+				"spec.routerConfig.oidc.forwardClaims[2].claim",
+				"spec.routerConfig.oidc.forwardClaims[2].header",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateAuthentication(&tt.spec, field.NewPath("spec"))
+			var fields []string
+			for _, err := range errs {
+				fields = append(fields, err.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("validateAuthentication() = %v, want errors on %v", errs, tt.wantFields)
+			}
+		})
+	}
+}
+
+func Test_validateHeaderPolicies(t *testing.T) {
+	nodesPath := field.NewPath("spec").Child("nodes")
+	apiKey := &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "llm-api"}, Key: "key"}
+	tests := []struct {
+		name       string
+		headers    *HeaderPolicy
+		wantFields []string
+	}{
+		{
+			name: "forwarded, static and secret headers",
+			headers: &HeaderPolicy{
+				Forward: []string{"Authorization", "x-request-id"},
+				Set: []HeaderValue{
+					{Name: "X-Tenant", Value: "opea"},
+					{Name: "Api-Key", SecretKeyRef: apiKey},
+				},
+			},
+		},
+		{
+			name:       "invalid forwarded headers",
+			headers:    &HeaderPolicy{Forward: []string{"X Request", "content-length"}},
+			wantFields: []string{"spec.nodes.root.steps[0].headers.forward[0]", "spec.nodes.root.steps[0].headers.forward[1]"},
+		},
+		{
+			name: "invalid set headers",
+			headers: &HeaderPolicy{Set: []HeaderValue{
+				{Name: "Api-Key", Value: "opea"},
+				{Name: "api-key", SecretKeyRef: apiKey},
+				{Name: "X-Tenant"},
+				{Name: "X-Token", Value: "opea", SecretKeyRef: apiKey},
+				{Name: "X-Key", SecretKeyRef: &corev1.SecretKeySelector{Key: "key"}},
+				{Name: "X-Secret", SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: apiKey.LocalObjectReference, Key: "../key"}},
+			}},
+			wantFields: []string{
+				"spec.nodes.root.steps[0].headers.set[1].name",
+				"spec.nodes.root.steps[0].headers.set[2]",
+				"spec.nodes.root.steps[0].headers.set[3]",
+				"spec.nodes.root.steps[0].headers.set[4].secretKeyRef",
+				"spec.nodes.root.steps[0].headers.set[5].secretKeyRef",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := map[string]Router{"root": {RouterType: Sequence, Steps: []Step{{StepName: "Llm", Headers: tt.headers}}}}
+			errs := validateHeaderPolicies(nodes, nodesPath)
+			var fields []string
+			for _, err := range errs {
+				fields = append(fields, err.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("validateHeaderPolicies() = %v, want errors on %v", errs, tt.wantFields)
+			}
+		})
+	}
+}
+
+func Test_validateTransports(t *testing.T) {
+	nodesPath := field.NewPath("spec").Child("nodes")
+	ca := &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "llm-ca"}, Key: "ca.crt"}
+	tests := []struct {
+		name       string
+		step       Step
+		wantFields []string
+	}{
+		{
+			name: "proxy and mTLS",
+			step: Step{StepName: "Llm", Transport: &Transport{
+				ProxyURL: "http://proxy.example.com:912",
+				NoProxy:  ".svc.cluster.local",
+				TLS:      &TransportTLS{CASecretRef: ca, ClientCertSecretName: "router-client"},
+			}},
+		},
+		{
+			name:       "node step",
+			step:       Step{StepName: "Llm", Executor: Executor{NodeName: "llm"}, Transport: &Transport{DisableHTTP2: true}},
+			wantFields: []string{"spec.nodes.root.steps[0].transport"},
+		},
+		{
+			name: "invalid proxy and secrets",
+			step: Step{StepName: "Llm", Transport: &Transport{
+				ProxyURL: "http://",
+				TLS: &TransportTLS{
+					CASecretRef:          &corev1.SecretKeySelector{LocalObjectReference: ca.LocalObjectReference},
+					ClientCertSecretName: "Router_Client",
+				},
+			}},
+			wantFields: []string{
+				"spec.nodes.root.steps[0].transport.proxyURL",
+				"spec.nodes.root.steps[0].transport.tls.caSecretRef",
+				"spec.nodes.root.steps[0].transport.tls.clientCertSecretName",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := map[string]Router{"root": {RouterType: Sequence, Steps: []Step{tt.step}}}
+			errs := validateTransports(nodes, nodesPath)
+			var fields []string
+			for _, err := range errs {
+				fields = append(fields, err.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("validateTransports() = %v, want errors on %v", errs, tt.wantFields)
+			}
+		})
+	}
+}
+
+func Test_validateMTLS(t *testing.T) {
+	mtlsPath := field.NewPath("spec").Child("mtls")
+	tests := []struct {
+		name       string
+		mtls       *MTLS
+		wantFields []string
+	}{
+		{name: "no mtls"},
+		{name: "defaults", mtls: &MTLS{}},
+		{name: "renewal within the default lifetime", mtls: &MTLS{RenewBefore: &metav1.Duration{Duration: 30 * 24 * time.Hour}}},
+		{
+			name:       "short lifetime",
+			mtls:       &MTLS{CertDuration: &metav1.Duration{Duration: time.Minute}},
+			wantFields: []string{"spec.mtls.certDuration"},
+		},
+		{
+			name: "renewal after the expiration",
+			mtls: &MTLS{
+				CertDuration: &metav1.Duration{Duration: 24 * time.Hour},
+				RenewBefore:  &metav1.Duration{Duration: 48 * time.Hour},
+			},
+			wantFields: []string{"spec.mtls.renewBefore"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateMTLS(tt.mtls, mtlsPath)
+			var fields []string
+			for _, err := range errs {
+				fields = append(fields, err.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("validateMTLS() = %v, want errors on %v", errs, tt.wantFields)
+			}
+		})
+	}
+}
+
+func Test_validateEntrypoints(t *testing.T) {
+	entrypointsPath := field.NewPath("spec").Child("entrypoints")
+	nodes := map[string]Router{"chat": {}, "retrieval": {}, "embedding": {}}
+	tests := []struct {
+		name        string
+		entrypoints []Entrypoint
+		wantFields  []string
+	}{
+		{
+			name: "entrypoints of a ChatQnA graph",
+			entrypoints: []Entrypoint{
+				{Name: "chat", Path: "/v1/chatqna", NodeName: "chat"},
+				{Name: "retrieval", Path: "/v1/retrieval", Method: "POST", NodeName: "retrieval"},
+				{Name: "embedding", Path: "/v1/embedding", NodeName: "embedding"},
+				{Name: "chat-get", Path: "/v1/chatqna", Method: "GET", NodeName: "chat"},
+			},
+		},
+		{
+			name: "duplicated names",
+			entrypoints: []Entrypoint{
+				{Name: "chat", Path: "/v1/chatqna", NodeName: "chat"},
+				{Name: "chat", Path: "/v1/chat", NodeName: "chat"},
+				{Path: "/v1/retrieval", NodeName: "retrieval"},
+			},
+			wantFields: []string{"spec.entrypoints[1].name", "spec.entrypoints[2].name"},
+		},
+		{
+			name: "duplicated paths",
+			entrypoints: []Entrypoint{
+				{Name: "chat", Path: "/v1/chatqna", NodeName: "chat"},
+				{Name: "retrieval", Path: "/v1/chatqna", Method: "POST", NodeName: "retrieval"},
+			},
+			wantFields: []string{"spec.entrypoints[1].path"},
+		},
+		{
+			name: "invalid and reserved paths",
+			entrypoints: []Entrypoint{
+				{Name: "chat", Path: "v1/chatqna", NodeName: "chat"},
+				{Name: "metrics", Path: "/metrics", NodeName: "chat"},
+				{Name: "assets", Path: "/assets/logo.png", NodeName: "chat"},
+			},
+			wantFields: []string{"spec.entrypoints[0].path", "spec.entrypoints[1].path", "spec.entrypoints[2].path"},
+		},
+		{
+			name:        "unknown node",
+			entrypoints: []Entrypoint{{Name: "chat", Path: "/v1/chatqna", NodeName: "root"}},
+			wantFields:  []string{"spec.entrypoints[0].nodeName"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := validateEntrypoints(tt.entrypoints, nodes, entrypointsPath)
+			var fields []string
+			for _, err := range errs {
+				fields = append(fields, err.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("validateEntrypoints() = %v, want errors on %v", errs, tt.wantFields)
+			}
+		})
+	}
+}
@@ -10,9 +10,120 @@
 package v1alpha3
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClaimHeader) DeepCopyInto(out *ClaimHeader) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClaimHeader.
+func (in *ClaimHeader) DeepCopy() *ClaimHeader {
+	if in == nil {
+		return nil
+	}
+	out := new(ClaimHeader)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClaimRule) DeepCopyInto(out *ClaimRule) {
+	*out = *in
+	if in.Values != nil {
+		in, out := &in.Values, &out.Values
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClaimRule.
+func (in *ClaimRule) DeepCopy() *ClaimRule {
+	if in == nil {
+		return nil
+	}
+	out := new(ClaimRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DataPrep) DeepCopyInto(out *DataPrep) {
+	*out = *in
+	if in.MaxRequestSize != nil {
+		in, out := &in.MaxRequestSize, &out.MaxRequestSize
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.Operations != nil {
+		in, out := &in.Operations, &out.Operations
+		*out = make([]DataPrepOperation, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DataPrep.
+func (in *DataPrep) DeepCopy() *DataPrep {
+	if in == nil {
+		return nil
+	}
+	out := new(DataPrep)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DataPrepOperation) DeepCopyInto(out *DataPrepOperation) {
+	*out = *in
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MaxRequestSize != nil {
+		in, out := &in.MaxRequestSize, &out.MaxRequestSize
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DataPrepOperation.
+func (in *DataPrepOperation) DeepCopy() *DataPrepOperation {
+	if in == nil {
+		return nil
+	}
+	out := new(DataPrepOperation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Entrypoint) DeepCopyInto(out *Entrypoint) {
+	*out = *in
+	if in.Claims != nil {
+		in, out := &in.Claims, &out.Claims
+		*out = make([]ClaimRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Entrypoint.
+func (in *Entrypoint) DeepCopy() *Entrypoint {
+	if in == nil {
+		return nil
+	}
+	out := new(Entrypoint)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Executor) DeepCopyInto(out *Executor) {
 	*out = *in
@@ -29,6 +140,22 @@ func (in *Executor) DeepCopy() *Executor {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Fallback) DeepCopyInto(out *Fallback) {
+	*out = *in
+	in.Executor.DeepCopyInto(&out.Executor)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Fallback.
+func (in *Fallback) DeepCopy() *Fallback {
+	if in == nil {
+		return nil
+	}
+	out := new(Fallback)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GMCTarget) DeepCopyInto(out *GMCTarget) {
 	*out = *in
@@ -137,6 +264,18 @@ func (in *GMConnectorSpec) DeepCopyInto(out *GMConnectorSpec) {
 		}
 	}
 	in.RouterConfig.DeepCopyInto(&out.RouterConfig)
+	if in.Entrypoints != nil {
+		in, out := &in.Entrypoints, &out.Entrypoints
+		*out = make([]Entrypoint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.MTLS != nil {
+		in, out := &in.MTLS, &out.MTLS
+		*out = new(MTLS)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GMConnectorSpec.
@@ -172,6 +311,148 @@ func (in *GMConnectorStatus) DeepCopy() *GMConnectorStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HeaderPolicy) DeepCopyInto(out *HeaderPolicy) {
+	*out = *in
+	if in.Forward != nil {
+		in, out := &in.Forward, &out.Forward
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Set != nil {
+		in, out := &in.Set, &out.Set
+		*out = make([]HeaderValue, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HeaderPolicy.
+func (in *HeaderPolicy) DeepCopy() *HeaderPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(HeaderPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HeaderValue) DeepCopyInto(out *HeaderValue) {
+	*out = *in
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HeaderValue.
+func (in *HeaderValue) DeepCopy() *HeaderValue {
+	if in == nil {
+		return nil
+	}
+	out := new(HeaderValue)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MTLS) DeepCopyInto(out *MTLS) {
+	*out = *in
+	if in.CertDuration != nil {
+		in, out := &in.CertDuration, &out.CertDuration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.RenewBefore != nil {
+		in, out := &in.RenewBefore, &out.RenewBefore
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MTLS.
+func (in *MTLS) DeepCopy() *MTLS {
+	if in == nil {
+		return nil
+	}
+	out := new(MTLS)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MergeStrategy) DeepCopyInto(out *MergeStrategy) {
+	*out = *in
+	if in.Deadline != nil {
+		in, out := &in.Deadline, &out.Deadline
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MergeStrategy.
+func (in *MergeStrategy) DeepCopy() *MergeStrategy {
+	if in == nil {
+		return nil
+	}
+	out := new(MergeStrategy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OIDC) DeepCopyInto(out *OIDC) {
+	*out = *in
+	if in.Audiences != nil {
+		in, out := &in.Audiences, &out.Audiences
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ForwardClaims != nil {
+		in, out := &in.ForwardClaims, &out.ForwardClaims
+		*out = make([]ClaimHeader, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OIDC.
+func (in *OIDC) DeepCopy() *OIDC {
+	if in == nil {
+		return nil
+	}
+	out := new(OIDC)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ResponseCache) DeepCopyInto(out *ResponseCache) {
+	*out = *in
+	if in.TTL != nil {
+		in, out := &in.TTL, &out.TTL
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.IgnoreFields != nil {
+		in, out := &in.IgnoreFields, &out.IgnoreFields
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ResponseCache.
+func (in *ResponseCache) DeepCopy() *ResponseCache {
+	if in == nil {
+		return nil
+	}
+	out := new(ResponseCache)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Router) DeepCopyInto(out *Router) {
 	*out = *in
@@ -182,6 +463,16 @@ func (in *Router) DeepCopyInto(out *Router) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.MergeStrategy != nil {
+		in, out := &in.MergeStrategy, &out.MergeStrategy
+		*out = new(MergeStrategy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Sticky != nil {
+		in, out := &in.Sticky, &out.Sticky
+		*out = new(Sticky)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Router.
@@ -204,6 +495,11 @@ func (in *RouterConfig) DeepCopyInto(out *RouterConfig) {
 			(*out)[key] = val
 		}
 	}
+	if in.OIDC != nil {
+		in, out := &in.OIDC, &out.OIDC
+		*out = new(OIDC)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RouterConfig.
@@ -216,10 +512,87 @@ func (in *RouterConfig) DeepCopy() *RouterConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SemanticCache) DeepCopyInto(out *SemanticCache) {
+	*out = *in
+	if in.TTL != nil {
+		in, out := &in.TTL, &out.TTL
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SemanticCache.
+func (in *SemanticCache) DeepCopy() *SemanticCache {
+	if in == nil {
+		return nil
+	}
+	out := new(SemanticCache)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Step) DeepCopyInto(out *Step) {
 	*out = *in
 	in.Executor.DeepCopyInto(&out.Executor)
+	if in.DependsOn != nil {
+		in, out := &in.DependsOn, &out.DependsOn
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Claims != nil {
+		in, out := &in.Claims, &out.Claims
+		*out = make([]ClaimRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.RetryBackoff != nil {
+		in, out := &in.RetryBackoff, &out.RetryBackoff
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.RetryOn != nil {
+		in, out := &in.RetryOn, &out.RetryOn
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Fallback != nil {
+		in, out := &in.Fallback, &out.Fallback
+		*out = new(Fallback)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Cache != nil {
+		in, out := &in.Cache, &out.Cache
+		*out = new(ResponseCache)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.SemanticCache != nil {
+		in, out := &in.SemanticCache, &out.SemanticCache
+		*out = new(SemanticCache)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Headers != nil {
+		in, out := &in.Headers, &out.Headers
+		*out = new(HeaderPolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DataPrep != nil {
+		in, out := &in.DataPrep, &out.DataPrep
+		*out = new(DataPrep)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Transport != nil {
+		in, out := &in.Transport, &out.Transport
+		*out = new(Transport)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Step.
@@ -231,3 +604,63 @@ func (in *Step) DeepCopy() *Step {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Sticky) DeepCopyInto(out *Sticky) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Sticky.
+func (in *Sticky) DeepCopy() *Sticky {
+	if in == nil {
+		return nil
+	}
+	out := new(Sticky)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Transport) DeepCopyInto(out *Transport) {
+	*out = *in
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(TransportTLS)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.IdleConnTimeout != nil {
+		in, out := &in.IdleConnTimeout, &out.IdleConnTimeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Transport.
+func (in *Transport) DeepCopy() *Transport {
+	if in == nil {
+		return nil
+	}
+	out := new(Transport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TransportTLS) DeepCopyInto(out *TransportTLS) {
+	*out = *in
+	if in.CASecretRef != nil {
+		in, out := &in.CASecretRef, &out.CASecretRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TransportTLS.
+func (in *TransportTLS) DeepCopy() *TransportTLS {
+	if in == nil {
+		return nil
+	}
+	out := new(TransportTLS)
+	in.DeepCopyInto(out)
+	return out
+}
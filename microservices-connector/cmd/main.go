@@ -6,6 +6,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"flag"
@@ -22,6 +23,7 @@ import (
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/dynamic"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
@@ -37,6 +39,9 @@ import (
 const (
 	webhookServiceNameEnv      = "SERVICE_NAME"
 	webhookServiceNamespaceEnv = "NAMESPACE"
+	webhookCertSecretEnv       = "WEBHOOK_CERT_SECRET"
+	// name of a cert-manager Certificate issuing the webhook certificate instead of the manager
+	webhookCertificateEnv = "WEBHOOK_CERTIFICATE"
 )
 
 var (
@@ -144,25 +149,28 @@ func main() {
 	webhookServiceName := controller.GetEnvWithDefault(webhookServiceNameEnv, "gmc-validating-webhook-service")
 	webhookServiceNamespace := controller.GetEnvWithDefault(webhookServiceNamespaceEnv, "system")
 
-	pair, CABytes, err := controller.GenerateX509Cert(webhookServiceName, webhookServiceNamespace)
+	client, err := controller.GetClient()
 	if err != nil {
-		setupLog.Error(err, "failed to generate x509 cert")
+		setupLog.Error(err, "unable to get client config")
 		os.Exit(1)
 	}
-
-	client, err := controller.GetClient()
+	dynamicClient, err := dynamic.NewForConfig(ctrl.GetConfigOrDie())
 	if err != nil {
-		setupLog.Error(err, "unable to get client config")
+		setupLog.Error(err, "unable to get dynamic client")
 		os.Exit(1)
 	}
 
-	if err = controller.CreateOrUpdateValidatingWebhookConfiguration(
+	// the replicas share the certificate from a Secret, which also sets the CA bundle of the webhook configuration
+	webhookCert := controller.NewWebhookCert(
 		client,
-		CABytes,
-		int32(webhookPort),
+		dynamicClient,
 		webhookServiceName,
-		webhookServiceNamespace); err != nil {
-		setupLog.Error(err, "failed to create or update the validation webhook configuration")
+		webhookServiceNamespace,
+		controller.GetEnvWithDefault(webhookCertSecretEnv, "gmc-webhook-cert"),
+		os.Getenv(webhookCertificateEnv),
+		int32(webhookPort))
+	if err = webhookCert.Sync(context.Background()); err != nil {
+		setupLog.Error(err, "failed to load the webhook certificate")
 		os.Exit(1)
 	}
 
@@ -170,9 +178,7 @@ func main() {
 		Port: webhookPort,
 		TLSOpts: []func(*tls.Config){
 			func(cfg *tls.Config) {
-				cfg.GetCertificate = func(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
-					return pair, nil
-				}
+				cfg.GetCertificate = webhookCert.GetCertificate
 				cfg.MinVersion = tls.VersionTLS12
 			},
 		},
@@ -206,6 +212,12 @@ func main() {
 		os.Exit(1)
 	}
 
+	// reload the rotated webhook certificate without restarting
+	if err = mgr.Add(webhookCert); err != nil {
+		setupLog.Error(err, "unable to watch the webhook certificate")
+		os.Exit(1)
+	}
+
 	if err = (&mcv1alpha3.GMConnector{}).SetupWebhookWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create validating webhook", "webhook", "gmcValidator")
 		os.Exit(1)
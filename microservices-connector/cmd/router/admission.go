@@ -0,0 +1,288 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/tidwall/gjson"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// RouterConfig.Config keys of the admission control, the tenant of a request is the value of
+	// the tenant header, or else the tenant claim of its JWT bearer token
+	tenantHeaderKey         = "tenantHeader"
+	tenantClaimKey          = "tenantClaim"
+	rateLimitKey            = "rateLimit"
+	rateLimitBurstKey       = "rateLimitBurst"
+	tenantMaxConcurrencyKey = "tenantMaxConcurrency"
+	// RouterConfig.Config keys of the queue of the calls waiting for a free slot
+	callQueueSizeKey    = "callQueueSize"
+	callQueueTimeoutKey = "callQueueTimeout"
+
+	defaultCallQueueSize    = MaxGoroutines
+	defaultCallQueueTimeout = 10 * time.Second
+
+	// the idle tenants are forgotten once more are tracked
+	maxTrackedTenants = 10000
+
+	// reason labels of the rejected requests
+	rejectedRateLimit   = "rate_limit"
+	rejectedConcurrency = "concurrency"
+)
+
+var (
+	errCallQueueFull    = errors.New("too many calls are waiting for a free slot")
+	errCallQueueTimeout = errors.New("timed out waiting for a free slot")
+
+	tenants = &tenantLimiters{limiters: map[string]*tenantLimiter{}}
+)
+
+// admissionQueue caps the calls in flight, the calls beyond the cap wait
+// in a bounded queue for a free slot until their deadline
+type admissionQueue struct {
+	slots   chan struct{}
+	waiting atomic.Int64
+}
+
+func newAdmissionQueue(maxInFlight int) *admissionQueue {
+	return &admissionQueue{slots: make(chan struct{}, maxInFlight)}
+}
+
+// acquire takes a slot, the caller has to release it once done
+func (q *admissionQueue) acquire(ctx context.Context, maxWaiting int, timeout time.Duration) error {
+	select {
+	case q.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if q.waiting.Add(1) > int64(maxWaiting) {
+		q.waiting.Add(-1)
+		return errCallQueueFull
+	}
+	defer q.waiting.Add(-1)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case q.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errCallQueueTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *admissionQueue) release() {
+	<-q.slots
+}
+
+// isCallQueueRejection tells if the call was not made as no slot was free in time
+func isCallQueueRejection(err error) bool {
+	return errors.Is(err, errCallQueueFull) || errors.Is(err, errCallQueueTimeout)
+}
+
+// callQueueLimits reads the number of calls waiting for a free slot and the time they wait
+// from the router config, ignoring the invalid values
+func callQueueLimits(graph *mcv1alpha3.GMConnector) (int, time.Duration) {
+	size, timeout := defaultCallQueueSize, defaultCallQueueTimeout
+	if graph == nil {
+		return size, timeout
+	}
+	config := graph.Spec.RouterConfig.Config
+	if value, ok := config[callQueueSizeKey]; ok {
+		if n, err := strconv.Atoi(value); err == nil && n >= 0 {
+			size = n
+		} else {
+			log.Info("Invalid call queue size in router config, use the default one", "callQueueSize", value)
+		}
+	}
+	if value, ok := config[callQueueTimeoutKey]; ok {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			timeout = d
+		} else {
+			log.Info("Invalid call queue timeout in router config, use the default one", "callQueueTimeout", value)
+		}
+	}
+	return size, timeout
+}
+
+// admissionLimits are the limits of every tenant, 0 means no limit
+type admissionLimits struct {
+	rate           rate.Limit
+	burst          int
+	maxConcurrency int
+}
+
+func (l admissionLimits) enabled() bool {
+	return l.rate > 0 || l.maxConcurrency > 0
+}
+
+// admissionLimitsFor reads the limits from the router config, ignoring the invalid values
+func admissionLimitsFor(graph *mcv1alpha3.GMConnector) admissionLimits {
+	config := graph.Spec.RouterConfig.Config
+	var limits admissionLimits
+	if value, ok := config[rateLimitKey]; ok {
+		perSecond, err := strconv.ParseFloat(value, 64)
+		if err == nil && perSecond > 0 {
+			limits.rate = rate.Limit(perSecond)
+			limits.burst = int(math.Max(1, math.Ceil(perSecond)))
+		} else {
+			log.Info("Invalid rate limit in router config, ignore it", "rateLimit", value)
+		}
+	}
+	if value, ok := config[rateLimitBurstKey]; ok && limits.rate > 0 {
+		burst, err := strconv.Atoi(value)
+		if err == nil && burst > 0 {
+			limits.burst = burst
+		} else {
+			log.Info("Invalid rate limit burst in router config, ignore it", "rateLimitBurst", value)
+		}
+	}
+	if value, ok := config[tenantMaxConcurrencyKey]; ok {
+		maxConcurrency, err := strconv.Atoi(value)
+		if err == nil && maxConcurrency > 0 {
+			limits.maxConcurrency = maxConcurrency
+		} else {
+			log.Info("Invalid tenant max concurrency in router config, ignore it", "tenantMaxConcurrency", value)
+		}
+	}
+	return limits
+}
+
+// tenantOf returns the tenant the request counts against, the requests
+// without tenant share the limits of the default tenant ""
+func tenantOf(graph *mcv1alpha3.GMConnector, req *http.Request) string {
+	config := graph.Spec.RouterConfig.Config
+	if header := config[tenantHeaderKey]; header != "" {
+		if tenant := req.Header.Get(header); tenant != "" {
+			return tenant
+		}
+	}
+	if claim := config[tenantClaimKey]; claim != "" {
+		if claims := claimsFrom(req.Context()); claims != nil {
+			return gjson.GetBytes(claims, gjson.Escape(claim)).String()
+		}
+		return bearerClaim(req.Header, claim)
+	}
+	return ""
+}
+
+// bearerClaim reads a claim of the JWT bearer token of the request when the router does not validate
+// the tokens. The signature is not verified, the claim only selects the limits the request counts against.
+func bearerClaim(headers http.Header, claim string) string {
+	token, ok := strings.CutPrefix(headers.Get("Authorization"), "Bearer ")
+	parts := strings.Split(token, ".")
+	if !ok || len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	return gjson.GetBytes(payload, gjson.Escape(claim)).String()
+}
+
+type tenantLimiter struct {
+	// token bucket of the tenant, nil while there is no rate limit
+	limiter  *rate.Limiter
+	inFlight int
+}
+
+// tenantLimiters holds the token bucket and the requests in flight of each tenant
+type tenantLimiters struct {
+	mu       sync.Mutex
+	limiters map[string]*tenantLimiter
+}
+
+// admit counts the request against the limits of the tenant. A rejected request gets the reason
+// and the time to wait before retrying, an admitted one the func to call once it is done.
+func (t *tenantLimiters) admit(tenant string, limits admissionLimits, now time.Time) (func(), string, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l, ok := t.limiters[tenant]
+	if !ok {
+		if len(t.limiters) >= maxTrackedTenants {
+			t.forgetIdle(now)
+		}
+		l = &tenantLimiter{}
+		t.limiters[tenant] = l
+	}
+	if limits.maxConcurrency > 0 && l.inFlight >= limits.maxConcurrency {
+		return nil, rejectedConcurrency, time.Second
+	}
+	if limits.rate > 0 {
+		if l.limiter == nil {
+			l.limiter = rate.NewLimiter(limits.rate, limits.burst)
+		} else if l.limiter.Limit() != limits.rate || l.limiter.Burst() != limits.burst {
+			// the limits follow the reloads of the graph
+			l.limiter.SetLimitAt(now, limits.rate)
+			l.limiter.SetBurstAt(now, limits.burst)
+		}
+		reservation := l.limiter.ReserveN(now, 1)
+		if delay := reservation.DelayFrom(now); delay > 0 {
+			reservation.CancelAt(now)
+			return nil, rejectedRateLimit, delay
+		}
+	}
+	l.inFlight++
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		l.inFlight--
+	}, "", 0
+}
+
+// forgetIdle drops the tenants without requests in flight and with a full bucket,
+// they start over with the same state
+func (t *tenantLimiters) forgetIdle(now time.Time) {
+	for tenant, l := range t.limiters {
+		if l.inFlight == 0 && (l.limiter == nil || l.limiter.TokensAt(now) >= float64(l.limiter.Burst())) {
+			delete(t.limiters, tenant)
+		}
+	}
+}
+
+// withAdmission enforces the limits of the tenant before the request is routed through the graph,
+// the rejected requests get a 429 response telling when to retry
+func withAdmission(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		graph := mcGraph.Load()
+		if graph == nil {
+			next(w, req)
+			return
+		}
+		limits := admissionLimitsFor(graph)
+		if !limits.enabled() {
+			next(w, req)
+			return
+		}
+		tenant := tenantOf(graph, req)
+		release, reason, retryAfter := tenants.admit(tenant, limits, time.Now())
+		if release == nil {
+			log.Info("Reject the request of the tenant", "tenant", tenant, "reason", reason, "retryAfter", retryAfter)
+			rejectedRequests.WithLabelValues(reason).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		defer release()
+		next(w, req)
+	}
+}
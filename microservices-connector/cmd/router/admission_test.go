@@ -0,0 +1,217 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmissionQueue(t *testing.T) {
+	queue := newAdmissionQueue(1)
+	ctx := context.Background()
+	assert.NoError(t, queue.acquire(ctx, 1, time.Second))
+
+	// the queue is full while a call waits
+	waited := make(chan error)
+	go func() {
+		waited <- queue.acquire(ctx, 1, time.Second)
+	}()
+	assert.Eventually(t, func() bool { return queue.waiting.Load() == 1 }, time.Second, time.Millisecond)
+	assert.ErrorIs(t, queue.acquire(ctx, 1, time.Second), errCallQueueFull)
+
+	// the waiting call gets the released slot
+	queue.release()
+	assert.NoError(t, <-waited)
+
+	assert.ErrorIs(t, queue.acquire(ctx, 1, 10*time.Millisecond), errCallQueueTimeout)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, queue.acquire(cancelled, 1, time.Second), context.Canceled)
+	assert.Equal(t, int64(0), queue.waiting.Load())
+}
+
+func TestCallQueueLimits(t *testing.T) {
+	size, timeout := callQueueLimits(nil)
+	assert.Equal(t, defaultCallQueueSize, size)
+	assert.Equal(t, defaultCallQueueTimeout, timeout)
+
+	graph := &mcv1alpha3.GMConnector{}
+	graph.Spec.RouterConfig.Config = map[string]string{callQueueSizeKey: "0", callQueueTimeoutKey: "2s"}
+	size, timeout = callQueueLimits(graph)
+	assert.Equal(t, 0, size)
+	assert.Equal(t, 2*time.Second, timeout)
+
+	graph.Spec.RouterConfig.Config = map[string]string{callQueueSizeKey: "-1", callQueueTimeoutKey: "soon"}
+	size, timeout = callQueueLimits(graph)
+	assert.Equal(t, defaultCallQueueSize, size)
+	assert.Equal(t, defaultCallQueueTimeout, timeout)
+}
+
+func TestCallQueueFull(t *testing.T) {
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	oldQueue := callQueue
+	defer func() { callQueue = oldQueue }()
+
+	graph := &mcv1alpha3.GMConnector{}
+	graph.Spec.RouterConfig.Config = map[string]string{callQueueSizeKey: "0", callQueueTimeoutKey: "1500ms"}
+	mcGraph.Store(graph)
+	callQueue = newAdmissionQueue(1)
+	assert.NoError(t, callQueue.acquire(context.Background(), 0, time.Second))
+	defer callQueue.release()
+
+	// no call can wait for the only slot, the caller is told when to try again
+	ctx := withResponseHeaders(context.Background())
+	step := &mcv1alpha3.Step{StepName: "Llm"}
+	_, statusCode, err := doCallService(ctx, step, "http://llm", []byte(`{}`), http.Header{})
+	assert.ErrorIs(t, err, errCallQueueFull)
+	assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+	header := http.Header{}
+	copyResponseHeaders(ctx, header)
+	assert.Equal(t, "2", header.Get("Retry-After"))
+}
+
+func TestCallQueueFullResponse(t *testing.T) {
+	service := newEchoService(`{"text":"OPEA"}`)
+	defer service.Close()
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	oldQueue := callQueue
+	defer func() { callQueue = oldQueue }()
+	callQueue = newAdmissionQueue(1)
+	assert.NoError(t, callQueue.acquire(context.Background(), 0, time.Second))
+	defer callQueue.release()
+
+	for _, routerType := range []mcv1alpha3.RouterType{mcv1alpha3.Sequence, mcv1alpha3.Switch} {
+		mcGraph.Store(&mcv1alpha3.GMConnector{
+			Spec: mcv1alpha3.GMConnectorSpec{
+				RouterConfig: mcv1alpha3.RouterConfig{
+					Config: map[string]string{callQueueSizeKey: "0", callQueueTimeoutKey: "3s"},
+				},
+				Nodes: map[string]mcv1alpha3.Router{
+					"root": {
+						RouterType: routerType,
+						Steps:      []mcv1alpha3.Step{{StepName: "Llm", ServiceURL: service.URL}},
+					},
+				},
+			},
+		})
+		// the client is told the router is overloaded, not that the request failed
+		rr := httptest.NewRecorder()
+		mcGraphHandler(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query":"OPEA"}`)))
+		assert.Equal(t, http.StatusServiceUnavailable, rr.Code, routerType)
+		assert.Equal(t, "3", rr.Header().Get("Retry-After"), routerType)
+	}
+}
+
+func TestTenantLimiters(t *testing.T) {
+	limiters := &tenantLimiters{limiters: map[string]*tenantLimiter{}}
+	now := time.Now()
+
+	limits := admissionLimits{rate: 1, burst: 2}
+	for i := 0; i < 2; i++ {
+		release, reason, _ := limiters.admit("a", limits, now)
+		assert.NotNil(t, release)
+		assert.Empty(t, reason)
+		release()
+	}
+	release, reason, retryAfter := limiters.admit("a", limits, now)
+	assert.Nil(t, release)
+	assert.Equal(t, rejectedRateLimit, reason)
+	assert.Equal(t, time.Second, retryAfter)
+	// the tenants have their own buckets
+	release, _, _ = limiters.admit("b", limits, now)
+	assert.NotNil(t, release)
+	release, _, _ = limiters.admit("a", limits, now.Add(time.Second))
+	assert.NotNil(t, release)
+
+	limits = admissionLimits{maxConcurrency: 1}
+	release, _, _ = limiters.admit("c", limits, now)
+	assert.NotNil(t, release)
+	rejected, reason, _ := limiters.admit("c", limits, now)
+	assert.Nil(t, rejected)
+	assert.Equal(t, rejectedConcurrency, reason)
+	release()
+	release, _, _ = limiters.admit("c", limits, now)
+	assert.NotNil(t, release)
+}
+
+func TestTenantOf(t *testing.T) {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice","org":"opea"}`))
+	graph := &mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			RouterConfig: mcv1alpha3.RouterConfig{
+				Config: map[string]string{tenantHeaderKey: "X-Tenant", tenantClaimKey: "org"},
+			},
+		},
+	}
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{name: "tenant header", headers: map[string]string{"X-Tenant": "intel", "Authorization": "Bearer e30." + claims + ".sig"}, want: "intel"},
+		{name: "tenant claim", headers: map[string]string{"Authorization": "Bearer e30." + claims + ".sig"}, want: "opea"},
+		{name: "invalid token", headers: map[string]string{"Authorization": "Bearer opea"}, want: ""},
+		{name: "no tenant", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			assert.Equal(t, tt.want, tenantOf(graph, req))
+		})
+	}
+}
+
+func TestWithAdmission(t *testing.T) {
+	service := newEchoService(`{"text":"OPEA"}`)
+	defer service.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	oldTenants := tenants
+	defer func() { tenants = oldTenants }()
+	tenants = &tenantLimiters{limiters: map[string]*tenantLimiter{}}
+	mcGraph.Store(&mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			RouterConfig: mcv1alpha3.RouterConfig{
+				Config: map[string]string{tenantHeaderKey: "X-Tenant", rateLimitKey: "0.5"},
+			},
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps:      []mcv1alpha3.Step{{StepName: "Llm", ServiceURL: service.URL}},
+				},
+			},
+		},
+	})
+
+	mux := initializeRoutes()
+	send := func(tenant string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"text":"What is OPEA?"}`))
+		req.Header.Set("X-Tenant", tenant)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	assert.Equal(t, http.StatusOK, send("intel").Code)
+	rr := send("intel")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusOK, send("opea").Code)
+}
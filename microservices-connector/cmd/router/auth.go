@@ -0,0 +1,419 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	// register the hashes of the signing algorithms
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+	// the keys of the issuer are refetched this often
+	jwksRefreshInterval = 10 * time.Minute
+	// a token signed with an unknown key refetches the keys at most this often
+	jwksMinRefreshInterval = 30 * time.Second
+	// tolerated clock difference with the issuer
+	tokenClockSkew = time.Minute
+
+	// reason labels of the rejected requests
+	rejectedUnauthorized = "unauthorized"
+	rejectedForbidden    = "forbidden"
+)
+
+var (
+	// the signing keys by issuer and JWKS URL
+	jwksCaches sync.Map
+	jwksClient = &http.Client{
+		Transport: transport,
+		Timeout:   10 * time.Second,
+	}
+	// the hashes of the supported signing algorithms, RFC 7518
+	signingHashes = map[string]crypto.Hash{
+		"RS256": crypto.SHA256,
+		"RS384": crypto.SHA384,
+		"RS512": crypto.SHA512,
+		"PS256": crypto.SHA256,
+		"PS384": crypto.SHA384,
+		"PS512": crypto.SHA512,
+		"ES256": crypto.SHA256,
+		"ES384": crypto.SHA384,
+		"ES512": crypto.SHA512,
+	}
+)
+
+type claimsKey struct{}
+
+type forwardedHeadersKey struct{}
+
+// claimsFrom returns the JSON claims of the validated token of the request, nil without validation
+func claimsFrom(ctx context.Context) []byte {
+	claims, _ := ctx.Value(claimsKey{}).([]byte)
+	return claims
+}
+
+// forwardedHeadersFrom returns the headers set from the claims on every call of the request
+func forwardedHeadersFrom(ctx context.Context) http.Header {
+	headers, _ := ctx.Value(forwardedHeadersKey{}).(http.Header)
+	return headers
+}
+
+// matchClaimRules checks the claims meet all the rules
+func matchClaimRules(claims []byte, rules []mcv1alpha3.ClaimRule) bool {
+	for _, rule := range rules {
+		value := gjson.GetBytes(claims, gjson.Escape(rule.Claim))
+		matched := false
+		if value.IsArray() {
+			for _, item := range value.Array() {
+				if slices.Contains(rule.Values, item.String()) {
+					matched = true
+					break
+				}
+			}
+		} else if value.Exists() {
+			matched = slices.Contains(rule.Values, value.String())
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// jsonWebKey is a public key of a JSON Web Key Set, RFC 7517
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(value string) (*big.Int, error) {
+		data, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil || len(data) == 0 {
+			return nil, fmt.Errorf("invalid parameter of key %s", k.Kid)
+		}
+		return new(big.Int).SetBytes(data), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid exponent of key %s", k.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s of key %s", k.Crv, k.Kid)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s of key %s", k.Kty, k.Kid)
+	}
+}
+
+// jwksCache holds the signing keys of an issuer by key id
+type jwksCache struct {
+	issuer  string
+	jwksURL string
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func jwksFor(oidc *mcv1alpha3.OIDC) *jwksCache {
+	key := oidc.Issuer + " " + oidc.JWKSURL
+	if cache, ok := jwksCaches.Load(key); ok {
+		return cache.(*jwksCache)
+	}
+	cache, _ := jwksCaches.LoadOrStore(key, &jwksCache{issuer: oidc.Issuer, jwksURL: oidc.JWKSURL})
+	return cache.(*jwksCache)
+}
+
+// key returns the key with the id, a token without id can use the only key of the issuer
+func (c *jwksCache) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key, ok := c.lookup(kid)
+	age := time.Since(c.fetched)
+	if c.keys == nil || age >= jwksRefreshInterval || !ok && age >= jwksMinRefreshInterval {
+		if err := c.refresh(ctx); err != nil {
+			if c.keys == nil {
+				return nil, err
+			}
+			log.Error(err, "failed to refresh the signing keys, keep the current ones", "issuer", c.issuer)
+		}
+		key, ok = c.lookup(kid)
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+func (c *jwksCache) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, true
+		}
+	}
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+func (c *jwksCache) refresh(ctx context.Context) error {
+	jwksURL := c.jwksURL
+	if jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := getJSON(ctx, strings.TrimSuffix(c.issuer, "/")+oidcDiscoveryPath, &discovery); err != nil {
+			return fmt.Errorf("failed to discover the keys of issuer %s: %v", c.issuer, err)
+		}
+		if discovery.JWKSURI == "" {
+			return fmt.Errorf("issuer %s has no jwks_uri", c.issuer)
+		}
+		jwksURL = discovery.JWKSURI
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJSON(ctx, jwksURL, &set); err != nil {
+		return fmt.Errorf("failed to fetch the keys of issuer %s: %v", c.issuer, err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for i := range set.Keys {
+		jwk := &set.Keys[i]
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Info("Skip a signing key of the issuer", "issuer", c.issuer, "error", err.Error())
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	c.keys = keys
+	c.fetched = time.Now()
+	log.Info("Fetched the signing keys of the issuer", "issuer", c.issuer, "keys", len(keys))
+	return nil
+}
+
+func getJSON(ctx context.Context, url string, value interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := jwksClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(value)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed []byte, signature []byte) error {
+	hash, ok := signingHashes[alg]
+	if !ok {
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS", "PS":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("the signing key is not an RSA key for algorithm %s", alg)
+		}
+		if alg[:2] == "PS" {
+			return rsa.VerifyPSS(rsaKey, hash, digest, signature, nil)
+		}
+		return rsa.VerifyPKCS1v15(rsaKey, hash, digest, signature)
+	case "ES":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("the signing key is not an EC key for algorithm %s", alg)
+		}
+		size := (ecKey.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("invalid signature length")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+}
+
+// verifyToken checks the signature, the issuer, the audience and the validity period of the JWT,
+// and returns its JSON claims
+func verifyToken(ctx context.Context, oidc *mcv1alpha3.OIDC, token string, now time.Time) ([]byte, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("the token is not a JWT")
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token header: %v", err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("invalid token header: %v", err)
+	}
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !gjson.ValidBytes(claims) || !gjson.ParseBytes(claims).IsObject() {
+		return nil, errors.New("invalid token claims")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature: %v", err)
+	}
+
+	key, err := jwksFor(oidc).key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	if iss := gjson.GetBytes(claims, "iss").String(); strings.TrimSuffix(iss, "/") != strings.TrimSuffix(oidc.Issuer, "/") {
+		return nil, fmt.Errorf("unexpected issuer %q", iss)
+	}
+	exp := gjson.GetBytes(claims, "exp")
+	if !exp.Exists() || now.After(time.Unix(exp.Int(), 0).Add(tokenClockSkew)) {
+		return nil, errors.New("the token is expired")
+	}
+	if nbf := gjson.GetBytes(claims, "nbf"); nbf.Exists() && now.Add(tokenClockSkew).Before(time.Unix(nbf.Int(), 0)) {
+		return nil, errors.New("the token is not valid yet")
+	}
+	if len(oidc.Audiences) > 0 {
+		aud := gjson.GetBytes(claims, "aud")
+		audiences := []string{aud.String()}
+		if aud.IsArray() {
+			audiences = audiences[:0]
+			for _, item := range aud.Array() {
+				audiences = append(audiences, item.String())
+			}
+		}
+		if !slices.ContainsFunc(audiences, func(audience string) bool { return slices.Contains(oidc.Audiences, audience) }) {
+			return nil, fmt.Errorf("unexpected audience %s", aud.Raw)
+		}
+	}
+	return claims, nil
+}
+
+// withAuthentication validates the bearer token of the request when the router config has oidc settings,
+// checks the claim rules of the entrypoint and forwards the claims as headers to the steps
+func withAuthentication(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		graph := mcGraph.Load()
+		if graph == nil || graph.Spec.RouterConfig.OIDC == nil {
+			next(w, req)
+			return
+		}
+		oidc := graph.Spec.RouterConfig.OIDC
+
+		token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			rejectedRequests.WithLabelValues(rejectedUnauthorized).Inc()
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "the request has no bearer token", http.StatusUnauthorized)
+			return
+		}
+		claims, err := verifyToken(req.Context(), oidc, token, time.Now())
+		if err != nil {
+			log.Info("Reject the request with an invalid token", "error", err.Error())
+			rejectedRequests.WithLabelValues(rejectedUnauthorized).Inc()
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		if entrypoint := findEntrypoint(graph, req.URL.Path, req.Method); entrypoint != nil && !matchClaimRules(claims, entrypoint.Claims) {
+			log.Info("Reject the request without the claims of the entrypoint", "entrypoint", entrypoint.Name)
+			rejectedRequests.WithLabelValues(rejectedForbidden).Inc()
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		// the forwarded headers only carry the values of the token
+		forwarded := http.Header{}
+		for _, forward := range oidc.ForwardClaims {
+			req.Header.Del(forward.Header)
+			if value := gjson.GetBytes(claims, gjson.Escape(forward.Claim)); value.Exists() {
+				forwarded.Set(forward.Header, value.String())
+				req.Header.Set(forward.Header, value.String())
+			}
+		}
+		ctx := context.WithValue(req.Context(), claimsKey{}, claims)
+		ctx = context.WithValue(ctx, forwardedHeadersKey{}, forwarded)
+		next(w, req.WithContext(ctx))
+	}
+}
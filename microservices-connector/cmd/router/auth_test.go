@@ -0,0 +1,310 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/stretchr/testify/assert"
+)
+
+// testIssuer is a local stand-in of an OIDC issuer serving its discovery document and keys
+type testIssuer struct {
+	*httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate the rsa key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate the ec key: %v", err)
+	}
+	issuer := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+	encode := func(i *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(i.Bytes())
+	}
+	keys := map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": encode(rsaKey.N), "e": encode(big.NewInt(int64(rsaKey.E)))},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encode(ecKey.X), "y": encode(ecKey.Y)},
+	}}
+	mux := http.NewServeMux()
+	mux.HandleFunc(oidcDiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": issuer.URL, "jwks_uri": issuer.URL + "/certs"})
+	})
+	mux.HandleFunc("/certs", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(keys)
+	})
+	issuer.Server = httptest.NewServer(mux)
+	return issuer
+}
+
+// token signs the claims with the key of the kid, claims set to nil are removed
+func (i *testIssuer) token(t *testing.T, kid string, claims map[string]interface{}) string {
+	all := map[string]interface{}{
+		"iss": i.URL,
+		"aud": "chatqna",
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"sub": "alice",
+	}
+	for key, value := range claims {
+		if value == nil {
+			delete(all, key)
+			continue
+		}
+		all[key] = value
+	}
+	alg := "RS256"
+	if kid == "ec" {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(all)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	var err error
+	if kid == "ec" {
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, i.ecKey, digest[:])
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	} else {
+		signature, err = rsa.SignPKCS1v15(rand.Reader, i.rsaKey, crypto.SHA256, digest[:])
+	}
+	if err != nil {
+		t.Fatalf("failed to sign the token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyToken(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+	oidc := &mcv1alpha3.OIDC{Issuer: issuer.URL, Audiences: []string{"chatqna", "codegen"}}
+
+	valid := issuer.token(t, "rsa", nil)
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{name: "rsa signature", token: valid},
+		{name: "ec signature", token: issuer.token(t, "ec", nil)},
+		{name: "one of the audiences", token: issuer.token(t, "rsa", map[string]interface{}{"aud": []string{"account", "codegen"}})},
+		{name: "other audience", token: issuer.token(t, "rsa", map[string]interface{}{"aud": "account"}), wantErr: true},
+		{name: "other issuer", token: issuer.token(t, "rsa", map[string]interface{}{"iss": "https://other"}), wantErr: true},
+		{name: "expired", token: issuer.token(t, "rsa", map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}), wantErr: true},
+		{name: "without expiration", token: issuer.token(t, "rsa", map[string]interface{}{"exp": nil}), wantErr: true},
+		{name: "not valid yet", token: issuer.token(t, "rsa", map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()}), wantErr: true},
+		{name: "unknown key", token: issuer.token(t, "other", nil), wantErr: true},
+		{name: "tampered claims", token: strings.Join([]string{
+			strings.Split(valid, ".")[0],
+			base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"` + issuer.URL + `","aud":"chatqna","sub":"admin"}`)),
+			strings.Split(valid, ".")[2],
+		}, "."), wantErr: true},
+		{name: "unsigned", token: base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+			strings.Split(valid, ".")[1] + ".", wantErr: true},
+		{name: "not a JWT", token: "opea", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifyToken(context.Background(), oidc, tt.token, time.Now())
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Contains(t, string(claims), `"sub":"alice"`)
+		})
+	}
+}
+
+func TestMatchClaimRules(t *testing.T) {
+	claims := []byte(`{"sub":"alice","groups":["dev","admin"],"realm_access":{"roles":["user"]}}`)
+	tests := []struct {
+		name  string
+		rules []mcv1alpha3.ClaimRule
+		want  bool
+	}{
+		{name: "no rules", want: true},
+		{name: "string claim", rules: []mcv1alpha3.ClaimRule{{Claim: "sub", Values: []string{"bob", "alice"}}}, want: true},
+		{name: "list claim", rules: []mcv1alpha3.ClaimRule{{Claim: "groups", Values: []string{"admin"}}}, want: true},
+		{name: "all rules", rules: []mcv1alpha3.ClaimRule{
+			{Claim: "sub", Values: []string{"alice"}},
+			{Claim: "groups", Values: []string{"ops"}},
+		}, want: false},
+		{name: "missing claim", rules: []mcv1alpha3.ClaimRule{{Claim: "email", Values: []string{"alice"}}}, want: false},
+		{name: "claim with a dot", rules: []mcv1alpha3.ClaimRule{{Claim: "realm_access.roles", Values: []string{"user"}}}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, matchClaimRules(claims, tt.rules))
+		})
+	}
+}
+
+func TestWithAuthentication(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+
+	var adminCalls atomic.Int32
+	users := make(chan string, 2)
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/admin" {
+			adminCalls.Add(1)
+		}
+		users <- r.Header.Get("X-User")
+		_, _ = w.Write([]byte(`{"text":"OPEA"}`))
+	}))
+	defer service.Close()
+
+	admins := []mcv1alpha3.ClaimRule{{Claim: "groups", Values: []string{"admin"}}}
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(&mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			RouterConfig: mcv1alpha3.RouterConfig{
+				OIDC: &mcv1alpha3.OIDC{
+					Issuer:        issuer.URL,
+					Audiences:     []string{"chatqna"},
+					ForwardClaims: []mcv1alpha3.ClaimHeader{{Claim: "sub", Header: "X-User"}},
+				},
+			},
+			Entrypoints: []mcv1alpha3.Entrypoint{
+				{Name: "chat", Path: "/v1/chatqna", NodeName: "root"},
+				{Name: "admin", Path: "/v1/admin", NodeName: "root", Claims: admins},
+			},
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Switch,
+					Steps: []mcv1alpha3.Step{
+						{StepName: "Admin", ServiceURL: service.URL + "/admin", Claims: admins},
+						{StepName: "Llm", ServiceURL: service.URL + "/llm"},
+					},
+				},
+			},
+		},
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		statusCode int
+		adminCalls int32
+	}{
+		{name: "no token", path: "/v1/chatqna", statusCode: http.StatusUnauthorized},
+		{name: "invalid token", path: "/v1/chatqna", token: "opea", statusCode: http.StatusUnauthorized},
+		{name: "user", path: "/v1/chatqna", token: issuer.token(t, "rsa", nil), statusCode: http.StatusOK},
+		{name: "user on the admin entrypoint", path: "/v1/admin", token: issuer.token(t, "rsa", nil), statusCode: http.StatusForbidden},
+		{
+			name:       "admin on the admin entrypoint",
+			path:       "/v1/admin",
+			token:      issuer.token(t, "ec", map[string]interface{}{"groups": []string{"admin"}}),
+			statusCode: http.StatusOK,
+			adminCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adminCalls.Store(0)
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(`{"text":"What is OPEA?"}`))
+			// the claims replace the headers sent by the client
+			req.Header.Set("X-User", "mallory")
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rr := httptest.NewRecorder()
+			initializeRoutes().ServeHTTP(rr, req)
+			assert.Equal(t, tt.statusCode, rr.Code)
+			if rr.Code == http.StatusUnauthorized {
+				assert.Contains(t, rr.Header().Get("WWW-Authenticate"), "Bearer")
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			assert.Equal(t, tt.adminCalls, adminCalls.Load())
+			for i := int32(0); i <= tt.adminCalls; i++ {
+				assert.Equal(t, "alice", <-users)
+			}
+		})
+	}
+}
+
+func TestUiAuthentication(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+
+	users := make(chan string, 1)
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		users <- r.Header.Get("X-User")
+		_, _ = w.Write([]byte(`{"text":"OPEA"}`))
+	}))
+	defer llm.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(&mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			RouterConfig: mcv1alpha3.RouterConfig{
+				OIDC: &mcv1alpha3.OIDC{
+					Issuer:        issuer.URL,
+					Audiences:     []string{"chatqna"},
+					ForwardClaims: []mcv1alpha3.ClaimHeader{{Claim: "sub", Header: "X-User"}},
+				},
+			},
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps: []mcv1alpha3.Step{
+						{StepName: "Llm", ServiceURL: llm.URL},
+						{StepName: UI, Executor: mcv1alpha3.Executor{InternalService: mcv1alpha3.GMCTarget{
+							ServiceName:         "ui-svc",
+							IsDownstreamService: true,
+						}}},
+					},
+				},
+			},
+		},
+	})
+
+	body := `{"messages":"What is OPEA?"}`
+	// the payload of the ui is routed through the graph, it needs a token as well
+	req := httptest.NewRequest(http.MethodPost, "/ui", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	initializeRoutes().ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/ui", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+issuer.token(t, "rsa", nil))
+	rr = httptest.NewRecorder()
+	initializeRoutes().ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "alice", <-users)
+}
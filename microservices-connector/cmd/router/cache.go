@@ -0,0 +1,202 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/opea-project/GenAIInfra/microservices-connector/internal/cache"
+)
+
+const (
+	// cacheHeader tells for each cached step of the request if its response came from the cache
+	cacheHeader = "X-Gmc-Cache"
+	cacheHit    = "hit"
+	cacheMiss   = "miss"
+
+	defaultCacheTTL        = 5 * time.Minute
+	defaultCacheMaxEntries = 1000
+	// larger responses are passed on without being cached
+	maxCachedResponseSize = 4 << 20
+)
+
+// stepCacheKey identifies the cache of the responses of a step target, the steps calling
+// the same target with different sizes have their own cache
+type stepCacheKey struct {
+	target     string
+	maxEntries int
+}
+
+// stepCaches holds the caches by stepCacheKey, they outlive the graph reloads
+// until no step of the graph uses them anymore
+var stepCaches sync.Map
+
+// cacheTarget identifies what a step calls, the steps calling the same target share their cache
+func cacheTarget(step *mcv1alpha3.Step, graph *mcv1alpha3.GMConnector) string {
+	if step.NodeName != "" {
+		return "node/" + step.NodeName
+	}
+	return getServiceURLByStepTarget(step, graph.Namespace)
+}
+
+// cacheNamespace separates the cached responses of a step target by the headers the step forwards
+// to its service, like the Authorization header or the ones set from the claims of the token, so a
+// response is only served to the callers sending the same headers. The values are hashed.
+func cacheNamespace(ctx context.Context, target string, step *mcv1alpha3.Step, headers http.Header) string {
+	forwarded := http.Header{}
+	if step.Headers != nil {
+		for _, name := range step.Headers.Forward {
+			if values := headers.Values(name); len(values) > 0 {
+				forwarded[http.CanonicalHeaderKey(name)] = values
+			}
+		}
+	}
+	for key, values := range forwardedHeadersFrom(ctx) {
+		forwarded[key] = values
+	}
+	if len(forwarded) == 0 {
+		return target
+	}
+	names := make([]string, 0, len(forwarded))
+	for name := range forwarded {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(name))
+		for _, value := range forwarded[name] {
+			h.Write([]byte{0})
+			h.Write([]byte(value))
+		}
+		h.Write([]byte{0, 0})
+	}
+	return target + "#" + hex.EncodeToString(h.Sum(nil))
+}
+
+func cacheMaxEntries(spec *mcv1alpha3.ResponseCache) int {
+	if spec.MaxEntries > 0 {
+		return int(spec.MaxEntries)
+	}
+	return defaultCacheMaxEntries
+}
+
+func cacheFor(target string, spec *mcv1alpha3.ResponseCache) cache.Cache {
+	key := stepCacheKey{target: target, maxEntries: cacheMaxEntries(spec)}
+	if cached, ok := stepCaches.Load(key); ok {
+		return cached.(cache.Cache)
+	}
+	cached, _ := stepCaches.LoadOrStore(key, cache.NewLRU(key.maxEntries))
+	return cached.(cache.Cache)
+}
+
+// pruneStepCaches drops the caches no step of the reloaded graph uses anymore
+func pruneStepCaches(graph *mcv1alpha3.GMConnector) {
+	used := map[stepCacheKey]bool{}
+	for _, node := range graph.Spec.Nodes {
+		for i := range node.Steps {
+			step := &node.Steps[i]
+			if step.Cache != nil {
+				used[stepCacheKey{target: cacheTarget(step, graph), maxEntries: cacheMaxEntries(step.Cache)}] = true
+			}
+		}
+	}
+	stepCaches.Range(func(key, _ any) bool {
+		if !used[key.(stepCacheKey)] {
+			stepCaches.Delete(key)
+		}
+		return true
+	})
+}
+
+func cacheTTL(spec *mcv1alpha3.ResponseCache) time.Duration {
+	if spec.TTL != nil && spec.TTL.Duration > 0 {
+		return spec.TTL.Duration
+	}
+	return defaultCacheTTL
+}
+
+// executeCachedStep returns the cached response of a step for the same request,
+// or executes the step and caches its response once it is completely read
+func executeCachedStep(
+	ctx context.Context,
+	step *mcv1alpha3.Step,
+	graph mcv1alpha3.GMConnector,
+	initInput []byte,
+	input []byte,
+	headers http.Header,
+) (io.ReadCloser, int, error) {
+	target := cacheTarget(step, &graph)
+	stepCache := cacheFor(target, step.Cache)
+	key := cache.Key(cacheNamespace(ctx, target, step, headers), input, step.Cache.IgnoreFields)
+	cached, ok, err := stepCache.Get(ctx, key)
+	if err != nil {
+		log.Error(err, "Failed to read the cache, execute the step", "stepName", step.StepName)
+	}
+	if ok {
+		log.Info("Use the cached response of step", "stepName", step.StepName)
+		observeCache(ctx, step.StepName, cacheHit)
+		return NewReadCloser(cached), http.StatusOK, nil
+	}
+	observeCache(ctx, step.StepName, cacheMiss)
+
+	responseBody, statusCode, err := executeStepTarget(ctx, step, graph, initInput, input, headers)
+	if err != nil || statusCode != http.StatusOK {
+		return responseBody, statusCode, err
+	}
+	ttl := cacheTTL(step.Cache)
+	// the response is still streamed to the caller, it is cached when the caller read all of it
+	storeCtx := context.WithoutCancel(ctx)
+	return &cachingReadCloser{
+		ReadCloser: responseBody,
+		store: func(response []byte) {
+			if err := stepCache.Set(storeCtx, key, response, ttl); err != nil {
+				log.Error(err, "Failed to cache the response", "stepName", step.StepName)
+			}
+		},
+	}, statusCode, nil
+}
+
+func observeCache(ctx context.Context, stepName string, result string) {
+	cacheRequests.WithLabelValues(stepName, result).Inc()
+	addResponseHeader(ctx, cacheHeader, stepName+"="+result)
+}
+
+// cachingReadCloser keeps a copy of the body read through it and stores it at the end of the body,
+// a body closed before its end or larger than maxCachedResponseSize is not stored
+type cachingReadCloser struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	overflow bool
+	stored   bool
+	store    func([]byte)
+}
+
+func (r *cachingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 && !r.overflow {
+		if r.buf.Len()+n > maxCachedResponseSize {
+			r.overflow = true
+			r.buf = bytes.Buffer{}
+		} else {
+			r.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !r.overflow && !r.stored {
+		r.stored = true
+		r.store(bytes.Clone(r.buf.Bytes()))
+	}
+	return n, err
+}
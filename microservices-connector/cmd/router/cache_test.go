@@ -0,0 +1,162 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newCountingService(statusCode int, response string, calls *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.ReadAll(req.Body)
+		calls.Add(1)
+		rw.WriteHeader(statusCode)
+		_, _ = rw.Write([]byte(response))
+	}))
+}
+
+func TestCachedStep(t *testing.T) {
+	var calls atomic.Int32
+	llm := newCountingService(http.StatusOK, `{"text":"OPEA is an open platform"}`, &calls)
+	defer llm.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(&mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps: []mcv1alpha3.Step{{
+						StepName:   "Llm",
+						ServiceURL: llm.URL,
+						Cache:      &mcv1alpha3.ResponseCache{IgnoreFields: []string{"streaming"}},
+					}},
+				},
+			},
+		},
+	})
+	hits := testutil.ToFloat64(cacheRequests.WithLabelValues("Llm", cacheHit))
+	misses := testutil.ToFloat64(cacheRequests.WithLabelValues("Llm", cacheMiss))
+
+	// the second request only differs by an ignored field and the order of the fields
+	for i, input := range []string{
+		`{"query":"What is OPEA?","max_tokens":64,"streaming":true}`,
+		`{"max_tokens":64,"query":"What is OPEA?","streaming":false}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(input))
+		rr := httptest.NewRecorder()
+		mcGraphHandler(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, `{"text":"OPEA is an open platform"}`, rr.Body.String())
+		if i == 0 {
+			assert.Equal(t, "Llm=miss", rr.Header().Get(cacheHeader))
+		} else {
+			assert.Equal(t, "Llm=hit", rr.Header().Get(cacheHeader))
+		}
+	}
+	assert.Equal(t, int32(1), calls.Load())
+	assert.Equal(t, hits+1, testutil.ToFloat64(cacheRequests.WithLabelValues("Llm", cacheHit)))
+	assert.Equal(t, misses+1, testutil.ToFloat64(cacheRequests.WithLabelValues("Llm", cacheMiss)))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query":"What is GMC?"}`))
+	rr := httptest.NewRecorder()
+	mcGraphHandler(rr, req)
+	assert.Equal(t, "Llm=miss", rr.Header().Get(cacheHeader))
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestCachedStepIncompleteResponses(t *testing.T) {
+	var calls atomic.Int32
+	llm := newCountingService(http.StatusOK, `{"text":"a long answer"}`, &calls)
+	defer llm.Close()
+	var failedCalls atomic.Int32
+	failing := newCountingService(http.StatusServiceUnavailable, `{"error":"overloaded"}`, &failedCalls)
+	defer failing.Close()
+
+	graph := mcv1alpha3.GMConnector{}
+	step := &mcv1alpha3.Step{StepName: "Llm", ServiceURL: llm.URL, Cache: &mcv1alpha3.ResponseCache{}}
+	input := []byte(`{"query":"What is OPEA?"}`)
+
+	// a response closed before its end is not cached
+	res, _, err := executeStep(context.Background(), step, graph, input, input, http.Header{})
+	assert.NoError(t, err)
+	_, _ = res.Read(make([]byte, 4))
+	_ = res.Close()
+	res, _, err = executeStep(context.Background(), step, graph, input, input, http.Header{})
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(res)
+	assert.Equal(t, `{"text":"a long answer"}`, string(body))
+	assert.Equal(t, int32(2), calls.Load())
+
+	// an unsuccessful response is not cached
+	step = &mcv1alpha3.Step{StepName: "Llm", ServiceURL: failing.URL, Cache: &mcv1alpha3.ResponseCache{}}
+	for i := 0; i < 2; i++ {
+		res, statusCode, err := executeStep(context.Background(), step, graph, input, input, http.Header{})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+		_, _ = io.ReadAll(res)
+	}
+	assert.Equal(t, int32(2), failedCalls.Load())
+}
+
+func TestCachedStepForwardedHeaders(t *testing.T) {
+	var calls atomic.Int32
+	llm := newCountingService(http.StatusOK, `{"text":"your documents"}`, &calls)
+	defer llm.Close()
+
+	graph := mcv1alpha3.GMConnector{}
+	step := &mcv1alpha3.Step{
+		StepName:   "Llm",
+		ServiceURL: llm.URL,
+		Cache:      &mcv1alpha3.ResponseCache{},
+		Headers:    &mcv1alpha3.HeaderPolicy{Forward: []string{"Authorization"}},
+	}
+	input := []byte(`{"query":"What are my documents?"}`)
+	call := func(authorization string) {
+		headers := http.Header{}
+		headers.Set("Authorization", authorization)
+		res, _, err := executeStep(context.Background(), step, graph, input, input, headers)
+		assert.NoError(t, err)
+		_, _ = io.ReadAll(res)
+		_ = res.Close()
+	}
+
+	// the response to a caller is not served to another one
+	call("Bearer alice")
+	call("Bearer bob")
+	assert.Equal(t, int32(2), calls.Load())
+	call("Bearer alice")
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestStepCachesBySize(t *testing.T) {
+	small := &mcv1alpha3.ResponseCache{MaxEntries: 10}
+	large := &mcv1alpha3.ResponseCache{MaxEntries: 100}
+	first := cacheFor("http://llm", small)
+	other := cacheFor("http://llm", large)
+	// the steps with different sizes keep their own cache
+	assert.NotSame(t, first, other)
+	assert.Same(t, first, cacheFor("http://llm", small))
+	assert.Same(t, other, cacheFor("http://llm", large))
+
+	// the reloaded graph only keeps the cache of the larger step
+	pruneStepCaches(&mcv1alpha3.GMConnector{Spec: mcv1alpha3.GMConnectorSpec{Nodes: map[string]mcv1alpha3.Router{
+		"root": {Steps: []mcv1alpha3.Step{{StepName: "Llm", ServiceURL: "http://llm", Cache: large}}},
+	}}})
+	assert.Same(t, other, cacheFor("http://llm", large))
+	assert.NotSame(t, first, cacheFor("http://llm", small))
+}
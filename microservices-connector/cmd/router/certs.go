@@ -0,0 +1,105 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// reloadingFiles caches the value loaded from files and loads it again once one of the files changed,
+// the kubelet replaces the files of a mounted Secret when the controller rotates the certificates
+type reloadingFiles[T any] struct {
+	paths []string
+	load  func() (T, error)
+
+	mu       sync.Mutex
+	modTimes []time.Time
+	value    T
+}
+
+func newReloadingFiles[T any](load func() (T, error), paths ...string) *reloadingFiles[T] {
+	return &reloadingFiles[T]{paths: paths, load: load}
+}
+
+func (f *reloadingFiles[T]) get() (T, error) {
+	modTimes := make([]time.Time, len(f.paths))
+	for i, path := range f.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.modTimes != nil && slices.EqualFunc(f.modTimes, modTimes, time.Time.Equal) {
+		return f.value, nil
+	}
+	value, err := f.load()
+	if err != nil {
+		return value, err
+	}
+	f.value = value
+	f.modTimes = modTimes
+	return value, nil
+}
+
+// newKeyPair loads the certificate and its key from PEM files
+func newKeyPair(certFile, keyFile string) *reloadingFiles[*tls.Certificate] {
+	return newReloadingFiles(func() (*tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	}, certFile, keyFile)
+}
+
+// newCertPool loads the CAs of a PEM bundle
+func newCertPool(bundleFile string) *reloadingFiles[*x509.CertPool] {
+	return newReloadingFiles(func() (*x509.CertPool, error) {
+		bundle, err := os.ReadFile(bundleFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificate found in the CA bundle %s", bundleFile)
+		}
+		return pool, nil
+	}, bundleFile)
+}
+
+// verifyPeer verifies the certificate chain of the peer with the current CAs of the pool,
+// and its name when one is given
+func verifyPeer(cs tls.ConnectionState, pool *reloadingFiles[*x509.CertPool], name string, usage x509.ExtKeyUsage) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("the peer sent no certificate")
+	}
+	roots, err := pool.get()
+	if err != nil {
+		return err
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err = cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       name,
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	return err
+}
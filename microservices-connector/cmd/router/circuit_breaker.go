@@ -0,0 +1,264 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+)
+
+const (
+	// RouterConfig.Config keys of the circuit breaker, the breaker is enabled by setting the failure ratio
+	breakerFailureRatioKey = "circuitBreakerFailureRatio"
+	breakerMinRequestsKey  = "circuitBreakerMinRequests"
+	breakerCooldownKey     = "circuitBreakerCooldown"
+	breakerWindowKey       = "circuitBreakerWindow"
+
+	defaultBreakerMinRequests = 10
+	defaultBreakerCooldown    = 30 * time.Second
+	defaultBreakerWindow      = time.Minute
+
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+type breakerSettings struct {
+	// ratio of failed calls within the window which opens the circuit
+	failureRatio float64
+	// minimum number of calls within the window before the ratio is evaluated
+	minRequests int
+	// time the circuit stays open before a probe call is let through
+	cooldown time.Duration
+	// period after which the call counters are reset
+	window time.Duration
+}
+
+// breakerSettingsFor reads the circuit breaker settings from the router config,
+// it returns false if the circuit breaker is not enabled for the graph
+func breakerSettingsFor(graph *mcv1alpha3.GMConnector) (breakerSettings, bool) {
+	config := graph.Spec.RouterConfig.Config
+	settings := breakerSettings{
+		minRequests: defaultBreakerMinRequests,
+		cooldown:    defaultBreakerCooldown,
+		window:      defaultBreakerWindow,
+	}
+	ratio, err := strconv.ParseFloat(config[breakerFailureRatioKey], 64)
+	if err != nil || ratio <= 0 || ratio > 1 {
+		return settings, false
+	}
+	settings.failureRatio = ratio
+	if minRequests, err := strconv.Atoi(config[breakerMinRequestsKey]); err == nil && minRequests > 0 {
+		settings.minRequests = minRequests
+	}
+	if cooldown, err := time.ParseDuration(config[breakerCooldownKey]); err == nil && cooldown > 0 {
+		settings.cooldown = cooldown
+	}
+	if window, err := time.ParseDuration(config[breakerWindowKey]); err == nil && window > 0 {
+		settings.window = window
+	}
+	return settings, true
+}
+
+type circuitBreaker struct {
+	mu          sync.Mutex
+	settings    breakerSettings
+	state       string
+	requests    int
+	failures    int
+	windowStart time.Time
+	openedAt    time.Time
+	// a probe call is in progress while half-open
+	probing bool
+}
+
+// breakerStatus is the state of a circuit breaker shown on the debug endpoint
+type breakerStatus struct {
+	State    string    `json:"state"`
+	Requests int       `json:"requests"`
+	Failures int       `json:"failures"`
+	OpenedAt time.Time `json:"openedAt"`
+}
+
+func newCircuitBreaker(settings breakerSettings) *circuitBreaker {
+	return &circuitBreaker{
+		settings:    settings,
+		state:       breakerClosed,
+		windowStart: time.Now(),
+	}
+}
+
+// allow tells if a call to the service can be made
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case breakerOpen:
+		if time.Since(cb.openedAt) < cb.settings.cooldown {
+			return false
+		}
+		cb.state = breakerHalfOpen
+		cb.probing = true
+		return true
+	case breakerHalfOpen:
+		// only one probe call at a time
+		if cb.probing {
+			return false
+		}
+		cb.probing = true
+		return true
+	default:
+		if time.Since(cb.windowStart) > cb.settings.window {
+			cb.requests, cb.failures = 0, 0
+			cb.windowStart = time.Now()
+		}
+		return true
+	}
+}
+
+// record saves the result of a call allowed by the breaker
+func (cb *circuitBreaker) record(success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == breakerHalfOpen {
+		cb.probing = false
+		if success {
+			cb.state = breakerClosed
+			cb.requests, cb.failures = 0, 0
+			cb.windowStart = time.Now()
+		} else {
+			cb.state = breakerOpen
+			cb.openedAt = time.Now()
+		}
+		return
+	}
+
+	cb.requests++
+	if !success {
+		cb.failures++
+	}
+	if cb.requests >= cb.settings.minRequests &&
+		float64(cb.failures)/float64(cb.requests) >= cb.settings.failureRatio {
+		cb.state = breakerOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+// release ends a call allowed by the breaker without recording its result,
+// the calls cancelled by the caller tell nothing about the service
+func (cb *circuitBreaker) release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == breakerHalfOpen {
+		cb.probing = false
+	}
+}
+
+func (cb *circuitBreaker) status() breakerStatus {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return breakerStatus{
+		State:    cb.state,
+		Requests: cb.requests,
+		Failures: cb.failures,
+		OpenedAt: cb.openedAt,
+	}
+}
+
+// breakerRegistry holds one circuit breaker per ServiceURL
+type breakerRegistry struct {
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+var circuitBreakers = &breakerRegistry{breakers: map[string]*circuitBreaker{}}
+
+// get returns the breaker of the service, the settings follow the latest graph
+func (r *breakerRegistry) get(serviceURL string, settings breakerSettings) *circuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cb, ok := r.breakers[serviceURL]
+	if !ok {
+		cb = newCircuitBreaker(settings)
+		r.breakers[serviceURL] = cb
+		return cb
+	}
+	cb.mu.Lock()
+	cb.settings = settings
+	cb.mu.Unlock()
+	return cb
+}
+
+func (r *breakerRegistry) status() map[string]breakerStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statuses := make(map[string]breakerStatus, len(r.breakers))
+	for serviceURL, cb := range r.breakers {
+		statuses[serviceURL] = cb.status()
+	}
+	return statuses
+}
+
+// breakerDebugHandler shows the state of the circuit breaker of every service
+func breakerDebugHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(circuitBreakers.status()); err != nil {
+		log.Error(err, "failed to write circuit breaker status")
+	}
+}
+
+// executeFallback runs the fallback executor of the step
+func executeFallback(
+	ctx context.Context,
+	step *mcv1alpha3.Step,
+	graph mcv1alpha3.GMConnector,
+	initInput []byte,
+	input []byte,
+	headers http.Header,
+) (io.ReadCloser, int, error) {
+	fallback := step.Fallback
+	log.Info("Use the fallback of step", "stepName", step.StepName)
+	if fallback.NodeName != "" {
+		return routeStep(ctx, fallback.NodeName, graph, initInput, input, headers)
+	}
+	fallbackStep := fallbackServiceStep(step)
+	if fallbackStep == nil {
+		return nil, 503, fmt.Errorf("the fallback of step %s is not ready", step.StepName)
+	}
+	return callService(ctx, fallbackStep, fallbackStep.ServiceURL, input, headers)
+}
+
+// fallbackServiceStep returns the step calling the fallback service of a step with the settings of the step,
+// nil when the fallback is a node or its service is not ready
+func fallbackServiceStep(step *mcv1alpha3.Step) *mcv1alpha3.Step {
+	fallback := step.Fallback
+	if fallback == nil || fallback.NodeName != "" {
+		return nil
+	}
+	serviceURL := fallback.ServiceURL
+	if serviceURL == "" {
+		serviceURL = fallback.ExternalService
+	}
+	if serviceURL == "" {
+		return nil
+	}
+	fallbackStep := *step
+	fallbackStep.Executor = fallback.Executor
+	fallbackStep.ServiceURL = serviceURL
+	fallbackStep.Fallback = nil
+	return &fallbackStep
+}
@@ -0,0 +1,210 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreakerSettingsFor(t *testing.T) {
+	graph := &mcv1alpha3.GMConnector{}
+	_, enabled := breakerSettingsFor(graph)
+	assert.False(t, enabled)
+
+	graph.Spec.RouterConfig.Config = map[string]string{
+		breakerFailureRatioKey: "0.5",
+		breakerMinRequestsKey:  "4",
+		breakerCooldownKey:     "10s",
+	}
+	settings, enabled := breakerSettingsFor(graph)
+	assert.True(t, enabled)
+	assert.Equal(t, breakerSettings{
+		failureRatio: 0.5,
+		minRequests:  4,
+		cooldown:     10 * time.Second,
+		window:       defaultBreakerWindow,
+	}, settings)
+
+	graph.Spec.RouterConfig.Config[breakerFailureRatioKey] = "2"
+	_, enabled = breakerSettingsFor(graph)
+	assert.False(t, enabled)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cb := newCircuitBreaker(breakerSettings{
+		failureRatio: 0.5,
+		minRequests:  2,
+		cooldown:     50 * time.Millisecond,
+		window:       time.Minute,
+	})
+
+	assert.True(t, cb.allow())
+	cb.record(false)
+	assert.Equal(t, breakerClosed, cb.status().State)
+	assert.True(t, cb.allow())
+	cb.record(false)
+	assert.Equal(t, breakerOpen, cb.status().State)
+	assert.False(t, cb.allow())
+
+	// a failed probe opens the circuit again
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, cb.allow())
+	assert.False(t, cb.allow(), "only one probe is allowed while half-open")
+	cb.record(false)
+	assert.Equal(t, breakerOpen, cb.status().State)
+
+	// a successful probe closes the circuit
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, cb.allow())
+	cb.record(true)
+	assert.Equal(t, breakerClosed, cb.status().State)
+	assert.True(t, cb.allow())
+}
+
+func TestExecuteStepWithFallback(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer service.Close()
+	fallback := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(`{"predictions":"fallback"}`))
+	}))
+	defer fallback.Close()
+
+	step := &mcv1alpha3.Step{
+		StepName:   "Llm",
+		ServiceURL: service.URL,
+		Fallback: &mcv1alpha3.Fallback{
+			Executor: mcv1alpha3.Executor{ExternalService: fallback.URL},
+		},
+	}
+	graph := mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			RouterConfig: mcv1alpha3.RouterConfig{
+				Config: map[string]string{
+					breakerFailureRatioKey: "1",
+					breakerMinRequestsKey:  "2",
+					breakerCooldownKey:     "1m",
+				},
+			},
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		_, statusCode, err := executeStep(context.Background(), step, graph, []byte(`{}`), []byte(`{}`), http.Header{})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, statusCode)
+	}
+
+	res, statusCode, err := executeStep(context.Background(), step, graph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	body, err := io.ReadAll(res)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"predictions":"fallback"}`, string(body))
+
+	// the breaker state is visible on the debug endpoint
+	rr := httptest.NewRecorder()
+	breakerDebugHandler(rr, httptest.NewRequest(http.MethodGet, "/debug/circuitbreakers", nil))
+	var statuses map[string]breakerStatus
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &statuses))
+	assert.Equal(t, breakerOpen, statuses[service.URL].State)
+	assert.Equal(t, 2, statuses[service.URL].Failures)
+}
+
+func TestCircuitBreakerCancelledCalls(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.ReadAll(req.Body)
+		select {
+		case <-req.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer service.Close()
+
+	step := &mcv1alpha3.Step{StepName: "Llm", ServiceURL: service.URL}
+	graph := mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			RouterConfig: mcv1alpha3.RouterConfig{
+				Config: map[string]string{
+					breakerFailureRatioKey: "1",
+					breakerMinRequestsKey:  "1",
+					breakerCooldownKey:     "1m",
+				},
+			},
+		},
+	}
+
+	// the callers giving up do not open the circuit of the service
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, _, err := executeStep(ctx, step, graph, []byte(`{}`), []byte(`{}`), http.Header{})
+		cancel()
+		assert.Error(t, err)
+	}
+	status := circuitBreakers.status()[service.URL]
+	assert.Equal(t, breakerClosed, status.State)
+	assert.Equal(t, 0, status.Failures)
+
+	// nor keep the probe of a half-open circuit
+	cb := newCircuitBreaker(breakerSettings{failureRatio: 1, minRequests: 1, cooldown: 0, window: time.Minute})
+	assert.True(t, cb.allow())
+	cb.record(false)
+	assert.True(t, cb.allow())
+	cb.release()
+	assert.True(t, cb.allow())
+}
+
+func TestCircuitBreakerCallQueueFull(t *testing.T) {
+	service := newEchoService(`{"text":"OPEA"}`)
+	defer service.Close()
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	oldQueue := callQueue
+	defer func() { callQueue = oldQueue }()
+
+	step := &mcv1alpha3.Step{StepName: "Llm", ServiceURL: service.URL}
+	graph := &mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			RouterConfig: mcv1alpha3.RouterConfig{
+				Config: map[string]string{
+					breakerFailureRatioKey: "1",
+					breakerMinRequestsKey:  "1",
+					breakerCooldownKey:     "1m",
+					callQueueSizeKey:       "0",
+				},
+			},
+		},
+	}
+	mcGraph.Store(graph)
+	callQueue = newAdmissionQueue(1)
+	assert.NoError(t, callQueue.acquire(context.Background(), 0, time.Second))
+
+	// the calls the router cannot make do not open the circuit of the service
+	for i := 0; i < 2; i++ {
+		_, statusCode, err := executeStep(context.Background(), step, *graph, []byte(`{}`), []byte(`{}`), http.Header{})
+		assert.ErrorIs(t, err, errCallQueueFull)
+		assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+	}
+	status := circuitBreakers.status()[service.URL]
+	assert.Equal(t, breakerClosed, status.State)
+	assert.Equal(t, 0, status.Failures)
+
+	callQueue.release()
+	body, statusCode, err := executeStep(context.Background(), step, *graph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	_ = body.Close()
+}
@@ -0,0 +1,73 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/opea-project/GenAIInfra/microservices-connector/internal/condition"
+	"github.com/tidwall/gjson"
+)
+
+// compiledConditions caches the parsed expressions of the step conditions by their source,
+// it only keeps the conditions of the current graph once it is reloaded
+var compiledConditions sync.Map
+
+func compileCondition(expr string) (*condition.Condition, error) {
+	if cached, ok := compiledConditions.Load(expr); ok {
+		return cached.(*condition.Condition), nil
+	}
+	cond, err := condition.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	compiledConditions.Store(expr, cond)
+	return cond, nil
+}
+
+// pruneCompiledConditions drops the compiled conditions no step of the graph has
+func pruneCompiledConditions(graph *mcv1alpha3.GMConnector) {
+	used := map[string]bool{}
+	for _, node := range graph.Spec.Nodes {
+		for _, step := range node.Steps {
+			used[step.Condition] = true
+		}
+	}
+	compiledConditions.Range(func(key, _ any) bool {
+		if !used[key.(string)] {
+			compiledConditions.Delete(key)
+		}
+		return true
+	})
+}
+
+// matchCondition evaluates a condition written in the expression language,
+// an invalid expression never matches
+func matchCondition(expr string, env condition.Env) bool {
+	cond, err := compileCondition(expr)
+	if err != nil {
+		log.Error(err, "invalid condition", "condition", expr)
+		return false
+	}
+	matched := cond.Eval(env)
+	log.Info("Evaluated condition", "condition", expr, "matched", matched)
+	return matched
+}
+
+// matchStepCondition checks the condition of a step against the response of the step before it,
+// a gjson path condition is checked against the request when there is no such response
+func matchStepCondition(step *mcv1alpha3.Step, initInput []byte, response []byte, headers http.Header) bool {
+	if condition.IsExpression(step.Condition) {
+		env := condition.Env{Request: initInput, Response: response, Headers: headers}
+		return matchCondition(step.Condition, env)
+	}
+	if response == nil {
+		return pickupRouteByCondition(initInput, step.Condition)
+	}
+	return gjson.GetBytes(response, step.Condition).Exists()
+}
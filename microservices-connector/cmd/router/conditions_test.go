@@ -0,0 +1,126 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/stretchr/testify/assert"
+)
+
+func newEchoService(response string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.ReadAll(req.Body)
+		_, _ = rw.Write([]byte(response))
+	}))
+}
+
+func TestSwitchWithExpression(t *testing.T) {
+	small := newEchoService(`{"model":"small"}`)
+	defer small.Close()
+	big := newEchoService(`{"model":"big"}`)
+	defer big.Close()
+
+	gmcGraph := mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Switch,
+					Steps: []mcv1alpha3.Step{
+						{
+							StepName:   "Llm",
+							ServiceURL: big.URL,
+							Condition:  `len($request.query) > 10 || $headers.X-Tier == "gold"`,
+						},
+						{
+							StepName:   "Llm",
+							ServiceURL: small.URL,
+							Condition:  `len($request.query) <= 10 && $headers.X-Tier != "gold"`,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		headers http.Header
+		want    string
+	}{
+		{name: "short prompt", input: `{"query":"hi"}`, headers: http.Header{}, want: `{"model":"small"}`},
+		{name: "long prompt", input: `{"query":"tell me a long story"}`, headers: http.Header{}, want: `{"model":"big"}`},
+		{name: "gold tier", input: `{"query":"hi"}`, headers: http.Header{"X-Tier": {"gold"}}, want: `{"model":"big"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, _, err := routeStep(context.Background(), "root", gmcGraph, []byte(tt.input), []byte(tt.input), tt.headers)
+			assert.NoError(t, err)
+			body, err := io.ReadAll(res)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(body))
+		})
+	}
+}
+
+func TestSequenceWithExpression(t *testing.T) {
+	classifier := newEchoService(`{"label":"cat","score":0.4}`)
+	defer classifier.Close()
+	llm := newEchoService(`{"text":"a cat"}`)
+	defer llm.Close()
+
+	gmcGraph := mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps: []mcv1alpha3.Step{
+						{StepName: "Classifier", ServiceURL: classifier.URL},
+						{StepName: "Llm", ServiceURL: llm.URL, Condition: `$response.score > 0.5`},
+					},
+				},
+			},
+		},
+	}
+
+	// the condition does not match, the sequence stops with the response of the classifier
+	res, _, err := routeStep(context.Background(), "root", gmcGraph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.NoError(t, err)
+	body, err := io.ReadAll(res)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"label":"cat","score":0.4}`, string(body))
+
+	node := gmcGraph.Spec.Nodes["root"]
+	node.Steps[1].Condition = `$response.label == "cat" && $response.score > 0.3`
+	gmcGraph.Spec.Nodes["root"] = node
+	res, _, err = routeStep(context.Background(), "root", gmcGraph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.NoError(t, err)
+	body, err = io.ReadAll(res)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"text":"a cat"}`, string(body))
+}
+
+func TestPruneCompiledConditions(t *testing.T) {
+	kept, removed := `$headers.X-Tier == "gold"`, `$headers.X-Tier == "silver"`
+	for _, expr := range []string{kept, removed} {
+		_, err := compileCondition(expr)
+		assert.NoError(t, err)
+	}
+
+	pruneCompiledConditions(&mcv1alpha3.GMConnector{Spec: mcv1alpha3.GMConnectorSpec{Nodes: map[string]mcv1alpha3.Router{
+		"root": {RouterType: mcv1alpha3.Switch, Steps: []mcv1alpha3.Step{{StepName: "Llm", Condition: kept}}},
+	}}})
+	_, ok := compiledConditions.Load(kept)
+	assert.True(t, ok)
+	_, ok = compiledConditions.Load(removed)
+	assert.False(t, ok)
+}
@@ -0,0 +1,244 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+)
+
+// dagStepResult is the outcome of a step of a DAG node
+type dagStepResult struct {
+	// the response of a step nothing depends on, when it is the only one
+	body io.ReadCloser
+	// the response of the other steps
+	output     []byte
+	statusCode int
+	// the step did not run because of its condition or because it is a downstream service
+	skipped bool
+	// the step is a hard dependency and it is unsuccessful
+	failed bool
+	err    error
+}
+
+// dagRun holds the values shared by the steps of a DAG node for a request
+type dagRun struct {
+	nodeName    string
+	graph       mcv1alpha3.GMConnector
+	initInput   []byte
+	initReqData map[string]interface{}
+	input       []byte
+	headers     http.Header
+}
+
+// handleDAGPipeline starts every step once the steps in its dependsOn finished, so the
+// independent branches of the node run concurrently. The steps without dependencies get
+// the input of the node, the others refer to the response of their first dependency with
+// $response and to the responses of any earlier step with $steps in their Data.
+// A single step nothing depends on streams its response back, the responses of several
+// such steps are merged into an object by step name.
+func handleDAGPipeline(ctx context.Context,
+	nodeName string,
+	graph mcv1alpha3.GMConnector,
+	initInput []byte,
+	input []byte,
+	headers http.Header,
+) (io.ReadCloser, int, error) {
+	currentNode := graph.Spec.Nodes[nodeName]
+	steps := currentNode.Steps
+
+	initReqData := make(map[string]interface{})
+	if err := json.Unmarshal(initInput, &initReqData); err != nil {
+		log.Error(err, "Error unmarshaling initReqData:")
+		return nil, 500, err
+	}
+	run := &dagRun{
+		nodeName:    nodeName,
+		graph:       graph,
+		initInput:   initInput,
+		initReqData: initReqData,
+		input:       input,
+		headers:     headers,
+	}
+
+	indexes := make(map[string]int, len(steps))
+	for i, step := range steps {
+		indexes[step.StepName] = i
+	}
+	dependencies := make([][]int, len(steps))
+	sink := make([]bool, len(steps))
+	for i := range sink {
+		sink[i] = true
+	}
+	for i, step := range steps {
+		for _, dep := range step.DependsOn {
+			j, ok := indexes[dep]
+			if !ok {
+				return nil, 500, fmt.Errorf("step %s depends on unknown step %s", step.StepName, dep)
+			}
+			dependencies[i] = append(dependencies[i], j)
+			sink[j] = false
+		}
+	}
+	sinks := 0
+	for i, isSink := range sink {
+		// the downstream services are called by the other services, and the router looks up
+		// the semantic cache itself, these steps never answer the request
+		if steps[i].InternalService.IsDownstreamService || steps[i].StepName == mcv1alpha3.SemanticCacheStep {
+			sink[i] = false
+			continue
+		}
+		if isSink {
+			sinks++
+		}
+	}
+
+	// stop the remaining steps once a step failed or the request is cancelled
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]dagStepResult, len(steps))
+	done := make([]chan struct{}, len(steps))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	// buffered, so the steps finishing after the node returned do not block
+	finished := make(chan int, len(steps))
+	for i := range steps {
+		go func(i int) {
+			for _, dep := range dependencies[i] {
+				select {
+				case <-done[dep]:
+				case <-ctx.Done():
+					return
+				}
+			}
+			var response []byte
+			if len(dependencies[i]) > 0 {
+				response = results[dependencies[i][0]].output
+			}
+			// only a single sink is streamed, it is the last step to finish
+			stream := sink[i] && sinks == 1
+			results[i] = run.runStep(ctx, &steps[i], response, len(dependencies[i]) > 0, stream)
+			close(done[i])
+			finished <- i
+		}(i)
+	}
+
+	for remaining := len(steps); remaining > 0; remaining-- {
+		select {
+		case i := <-finished:
+			result := results[i]
+			if result.err != nil {
+				return nil, 500, result.err
+			}
+			if result.failed {
+				log.Info(
+					"This step is a hard dependency and it is unsuccessful",
+					"stepName",
+					steps[i].StepName,
+					"statusCode",
+					result.statusCode,
+				)
+				// stop the execution of the DAG right away if a step is a hard dependency and is unsuccessful
+				if result.body != nil {
+					return result.body, result.statusCode, nil
+				}
+				return NewReadCloser(result.output), result.statusCode, nil
+			}
+		case <-ctx.Done():
+			return nil, 500, ctx.Err()
+		}
+	}
+
+	response := map[string]json.RawMessage{}
+	for i, step := range steps {
+		if !sink[i] || results[i].skipped {
+			continue
+		}
+		if results[i].body != nil {
+			return results[i].body, results[i].statusCode, nil
+		}
+		response[step.StepName] = rawJSON(results[i].output)
+	}
+	combinedResponse, err := json.Marshal(response)
+	if err != nil {
+		return nil, 500, err
+	}
+	return NewReadCloser(combinedResponse), 200, nil
+}
+
+// runStep executes a step of the DAG node, response is the response of its first dependency
+func (r *dagRun) runStep(ctx context.Context,
+	step *mcv1alpha3.Step,
+	response []byte,
+	hasDependencies bool,
+	stream bool,
+) dagStepResult {
+	stepType := ServiceURL
+	if step.NodeName != "" {
+		stepType = ServiceNode
+	}
+	if step.InternalService.IsDownstreamService {
+		log.Info(
+			"InternalService DownstreamService is true, skip the execution of step",
+			"type",
+			stepType,
+			"stepName",
+			step.StepName,
+		)
+		return dagStepResult{skipped: true}
+	}
+	if step.Condition != "" && !matchStepCondition(step, r.initInput, response, r.headers) {
+		log.Info("The condition does not match, skip the execution of step", "stepName", step.StepName)
+		return dagStepResult{skipped: true}
+	}
+	log.Info("Starting execution of step", "type", stepType, "stepName", step.StepName)
+
+	request := r.input
+	if step.Data == responseData && hasDependencies {
+		request = mergeRequests(response, r.initReqData)
+	} else if isDataTemplate(step.Data) {
+		var err error
+		if request, err = renderStepData(ctx, step, r.initInput, response); err != nil {
+			return dagStepResult{err: err}
+		}
+	}
+	log.Info("Print New Request Bytes", "Request Bytes", request)
+
+	stepStart := time.Now()
+	stepCtx, span := startStepSpan(ctx, r.nodeName, step, request)
+	responseBody, statusCode, err := executeStep(stepCtx, step, r.graph, r.initInput, request, r.headers)
+	endSpan(span, statusCode, err)
+	observeStep(r.nodeName, step.StepName, statusCode, err, stepStart)
+	if err != nil {
+		return dagStepResult{err: err}
+	}
+
+	result := dagStepResult{
+		statusCode: statusCode,
+		failed:     step.Dependency == mcv1alpha3.Hard && !isSuccessFul(statusCode),
+	}
+	if stream {
+		result.body = responseBody
+		return result
+	}
+	result.output, err = io.ReadAll(responseBody)
+	if cerr := responseBody.Close(); cerr != nil {
+		log.Error(cerr, "Error while trying to close the responseBody in handleDAGPipeline")
+	}
+	if err != nil {
+		log.Error(err, "Error while reading the response body")
+		return dagStepResult{err: err}
+	}
+	recordStepOutput(ctx, step.StepName, result.output)
+	return result
+}
@@ -0,0 +1,207 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDAGConcurrentBranches(t *testing.T) {
+	// both retrievers only answer once the other one was called as well
+	var branches sync.WaitGroup
+	branches.Add(2)
+	newRetriever := func(response string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			_, _ = io.ReadAll(req.Body)
+			branches.Done()
+			waited := make(chan struct{})
+			go func() {
+				branches.Wait()
+				close(waited)
+			}()
+			select {
+			case <-waited:
+				_, _ = rw.Write([]byte(response))
+			case <-time.After(5 * time.Second):
+				rw.WriteHeader(http.StatusGatewayTimeout)
+			}
+		}))
+	}
+	embedding := newEchoService(`{"embedding":[0.1,0.2]}`)
+	defer embedding.Close()
+	retriever := newRetriever(`{"retrieved_docs":[{"text":"OPEA is an open platform"}]}`)
+	defer retriever.Close()
+	webRetriever := newRetriever(`{"retrieved_docs":[{"text":"for enterprise AI"}]}`)
+	defer webRetriever.Close()
+	var rerankRequest []byte
+	reranking := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rerankRequest, _ = io.ReadAll(req.Body)
+		_, _ = rw.Write([]byte(`{"reranked_docs":[{"text":"OPEA is an open platform"}]}`))
+	}))
+	defer reranking.Close()
+
+	gmcGraph := mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.DAG,
+					Steps: []mcv1alpha3.Step{
+						{
+							StepName:   "Reranking",
+							ServiceURL: reranking.URL,
+							DependsOn:  []string{"Retriever", "WebRetriever"},
+							Data: `{
+								"query": "$request.query",
+								"docs": "$steps.Retriever.response.retrieved_docs",
+								"web_docs": "$steps.WebRetriever.response.retrieved_docs"
+							}`,
+						},
+						{StepName: "Retriever", ServiceURL: retriever.URL, DependsOn: []string{"Embedding"}, Data: "$response"},
+						{StepName: "Embedding", ServiceURL: embedding.URL, Data: `{"text":"$request.query"}`},
+						{StepName: "WebRetriever", ServiceURL: webRetriever.URL},
+					},
+				},
+			},
+		},
+	}
+
+	input := []byte(`{"query":"What is OPEA?"}`)
+	res, statusCode, err := routeStep(context.Background(), "root", gmcGraph, input, input, http.Header{})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, statusCode)
+	body, err := io.ReadAll(res)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"reranked_docs":[{"text":"OPEA is an open platform"}]}`, string(body))
+	assert.JSONEq(t, `{
+		"query": "What is OPEA?",
+		"docs": [{"text":"OPEA is an open platform"}],
+		"web_docs": [{"text":"for enterprise AI"}]
+	}`, string(rerankRequest))
+}
+
+func TestDAGMultipleSinks(t *testing.T) {
+	embedding := newEchoService(`{"embedding":[0.1,0.2]}`)
+	defer embedding.Close()
+	retriever := newEchoService(`{"docs":["OPEA"]}`)
+	defer retriever.Close()
+	summary := newEchoService(`plain text`)
+	defer summary.Close()
+	var called atomic.Bool
+	llm := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		called.Store(true)
+		_, _ = rw.Write([]byte(`{"text":"answer"}`))
+	}))
+	defer llm.Close()
+
+	gmcGraph := mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.DAG,
+					Steps: []mcv1alpha3.Step{
+						{StepName: "Embedding", ServiceURL: embedding.URL},
+						{StepName: "Retriever", ServiceURL: retriever.URL, DependsOn: []string{"Embedding"}},
+						{StepName: "DocSum", ServiceURL: summary.URL, DependsOn: []string{"Embedding"}},
+						{
+							StepName:   "Llm",
+							ServiceURL: llm.URL,
+							DependsOn:  []string{"Retriever"},
+							Condition:  `len($response.docs) > 1`,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	res, statusCode, err := routeStep(context.Background(), "root", gmcGraph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, statusCode)
+	body, err := io.ReadAll(res)
+	assert.NoError(t, err)
+	// the skipped Llm step is left out
+	assert.JSONEq(t, `{"DocSum":"plain text"}`, string(body))
+	assert.False(t, called.Load())
+}
+
+func TestDAGHardDependencyFailure(t *testing.T) {
+	retriever := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusNotFound)
+		_, _ = rw.Write([]byte(`{"error":"no index"}`))
+	}))
+	defer retriever.Close()
+	var called atomic.Bool
+	llm := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		called.Store(true)
+		_, _ = rw.Write([]byte(`{"text":"answer"}`))
+	}))
+	defer llm.Close()
+
+	gmcGraph := mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.DAG,
+					Steps: []mcv1alpha3.Step{
+						{StepName: "Retriever", ServiceURL: retriever.URL, Dependency: mcv1alpha3.Hard},
+						{StepName: "Llm", ServiceURL: llm.URL, DependsOn: []string{"Retriever"}},
+					},
+				},
+			},
+		},
+	}
+
+	res, statusCode, err := routeStep(context.Background(), "root", gmcGraph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+	body, err := io.ReadAll(res)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"error":"no index"}`, string(body))
+	assert.False(t, called.Load())
+}
+
+func TestDAGDownstreamStepIsNoSink(t *testing.T) {
+	llm := newEchoService(`{"text":"answer"}`)
+	defer llm.Close()
+
+	gmcGraph := mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.DAG,
+					Steps: []mcv1alpha3.Step{
+						{StepName: "Llm", ServiceURL: llm.URL},
+						{
+							StepName: "Tgi",
+							Executor: mcv1alpha3.Executor{InternalService: mcv1alpha3.GMCTarget{
+								ServiceName:         "tgi-service-m",
+								IsDownstreamService: true,
+							}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	res, statusCode, err := routeStep(context.Background(), "root", gmcGraph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, statusCode)
+	body, err := io.ReadAll(res)
+	assert.NoError(t, err)
+	// the Llm step is the only sink, its response is streamed as is
+	assert.Equal(t, `{"text":"answer"}`, string(body))
+}
@@ -0,0 +1,173 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+func dataPrepPath(step *mcv1alpha3.Step) string {
+	if step.DataPrep == nil || step.DataPrep.Path == "" {
+		return mcv1alpha3.DefaultDataPrepPath
+	}
+	return step.DataPrep.Path
+}
+
+// findDataPrepRoute returns the DataPrep step serving the path, and the operation the path is for
+func findDataPrepRoute(graph *mcv1alpha3.GMConnector, path string) (*mcv1alpha3.Step, string) {
+	nodeNames := make([]string, 0, len(graph.Spec.Nodes))
+	for nodeName := range graph.Spec.Nodes {
+		nodeNames = append(nodeNames, nodeName)
+	}
+	slices.Sort(nodeNames)
+	for _, nodeName := range nodeNames {
+		node := graph.Spec.Nodes[nodeName]
+		for i := range node.Steps {
+			step := &node.Steps[i]
+			if step.StepName != DataPrep {
+				continue
+			}
+			routePath := dataPrepPath(step)
+			if path == routePath {
+				return step, mcv1alpha3.DataPrepIngest
+			}
+			switch operation := strings.TrimPrefix(path, routePath+"/"); operation {
+			case mcv1alpha3.DataPrepGetFile, mcv1alpha3.DataPrepDeleteFile:
+				return step, operation
+			}
+		}
+	}
+	return nil, ""
+}
+
+func dataPrepOperation(step *mcv1alpha3.Step, operation string) *mcv1alpha3.DataPrepOperation {
+	if step.DataPrep == nil {
+		return nil
+	}
+	for i := range step.DataPrep.Operations {
+		if step.DataPrep.Operations[i].Name == operation {
+			return &step.DataPrep.Operations[i]
+		}
+	}
+	return nil
+}
+
+// dataPrepTimeout returns the timeout of the operation, or else the one of the step or of the router config
+func dataPrepTimeout(graph *mcv1alpha3.GMConnector, step *mcv1alpha3.Step, operation string) time.Duration {
+	if settings := dataPrepOperation(step, operation); settings != nil && settings.Timeout != nil && settings.Timeout.Duration > 0 {
+		return settings.Timeout.Duration
+	}
+	if step.Timeout != nil && step.Timeout.Duration > 0 {
+		return step.Timeout.Duration
+	}
+	return requestTimeout(graph)
+}
+
+// dataPrepMaxRequestSize returns the largest request body of the operation, 0 when there is no limit
+func dataPrepMaxRequestSize(step *mcv1alpha3.Step, operation string) int64 {
+	if settings := dataPrepOperation(step, operation); settings != nil && settings.MaxRequestSize != nil {
+		return settings.MaxRequestSize.Value()
+	}
+	if step.DataPrep != nil && step.DataPrep.MaxRequestSize != nil {
+		return step.DataPrep.MaxRequestSize.Value()
+	}
+	return 0
+}
+
+// serveDataPrep proxies the request to the service of the DataPrep step. The bodies are streamed
+// in both directions, the uploaded files are never held in memory.
+func serveDataPrep(w http.ResponseWriter, r *http.Request, graph *mcv1alpha3.GMConnector, step *mcv1alpha3.Step, operation string) {
+	serviceURL := getServiceURLByStepTarget(step, graph.Namespace)
+	target, err := url.Parse(serviceURL)
+	if err != nil {
+		log.Error(err, "invalid service URL of the dataprep step", "stepName", step.StepName)
+		http.Error(w, "invalid dataprep service URL", http.StatusInternalServerError)
+		return
+	}
+	stepTransport, err := transports.get(step, serviceURL)
+	if err != nil {
+		log.Error(err, "failed to create the transport of the dataprep step", "stepName", step.StepName)
+		http.Error(w, "invalid dataprep transport", http.StatusInternalServerError)
+		return
+	}
+	if operation != mcv1alpha3.DataPrepIngest {
+		target.Path = strings.TrimSuffix(target.Path, "/") + "/" + operation
+		target.RawPath = ""
+	}
+
+	maxRequestSize := dataPrepMaxRequestSize(step, operation)
+	if maxRequestSize > 0 {
+		if r.ContentLength > maxRequestSize {
+			http.Error(w, fmt.Sprintf("the request body is larger than %d bytes", maxRequestSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	}
+
+	timeout := dataPrepTimeout(graph, step, operation)
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	// the uploads are read for as long as the operation may take, not only for the read timeout of the server
+	if err := http.NewResponseController(w).SetReadDeadline(time.Now().Add(timeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Error(err, "failed to extend the read deadline of the dataprep request", "stepName", step.StepName)
+	}
+
+	// the service gets the headers of the step policy like the calls of the other steps,
+	// only the headers describing the body are passed on as they are
+	outgoing := http.Header{}
+	for _, name := range []string{"Content-Type", "Content-Length"} {
+		if values := r.Header.Values(name); len(values) > 0 {
+			outgoing[name] = values
+		}
+	}
+	if err := applyHeaderPolicy(step, r.Header, outgoing); err != nil {
+		log.Error(err, "Failed to set the headers of the dataprep request", "stepName", step.StepName)
+		http.Error(w, "Failed to set the headers of the dataprep request", http.StatusInternalServerError)
+		return
+	}
+	for key, values := range forwardedHeadersFrom(r.Context()) {
+		outgoing[key] = values
+	}
+
+	log.Info("Proxying the dataprep request", "stepName", step.StepName, "operation", operation, "serviceURL", target.String())
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			// SetURL joins the paths, the target is the full path of the operation
+			pr.Out.URL.Path = target.Path
+			pr.Out.URL.RawPath = target.RawPath
+			pr.Out.Header = outgoing.Clone()
+			propagator.Inject(pr.Out.Context(), propagation.HeaderCarrier(pr.Out.Header))
+			pr.SetXForwarded()
+		},
+		Transport: stepTransport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			var maxBytesErr *http.MaxBytesError
+			switch {
+			case errors.As(err, &maxBytesErr):
+				http.Error(w, fmt.Sprintf("the request body is larger than %d bytes", maxBytesErr.Limit), http.StatusRequestEntityTooLarge)
+			case errors.Is(err, context.DeadlineExceeded) || errors.Is(r.Context().Err(), context.DeadlineExceeded):
+				log.Error(err, "the dataprep request timed out", "stepName", step.StepName, "operation", operation)
+				http.Error(w, "the dataprep request timed out", http.StatusGatewayTimeout)
+			default:
+				log.Error(err, "failed to send the dataprep request", "stepName", step.StepName, "operation", operation)
+				http.Error(w, "Failed to send request to backend", http.StatusBadGateway)
+			}
+		},
+	}
+	proxy.ServeHTTP(w, r.WithContext(ctx))
+}
@@ -0,0 +1,306 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDataPrepProxy(t *testing.T) {
+	type received struct {
+		method      string
+		path        string
+		contentType string
+		body        []byte
+	}
+	requests := make(chan received, 1)
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		if r.URL.Query().Get("sleep") != "" {
+			time.Sleep(200 * time.Millisecond)
+			return
+		}
+		requests <- received{method: r.Method, path: r.URL.Path, contentType: r.Header.Get("Content-Type"), body: body}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":200}`))
+	}))
+	defer service.Close()
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, err := writer.CreateFormFile("files", "opea.txt")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	_, _ = part.Write(bytes.Repeat([]byte("OPEA "), 100))
+	_ = writer.Close()
+
+	maxRequestSize := resource.MustParse("1Ki")
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(&mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps: []mcv1alpha3.Step{
+						{StepName: "DataPrep", ServiceURL: service.URL + "/v1/dataprep"},
+					},
+				},
+				"docs": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps: []mcv1alpha3.Step{
+						{
+							StepName:   "DataPrep",
+							ServiceURL: service.URL + "/v1/dataprep",
+							DataPrep: &mcv1alpha3.DataPrep{
+								Path:           "/v1/docs",
+								MaxRequestSize: &maxRequestSize,
+								Operations: []mcv1alpha3.DataPrepOperation{
+									{Name: "get_file", Timeout: &metav1.Duration{Duration: 50 * time.Millisecond}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		body        []byte
+		contentType string
+		chunked     bool
+		statusCode  int
+		wantPath    string
+	}{
+		{
+			name:        "ingest on the default path",
+			method:      http.MethodPost,
+			path:        "/dataprep",
+			body:        form.Bytes(),
+			contentType: writer.FormDataContentType(),
+			statusCode:  http.StatusOK,
+			wantPath:    "/v1/dataprep",
+		},
+		{
+			name:        "get_file on the default path",
+			method:      http.MethodPost,
+			path:        "/dataprep/get_file",
+			body:        []byte(`{}`),
+			contentType: "application/json",
+			statusCode:  http.StatusOK,
+			wantPath:    "/v1/dataprep/get_file",
+		},
+		{
+			name:        "delete_file on a declared path",
+			method:      http.MethodPost,
+			path:        "/v1/docs/delete_file",
+			body:        []byte(`{"file_path":"opea.txt"}`),
+			contentType: "application/json",
+			statusCode:  http.StatusOK,
+			wantPath:    "/v1/dataprep/delete_file",
+		},
+		{
+			name:        "body larger than the limit",
+			method:      http.MethodPost,
+			path:        "/v1/docs",
+			body:        bytes.Repeat([]byte("x"), 2048),
+			contentType: "text/plain",
+			statusCode:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:        "chunked body larger than the limit",
+			method:      http.MethodPost,
+			path:        "/v1/docs",
+			body:        bytes.Repeat([]byte("x"), 2048),
+			contentType: "text/plain",
+			chunked:     true,
+			statusCode:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:        "operation timeout",
+			method:      http.MethodPost,
+			path:        "/v1/docs/get_file?sleep=1",
+			body:        []byte(`{}`),
+			contentType: "application/json",
+			statusCode:  http.StatusGatewayTimeout,
+		},
+		{
+			name:        "unknown operation",
+			method:      http.MethodPost,
+			path:        "/dataprep/upload",
+			body:        []byte(`{}`),
+			contentType: "application/json",
+			statusCode:  http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, bytes.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			rr := httptest.NewRecorder()
+			initializeRoutes().ServeHTTP(rr, req)
+			assert.Equal(t, tt.statusCode, rr.Code)
+
+			if tt.wantPath == "" {
+				return
+			}
+			select {
+			case got := <-requests:
+				assert.Equal(t, tt.method, got.method)
+				assert.Equal(t, tt.wantPath, got.path)
+				assert.Equal(t, tt.contentType, got.contentType)
+				assert.Equal(t, tt.body, got.body)
+				assert.Equal(t, `{"status":200}`, strings.TrimSpace(rr.Body.String()))
+			case <-time.After(time.Second):
+				t.Fatal("the request was not proxied")
+			}
+		})
+	}
+}
+
+func TestDataPrepSlowUpload(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(`{"status":200}`))
+	}))
+	defer service.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(&mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps: []mcv1alpha3.Step{{
+						StepName:   "DataPrep",
+						ServiceURL: service.URL + "/v1/dataprep",
+						Timeout:    &metav1.Duration{Duration: 5 * time.Second},
+					}},
+				},
+			},
+		},
+	})
+	router := httptest.NewUnstartedServer(initializeRoutes())
+	router.Config.ReadTimeout = 100 * time.Millisecond
+	router.Start()
+	defer router.Close()
+
+	// the upload takes longer than the read timeout of the server, but not than the timeout of the step
+	body, upload := io.Pipe()
+	go func() {
+		for i := 0; i < 4; i++ {
+			time.Sleep(50 * time.Millisecond)
+			_, _ = upload.Write(bytes.Repeat([]byte("OPEA "), 100))
+		}
+		_ = upload.Close()
+	}()
+	resp, err := http.Post(router.URL+"/dataprep", "text/plain", body)
+	if err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestDataPrepHeaders(t *testing.T) {
+	oldSecretsDir := *secretsDir
+	defer func() { *secretsDir = oldSecretsDir }()
+	*secretsDir = t.TempDir()
+	if err := os.MkdirAll(filepath.Join(*secretsDir, "dataprep-api"), 0700); err != nil {
+		t.Fatalf("failed to create the secret directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(*secretsDir, "dataprep-api", "key"), []byte("sk-opea\n"), 0600); err != nil {
+		t.Fatalf("failed to write the secret: %v", err)
+	}
+	secretRef := func(name string) *corev1.SecretKeySelector {
+		return &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: name}, Key: "key"}
+	}
+
+	received := make(chan http.Header, 1)
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		received <- r.Header.Clone()
+		_, _ = w.Write([]byte(`{"status":200}`))
+	}))
+	defer service.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	graph := &mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps: []mcv1alpha3.Step{{
+						StepName:   "DataPrep",
+						ServiceURL: service.URL + "/v1/dataprep",
+						Headers: &mcv1alpha3.HeaderPolicy{
+							Forward: []string{"X-Tenant"},
+							Set:     []mcv1alpha3.HeaderValue{{Name: "Authorization", SecretKeyRef: secretRef("dataprep-api")}},
+						},
+					}},
+				},
+			},
+		},
+	}
+	mcGraph.Store(graph)
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/dataprep", strings.NewReader(`{}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer user-token")
+		req.Header.Set("Cookie", "session=opea")
+		req.Header.Set("X-Tenant", "a")
+		return req
+	}
+	rr := httptest.NewRecorder()
+	initializeRoutes().ServeHTTP(rr, newRequest())
+	assert.Equal(t, http.StatusOK, rr.Code)
+	select {
+	case headers := <-received:
+		// only the allowed headers of the caller reach the service, with the key of the step
+		assert.Equal(t, "sk-opea", headers.Get("Authorization"))
+		assert.Equal(t, "a", headers.Get("X-Tenant"))
+		assert.Equal(t, "application/json", headers.Get("Content-Type"))
+		assert.Empty(t, headers.Get("Cookie"))
+	case <-time.After(time.Second):
+		t.Fatal("the request was not proxied")
+	}
+
+	// the request is not proxied without the value of its headers
+	graph.Spec.Nodes["root"].Steps[0].Headers.Set[0].SecretKeyRef = secretRef("missing")
+	rr = httptest.NewRecorder()
+	initializeRoutes().ServeHTTP(rr, newRequest())
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Empty(t, received)
+}
@@ -0,0 +1,378 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/tidwall/gjson"
+)
+
+// ensembleStepResult is the response of a step of an Ensemble node
+type ensembleStepResult struct {
+	index      int
+	output     []byte
+	statusCode int
+	err        error
+}
+
+func (r ensembleStepResult) successful() bool {
+	return r.err == nil && isSuccessFul(r.statusCode)
+}
+
+// ensembleMerger merges the responses of the steps of an Ensemble node
+type ensembleMerger interface {
+	// add takes the response of a step, it returns true once the result
+	// is decided and the remaining steps can be dropped
+	add(step *mcv1alpha3.Step, result ensembleStepResult) (bool, error)
+	// merge builds the response of the node out of the responses added so far
+	merge() (io.ReadCloser, int, error)
+}
+
+func newEnsembleMerger(strategy *mcv1alpha3.MergeStrategy, steps int) (ensembleMerger, error) {
+	if strategy == nil {
+		return &mapMerger{response: map[string]interface{}{}}, nil
+	}
+	switch strategy.Type {
+	case "", mcv1alpha3.MergeMap:
+		return &mapMerger{response: map[string]interface{}{}}, nil
+	case mcv1alpha3.MergeFirstSuccess:
+		return &firstSuccessMerger{}, nil
+	case mcv1alpha3.MergeQuorum:
+		quorum := int(strategy.Quorum)
+		if quorum == 0 {
+			quorum = steps/2 + 1
+		}
+		if quorum < 1 || quorum > steps {
+			return nil, fmt.Errorf("the quorum %d is not between 1 and the %d steps", quorum, steps)
+		}
+		return &quorumMerger{quorum: quorum, steps: steps, response: map[string]json.RawMessage{}}, nil
+	case mcv1alpha3.MergeConcatArray:
+		if strategy.Path == "" {
+			return nil, fmt.Errorf("the %s merge strategy needs a path", strategy.Type)
+		}
+		return &concatArrayMerger{path: strategy.Path, outputs: make([][]byte, steps)}, nil
+	case mcv1alpha3.MergeScoreMax:
+		if strategy.Path == "" {
+			return nil, fmt.Errorf("the %s merge strategy needs a path", strategy.Type)
+		}
+		return &scoreMaxMerger{path: strategy.Path}, nil
+	default:
+		return nil, fmt.Errorf("invalid merge strategy: %v", strategy.Type)
+	}
+}
+
+func handleEnsemblePipeline(ctx context.Context,
+	nodeName string,
+	graph mcv1alpha3.GMConnector,
+	initInput []byte,
+	input []byte,
+	headers http.Header,
+) (io.ReadCloser, int, error) {
+	currentNode := graph.Spec.Nodes[nodeName]
+	merger, err := newEnsembleMerger(currentNode.MergeStrategy, len(currentNode.Steps))
+	if err != nil {
+		return nil, 500, err
+	}
+	// stop the remaining branches once the result is decided or the request is cancelled
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// buffered, so the steps finishing after the node returned do not block
+	results := make(chan ensembleStepResult, len(currentNode.Steps))
+	for i := range currentNode.Steps {
+		step := &currentNode.Steps[i]
+		stepType := ServiceURL
+		if step.NodeName != "" {
+			stepType = ServiceNode
+		}
+		log.Info("Starting execution of step", "type", stepType, "stepName", step.StepName)
+		request := input
+		if isDataTemplate(step.Data) {
+			if request, err = renderStepData(ctx, step, initInput, nil); err != nil {
+				return nil, 500, err
+			}
+		}
+		go func(index int) {
+			stepStart := time.Now()
+			stepCtx, span := startStepSpan(ctx, nodeName, step, request)
+			responseBody, statusCode, err := executeStep(stepCtx, step, graph, initInput, request, headers)
+			endSpan(span, statusCode, err)
+			observeStep(nodeName, step.StepName, statusCode, err, stepStart)
+			result := ensembleStepResult{index: index, statusCode: statusCode, err: err}
+			if err == nil {
+				result.output, result.err = io.ReadAll(responseBody)
+				if cerr := responseBody.Close(); cerr != nil {
+					log.Error(cerr, "Error while trying to close the responseBody in handleEnsemblePipeline")
+				}
+				if result.err != nil {
+					log.Error(result.err, "Error while reading the response body")
+				} else {
+					recordStepOutput(ctx, step.StepName, result.output)
+				}
+			}
+			results <- result
+		}(i)
+	}
+
+	var deadline <-chan time.Time
+	if strategy := currentNode.MergeStrategy; strategy != nil && strategy.Deadline != nil {
+		timer := time.NewTimer(strategy.Deadline.Duration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for responded := 0; responded < len(currentNode.Steps); {
+		select {
+		case result := <-results:
+			responded++
+			step := &currentNode.Steps[result.index]
+			if step.Dependency == mcv1alpha3.Hard {
+				if result.err != nil {
+					return nil, 500, result.err
+				}
+				if !isSuccessFul(result.statusCode) {
+					log.Info(
+						"This step is a hard dependency and it is unsuccessful",
+						"stepName",
+						step.StepName,
+						"statusCode",
+						result.statusCode,
+					)
+					return NewReadCloser(result.output), result.statusCode, nil
+				}
+			}
+			decided, err := merger.add(step, result)
+			if err != nil {
+				return nil, 500, err
+			}
+			if decided {
+				return merger.merge()
+			}
+		case <-deadline:
+			log.Info("The deadline of the ensemble passed, drop the steps which did not respond",
+				"node", nodeName, "responded", responded, "steps", len(currentNode.Steps))
+			if responded == 0 {
+				return nil, http.StatusGatewayTimeout, fmt.Errorf("no step of node %s responded before the deadline", nodeName)
+			}
+			return merger.merge()
+		case <-ctx.Done():
+			return nil, 500, ctx.Err()
+		}
+	}
+	return merger.merge()
+}
+
+func ensembleKey(step *mcv1alpha3.Step, index int) string {
+	if step.StepName == "" {
+		return strconv.Itoa(index) // Use index if no step name
+	}
+	return step.StepName
+}
+
+// rawJSON returns the output as it is when it is valid JSON, otherwise as a JSON string
+func rawJSON(output []byte) json.RawMessage {
+	if json.Valid(output) {
+		return output
+	}
+	quoted, _ := json.Marshal(string(output))
+	return quoted
+}
+
+// mapMerger builds an object with the response of every step by step name,
+// any step failing to respond with a JSON object fails the node
+type mapMerger struct {
+	response map[string]interface{}
+}
+
+func (m *mapMerger) add(step *mcv1alpha3.Step, result ensembleStepResult) (bool, error) {
+	if result.err != nil {
+		return false, result.err
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal(result.output, &res); err != nil {
+		return false, err
+	}
+	m.response[ensembleKey(step, result.index)] = res
+	return false, nil
+}
+
+func (m *mapMerger) merge() (io.ReadCloser, int, error) {
+	combinedResponse, err := json.Marshal(m.response)
+	if err != nil {
+		return nil, 500, err
+	}
+	return NewReadCloser(combinedResponse), 200, nil
+}
+
+// firstSuccessMerger returns the first successful response,
+// or the last unsuccessful one when no step succeeds
+type firstSuccessMerger struct {
+	success *ensembleStepResult
+	failure *ensembleStepResult
+	err     error
+}
+
+func (m *firstSuccessMerger) add(step *mcv1alpha3.Step, result ensembleStepResult) (bool, error) {
+	switch {
+	case result.successful():
+		m.success = &result
+		return true, nil
+	case result.err != nil:
+		log.Info("The step failed, wait for the other steps", "stepName", step.StepName, "error", result.err.Error())
+		m.err = result.err
+	default:
+		m.failure = &result
+	}
+	return false, nil
+}
+
+func (m *firstSuccessMerger) merge() (io.ReadCloser, int, error) {
+	if m.success != nil {
+		return NewReadCloser(m.success.output), m.success.statusCode, nil
+	}
+	if m.failure != nil {
+		return NewReadCloser(m.failure.output), m.failure.statusCode, nil
+	}
+	return nil, http.StatusBadGateway, fmt.Errorf("no step of the ensemble succeeded: %v", m.err)
+}
+
+// quorumMerger returns the successful responses by step name once the quorum of steps succeeded
+type quorumMerger struct {
+	quorum   int
+	steps    int
+	failures int
+	response map[string]json.RawMessage
+}
+
+func (m *quorumMerger) add(step *mcv1alpha3.Step, result ensembleStepResult) (bool, error) {
+	if !result.successful() {
+		m.failures++
+		// the quorum cannot be reached anymore
+		return m.steps-m.failures < m.quorum, nil
+	}
+	m.response[ensembleKey(step, result.index)] = rawJSON(result.output)
+	return len(m.response) >= m.quorum, nil
+}
+
+func (m *quorumMerger) merge() (io.ReadCloser, int, error) {
+	if len(m.response) < m.quorum {
+		return nil, http.StatusBadGateway, fmt.Errorf("only %d steps of the ensemble succeeded, the quorum is %d",
+			len(m.response), m.quorum)
+	}
+	combinedResponse, err := json.Marshal(m.response)
+	if err != nil {
+		return nil, 500, err
+	}
+	return NewReadCloser(combinedResponse), 200, nil
+}
+
+// concatArrayMerger concatenates the arrays at the path of the successful responses in the
+// order of the steps, into the response of the first successful step
+type concatArrayMerger struct {
+	path string
+	// the successful responses by step index
+	outputs [][]byte
+}
+
+func (m *concatArrayMerger) add(step *mcv1alpha3.Step, result ensembleStepResult) (bool, error) {
+	if !result.successful() {
+		log.Info("The step is unsuccessful, leave out its response", "stepName", step.StepName, "statusCode", result.statusCode)
+		return false, nil
+	}
+	m.outputs[result.index] = result.output
+	return false, nil
+}
+
+func (m *concatArrayMerger) merge() (io.ReadCloser, int, error) {
+	var base []byte
+	items := []json.RawMessage{}
+	for _, output := range m.outputs {
+		if output == nil {
+			continue
+		}
+		array := gjson.GetBytes(output, m.path)
+		if !array.IsArray() {
+			log.Info("The response has no array at the path, leave it out", "path", m.path)
+			continue
+		}
+		if base == nil {
+			base = output
+		}
+		for _, item := range array.Array() {
+			items = append(items, json.RawMessage(item.Raw))
+		}
+	}
+	if base == nil {
+		return nil, http.StatusBadGateway, fmt.Errorf("no step of the ensemble responded with an array at %s", m.path)
+	}
+	merged, err := setJSONPath(base, m.path, items)
+	if err != nil {
+		return nil, 500, err
+	}
+	return NewReadCloser(merged), 200, nil
+}
+
+// setJSONPath sets the value at the dotted path of a JSON object, creating the missing objects
+func setJSONPath(doc []byte, path string, value interface{}) ([]byte, error) {
+	var root map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+	if err := decoder.Decode(&root); err != nil {
+		return nil, err
+	}
+	keys := strings.Split(path, ".")
+	obj := root
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := obj[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			obj[key] = child
+		}
+		obj = child
+	}
+	obj[keys[len(keys)-1]] = value
+	return json.Marshal(root)
+}
+
+// scoreMaxMerger returns the successful response with the highest number at the path,
+// the earlier step wins a tie
+type scoreMaxMerger struct {
+	path  string
+	best  *ensembleStepResult
+	score float64
+}
+
+func (m *scoreMaxMerger) add(step *mcv1alpha3.Step, result ensembleStepResult) (bool, error) {
+	if !result.successful() {
+		log.Info("The step is unsuccessful, leave out its response", "stepName", step.StepName, "statusCode", result.statusCode)
+		return false, nil
+	}
+	score := gjson.GetBytes(result.output, m.path)
+	if score.Type != gjson.Number {
+		log.Info("The response has no score at the path, leave it out", "stepName", step.StepName, "path", m.path)
+		return false, nil
+	}
+	if m.best == nil || score.Num > m.score || (score.Num == m.score && result.index < m.best.index) {
+		m.best = &result
+		m.score = score.Num
+	}
+	return false, nil
+}
+
+func (m *scoreMaxMerger) merge() (io.ReadCloser, int, error) {
+	if m.best == nil {
+		return nil, http.StatusBadGateway, fmt.Errorf("no step of the ensemble responded with a score at %s", m.path)
+	}
+	return NewReadCloser(m.best.output), m.best.statusCode, nil
+}
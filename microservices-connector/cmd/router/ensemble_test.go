@@ -0,0 +1,223 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newBlockingService only returns once its request is cancelled, it closes cancelled then
+func newBlockingService(cancelled chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.ReadAll(req.Body)
+		<-req.Context().Done()
+		close(cancelled)
+	}))
+}
+
+func newStatusService(statusCode int, response string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.ReadAll(req.Body)
+		rw.WriteHeader(statusCode)
+		_, _ = rw.Write([]byte(response))
+	}))
+}
+
+func ensembleGraph(strategy *mcv1alpha3.MergeStrategy, steps ...mcv1alpha3.Step) mcv1alpha3.GMConnector {
+	return mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType:    mcv1alpha3.Ensemble,
+					MergeStrategy: strategy,
+					Steps:         steps,
+				},
+			},
+		},
+	}
+}
+
+func assertCancelled(t *testing.T, cancelled chan struct{}) {
+	t.Helper()
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the slow step was not cancelled")
+	}
+}
+
+func TestEnsembleFirstSuccess(t *testing.T) {
+	failing := newStatusService(http.StatusServiceUnavailable, `{"error":"overloaded"}`)
+	defer failing.Close()
+	llm := newEchoService(`{"text":"answer"}`)
+	defer llm.Close()
+	cancelled := make(chan struct{})
+	slow := newBlockingService(cancelled)
+	defer slow.Close()
+
+	gmcGraph := ensembleGraph(&mcv1alpha3.MergeStrategy{Type: mcv1alpha3.MergeFirstSuccess},
+		mcv1alpha3.Step{StepName: "Tgi", ServiceURL: failing.URL},
+		mcv1alpha3.Step{StepName: "TgiGaudi", ServiceURL: slow.URL},
+		mcv1alpha3.Step{StepName: "Llm", ServiceURL: llm.URL},
+	)
+	res, statusCode, err := routeStep(context.Background(), "root", gmcGraph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, statusCode)
+	body, err := io.ReadAll(res)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"text":"answer"}`, string(body))
+	assertCancelled(t, cancelled)
+
+	// without any success the last failure is returned
+	gmcGraph = ensembleGraph(&mcv1alpha3.MergeStrategy{Type: mcv1alpha3.MergeFirstSuccess},
+		mcv1alpha3.Step{StepName: "Tgi", ServiceURL: failing.URL},
+	)
+	res, statusCode, err = routeStep(context.Background(), "root", gmcGraph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+	body, err = io.ReadAll(res)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"error":"overloaded"}`, string(body))
+}
+
+func TestEnsembleQuorum(t *testing.T) {
+	first := newEchoService(`{"label":"cat"}`)
+	defer first.Close()
+	second := newEchoService(`{"label":"dog"}`)
+	defer second.Close()
+	failing := newStatusService(http.StatusInternalServerError, `{}`)
+	defer failing.Close()
+	cancelled := make(chan struct{})
+	slow := newBlockingService(cancelled)
+	defer slow.Close()
+
+	// a majority of the 3 steps is enough
+	gmcGraph := ensembleGraph(&mcv1alpha3.MergeStrategy{Type: mcv1alpha3.MergeQuorum},
+		mcv1alpha3.Step{StepName: "Llm", ServiceURL: first.URL},
+		mcv1alpha3.Step{StepName: "Tgi", ServiceURL: slow.URL},
+		mcv1alpha3.Step{StepName: "TgiGaudi", ServiceURL: second.URL},
+	)
+	res, statusCode, err := routeStep(context.Background(), "root", gmcGraph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, statusCode)
+	body, err := io.ReadAll(res)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"Llm":{"label":"cat"},"TgiGaudi":{"label":"dog"}}`, string(body))
+	assertCancelled(t, cancelled)
+
+	// the quorum cannot be reached once too many steps failed
+	gmcGraph = ensembleGraph(&mcv1alpha3.MergeStrategy{Type: mcv1alpha3.MergeQuorum, Quorum: 2},
+		mcv1alpha3.Step{StepName: "Llm", ServiceURL: first.URL},
+		mcv1alpha3.Step{StepName: "Tgi", ServiceURL: failing.URL},
+	)
+	_, statusCode, err = routeStep(context.Background(), "root", gmcGraph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadGateway, statusCode)
+}
+
+func TestEnsembleConcatArray(t *testing.T) {
+	retriever := newEchoService(`{"id":"1","data":{"docs":[{"text":"OPEA"}],"total":1}}`)
+	defer retriever.Close()
+	webRetriever := newEchoService(`{"id":"2","data":{"docs":[{"text":"is an"},{"text":"open platform"}]}}`)
+	defer webRetriever.Close()
+	failing := newStatusService(http.StatusInternalServerError, `{"data":{"docs":[{"text":"error"}]}}`)
+	defer failing.Close()
+
+	gmcGraph := ensembleGraph(&mcv1alpha3.MergeStrategy{Type: mcv1alpha3.MergeConcatArray, Path: "data.docs"},
+		mcv1alpha3.Step{StepName: "Retriever", ServiceURL: retriever.URL},
+		mcv1alpha3.Step{StepName: "VectorDB", ServiceURL: failing.URL},
+		mcv1alpha3.Step{StepName: "WebRetriever", ServiceURL: webRetriever.URL},
+	)
+	res, statusCode, err := routeStep(context.Background(), "root", gmcGraph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, statusCode)
+	body, err := io.ReadAll(res)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"id":"1","data":{"docs":[{"text":"OPEA"},{"text":"is an"},{"text":"open platform"}],"total":1}}`,
+		string(body))
+}
+
+func TestEnsembleScoreMax(t *testing.T) {
+	low := newEchoService(`{"text":"a dog","score":0.4}`)
+	defer low.Close()
+	high := newEchoService(`{"text":"a cat","score":0.9}`)
+	defer high.Close()
+	noScore := newEchoService(`{"text":"a bird"}`)
+	defer noScore.Close()
+
+	gmcGraph := ensembleGraph(&mcv1alpha3.MergeStrategy{Type: mcv1alpha3.MergeScoreMax, Path: "score"},
+		mcv1alpha3.Step{StepName: "Llm", ServiceURL: low.URL},
+		mcv1alpha3.Step{StepName: "Tgi", ServiceURL: noScore.URL},
+		mcv1alpha3.Step{StepName: "TgiGaudi", ServiceURL: high.URL},
+	)
+	res, statusCode, err := routeStep(context.Background(), "root", gmcGraph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, statusCode)
+	body, err := io.ReadAll(res)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"text":"a cat","score":0.9}`, string(body))
+}
+
+func TestEnsembleDeadline(t *testing.T) {
+	fast := newEchoService(`{"predictions":"1"}`)
+	defer fast.Close()
+	cancelled := make(chan struct{})
+	slow := newBlockingService(cancelled)
+	defer slow.Close()
+
+	strategy := &mcv1alpha3.MergeStrategy{Deadline: &metav1.Duration{Duration: 100 * time.Millisecond}}
+	gmcGraph := ensembleGraph(strategy,
+		mcv1alpha3.Step{StepName: "service1", ServiceURL: fast.URL},
+		mcv1alpha3.Step{StepName: "service2", ServiceURL: slow.URL},
+	)
+	res, statusCode, err := routeStep(context.Background(), "root", gmcGraph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, statusCode)
+	body, err := io.ReadAll(res)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"service1":{"predictions":"1"}}`, string(body))
+	assertCancelled(t, cancelled)
+
+	// nothing responded before the deadline
+	cancelled = make(chan struct{})
+	slow2 := newBlockingService(cancelled)
+	defer slow2.Close()
+	gmcGraph = ensembleGraph(strategy, mcv1alpha3.Step{StepName: "service2", ServiceURL: slow2.URL})
+	_, statusCode, err = routeStep(context.Background(), "root", gmcGraph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusGatewayTimeout, statusCode)
+	assertCancelled(t, cancelled)
+}
+
+func TestEnsembleHardDependencyFailure(t *testing.T) {
+	failing := newStatusService(http.StatusNotFound, `{"error":"no model"}`)
+	defer failing.Close()
+	cancelled := make(chan struct{})
+	slow := newBlockingService(cancelled)
+	defer slow.Close()
+
+	gmcGraph := ensembleGraph(nil,
+		mcv1alpha3.Step{StepName: "service1", ServiceURL: slow.URL},
+		mcv1alpha3.Step{StepName: "service2", ServiceURL: failing.URL, Dependency: mcv1alpha3.Hard},
+	)
+	res, statusCode, err := routeStep(context.Background(), "root", gmcGraph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, statusCode)
+	body, err := io.ReadAll(res)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"error":"no model"}`, string(body))
+	// the other branch does not stay blocked
+	assertCancelled(t, cancelled)
+}
@@ -0,0 +1,62 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+)
+
+func entrypointMethod(entrypoint *mcv1alpha3.Entrypoint) string {
+	if entrypoint.Method == "" {
+		return mcv1alpha3.DefaultEntrypointMethod
+	}
+	return entrypoint.Method
+}
+
+// findEntrypoint returns the entrypoint of the graph serving the path with the method, nil when there is none
+func findEntrypoint(graph *mcv1alpha3.GMConnector, path string, method string) *mcv1alpha3.Entrypoint {
+	for i := range graph.Spec.Entrypoints {
+		entrypoint := &graph.Spec.Entrypoints[i]
+		if entrypoint.Path == path && entrypointMethod(entrypoint) == method {
+			return entrypoint
+		}
+	}
+	return nil
+}
+
+// entrypointNode returns the node the requests to the path with the method start from: the node of the
+// matching entrypoint of the graph, or else the root node. The status code is 405 when the path is only
+// declared with other methods, and 404 when the graph has no root node for the other paths.
+func entrypointNode(graph *mcv1alpha3.GMConnector, path string, method string) (string, int) {
+	if entrypoint := findEntrypoint(graph, path, method); entrypoint != nil {
+		return entrypoint.NodeName, http.StatusOK
+	}
+	for _, entrypoint := range graph.Spec.Entrypoints {
+		if entrypoint.Path == path {
+			return "", http.StatusMethodNotAllowed
+		}
+	}
+	if _, ok := graph.Spec.Nodes[defaultNodeName]; !ok {
+		return "", http.StatusNotFound
+	}
+	return defaultNodeName, http.StatusOK
+}
+
+// validateEntrypoints makes sure the requests have a node to start from
+func validateEntrypoints(graph *mcv1alpha3.GMConnector) error {
+	if _, ok := graph.Spec.Nodes[defaultNodeName]; !ok && len(graph.Spec.Entrypoints) == 0 {
+		return fmt.Errorf("the graph has no %s node and no entrypoint", defaultNodeName)
+	}
+	for _, entrypoint := range graph.Spec.Entrypoints {
+		if _, ok := graph.Spec.Nodes[entrypoint.NodeName]; !ok {
+			return fmt.Errorf("node %s of entrypoint %s does not exist", entrypoint.NodeName, entrypoint.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntrypoints(t *testing.T) {
+	chat := newEchoService(`{"text":"OPEA is an open platform"}`)
+	defer chat.Close()
+	retriever := newEchoService(`{"retrieved_docs":["OPEA"]}`)
+	defer retriever.Close()
+	embedding := newEchoService(`{"embedding":[0.1,0.2]}`)
+	defer embedding.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(&mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Entrypoints: []mcv1alpha3.Entrypoint{
+				{Name: "retrieval", Path: "/v1/retrieval", NodeName: "retrieval"},
+				{Name: "embedding", Path: "/v1/embedding", Method: http.MethodPut, NodeName: "embedding"},
+			},
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps:      []mcv1alpha3.Step{{StepName: "Llm", ServiceURL: chat.URL}},
+				},
+				"retrieval": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps:      []mcv1alpha3.Step{{StepName: "Retriever", ServiceURL: retriever.URL}},
+				},
+				"embedding": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps:      []mcv1alpha3.Step{{StepName: "Embedding", ServiceURL: embedding.URL}},
+				},
+			},
+		},
+	})
+
+	tests := []struct {
+		method     string
+		path       string
+		statusCode int
+		want       string
+	}{
+		{method: http.MethodPost, path: "/v1/retrieval", statusCode: http.StatusOK, want: `{"retrieved_docs":["OPEA"]}`},
+		{method: http.MethodPut, path: "/v1/embedding", statusCode: http.StatusOK, want: `{"embedding":[0.1,0.2]}`},
+		// the paths without entrypoint start from the root node
+		{method: http.MethodPost, path: "/", statusCode: http.StatusOK, want: `{"text":"OPEA is an open platform"}`},
+		{method: http.MethodPost, path: "/v1/chatqna", statusCode: http.StatusOK, want: `{"text":"OPEA is an open platform"}`},
+		{method: http.MethodPost, path: "/v1/embedding", statusCode: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{"text":"What is OPEA?"}`))
+			rr := httptest.NewRecorder()
+			mcGraphHandler(rr, req)
+			assert.Equal(t, tt.statusCode, rr.Code)
+			if tt.want != "" {
+				assert.Equal(t, tt.want, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestEntrypointsWithoutRoot(t *testing.T) {
+	retriever := newEchoService(`{"retrieved_docs":["OPEA"]}`)
+	defer retriever.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(&mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Entrypoints: []mcv1alpha3.Entrypoint{{Name: "retrieval", Path: "/v1/retrieval", NodeName: "retrieval"}},
+			Nodes: map[string]mcv1alpha3.Router{
+				"retrieval": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps:      []mcv1alpha3.Step{{StepName: "Retriever", ServiceURL: retriever.URL}},
+				},
+			},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/retrieval", strings.NewReader(`{"text":"What is OPEA?"}`))
+	rr := httptest.NewRecorder()
+	mcGraphHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	// the other paths have no node to start from
+	req = httptest.NewRequest(http.MethodPost, "/v1/chatqna", strings.NewReader(`{"text":"What is OPEA?"}`))
+	rr = httptest.NewRecorder()
+	mcGraphHandler(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	req = httptest.NewRequest(http.MethodPost, chatCompletionsPath,
+		strings.NewReader(`{"messages":[{"role":"user","content":"What is OPEA?"}]}`))
+	rr = httptest.NewRecorder()
+	chatCompletionsHandler(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,159 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/opea-project/GenAIInfra/microservices-connector/internal/condition"
+)
+
+// parseGraph unmarshals a serialized GMConnector and makes sure it can be routed
+func parseGraph(data []byte) (*mcv1alpha3.GMConnector, error) {
+	graph := &mcv1alpha3.GMConnector{}
+	if err := json.Unmarshal(data, graph); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal gmc graph json: %v", err)
+	}
+	if err := validateGraph(graph); err != nil {
+		return nil, err
+	}
+	return graph, nil
+}
+
+// validateGraph checks the parts of the graph the router relies on,
+// the full validation is done by the validating webhook at admission time
+func validateGraph(graph *mcv1alpha3.GMConnector) error {
+	if err := validateEntrypoints(graph); err != nil {
+		return err
+	}
+	for nodeName, node := range graph.Spec.Nodes {
+		switch node.RouterType {
+		case mcv1alpha3.Sequence, mcv1alpha3.Switch:
+		case mcv1alpha3.Ensemble:
+			if _, err := newEnsembleMerger(node.MergeStrategy, len(node.Steps)); err != nil {
+				return fmt.Errorf("invalid merge strategy of node %s: %v", nodeName, err)
+			}
+		case mcv1alpha3.Splitter:
+			if totalSplitterWeight(&node) <= 0 {
+				return fmt.Errorf("no step of Splitter node %s has a positive weight", nodeName)
+			}
+		case mcv1alpha3.DAG:
+			if err := validateDAG(nodeName, node.Steps); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("invalid route type %v for node %s", node.RouterType, nodeName)
+		}
+		for _, step := range node.Steps {
+			if step.Mirror && node.RouterType != mcv1alpha3.Sequence && node.RouterType != mcv1alpha3.Switch {
+				return fmt.Errorf("step %s in %s node %s cannot be a mirror", step.StepName, node.RouterType, nodeName)
+			}
+			if step.StepName == mcv1alpha3.SemanticCacheStep {
+				if node.RouterType != mcv1alpha3.Sequence {
+					return fmt.Errorf("step %s in %s node %s is only supported in Sequence nodes", step.StepName, node.RouterType, nodeName)
+				}
+				if findEmbeddingStep(graph, nodeName) == nil {
+					return fmt.Errorf("step %s in node %s needs an %s step in the graph", step.StepName, nodeName, mcv1alpha3.EmbeddingStep)
+				}
+			}
+			if condition.IsExpression(step.Condition) {
+				if _, err := compileCondition(step.Condition); err != nil {
+					return fmt.Errorf("invalid condition of step %s in node %s: %v", step.StepName, nodeName, err)
+				}
+			}
+			if isDataTemplate(step.Data) {
+				if _, err := compileTemplate(step.Data); err != nil {
+					return fmt.Errorf("invalid data template of step %s in node %s: %v", step.StepName, nodeName, err)
+				}
+			}
+			if step.NodeName == "" {
+				continue
+			}
+			if _, ok := graph.Spec.Nodes[step.NodeName]; !ok {
+				return fmt.Errorf("node %s in step %s of node %s does not exist", step.NodeName, step.StepName, nodeName)
+			}
+		}
+	}
+	return nil
+}
+
+// validateDAG makes sure every step of a DAG node eventually runs
+func validateDAG(nodeName string, steps []mcv1alpha3.Step) error {
+	names := make(map[string]bool, len(steps))
+	for _, step := range steps {
+		if names[step.StepName] {
+			return fmt.Errorf("step %s is not unique in DAG node %s", step.StepName, nodeName)
+		}
+		names[step.StepName] = true
+	}
+	for _, step := range steps {
+		for _, dep := range step.DependsOn {
+			if !names[dep] {
+				return fmt.Errorf("step %s in DAG node %s depends on unknown step %s", step.StepName, nodeName, dep)
+			}
+		}
+	}
+	if cycle := mcv1alpha3.FindDAGCycle(steps); cycle != nil {
+		return fmt.Errorf("the steps of DAG node %s form a cycle: %s", nodeName, strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+func loadGraphFile(path string) (*mcv1alpha3.GMConnector, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseGraph(data)
+}
+
+// watchGraphFile polls the graph file, which is usually a mounted ConfigMap,
+// and swaps in the new graph once it changes and passes the validation.
+// In-flight requests keep the graph they started with.
+func watchGraphFile(ctx context.Context, path string, interval time.Duration) {
+	lastData, err := os.ReadFile(path)
+	if err != nil {
+		log.Error(err, "failed to read the graph file", "path", path)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			data, err := os.ReadFile(path)
+			if err != nil {
+				log.Error(err, "failed to read the graph file", "path", path)
+				continue
+			}
+			if bytes.Equal(data, lastData) {
+				continue
+			}
+			lastData = data
+			graph, err := parseGraph(data)
+			if err != nil {
+				log.Error(err, "invalid graph, keep serving the current one", "path", path)
+				continue
+			}
+			mcGraph.Store(graph)
+			configureTracing(graph)
+			transports.prune(graph)
+			pruneStepCaches(graph)
+			pruneCompiledConditions(graph)
+			pruneCompiledTemplates(graph)
+			log.Info("Reloaded the gmc graph", "path", path)
+		}
+	}
+}
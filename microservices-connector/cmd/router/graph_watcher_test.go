@@ -0,0 +1,158 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGraph(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{
+			name:    "valid graph",
+			data:    `{"spec":{"nodes":{"root":{"routerType":"Sequence","steps":[{"name":"Embedding","serviceUrl":"http://embedding"}]}}}}`,
+			wantErr: false,
+		},
+		{
+			name:    "invalid json",
+			data:    `{"spec":`,
+			wantErr: true,
+		},
+		{
+			name:    "missing root node",
+			data:    `{"spec":{"nodes":{"other":{"routerType":"Sequence"}}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid router type",
+			data:    `{"spec":{"nodes":{"root":{"routerType":"Unknown"}}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid condition expression",
+			data:    `{"spec":{"nodes":{"root":{"routerType":"Switch","steps":[{"name":"Llm","condition":"$request.model >"}]}}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid data template",
+			data:    `{"spec":{"nodes":{"root":{"routerType":"Sequence","steps":[{"name":"Llm","data":"{\"query\":\"$body.text\"}"}]}}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "merge strategy without path",
+			data:    `{"spec":{"nodes":{"root":{"routerType":"Ensemble","mergeStrategy":{"type":"scoreMax"},"steps":[{"name":"Llm"}]}}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "splitter without weights",
+			data:    `{"spec":{"nodes":{"root":{"routerType":"Splitter","steps":[{"name":"Llm"}]}}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "mirror in an Ensemble node",
+			data:    `{"spec":{"nodes":{"root":{"routerType":"Ensemble","steps":[{"name":"Llm","mirror":true}]}}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "entrypoints without a root node",
+			data:    `{"spec":{"entrypoints":[{"name":"chat","path":"/v1/chatqna","nodeName":"chat"}],"nodes":{"chat":{"routerType":"Sequence"}}}}`,
+			wantErr: false,
+		},
+		{
+			name:    "unknown entrypoint node",
+			data:    `{"spec":{"entrypoints":[{"name":"chat","path":"/v1/chatqna","nodeName":"chat"}],"nodes":{"root":{"routerType":"Sequence"}}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "semantic cache without an embedding step",
+			data:    `{"spec":{"nodes":{"root":{"routerType":"Sequence","steps":[{"name":"SemanticCache"},{"name":"Llm"}]}}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "semantic cache in a Switch node",
+			data:    `{"spec":{"nodes":{"root":{"routerType":"Switch","steps":[{"name":"SemanticCache"},{"name":"Embedding"}]}}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "valid DAG",
+			data:    `{"spec":{"nodes":{"root":{"routerType":"DAG","steps":[{"name":"Embedding"},{"name":"Llm","dependsOn":["Embedding"]}]}}}}`,
+			wantErr: false,
+		},
+		{
+			name:    "unknown DAG dependency",
+			data:    `{"spec":{"nodes":{"root":{"routerType":"DAG","steps":[{"name":"Llm","dependsOn":["Embedding"]}]}}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "DAG cycle",
+			data:    `{"spec":{"nodes":{"root":{"routerType":"DAG","steps":[{"name":"Embedding","dependsOn":["Llm"]},{"name":"Llm","dependsOn":["Embedding"]}]}}}}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown nested node",
+			data:    `{"spec":{"nodes":{"root":{"routerType":"Sequence","steps":[{"name":"Llm","nodeName":"missing"}]}}}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			graph, err := parseGraph([]byte(tt.data))
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, graph)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, graph)
+			}
+		})
+	}
+}
+
+func TestWatchGraphFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.json")
+	oldGraph := `{"spec":{"nodes":{"root":{"routerType":"Sequence","steps":[{"name":"Embedding","serviceUrl":"http://old"}]}}}}`
+	newGraph := `{"spec":{"nodes":{"root":{"routerType":"Sequence","steps":[{"name":"Embedding","serviceUrl":"http://new"}]}}}}`
+	if err := os.WriteFile(path, []byte(oldGraph), 0600); err != nil {
+		t.Fatalf("failed to write graph file: %v", err)
+	}
+
+	graph, err := loadGraphFile(path)
+	if err != nil {
+		t.Fatalf("failed to load graph file: %v", err)
+	}
+	mcGraph.Store(graph)
+	// an in-flight request keeps its own snapshot
+	inFlight := mcGraph.Load()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchGraphFile(ctx, path, 10*time.Millisecond)
+
+	// an invalid graph must not replace the current one
+	if err := os.WriteFile(path, []byte(`{"spec":{"nodes":{}}}`), 0600); err != nil {
+		t.Fatalf("failed to write graph file: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "http://old", mcGraph.Load().Spec.Nodes["root"].Steps[0].ServiceURL)
+
+	if err := os.WriteFile(path, []byte(newGraph), 0600); err != nil {
+		t.Fatalf("failed to write graph file: %v", err)
+	}
+	assert.Eventually(t, func() bool {
+		return mcGraph.Load().Spec.Nodes["root"].Steps[0].ServiceURL == "http://new"
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "http://old", inFlight.Spec.Nodes["root"].Steps[0].ServiceURL)
+}
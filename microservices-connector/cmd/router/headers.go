@@ -0,0 +1,109 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+)
+
+const redactedValue = "[REDACTED]"
+
+var (
+	// headers carrying credentials, the values of the headers with one of the
+	// sensitiveHeaderWords in their name are redacted as well
+	sensitiveHeaders     = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+	sensitiveHeaderWords = []string{"auth", "key", "token", "secret", "password", "session", "credential"}
+)
+
+func isSensitiveHeader(name string) bool {
+	if slices.Contains(sensitiveHeaders, http.CanonicalHeaderKey(name)) {
+		return true
+	}
+	name = strings.ToLower(name)
+	return slices.ContainsFunc(sensitiveHeaderWords, func(word string) bool {
+		return strings.Contains(name, word)
+	})
+}
+
+// redactHeaders returns the headers to log, the values of the
+// sensitive headers are replaced unless --log-header-values is set
+func redactHeaders(headers http.Header) http.Header {
+	if *logHeaderValues {
+		return headers
+	}
+	redacted := make(http.Header, len(headers))
+	for name, values := range headers {
+		if isSensitiveHeader(name) {
+			redacted[name] = []string{redactedValue}
+			continue
+		}
+		redacted[name] = values
+	}
+	return redacted
+}
+
+// secretPath returns the file of the key of a Secret mounted into the router by the controller
+func secretPath(name string, key string) (string, error) {
+	for _, part := range []string{name, key} {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `/\`) {
+			return "", fmt.Errorf("invalid secret reference %s/%s", name, key)
+		}
+	}
+	return filepath.Join(*secretsDir, name, key), nil
+}
+
+// readSecretValue reads the value of the header from the Secret mounted into the router by the controller
+func readSecretValue(header *mcv1alpha3.HeaderValue) (string, error) {
+	path, err := secretPath(header.SecretKeyRef.Name, header.SecretKeyRef.Key)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	// the values written with echo end with a new line
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// applyHeaderPolicy adds the headers of the request allowed by the policy of the step to a call to its service,
+// and sets the static headers and the ones from Secrets. The steps without policy forward no header.
+func applyHeaderPolicy(step *mcv1alpha3.Step, incoming http.Header, outgoing http.Header) error {
+	policy := step.Headers
+	if policy == nil {
+		return nil
+	}
+	for _, name := range policy.Forward {
+		if values := incoming.Values(name); len(values) > 0 {
+			outgoing[http.CanonicalHeaderKey(name)] = slices.Clone(values)
+		}
+	}
+	for i := range policy.Set {
+		header := &policy.Set[i]
+		value := header.Value
+		if header.SecretKeyRef != nil {
+			secretValue, err := readSecretValue(header)
+			if err != nil {
+				optional := header.SecretKeyRef.Optional != nil && *header.SecretKeyRef.Optional
+				if optional && errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				return fmt.Errorf("failed to read the value of header %s of step %s: %v", header.Name, step.StepName, err)
+			}
+			value = secretValue
+		}
+		outgoing.Set(header.Name, value)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestRedactHeaders(t *testing.T) {
+	headers := http.Header{
+		"Authorization": {"Bearer opea"},
+		"X-Api-Key":     {"opea"},
+		"X-Auth-Token":  {"opea"},
+		"Cookie":        {"session=opea"},
+		"Content-Type":  {"application/json"},
+		"X-Request-Id":  {"42"},
+	}
+	redacted := redactHeaders(headers)
+	for _, name := range []string{"Authorization", "X-Api-Key", "X-Auth-Token", "Cookie"} {
+		assert.Equal(t, redactedValue, redacted.Get(name), name)
+	}
+	assert.Equal(t, "application/json", redacted.Get("Content-Type"))
+	assert.Equal(t, "42", redacted.Get("X-Request-Id"))
+	// the headers of the request are kept
+	assert.Equal(t, "Bearer opea", headers.Get("Authorization"))
+}
+
+func TestApplyHeaderPolicy(t *testing.T) {
+	oldSecretsDir := *secretsDir
+	defer func() { *secretsDir = oldSecretsDir }()
+	*secretsDir = t.TempDir()
+	if err := os.MkdirAll(filepath.Join(*secretsDir, "llm-api"), 0700); err != nil {
+		t.Fatalf("failed to create the secret directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(*secretsDir, "llm-api", "key"), []byte("sk-opea\n"), 0600); err != nil {
+		t.Fatalf("failed to write the secret: %v", err)
+	}
+	secretRef := func(name string, key string, optional bool) *corev1.SecretKeySelector {
+		return &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: name}, Key: key, Optional: &optional}
+	}
+
+	received := make(chan http.Header, 1)
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+		_, _ = w.Write([]byte(`{"text":"OPEA"}`))
+	}))
+	defer service.Close()
+
+	tests := []struct {
+		name       string
+		headers    *mcv1alpha3.HeaderPolicy
+		statusCode int
+		want       map[string]string
+	}{
+		{
+			name:       "no policy",
+			statusCode: http.StatusOK,
+			want:       map[string]string{"Authorization": "", "X-Request-Id": "", "Cookie": ""},
+		},
+		{
+			name: "forwarded, static and secret headers",
+			headers: &mcv1alpha3.HeaderPolicy{
+				Forward: []string{"x-request-id", "Authorization"},
+				Set: []mcv1alpha3.HeaderValue{
+					{Name: "X-Tenant", Value: "opea"},
+					{Name: "Authorization", SecretKeyRef: secretRef("llm-api", "key", false)},
+					{Name: "X-Optional", SecretKeyRef: secretRef("other", "key", true)},
+				},
+			},
+			statusCode: http.StatusOK,
+			want: map[string]string{
+				"X-Request-Id":  "42",
+				"X-Tenant":      "opea",
+				"Authorization": "sk-opea",
+				"Cookie":        "",
+				"X-Optional":    "",
+			},
+		},
+		{
+			name: "missing secret",
+			headers: &mcv1alpha3.HeaderPolicy{Set: []mcv1alpha3.HeaderValue{
+				{Name: "Api-Key", SecretKeyRef: secretRef("other", "key", false)},
+			}},
+			statusCode: http.StatusInternalServerError,
+		},
+		{
+			name: "secret outside the secrets directory",
+			headers: &mcv1alpha3.HeaderPolicy{Set: []mcv1alpha3.HeaderValue{
+				{Name: "Api-Key", SecretKeyRef: secretRef("..", "key", true)},
+			}},
+			statusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldGraph := mcGraph.Load()
+			defer mcGraph.Store(oldGraph)
+			mcGraph.Store(&mcv1alpha3.GMConnector{
+				Spec: mcv1alpha3.GMConnectorSpec{
+					Nodes: map[string]mcv1alpha3.Router{
+						"root": {
+							RouterType: mcv1alpha3.Sequence,
+							Steps:      []mcv1alpha3.Step{{StepName: "Llm", ServiceURL: service.URL, Headers: tt.headers}},
+						},
+					},
+				},
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"text":"What is OPEA?"}`))
+			req.Header.Set("Authorization", "Bearer user")
+			req.Header.Set("X-Request-Id", "42")
+			req.Header.Set("Cookie", "session=opea")
+			rr := httptest.NewRecorder()
+			mcGraphHandler(rr, req)
+			assert.Equal(t, tt.statusCode, rr.Code)
+			if tt.statusCode != http.StatusOK {
+				return
+			}
+			headers := <-received
+			for name, value := range tt.want {
+				assert.Equal(t, value, headers.Get(name), name)
+			}
+		})
+	}
+}
@@ -11,14 +11,14 @@
 package main
 
 import (
-	// "bufio"
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"mime/multipart"
+	"math"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -27,9 +27,14 @@ import (
 	// "regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/tidwall/gjson"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
@@ -37,6 +42,7 @@ import (
 	// "math/big"
 
 	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/opea-project/GenAIInfra/microservices-connector/internal/condition"
 	flag "github.com/spf13/pflag"
 )
 
@@ -53,12 +59,20 @@ const (
 )
 
 var (
-	jsonGraph       = flag.String("graph-json", "", "serialized json graph def")
-	log             = logf.Log.WithName("GMCGraphRouter")
-	mcGraph         *mcv1alpha3.GMConnector
-	defaultNodeName = "root"
-	semaphore       = make(chan struct{}, MaxGoroutines)
-	transport       = &http.Transport{
+	jsonGraph        = flag.String("graph-json", "", "serialized json graph def")
+	graphFile        = flag.String("graph-file", "", "path of the serialized json graph def, reloaded when it changes")
+	graphReload      = flag.Duration("graph-reload-interval", 5*time.Second, "interval for checking the graph file for changes")
+	secretsDir       = flag.String("secrets-dir", "/etc/gmc-secrets", "directory the Secrets referenced by the steps are mounted in, one directory per Secret")
+	logHeaderValues  = flag.Bool("log-header-values", false, "log the values of the headers carrying credentials instead of redacting them")
+	tlsProxyUpstream = flag.String("tls-proxy-upstream", "", "run as the mutual TLS sidecar of a service, proxying to this URL of the service")
+	tlsProxyListen   = flag.String("tls-proxy-listen", ":8443", "address the mutual TLS sidecar listens on")
+	tlsProxyClient   = flag.String("tls-proxy-client", "", "DNS name the client certificates need for the mutual TLS sidecar, the router of the graph")
+	tlsDir           = flag.String("tls-dir", "/etc/gmc-tls", "directory of the certificate, the key and the CA bundle of the mutual TLS sidecar")
+	log              = logf.Log.WithName("GMCGraphRouter")
+	mcGraph          atomic.Pointer[mcv1alpha3.GMConnector]
+	defaultNodeName  = "root"
+	callQueue        = newAdmissionQueue(MaxGoroutines)
+	transport        = &http.Transport{
 		MaxIdleConns:          1000,
 		MaxIdleConnsPerHost:   100,
 		IdleConnTimeout:       2 * time.Minute,
@@ -80,11 +94,6 @@ var (
 	}
 )
 
-type EnsembleStepOutput struct {
-	StepResponse   map[string]interface{}
-	StepStatusCode int
-}
-
 type GMCGraphRoutingError struct {
 	ErrorMessage string `json:"error"`
 	Cause        string `json:"cause"`
@@ -168,44 +177,117 @@ func prepareErrorResponse(err error, errorMessage string) []byte {
 }
 
 func callService(
+	ctx context.Context,
 	step *mcv1alpha3.Step,
 	serviceUrl string,
 	input []byte,
 	headers http.Header,
 ) (io.ReadCloser, int, error) {
-	semaphore <- struct{}{}
-	defer func() { <-semaphore }()
+	ctx, span := startSpan(ctx, "call "+step.StepName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attrStep.String(step.StepName),
+			attrURL.String(serviceUrl),
+			attrRequestBodySize.Int(len(input)),
+		))
+	responseBody, statusCode, err := doCallService(ctx, step, serviceUrl, input, headers)
+	endSpan(span, statusCode, err)
+	return responseBody, statusCode, err
+}
+
+func doCallService(
+	ctx context.Context,
+	step *mcv1alpha3.Step,
+	serviceUrl string,
+	input []byte,
+	headers http.Header,
+) (io.ReadCloser, int, error) {
+	// the mirror calls are capped by mirrorsInFlight instead
+	if !isMirrorCall(ctx) {
+		queueSize, queueTimeout := callQueueLimits(mcGraph.Load())
+		if err := callQueue.acquire(ctx, queueSize, queueTimeout); err != nil {
+			if ctx.Err() != nil {
+				return nil, 500, err
+			}
+			log.Error(err, "No free slot to call the service", "service", serviceUrl)
+			// a slot may be free once the calls in flight waited as long
+			setResponseHeader(ctx, "Retry-After", strconv.Itoa(int(math.Ceil(queueTimeout.Seconds()))))
+			return nil, http.StatusServiceUnavailable, err
+		}
+		defer callQueue.release()
+	}
 
 	defer timeTrack(time.Now(), "step", serviceUrl)
 	log.Info("Entering callService", "url", serviceUrl)
 
 	// log the http header from the original request
-	log.Info("Print the http request headers", "HTTP_Header", headers)
+	log.Info("Print the http request headers", "HTTP_Header", redactHeaders(headers))
 
-	if step.InternalService.Config != nil {
-		err := os.Setenv("no_proxy", step.InternalService.Config["no_proxy"])
-		if err != nil {
-			log.Error(err, "Error setting environment variable", "no_proxy", step.InternalService.Config["no_proxy"])
-			return nil, 400, err
-		}
-	}
-
-	req, err := http.NewRequest("POST", serviceUrl, bytes.NewBuffer(input))
+	stepTransport, err := transports.get(step, serviceUrl)
 	if err != nil {
-		log.Error(err, "An error occurred while preparing request object with serviceUrl.", "serviceUrl", serviceUrl)
+		log.Error(err, "Failed to create the transport of the service", "stepName", step.StepName, "service", serviceUrl)
 		return nil, 500, err
 	}
+	client := stepClient(step, stepTransport)
+	var resp *http.Response
+	attempts := 0
+	for {
+		attempts++
+		req, err := http.NewRequestWithContext(ctx, "POST", serviceUrl, bytes.NewBuffer(input))
+		if err != nil {
+			log.Error(err, "An error occurred while preparing request object with serviceUrl.", "serviceUrl", serviceUrl)
+			return nil, 500, err
+		}
 
-	if val := req.Header.Get("Content-Type"); val == "" {
-		req.Header.Add("Content-Type", "application/json")
-	}
+		if val := req.Header.Get("Content-Type"); val == "" {
+			req.Header.Add("Content-Type", "application/json")
+		}
+		if err := applyHeaderPolicy(step, headers, req.Header); err != nil {
+			log.Error(err, "Failed to set the headers of the call", "stepName", step.StepName)
+			return nil, 500, err
+		}
+		for key, values := range forwardedHeadersFrom(ctx) {
+			req.Header[key] = values
+		}
+		// continue the trace in the downstream microservice
+		propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
 
-	resp, err := callClient.Do(req)
-	if err != nil {
-		log.Error(err, "An error has occurred while calling service", "service", serviceUrl)
-		return nil, 500, err
+		statusCode := 0
+		resp, err = client.Do(req)
+		if err == nil {
+			statusCode = resp.StatusCode
+		}
+		upstreamResponses.WithLabelValues(step.StepName, statusLabel(statusCode, err)).Inc()
+		if ctx.Err() != nil || !shouldRetry(step, attempts, statusCode, err) {
+			log.Info("Step attempts", "stepName", step.StepName, "attempts", attempts)
+			trace.SpanFromContext(ctx).SetAttributes(attrAttempts.Int(attempts))
+			if err != nil {
+				log.Error(err, "An error has occurred while calling service", "service", serviceUrl)
+				return nil, 500, err
+			}
+			break
+		}
+
+		if err == nil {
+			// drain the body so the connection can be reused
+			_, _ = io.Copy(io.Discard, resp.Body)
+			if cerr := resp.Body.Close(); cerr != nil {
+				log.Error(cerr, "Error while trying to close the responseBody of a failed attempt")
+			}
+		}
+		backoff := retryBackoff(step, attempts)
+		log.Info("Retry the step", "stepName", step.StepName, "attempt", attempts, "statusCode", statusCode,
+			"error", err, "backoff", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, 500, ctx.Err()
+		}
 	}
 
+	if resp.ContentLength >= 0 {
+		trace.SpanFromContext(ctx).SetAttributes(attrResponseBodySize.Int64(resp.ContentLength))
+	}
 	return resp.Body, resp.StatusCode, nil
 }
 
@@ -220,6 +302,22 @@ func getServiceURLByStepTarget(step *mcv1alpha3.Step, svcNameSpace string) strin
 }
 
 func executeStep(
+	ctx context.Context,
+	step *mcv1alpha3.Step,
+	graph mcv1alpha3.GMConnector,
+	initInput []byte,
+	input []byte,
+	headers http.Header,
+) (io.ReadCloser, int, error) {
+	if step.Cache != nil {
+		return executeCachedStep(ctx, step, graph, initInput, input, headers)
+	}
+	return executeStepTarget(ctx, step, graph, initInput, input, headers)
+}
+
+// executeStepTarget calls the node or the service of a step
+func executeStepTarget(
+	ctx context.Context,
 	step *mcv1alpha3.Step,
 	graph mcv1alpha3.GMConnector,
 	initInput []byte,
@@ -228,10 +326,33 @@ func executeStep(
 ) (io.ReadCloser, int, error) {
 	if step.NodeName != "" {
 		// when nodeName is specified make a recursive call for routing to next step
-		return routeStep(step.NodeName, graph, initInput, input, headers)
+		return routeStep(ctx, step.NodeName, graph, initInput, input, headers)
 	}
 	serviceURL := getServiceURLByStepTarget(step, graph.Namespace)
-	return callService(step, serviceURL, input, headers)
+	settings, enabled := breakerSettingsFor(&graph)
+	if !enabled {
+		return callService(ctx, step, serviceURL, input, headers)
+	}
+	breaker := circuitBreakers.get(serviceURL, settings)
+	if !breaker.allow() {
+		log.Info("The circuit breaker is open", "stepName", step.StepName, "serviceUrl", serviceURL)
+		if step.Fallback != nil {
+			return executeFallback(ctx, step, graph, initInput, input, headers)
+		}
+		return nil, 503, fmt.Errorf("the circuit breaker of %s is open", serviceURL)
+	}
+	responseBody, statusCode, err := callService(ctx, step, serviceURL, input, headers)
+	switch {
+	case ctx.Err() != nil:
+		// the request was cancelled or timed out, not the call of the service
+		breaker.release()
+	case isCallQueueRejection(err):
+		// the router is overloaded, the service was not called
+		breaker.release()
+	default:
+		breaker.record(err == nil && statusCode < 500)
+	}
+	return responseBody, statusCode, err
 }
 
 func mergeRequests(respReq []byte, initReqData map[string]interface{}) []byte {
@@ -261,6 +382,7 @@ func mergeRequests(respReq []byte, initReqData map[string]interface{}) []byte {
 }
 
 func handleSwitchNode(
+	ctx context.Context,
 	route *mcv1alpha3.Step,
 	graph mcv1alpha3.GMConnector,
 	initInput []byte,
@@ -275,7 +397,7 @@ func handleSwitchNode(
 		stepType = ServiceNode
 	}
 	log.Info("Starting execution of step", "Node Name", route.NodeName, "type", stepType, "stepName", route.StepName)
-	if responseBody, statusCode, err = executeStep(route, graph, initInput, request, headers); err != nil {
+	if responseBody, statusCode, err = executeStep(ctx, route, graph, initInput, request, headers); err != nil {
 		return nil, 500, err
 	}
 
@@ -291,7 +413,8 @@ func handleSwitchNode(
 	return responseBody, statusCode, nil
 }
 
-func handleSwitchPipeline(nodeName string,
+func handleSwitchPipeline(ctx context.Context,
+	nodeName string,
 	graph mcv1alpha3.GMConnector,
 	initInput []byte,
 	input []byte,
@@ -301,6 +424,7 @@ func handleSwitchPipeline(nodeName string,
 	var statusCode int
 	var responseBody io.ReadCloser
 	var responseBytes []byte
+	var prevStepName string
 	var err error
 
 	initReqData := make(map[string]interface{})
@@ -310,6 +434,12 @@ func handleSwitchPipeline(nodeName string,
 	}
 
 	for index, route := range currentNode.Steps {
+		if err = ctx.Err(); err != nil {
+			if responseBody != nil {
+				_ = responseBody.Close()
+			}
+			return nil, 500, err
+		}
 		if route.InternalService.IsDownstreamService {
 			log.Info(
 				"InternalService DownstreamService is true, skip the execution of step",
@@ -323,11 +453,22 @@ func handleSwitchPipeline(nodeName string,
 
 		// make sure that the process goes to the correct step
 		if route.Condition != "" {
-			if !pickupRouteByCondition(initInput, route.Condition) {
+			if condition.IsExpression(route.Condition) {
+				env := condition.Env{Request: initInput, Headers: headers}
+				if !matchCondition(route.Condition, env) {
+					continue
+				}
+			} else if !pickupRouteByCondition(initInput, route.Condition) {
 				continue
 			}
 		}
 
+		// the branches restricted to some claims are skipped by the other requests
+		if len(route.Claims) > 0 && !matchClaimRules(claimsFrom(ctx), route.Claims) {
+			log.Info("The request does not have the claims of the step, skip it", "stepName", route.StepName)
+			continue
+		}
+
 		log.Info("Current Step Information", "Node Name", nodeName, "Step Index", index)
 		request := input
 		if responseBody != nil {
@@ -342,14 +483,32 @@ func handleSwitchPipeline(nodeName string,
 			if err != nil {
 				log.Error(err, "Error while trying to close the responseBody in handleSwitchPipeline")
 			}
+			recordStepOutput(ctx, prevStepName, responseBytes)
 		}
 
 		log.Info("Print Original Request Bytes", "Request Bytes", request)
-		if route.Data == "$response" && index > 0 {
+		if route.Data == responseData && index > 0 {
 			request = mergeRequests(responseBytes, initReqData)
+		} else if isDataTemplate(route.Data) {
+			if request, err = renderStepData(ctx, &route, initInput, responseBytes); err != nil {
+				return nil, 500, err
+			}
 		}
 		log.Info("Print New Request Bytes", "Request Bytes", request)
-		responseBody, statusCode, err = handleSwitchNode(&route, graph, initInput, request, headers)
+		if route.Mirror {
+			mirrorStep(ctx, nodeName, &route, graph, initInput, request, headers)
+			// the next step gets the response of the step before the mirror
+			if responseBody != nil {
+				responseBody = NewReadCloser(responseBytes)
+			}
+			continue
+		}
+		stepStart := time.Now()
+		stepCtx, span := startStepSpan(ctx, nodeName, &route, request)
+		responseBody, statusCode, err = handleSwitchNode(stepCtx, &route, graph, initInput, request, headers)
+		endSpan(span, statusCode, err)
+		observeStep(nodeName, route.StepName, statusCode, err, stepStart)
+		prevStepName = route.StepName
 		if err != nil {
 			return nil, statusCode, err
 		}
@@ -357,82 +516,8 @@ func handleSwitchPipeline(nodeName string,
 	return responseBody, statusCode, err
 }
 
-func handleEnsemblePipeline(nodeName string,
-	graph mcv1alpha3.GMConnector,
-	initInput []byte,
-	input []byte,
-	headers http.Header,
-) (io.ReadCloser, int, error) {
-	currentNode := graph.Spec.Nodes[nodeName]
-	ensembleRes := make([]chan EnsembleStepOutput, len(currentNode.Steps))
-	errChan := make(chan error)
-	for i := range currentNode.Steps {
-		step := &currentNode.Steps[i]
-		stepType := ServiceURL
-		if step.NodeName != "" {
-			stepType = ServiceNode
-		}
-		log.Info("Starting execution of step", "type", stepType, "stepName", step.StepName)
-		resultChan := make(chan EnsembleStepOutput)
-		ensembleRes[i] = resultChan
-		go func() {
-			responseBody, statusCode, err := executeStep(step, graph, initInput, input, headers)
-			if err == nil {
-				output, rerr := io.ReadAll(responseBody)
-				if rerr != nil {
-					log.Error(rerr, "Error while reading the response body")
-				}
-				var res map[string]interface{}
-				if err = json.Unmarshal(output, &res); err == nil {
-					resultChan <- EnsembleStepOutput{
-						StepResponse:   res,
-						StepStatusCode: statusCode,
-					}
-					return
-				}
-			}
-			rerr := responseBody.Close()
-			if rerr != nil {
-				log.Error(rerr, "Error while trying to close the responseBody in handleEnsemblePipeline")
-			}
-			errChan <- err
-		}()
-	}
-	// merge responses from parallel steps
-	response := map[string]interface{}{}
-	ensembleStepOutput := EnsembleStepOutput{}
-	for i, resultChan := range ensembleRes {
-		key := currentNode.Steps[i].StepName
-		if key == "" {
-			key = strconv.Itoa(i) // Use index if no step name
-		}
-		select {
-		case ensembleStepOutput = <-resultChan:
-			if !isSuccessFul(ensembleStepOutput.StepStatusCode) && currentNode.Steps[i].Dependency == mcv1alpha3.Hard {
-				log.Info(
-					"This step is a hard dependency and it is unsuccessful",
-					"stepName",
-					currentNode.Steps[i].StepName,
-					"statusCode",
-					ensembleStepOutput.StepStatusCode,
-				)
-				stepResponse, _ := json.Marshal(ensembleStepOutput.StepResponse)
-				stepIOReader := NewReadCloser(stepResponse)
-				return stepIOReader, ensembleStepOutput.StepStatusCode, nil
-			} else {
-				response[key] = ensembleStepOutput.StepResponse
-			}
-		case err := <-errChan:
-			return nil, 500, err
-		}
-	}
-	// return json.Marshal(response)
-	combinedResponse, _ := json.Marshal(response) // TODO check if you need err handling for Marshalling
-	combinedIOReader := NewReadCloser(combinedResponse)
-	return combinedIOReader, 200, nil
-}
-
-func handleSequencePipeline(nodeName string,
+func handleSequencePipeline(ctx context.Context,
+	nodeName string,
 	graph mcv1alpha3.GMConnector,
 	initInput []byte,
 	input []byte,
@@ -442,6 +527,8 @@ func handleSequencePipeline(nodeName string,
 	var statusCode int
 	var responseBody io.ReadCloser
 	var responseBytes []byte
+	var prevStepName string
+	var cacheMiss *semanticCacheMiss
 	var err error
 
 	initReqData := make(map[string]interface{})
@@ -450,6 +537,12 @@ func handleSequencePipeline(nodeName string,
 		return nil, 500, err
 	}
 	for i := range currentNode.Steps {
+		if err = ctx.Err(); err != nil {
+			if responseBody != nil {
+				_ = responseBody.Close()
+			}
+			return nil, 500, err
+		}
 		step := &currentNode.Steps[i]
 		stepType := ServiceURL
 		if step.NodeName != "" {
@@ -480,22 +573,74 @@ func handleSequencePipeline(nodeName string,
 			if err != nil {
 				log.Error(err, "Error while trying to close the responseBody in handleSequencePipeline")
 			}
+			recordStepOutput(ctx, prevStepName, responseBytes)
 		}
 
-		if step.Data == "$response" && i > 0 {
+		if step.Data == responseData && i > 0 {
 			request = mergeRequests(responseBytes, initReqData)
+		} else if isDataTemplate(step.Data) {
+			if request, err = renderStepData(ctx, step, initInput, responseBytes); err != nil {
+				return nil, 500, err
+			}
 		}
 		log.Info("Print New Request Bytes", "Request Bytes", request)
-		if step.Condition != "" {
-			if !gjson.ValidBytes(responseBytes) {
-				return nil, 500, fmt.Errorf("invalid response")
+		if step.Mirror {
+			if step.Condition == "" || matchStepCondition(step, initInput, responseBytes, headers) {
+				mirrorStep(ctx, nodeName, step, graph, initInput, request, headers)
+			}
+			// the next step gets the response of the step before the mirror
+			if responseBody != nil {
+				responseBody = NewReadCloser(responseBytes)
 			}
+			continue
+		}
+		if step.StepName == mcv1alpha3.SemanticCacheStep {
+			if step.Condition == "" || matchStepCondition(step, initInput, responseBytes, headers) {
+				cached, miss, err := lookupSemanticCache(ctx, nodeName, step, graph, initInput, request, headers)
+				if err != nil {
+					// the cache is an optimization, the sequence goes on without it
+					log.Error(err, "Failed to look up the semantic cache", "stepName", step.StepName)
+				} else if cached != nil {
+					// the response to a similar request replaces the rest of the sequence
+					return cached, http.StatusOK, nil
+				}
+				cacheMiss = miss
+			}
+			// the next step gets the response of the step before the lookup
+			if responseBody != nil {
+				responseBody = NewReadCloser(responseBytes)
+			}
+			continue
+		}
+		if step.Condition != "" {
 			// if the condition does not match for the step in the sequence we stop and return the response
-			if !gjson.GetBytes(responseBytes, step.Condition).Exists() {
-				return responseBody, 500, nil
+			if condition.IsExpression(step.Condition) {
+				env := condition.Env{Request: initInput, Response: responseBytes, Headers: headers}
+				if !matchCondition(step.Condition, env) {
+					return NewReadCloser(responseBytes), 500, nil
+				}
+			} else {
+				if !gjson.ValidBytes(responseBytes) {
+					return nil, 500, fmt.Errorf("invalid response")
+				}
+				if !gjson.GetBytes(responseBytes, step.Condition).Exists() {
+					return NewReadCloser(responseBytes), 500, nil
+				}
 			}
 		}
-		if responseBody, statusCode, err = executeStep(step, graph, initInput, request, headers); err != nil {
+		if embedding := cacheMiss.reuseEmbedding(step, request); embedding != nil {
+			log.Info("Use the embedding of the semantic cache lookup", "stepName", step.StepName)
+			responseBody, statusCode = NewReadCloser(embedding), http.StatusOK
+			prevStepName = step.StepName
+			continue
+		}
+		stepStart := time.Now()
+		stepCtx, span := startStepSpan(ctx, nodeName, step, request)
+		responseBody, statusCode, err = executeStep(stepCtx, step, graph, initInput, request, headers)
+		endSpan(span, statusCode, err)
+		observeStep(nodeName, step.StepName, statusCode, err, stepStart)
+		prevStepName = step.StepName
+		if err != nil {
 			return nil, 500, err
 		}
 		/*
@@ -515,230 +660,175 @@ func handleSequencePipeline(nodeName string,
 			}
 		}
 	}
+	if cacheMiss != nil && statusCode == http.StatusOK {
+		responseBody = cacheMiss.cacheResponse(ctx, responseBody)
+	}
 	return responseBody, statusCode, nil
 }
 
-func routeStep(nodeName string,
+func routeStep(ctx context.Context,
+	nodeName string,
 	graph mcv1alpha3.GMConnector,
 	initInput, input []byte,
 	headers http.Header,
 ) (io.ReadCloser, int, error) {
-	defer timeTrack(time.Now(), "node", nodeName)
+	start := time.Now()
+	defer timeTrack(start, "node", nodeName)
 	currentNode := graph.Spec.Nodes[nodeName]
 	log.Info("Current Node", "Node Name", nodeName)
 
-	if currentNode.RouterType == mcv1alpha3.Switch {
-		return handleSwitchPipeline(nodeName, graph, initInput, input, headers)
+	ctx = withStepOutputs(ctx)
+	ctx, span := startSpan(ctx, "node "+nodeName, trace.WithAttributes(
+		attrNode.String(nodeName),
+		attrRouterType.String(string(currentNode.RouterType)),
+		attrRequestBodySize.Int(len(input)),
+	))
+	var responseBody io.ReadCloser
+	var statusCode int
+	var err error
+	switch currentNode.RouterType {
+	case mcv1alpha3.Switch:
+		responseBody, statusCode, err = handleSwitchPipeline(ctx, nodeName, graph, initInput, input, headers)
+	case mcv1alpha3.Ensemble:
+		responseBody, statusCode, err = handleEnsemblePipeline(ctx, nodeName, graph, initInput, input, headers)
+	case mcv1alpha3.Sequence:
+		responseBody, statusCode, err = handleSequencePipeline(ctx, nodeName, graph, initInput, input, headers)
+	case mcv1alpha3.DAG:
+		responseBody, statusCode, err = handleDAGPipeline(ctx, nodeName, graph, initInput, input, headers)
+	case mcv1alpha3.Splitter:
+		responseBody, statusCode, err = handleSplitterPipeline(ctx, nodeName, graph, initInput, input, headers)
+	default:
+		log.Error(nil, "invalid route type", "type", currentNode.RouterType)
+		statusCode, err = 500, fmt.Errorf("invalid route type: %v", currentNode.RouterType)
 	}
+	endSpan(span, statusCode, err)
+	observeNode(nodeName, statusCode, err, start)
+	return responseBody, statusCode, err
+}
 
-	if currentNode.RouterType == mcv1alpha3.Ensemble {
-		return handleEnsemblePipeline(nodeName, graph, initInput, input, headers)
+func mcGraphHandler(w http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	// take a snapshot of the graph, so a reload does not affect the in-flight request
+	graph := mcGraph.Load()
+	if graph == nil {
+		http.Error(w, "the graph is not loaded", http.StatusServiceUnavailable)
+		return
 	}
-
-	if currentNode.RouterType == mcv1alpha3.Sequence {
-		return handleSequencePipeline(nodeName, graph, initInput, input, headers)
+	// the DataPrep steps can declare their routes anywhere
+	if step, _ := findDataPrepRoute(graph, req.URL.Path); step != nil {
+		mcDataHandler(w, req)
+		return
+	}
+	nodeName, status := entrypointNode(graph, req.URL.Path, req.Method)
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return
 	}
-	log.Error(nil, "invalid route type", "type", currentNode.RouterType)
-	return nil, 500, fmt.Errorf("invalid route type: %v", currentNode.RouterType)
-}
 
-func mcGraphHandler(w http.ResponseWriter, req *http.Request) {
-	ctx, cancel := context.WithTimeout(req.Context(), time.Minute)
-	defer cancel()
+	// continue the trace of the caller if there is one
+	ctx := propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	ctx, span := startSpan(ctx, "gmc-router "+req.Method+" "+req.URL.Path, trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
 
-	done := make(chan struct{})
-	go func() {
-		defer close(done)
+	// the deadline and the client disconnection cancel every call made for the request
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout(graph))
+	defer cancel()
+	ctx = withResponseHeaders(ctx)
 
-		inputBytes, err := io.ReadAll(req.Body)
-		if err != nil {
-			log.Error(err, "failed to read request body")
-			http.Error(w, "failed to read request body", http.StatusBadRequest)
-			return
-		}
+	inputBytes, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.Error(err, "failed to read request body")
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
 
-		responseBody, statusCode, err := routeStep(defaultNodeName, *mcGraph, inputBytes, inputBytes, req.Header)
-		if err != nil {
+	responseBody, statusCode, err := routeStep(ctx, nodeName, *graph, inputBytes, inputBytes, req.Header)
+	span.SetAttributes(attrStatusCode.Int(statusCode))
+	copyResponseHeaders(ctx, w.Header())
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			log.Error(err, "request timed out")
+			http.Error(w, "request timed out", http.StatusGatewayTimeout)
+		case errors.Is(ctx.Err(), context.Canceled):
+			log.Info("The request is cancelled by the client", "error", err.Error())
+		default:
 			log.Error(err, "failed to process request")
+			if isCallQueueRejection(err) {
+				// the nodes report the failed steps as 500, the client may retry after Retry-After
+				statusCode = http.StatusServiceUnavailable
+			}
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(statusCode)
 			if _, err := w.Write(prepareErrorResponse(err, "Failed to process request")); err != nil {
 				log.Error(err, "failed to write mcGraphHandler response")
 			}
-			return
 		}
-		defer func() {
-			err := responseBody.Close()
-			if err != nil {
-				log.Error(err, "Error while trying to close the responseBody in mcGraphHandler")
-			}
-		}()
-
-		w.Header().Set("Content-Type", "application/json")
-		buffer := make([]byte, BufferSize)
-		for {
-			n, err := responseBody.Read(buffer)
-			if err != nil && err != io.EOF {
-				log.Error(err, "failed to read from response body")
-				http.Error(w, "failed to read from response body", http.StatusInternalServerError)
-				return
-			}
-			if n == 0 {
-				break
-			}
-
-			// Write the chunk to the ResponseWriter
-			if _, err := w.Write(buffer[:n]); err != nil {
-				log.Error(err, "failed to write to ResponseWriter")
-				return
-			}
-			// Flush the data to the client immediately
-			if flusher, ok := w.(http.Flusher); ok {
-				flusher.Flush()
-			} else {
-				log.Error(errors.New("unable to flush data"), "ResponseWriter does not support flushing")
-				return
-			}
+		return
+	}
+	defer func() {
+		err := responseBody.Close()
+		if err != nil {
+			log.Error(err, "Error while trying to close the responseBody in mcGraphHandler")
 		}
 	}()
 
-	select {
-	case <-ctx.Done():
-		log.Error(errors.New("request timed out"), "failed to process request")
-		http.Error(w, "request timed out", http.StatusGatewayTimeout)
-	case <-done:
+	body := bufio.NewReaderSize(responseBody, BufferSize)
+	if isEventStream(body) {
+		streamEvents(ctx, w, body, graph, streamRewriter(graph, inputBytes), start)
 		log.Info("mcGraphHandler is done")
+		return
 	}
-}
-
-func mcDataHandler(w http.ResponseWriter, r *http.Request) {
-	var isDataHandled bool
-	serviceName := r.Header.Get("SERVICE_NAME")
-	defaultNode := mcGraph.Spec.Nodes[defaultNodeName]
-	for i := range defaultNode.Steps {
-		step := &defaultNode.Steps[i]
-		if DataPrep == step.StepName {
-			if serviceName != "" && serviceName != step.InternalService.ServiceName {
-				continue
-			}
-			log.Info("Starting execution of step", "stepName", step.StepName)
-			serviceURL := getServiceURLByStepTarget(step, mcGraph.Namespace)
-			log.Info("ServiceURL is", "serviceURL", serviceURL)
-			// Parse the multipart form in the request
-			// err := r.ParseMultipartForm(64 << 20) // 64 MB is the default used by ParseMultipartForm
-
-			// Set no limit on multipart form size
-			err := r.ParseMultipartForm(0)
-			if err != nil {
-				http.Error(w, "Failed to parse multipart form", http.StatusBadRequest)
-				return
-			}
-			// Create a buffer to hold the new form data
-			var buf bytes.Buffer
-			writer := multipart.NewWriter(&buf)
-			// Copy all form fields from the original request to the new request
-			for key, values := range r.MultipartForm.Value {
-				for _, value := range values {
-					err := writer.WriteField(key, value)
-					if err != nil {
-						handleMultipartError(writer, err)
-						http.Error(w, "Failed to write form field", http.StatusInternalServerError)
-						return
-					}
-				}
-			}
-			// Copy all files from the original request to the new request
-			for key, fileHeaders := range r.MultipartForm.File {
-				for _, fileHeader := range fileHeaders {
-					file, err := fileHeader.Open()
-					if err != nil {
-						handleMultipartError(writer, err)
-						http.Error(w, "Failed to open file", http.StatusInternalServerError)
-						return
-					}
-					defer func() {
-						if err := file.Close(); err != nil {
-							log.Error(err, "error closing file")
-						}
-					}()
-					part, err := writer.CreateFormFile(key, fileHeader.Filename)
-					if err != nil {
-						handleMultipartError(writer, err)
-						http.Error(w, "Failed to create form file", http.StatusInternalServerError)
-						return
-					}
-					_, err = io.Copy(part, file)
-					if err != nil {
-						handleMultipartError(writer, err)
-						http.Error(w, "Failed to copy file", http.StatusInternalServerError)
-						return
-					}
-				}
-			}
 
-			err = writer.Close()
-			if err != nil {
-				http.Error(w, "Failed to close writer", http.StatusInternalServerError)
-				return
-			}
+	w.Header().Set("Content-Type", "application/json")
+	buffer := make([]byte, BufferSize)
+	firstByte := true
+	for {
+		n, err := body.Read(buffer)
+		if err != nil && err != io.EOF {
+			log.Error(err, "failed to read from response body")
+			http.Error(w, "failed to read from response body", http.StatusInternalServerError)
+			return
+		}
+		if n == 0 {
+			break
+		}
 
-			req, err := http.NewRequest(r.Method, serviceURL, &buf)
-			if err != nil {
-				http.Error(w, "Failed to create new request", http.StatusInternalServerError)
-				return
-			}
-			// Copy headers from the original request to the new request
-			for key, values := range r.Header {
-				for _, value := range values {
-					req.Header.Add(key, value)
-				}
-			}
-			req.Header.Set("Content-Type", writer.FormDataContentType())
-			client := &http.Client{}
-			resp, err := client.Do(req)
-			if err != nil {
-				http.Error(w, "Failed to send request to backend", http.StatusInternalServerError)
-				return
-			}
-			defer func() {
-				if err := resp.Body.Close(); err != nil {
-					log.Error(err, "error closing response body stream")
-				}
-			}()
-			// Copy the response headers from the backend service to the original client
-			for key, values := range resp.Header {
-				for _, value := range values {
-					w.Header().Add(key, value)
-				}
-			}
-			w.WriteHeader(resp.StatusCode)
-			// Copy the response body from the backend service to the original client
-			_, err = io.Copy(w, resp.Body)
-			if err != nil {
-				log.Error(err, "failed to copy response body")
-			}
-			isDataHandled = true
+		// Write the chunk to the ResponseWriter
+		if _, err := w.Write(buffer[:n]); err != nil {
+			log.Error(err, "failed to write to ResponseWriter")
+			return
+		}
+		if firstByte {
+			timeToFirstByte.Observe(time.Since(start).Seconds())
+			firstByte = false
+		}
+		// Flush the data to the client immediately
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		} else {
+			log.Error(errors.New("unable to flush data"), "ResponseWriter does not support flushing")
+			return
 		}
 	}
+	log.Info("mcGraphHandler is done")
+}
 
-	if !isDataHandled {
+// mcDataHandler proxies the requests to the DataPrep step declaring the route of the path
+func mcDataHandler(w http.ResponseWriter, r *http.Request) {
+	graph := mcGraph.Load()
+	step, operation := findDataPrepRoute(graph, r.URL.Path)
+	if step == nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(404)
 		if _, err := w.Write([]byte("\n Message: None dataprep endpoint is available! \n")); err != nil {
 			log.Info("Message: ", "failed to write mcDataHandler response")
 		}
-	}
-}
-
-func handleMultipartError(writer *multipart.Writer, err error) {
-	// In case of an error, close the writer to clean up
-	werr := writer.Close()
-	if werr != nil {
-		log.Error(werr, "Error during close writer")
 		return
 	}
-	// Handle the error as needed, such as logging or returning an error response
-	log.Error(err, "Error during multipart creation")
+	log.Info("Starting execution of step", "stepName", step.StepName, "operation", operation)
+	serveDataPrep(w, r, graph, step, operation)
 }
 
 // create a handler to handle traffic to /ui
@@ -748,7 +838,8 @@ func mcUiHandler(w http.ResponseWriter, req *http.Request) {
 	// redirect traffic to ui pod if payload is empty
 	// redirect traffic to mcGraphHandler if payload is not empty
 	var finishProcessing bool
-	defaultNode := mcGraph.Spec.Nodes[defaultNodeName]
+	graph := mcGraph.Load()
+	defaultNode := graph.Spec.Nodes[defaultNodeName]
 	for i := range defaultNode.Steps {
 		step := &defaultNode.Steps[i]
 		if UI == step.StepName {
@@ -760,7 +851,7 @@ func mcUiHandler(w http.ResponseWriter, req *http.Request) {
 
 			// if no payload included in the request, redirect request to UI
 			if len(body) == 0 {
-				serviceURL := getServiceURLByStepTarget(step, mcGraph.Namespace)
+				serviceURL := getServiceURLByStepTarget(step, graph.Namespace)
 				targetURL, err := url.Parse(serviceURL)
 				if err != nil {
 					http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -865,12 +956,15 @@ func mcUiHandler(w http.ResponseWriter, req *http.Request) {
 					http.Error(w, err.Error(), http.StatusInternalServerError)
 				}
 
-				// create a new http request with the formatted data
-				proxyReq, err := http.NewRequest(req.Method, req.URL.String(), bytes.NewReader(marshalData))
+				// create a new http request with the formatted data, it keeps the headers and the claims of the request
+				proxyReq, err := http.NewRequestWithContext(req.Context(), req.Method, req.URL.String(), bytes.NewReader(marshalData))
 				if err != nil {
 					log.Error(err, "Failed to generate new http request with formatted payload.")
 					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
 				}
+				proxyReq.Header = req.Header.Clone()
+				proxyReq.Header.Del("Content-Length")
 				mcGraphHandler(w, proxyReq)
 				finishProcessing = true
 			}
@@ -887,12 +981,13 @@ func mcUiHandler(w http.ResponseWriter, req *http.Request) {
 // create a handler to redirect that request to ui endpoint
 func mcAssetHandler(w http.ResponseWriter, req *http.Request) {
 	// Determine the asset type based on the URL path
-	defaultNode := mcGraph.Spec.Nodes[defaultNodeName]
+	graph := mcGraph.Load()
+	defaultNode := graph.Spec.Nodes[defaultNodeName]
 	found := false
 	for i := range defaultNode.Steps {
 		step := &defaultNode.Steps[i]
 		if UI == step.StepName {
-			serviceURL := getServiceURLByStepTarget(step, mcGraph.Namespace)
+			serviceURL := getServiceURLByStepTarget(step, graph.Namespace)
 			targetURL, err := url.Parse(serviceURL)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -910,10 +1005,15 @@ func mcAssetHandler(w http.ResponseWriter, req *http.Request) {
 
 func initializeRoutes() *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", mcGraphHandler)
-	mux.HandleFunc("/dataprep", mcDataHandler)
+	mux.HandleFunc("/", withAuthentication(withAdmission(mcGraphHandler)))
+	mux.HandleFunc("/dataprep", withAuthentication(withAdmission(mcDataHandler)))
+	mux.HandleFunc("/dataprep/", withAuthentication(withAdmission(mcDataHandler)))
 	mux.HandleFunc("/assets/", mcAssetHandler)
-	mux.HandleFunc("/ui", mcUiHandler)
+	mux.HandleFunc("/ui", withAuthentication(withAdmission(mcUiHandler)))
+	mux.HandleFunc(chatCompletionsPath, withAuthentication(withAdmission(chatCompletionsHandler)))
+	mux.HandleFunc(embeddingsPath, withAuthentication(withAdmission(embeddingsHandler)))
+	mux.HandleFunc("/debug/circuitbreakers", breakerDebugHandler)
+	mux.Handle("/metrics", promhttp.Handler())
 	return mux
 }
 
@@ -921,12 +1021,31 @@ func main() {
 	flag.Parse()
 	logf.SetLogger(zap.New())
 
-	mcGraph = &mcv1alpha3.GMConnector{}
-	err := json.Unmarshal([]byte(*jsonGraph), mcGraph)
-	if err != nil {
-		log.Error(err, "failed to unmarshall gmc graph json")
-		os.Exit(1)
+	if *tlsProxyUpstream != "" {
+		if err := runTLSProxy(); err != nil {
+			log.Error(err, "failed to run the mutual TLS proxy")
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *graphFile != "" {
+		graph, err := loadGraphFile(*graphFile)
+		if err != nil {
+			log.Error(err, "failed to load gmc graph file", "path", *graphFile)
+			os.Exit(1)
+		}
+		mcGraph.Store(graph)
+		go watchGraphFile(context.Background(), *graphFile, *graphReload)
+	} else {
+		graph, err := parseGraph([]byte(*jsonGraph))
+		if err != nil {
+			log.Error(err, "failed to load gmc graph json")
+			os.Exit(1)
+		}
+		mcGraph.Store(graph)
 	}
+	configureTracing(mcGraph.Load())
 
 	mcRouter := initializeRoutes()
 
@@ -935,14 +1054,15 @@ func main() {
 		Addr: ":8080",
 		// specify the HTTP routers
 		Handler: mcRouter,
-		// set the maximum duration for reading the entire request, including the body
+		// set the maximum duration for reading the entire request, including the body,
+		// the dataprep routes extend it to the timeout of their operation for the uploads
 		ReadTimeout: time.Minute,
-		// set the maximum duration before timing out writes of the response
-		WriteTimeout: time.Minute,
+		// no WriteTimeout, the response of a long LLM generation is streamed for longer than a minute,
+		// the duration of a request is bounded by the timeout in the router config and the step timeouts
 		// set the maximum amount of time to wait for the next request when keep-alive are enabled
 		IdleTimeout: 3 * time.Minute,
 	}
-	err = server.ListenAndServe()
+	err := server.ListenAndServe()
 
 	if err != nil {
 		log.Error(err, "failed to listen on 8080")
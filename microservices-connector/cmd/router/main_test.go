@@ -112,7 +112,7 @@ func TestSimpleModelChainer(t *testing.T) {
 		"Authorization": {"Bearer Token"},
 	}
 
-	res, _, err := routeStep("root", gmcGraph, jsonBytes, jsonBytes, headers)
+	res, _, err := routeStep(context.Background(), "root", gmcGraph, jsonBytes, jsonBytes, headers)
 	if err != nil {
 		return
 	}
@@ -218,7 +218,7 @@ func TestSimpleServiceEnsemble(t *testing.T) {
 	headers := http.Header{
 		"Authorization": {"Bearer Token"},
 	}
-	res, _, err := routeStep("root", gmcGraph, jsonBytes, jsonBytes, headers)
+	res, _, err := routeStep(context.Background(), "root", gmcGraph, jsonBytes, jsonBytes, headers)
 	if err != nil {
 		return
 	}
@@ -244,6 +244,44 @@ func TestSimpleServiceEnsemble(t *testing.T) {
 	assert.Equal(t, expectedResponse, response)
 }
 
+func TestServiceEnsembleCancel(t *testing.T) {
+	// the slow service only returns once its request is cancelled
+	cancelled := make(chan struct{})
+	slowService := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.ReadAll(req.Body)
+		<-req.Context().Done()
+		close(cancelled)
+	}))
+	defer slowService.Close()
+	failingService := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(`not json`))
+	}))
+	defer failingService.Close()
+
+	gmcGraph := mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Ensemble,
+					Steps: []mcv1alpha3.Step{
+						{StepName: "slow", ServiceURL: slowService.URL},
+						{StepName: "failing", ServiceURL: failingService.URL},
+					},
+				},
+			},
+		},
+	}
+
+	// the failure of one step cancels the other branches
+	_, _, err := routeStep(context.Background(), "root", gmcGraph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.Error(t, err)
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the slow step was not cancelled")
+	}
+}
+
 func TestMCWithCondition(t *testing.T) {
 	// Start a local HTTP server
 	service1 := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
@@ -458,7 +496,7 @@ func TestMCWithCondition(t *testing.T) {
 	headers := http.Header{
 		"Authorization": {"Bearer Token"},
 	}
-	res, _, err := routeStep("root", gmcGraph, jsonBytes, jsonBytes, headers)
+	res, _, err := routeStep(context.Background(), "root", gmcGraph, jsonBytes, jsonBytes, headers)
 	if err != nil {
 		return
 	}
@@ -547,7 +585,7 @@ func TestCallServiceWhenNoneHeadersToPropagateIsEmpty(t *testing.T) {
 		Condition: "instances.#(modelId==\"1\")",
 	}
 
-	res, _, err := callService(step, service1Url.String(), jsonBytes, headers)
+	res, _, err := callService(context.Background(), step, service1Url.String(), jsonBytes, headers)
 	if err != nil {
 		return
 	}
@@ -581,7 +619,7 @@ func TestMalformedURL(t *testing.T) {
 		},
 		Condition: "instances.#(modelId==\"1\")",
 	}
-	_, response, err := callService(step, malformedURL, []byte{}, http.Header{})
+	_, response, err := callService(context.Background(), step, malformedURL, []byte{}, http.Header{})
 	if err != nil {
 		assert.Equal(t, 500, response)
 	}
@@ -717,7 +755,7 @@ func TestMcGraphHandler(t *testing.T) {
 		},
 	}
 
-	mcGraph = &mockGraph
+	mcGraph.Store(&mockGraph)
 	// Create a request with a sample input
 	input := []byte(`{"instances": ["test", "test2"]}`)
 	req, err := http.NewRequest("POST", "/", bytes.NewBuffer(input))
@@ -758,31 +796,29 @@ func TestMain(t *testing.T) {
 	}
 	jsonGraph := `
 	{
-		"root": {
-			"routerType": "sequence",
-			"steps": [
-				{
-					"stepName": "step1",
-					"executor": {
-						"nodeName": "animal-categorize",
-						"internalService": {
-							"namespace": "default",
-							"serviceName": "tei-embedding-service"
-						}
-					}
-				},
-				{
-					"stepName": "step2",
-					"executor": {
-						"nodeName": "breed-categorize",
-						"internalService": {
-							"namespace": "default",
-							"serviceName": "tgi-service"
+		"spec": {
+			"nodes": {
+				"root": {
+					"routerType": "Sequence",
+					"steps": [
+						{
+							"name": "step1",
+							"internalService": {
+								"nameSpace": "default",
+								"serviceName": "tei-embedding-service"
+							}
+						},
+						{
+							"name": "step2",
+							"internalService": {
+								"nameSpace": "default",
+								"serviceName": "tgi-service"
+							},
+							"condition": "predictions.#(label==\"dog\")"
 						}
-					},
-					"condition": "predictions.#(label==\"dog\")"
+					]
 				}
-			]
+			}
 		}
 	}
 	`
@@ -800,6 +836,22 @@ func TestMain(t *testing.T) {
 }
 
 func TestMcGraphHandler_Timeout(t *testing.T) {
+	// the service of the root node cannot be reached
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(&mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps:      []mcv1alpha3.Step{{StepName: "Llm", ServiceURL: unreachable.URL}},
+				},
+			},
+		},
+	})
+
 	// Mock server with a context timeout of 1 second
 	handler := http.HandlerFunc(mcGraphHandler)
 	server := httptest.NewServer(handler)
@@ -844,6 +896,36 @@ func TestMcGraphHandler_Timeout(t *testing.T) {
 	}
 }
 
+func TestMcGraphHandler_RequestTimeout(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.ReadAll(req.Body)
+		<-req.Context().Done()
+	}))
+	defer service.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(&mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			RouterConfig: mcv1alpha3.RouterConfig{
+				Config: map[string]string{requestTimeoutKey: "50ms"},
+			},
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps:      []mcv1alpha3.Step{{StepName: "slow", ServiceURL: service.URL}},
+				},
+			},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+	rr := httptest.NewRecorder()
+	mcGraphHandler(rr, req)
+	assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+	assert.Contains(t, rr.Body.String(), "request timed out")
+}
+
 func TestMcDataHandler(t *testing.T) {
 	// Start a local HTTP server
 	service1 := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
@@ -898,7 +980,7 @@ func TestMcDataHandler(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	// Mock the mcGraph data
-	mcGraph = &mcv1alpha3.GMConnector{
+	mcGraph.Store(&mcv1alpha3.GMConnector{
 		Spec: mcv1alpha3.GMConnectorSpec{
 			Nodes: map[string]mcv1alpha3.Router{
 				"root": {
@@ -917,7 +999,7 @@ func TestMcDataHandler(t *testing.T) {
 				},
 			},
 		},
-	}
+	})
 
 	// Call the mcDataHandler function
 	mcDataHandler(rr, req)
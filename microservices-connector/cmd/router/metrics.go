@@ -0,0 +1,122 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	metricsNamespace = "gmc_router"
+	// status label of the calls which failed without a response
+	statusError = "error"
+	// status label of the mirror calls dropped because too many were in flight
+	statusDropped = "dropped"
+)
+
+var (
+	nodeRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "node_requests_total",
+		Help:      "Number of requests routed through a graph node.",
+	}, []string{"node", "status"})
+	nodeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "node_duration_seconds",
+		Help:      "Time to route a request through a graph node, until the response headers of its last step.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 16),
+	}, []string{"node"})
+	stepRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "step_requests_total",
+		Help:      "Number of executions of a step of a graph node.",
+	}, []string{"node", "step", "status"})
+	stepDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "step_duration_seconds",
+		Help:      "Time to execute a step of a graph node, until the response headers of the step.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 16),
+	}, []string{"node", "step"})
+	upstreamResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_responses_total",
+		Help:      "Number of responses of the microservices by status code, retried attempts included.",
+	}, []string{"step", "code"})
+	timeToFirstByte = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "time_to_first_byte_seconds",
+		Help:      "Time from receiving a request to streaming the first byte of its response.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 16),
+	})
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cache_requests_total",
+		Help:      "Number of lookups in the response cache of a step by result, hit or miss.",
+	}, []string{"step", "result"})
+	mirrorRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "mirror_requests_total",
+		Help:      "Number of requests mirrored to the service of a mirror step.",
+	}, []string{"node", "step", "status"})
+	mirrorDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "mirror_duration_seconds",
+		Help:      "Time to execute a mirror step, until its whole response is received.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 16),
+	}, []string{"node", "step"})
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "inflight_mirror_calls",
+		Help:      "Number of mirror calls in progress.",
+	}, func() float64 { return float64(mirrorsInFlight.Load()) })
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "inflight_calls",
+		Help:      "Number of calls to the microservices in progress.",
+	}, func() float64 { return float64(len(callQueue.slots)) })
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "max_inflight_calls",
+		Help:      "Maximum number of concurrent calls to the microservices.",
+	}, func() float64 { return float64(cap(callQueue.slots)) })
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queued_calls",
+		Help:      "Number of calls to the microservices waiting for a free slot.",
+	}, func() float64 { return float64(callQueue.waiting.Load()) })
+	rejectedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rejected_requests_total",
+		Help:      "Number of requests rejected before routing by reason, unauthorized, forbidden, rate_limit or concurrency.",
+	}, []string{"reason"})
+)
+
+// statusLabel returns the status code of a response or "error" when there is none
+func statusLabel(statusCode int, err error) string {
+	if err != nil || statusCode == 0 {
+		return statusError
+	}
+	return strconv.Itoa(statusCode)
+}
+
+func observeNode(nodeName string, statusCode int, err error, start time.Time) {
+	nodeRequests.WithLabelValues(nodeName, statusLabel(statusCode, err)).Inc()
+	nodeDuration.WithLabelValues(nodeName).Observe(time.Since(start).Seconds())
+}
+
+func observeStep(nodeName string, stepName string, statusCode int, err error, start time.Time) {
+	stepRequests.WithLabelValues(nodeName, stepName, statusLabel(statusCode, err)).Inc()
+	stepDuration.WithLabelValues(nodeName, stepName).Observe(time.Since(start).Seconds())
+}
+
+func observeMirror(nodeName string, stepName string, statusCode int, err error, start time.Time) {
+	mirrorRequests.WithLabelValues(nodeName, stepName, statusLabel(statusCode, err)).Inc()
+	mirrorDuration.WithLabelValues(nodeName, stepName).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,89 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+func histogramSampleCount(t *testing.T) uint64 {
+	metric := &dto.Metric{}
+	if err := timeToFirstByte.Write(metric); err != nil {
+		t.Fatalf("failed to read the time to first byte histogram: %v", err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestStatusLabel(t *testing.T) {
+	assert.Equal(t, "200", statusLabel(200, nil))
+	assert.Equal(t, "503", statusLabel(503, nil))
+	assert.Equal(t, statusError, statusLabel(500, errors.New("connection refused")))
+	assert.Equal(t, statusError, statusLabel(0, nil))
+}
+
+func TestRouterMetrics(t *testing.T) {
+	service1 := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.ReadAll(req.Body)
+		_, _ = rw.Write([]byte(`{"text":"embedding"}`))
+	}))
+	defer service1.Close()
+	service2 := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.ReadAll(req.Body)
+		rw.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = rw.Write([]byte(`{"error":"overloaded"}`))
+	}))
+	defer service2.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(&mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps: []mcv1alpha3.Step{
+						{StepName: "MetricsEmbedding", ServiceURL: service1.URL},
+						{StepName: "MetricsLlm", ServiceURL: service2.URL},
+					},
+				},
+			},
+		},
+	})
+
+	nodeCount := testutil.ToFloat64(nodeRequests.WithLabelValues("root", "503"))
+	ttfbCount := histogramSampleCount(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"text":"question"}`))
+	rr := httptest.NewRecorder()
+	mcGraphHandler(rr, req)
+
+	assert.Equal(t, nodeCount+1, testutil.ToFloat64(nodeRequests.WithLabelValues("root", "503")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(stepRequests.WithLabelValues("root", "MetricsEmbedding", "200")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(stepRequests.WithLabelValues("root", "MetricsLlm", "503")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(upstreamResponses.WithLabelValues("MetricsEmbedding", "200")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(upstreamResponses.WithLabelValues("MetricsLlm", "503")))
+	assert.Equal(t, ttfbCount+1, histogramSampleCount(t))
+
+	// the metrics are served on /metrics
+	rr = httptest.NewRecorder()
+	initializeRoutes().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, `gmc_router_step_duration_seconds_count{node="root",step="MetricsLlm"} 1`)
+	assert.Contains(t, body, "gmc_router_inflight_calls 0")
+	assert.Contains(t, body, "gmc_router_max_inflight_calls")
+	assert.Contains(t, body, "gmc_router_time_to_first_byte_seconds_count")
+}
@@ -0,0 +1,119 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+)
+
+const (
+	// RouterConfig.Config keys of the mirror steps
+	mirrorMaxConcurrencyKey = "mirrorMaxConcurrency"
+	mirrorSampleRateKey     = "mirrorSampleRate"
+
+	defaultMirrorMaxConcurrency = 16
+)
+
+// mirrorsInFlight counts the mirror calls in progress. They are capped on their own
+// and skip the call queue, so the mirrors cannot take the call slots the steps of the requests need.
+var mirrorsInFlight atomic.Int64
+
+type mirrorCallKey struct{}
+
+// isMirrorCall tells if the context is the one of a mirror call
+func isMirrorCall(ctx context.Context) bool {
+	mirror, _ := ctx.Value(mirrorCallKey{}).(bool)
+	return mirror
+}
+
+func mirrorMaxConcurrency(graph *mcv1alpha3.GMConnector) int64 {
+	value, err := strconv.ParseInt(graph.Spec.RouterConfig.Config[mirrorMaxConcurrencyKey], 10, 64)
+	if err != nil || value <= 0 {
+		return defaultMirrorMaxConcurrency
+	}
+	return value
+}
+
+// mirrorSampleRate is the share of the mirror responses logged with their request
+// for offline evaluation, the others are discarded
+func mirrorSampleRate(graph *mcv1alpha3.GMConnector) float64 {
+	value, err := strconv.ParseFloat(graph.Spec.RouterConfig.Config[mirrorSampleRateKey], 64)
+	if err != nil || value < 0 {
+		return 0
+	}
+	return value
+}
+
+// mirrorContext keeps the values of the request context like the trace, but neither its
+// cancellation nor the places the steps of the request write their results to
+func mirrorContext(ctx context.Context, graph *mcv1alpha3.GMConnector) (context.Context, context.CancelFunc) {
+	outputs := stepOutputsFrom(ctx)
+	if outputs == nil {
+		outputs = map[string][]byte{}
+	}
+	ctx = context.WithValue(context.WithoutCancel(ctx), stepOutputsKey{}, &stepOutputs{outputs: outputs})
+	ctx = context.WithValue(ctx, responseHeadersKey{}, nil)
+	ctx = context.WithValue(ctx, mirrorCallKey{}, true)
+	return context.WithTimeout(ctx, requestTimeout(graph))
+}
+
+// mirrorStep sends the request of a mirror step to its service in the background,
+// the call is dropped when too many mirror calls are in flight
+func mirrorStep(ctx context.Context,
+	nodeName string,
+	step *mcv1alpha3.Step,
+	graph mcv1alpha3.GMConnector,
+	initInput []byte,
+	request []byte,
+	headers http.Header,
+) {
+	if mirrorsInFlight.Add(1) > mirrorMaxConcurrency(&graph) {
+		mirrorsInFlight.Add(-1)
+		log.Info("Too many mirror calls in flight, drop the mirror of step", "node", nodeName, "stepName", step.StepName)
+		mirrorRequests.WithLabelValues(nodeName, step.StepName, statusDropped).Inc()
+		return
+	}
+	log.Info("Mirroring the request of step", "node", nodeName, "stepName", step.StepName)
+	// the loop of the caller may reuse the step and the headers
+	mirror := *step
+	headers = headers.Clone()
+	sampled := rand.Float64() < mirrorSampleRate(&graph)
+	mirrorCtx, cancel := mirrorContext(ctx, &graph)
+	go func() {
+		defer mirrorsInFlight.Add(-1)
+		defer cancel()
+		start := time.Now()
+		stepCtx, span := startStepSpan(mirrorCtx, nodeName, &mirror, request)
+		responseBody, statusCode, err := executeStep(stepCtx, &mirror, graph, initInput, request, headers)
+		if err != nil {
+			log.Error(err, "Mirror call failed", "node", nodeName, "stepName", mirror.StepName)
+		} else {
+			if sampled {
+				output, rerr := io.ReadAll(responseBody)
+				if rerr != nil {
+					err = rerr
+				}
+				log.Info("Sampled mirror response", "node", nodeName, "stepName", mirror.StepName,
+					"statusCode", statusCode, "request", string(request), "response", string(output))
+			} else if _, rerr := io.Copy(io.Discard, responseBody); rerr != nil {
+				err = rerr
+			}
+			if cerr := responseBody.Close(); cerr != nil {
+				log.Error(cerr, "Error while trying to close the responseBody in mirrorStep")
+			}
+		}
+		endSpan(span, statusCode, err)
+		observeMirror(nodeName, mirror.StepName, statusCode, err, start)
+	}()
+}
@@ -0,0 +1,94 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSequenceWithMirror(t *testing.T) {
+	retriever := newEchoService(`{"docs":["OPEA"]}`)
+	defer retriever.Close()
+	var rerankRequest []byte
+	reranking := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rerankRequest, _ = io.ReadAll(req.Body)
+		_, _ = rw.Write([]byte(`{"docs":["OPEA"],"scores":[0.9]}`))
+	}))
+	defer reranking.Close()
+	// the candidate only answers once the user got the response
+	mirrored := make(chan []byte, 1)
+	release := make(chan struct{})
+	candidate := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		mirrored <- body
+		<-release
+		_, _ = rw.Write([]byte(`{"docs":["OPEA"],"scores":[0.7]}`))
+	}))
+	defer candidate.Close()
+
+	gmcGraph := mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			RouterConfig: mcv1alpha3.RouterConfig{
+				Config: map[string]string{mirrorMaxConcurrencyKey: "1", mirrorSampleRateKey: "1"},
+			},
+			Nodes: map[string]mcv1alpha3.Router{
+				"mirror": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps: []mcv1alpha3.Step{
+						{StepName: "Retriever", ServiceURL: retriever.URL},
+						{StepName: "Reranking", ServiceURL: candidate.URL, Data: "$response", Mirror: true},
+						{StepName: "Reranking", ServiceURL: reranking.URL, Data: "$response"},
+					},
+				},
+			},
+		},
+	}
+	completed := testutil.ToFloat64(mirrorRequests.WithLabelValues("mirror", "Reranking", "200"))
+	dropped := testutil.ToFloat64(mirrorRequests.WithLabelValues("mirror", "Reranking", statusDropped))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	res, statusCode, err := routeStep(ctx, "mirror", gmcGraph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, statusCode)
+	body, err := io.ReadAll(res)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"docs":["OPEA"],"scores":[0.9]}`, string(body))
+	// both rerankers get the response of the retriever
+	assert.Equal(t, `{"docs":["OPEA"]}`, string(rerankRequest))
+	select {
+	case request := <-mirrored:
+		assert.Equal(t, `{"docs":["OPEA"]}`, string(request))
+	case <-time.After(2 * time.Second):
+		t.Fatal("the request was not mirrored")
+	}
+	// the mirror in flight holds no call slot
+	assert.Equal(t, 0, len(callQueue.slots))
+
+	// the mirror in flight takes the only slot, the next mirror is dropped
+	res, _, err = routeStep(ctx, "mirror", gmcGraph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.NoError(t, err)
+	_, _ = io.ReadAll(res)
+	assert.Equal(t, dropped+1, testutil.ToFloat64(mirrorRequests.WithLabelValues("mirror", "Reranking", statusDropped)))
+
+	// the end of the request does not cancel the mirror
+	cancel()
+	close(release)
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(mirrorRequests.WithLabelValues("mirror", "Reranking", "200")) == completed+1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return mirrorsInFlight.Load() == 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,394 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/tidwall/gjson"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	chatCompletionsPath = "/v1/chat/completions"
+	embeddingsPath      = "/v1/embeddings"
+
+	openAIInvalidRequest = "invalid_request_error"
+	openAIAPIError       = "api_error"
+)
+
+// completionTextPaths are the paths of the generated text in the responses of the text generation services
+var completionTextPaths = []string{"choices.0.message.content", "text", "generated_text", "0.generated_text", "content"}
+
+// chatMessage is a message of a chat completion request, the content is either a string or an array of parts
+type chatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// text returns the content of the message, the text parts of an array content are joined
+func (m chatMessage) text() (string, error) {
+	var content string
+	if err := json.Unmarshal(m.Content, &content); err == nil {
+		return content, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return "", fmt.Errorf("the content of a %s message is neither a string nor an array of parts", m.Role)
+	}
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// writeOpenAIError writes an error in the format of the OpenAI API
+func writeOpenAIError(w http.ResponseWriter, statusCode int, errType string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	response, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{"message": message, "type": errType, "param": nil, "code": nil},
+	})
+	if _, err := w.Write(response); err != nil {
+		log.Error(err, "failed to write the OpenAI error response")
+	}
+}
+
+// entryStep returns the first step of the node which is not a downstream service
+func entryStep(graph *mcv1alpha3.GMConnector, nodeName string) *mcv1alpha3.Step {
+	steps := graph.Spec.Nodes[nodeName].Steps
+	for i := range steps {
+		if !steps[i].InternalService.IsDownstreamService {
+			return &steps[i]
+		}
+	}
+	return nil
+}
+
+// chatCompletionInput maps a chat completion request onto the request of the entry step of the node.
+// An Embedding entry step gets the last user message as text, the other steps get the conversation as query,
+// with the system prompt first. The messages and the parameters of the request are kept, stream is passed
+// on as streaming as well for the OPEA microservices.
+func chatCompletionInput(graph *mcv1alpha3.GMConnector, nodeName string, request map[string]json.RawMessage) ([]byte, error) {
+	var messages []chatMessage
+	if err := json.Unmarshal(request["messages"], &messages); err != nil || len(messages) == 0 {
+		return nil, errors.New("messages must be a non-empty array of messages")
+	}
+	var system, conversation []string
+	lastUserMessage := ""
+	for _, message := range messages {
+		text, err := message.text()
+		if err != nil {
+			return nil, err
+		}
+		switch message.Role {
+		case "system", "developer":
+			system = append(system, text)
+		case "user":
+			lastUserMessage = text
+			conversation = append(conversation, message.Role+": "+text)
+		default:
+			conversation = append(conversation, message.Role+": "+text)
+		}
+	}
+	if lastUserMessage == "" {
+		return nil, errors.New("messages must contain a user message")
+	}
+
+	input := make(map[string]json.RawMessage, len(request)+2)
+	for k, v := range request {
+		input[k] = v
+	}
+	if stream, ok := request["stream"]; ok {
+		input["streaming"] = stream
+	}
+	if step := entryStep(graph, nodeName); step != nil && step.StepName == mcv1alpha3.EmbeddingStep {
+		input[EmbeddingKeyword], _ = json.Marshal(lastUserMessage)
+	} else {
+		query := lastUserMessage
+		if len(system) > 0 || len(conversation) > 1 {
+			query = strings.Join(append(system, conversation...), "\n")
+		}
+		input[LLMKeyword], _ = json.Marshal(query)
+	}
+	return json.Marshal(input)
+}
+
+// completionText returns the generated text of a response which is not streamed
+func completionText(response []byte) string {
+	if gjson.ValidBytes(response) {
+		for _, path := range completionTextPaths {
+			if text := gjson.GetBytes(response, path); text.Type == gjson.String {
+				return text.String()
+			}
+		}
+	}
+	return string(response)
+}
+
+// collectCompletionText puts the generated text of the events of a streamed response together
+func collectCompletionText(body *bufio.Reader) (string, error) {
+	var text strings.Builder
+	for {
+		e, err := readSSEEvent(body)
+		if err == io.EOF {
+			return text.String(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if e.event == sseErrorEvent {
+			return "", fmt.Errorf("the response stream failed: %s", e.data)
+		}
+		if e.data == sseDone {
+			return text.String(), nil
+		}
+		if gjson.Valid(e.data) && gjson.Get(e.data, "choices").Exists() {
+			text.WriteString(gjson.Get(e.data, "choices.0.delta.content").String())
+			continue
+		}
+		text.WriteString(chunkText(e.data))
+	}
+}
+
+// startOpenAIRequest starts the span and the deadline of a request to the OpenAI facade,
+// like mcGraphHandler does for the requests to the graph
+func startOpenAIRequest(req *http.Request, graph *mcv1alpha3.GMConnector) (context.Context, trace.Span, context.CancelFunc) {
+	ctx := propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	ctx, span := startSpan(ctx, "gmc-router "+req.Method+" "+req.URL.Path, trace.WithSpanKind(trace.SpanKindServer))
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout(graph))
+	return withResponseHeaders(ctx), span, cancel
+}
+
+// writeGraphError writes the error of the execution of the graph in the format of the OpenAI API
+func writeGraphError(ctx context.Context, w http.ResponseWriter, span trace.Span, statusCode int, err error) {
+	span.SetStatus(codes.Error, err.Error())
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		log.Error(err, "request timed out")
+		writeOpenAIError(w, http.StatusGatewayTimeout, openAIAPIError, "request timed out")
+	case errors.Is(ctx.Err(), context.Canceled):
+		log.Info("The request is cancelled by the client", "error", err.Error())
+	default:
+		log.Error(err, "failed to process request")
+		if isCallQueueRejection(err) {
+			statusCode = http.StatusServiceUnavailable
+		} else if isSuccessFul(statusCode) {
+			statusCode = http.StatusInternalServerError
+		}
+		writeOpenAIError(w, statusCode, openAIAPIError, err.Error())
+	}
+}
+
+// chatCompletionsHandler serves the OpenAI chat completions API with the graph, the request is mapped
+// onto the entry step of the node of the entrypoint declared for the path, or else of the root node.
+// The response is returned as a chat completion, or as chat completion chunks when the request is streamed.
+func chatCompletionsHandler(w http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	if req.Method != http.MethodPost {
+		writeOpenAIError(w, http.StatusMethodNotAllowed, openAIInvalidRequest, "only POST is supported")
+		return
+	}
+	graph := mcGraph.Load()
+	if graph == nil {
+		writeOpenAIError(w, http.StatusServiceUnavailable, openAIAPIError, "the graph is not loaded")
+		return
+	}
+	nodeName, status := entrypointNode(graph, req.URL.Path, req.Method)
+	if status == http.StatusNotFound {
+		writeOpenAIError(w, status, openAIInvalidRequest, "no node of the graph serves the path")
+		return
+	}
+	if status != http.StatusOK {
+		writeOpenAIError(w, status, openAIInvalidRequest, "the method is not allowed for the path")
+		return
+	}
+	ctx, span, cancel := startOpenAIRequest(req, graph)
+	defer span.End()
+	defer cancel()
+
+	var request map[string]json.RawMessage
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, openAIInvalidRequest, "the request is not a JSON object")
+		return
+	}
+	input, err := chatCompletionInput(graph, nodeName, request)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, openAIInvalidRequest, err.Error())
+		return
+	}
+	stream := gjson.GetBytes(input, "stream").Bool()
+
+	responseBody, statusCode, err := routeStep(ctx, nodeName, *graph, input, input, req.Header)
+	span.SetAttributes(attrStatusCode.Int(statusCode))
+	copyResponseHeaders(ctx, w.Header())
+	if err != nil {
+		writeGraphError(ctx, w, span, statusCode, err)
+		return
+	}
+	defer func() {
+		if err := responseBody.Close(); err != nil {
+			log.Error(err, "Error while trying to close the responseBody in chatCompletionsHandler")
+		}
+	}()
+	body := bufio.NewReaderSize(responseBody, BufferSize)
+	if !isSuccessFul(statusCode) {
+		response, _ := io.ReadAll(body)
+		writeOpenAIError(w, statusCode, openAIAPIError, string(response))
+		return
+	}
+
+	rewriter := newOpenAIChunkRewriter(input)
+	eventStream := isEventStream(body)
+	if stream && eventStream {
+		streamEvents(ctx, w, body, graph, rewriter, start)
+		return
+	}
+
+	var text string
+	if eventStream {
+		text, err = collectCompletionText(body)
+	} else {
+		var response []byte
+		response, err = io.ReadAll(body)
+		if err == nil && !stream && gjson.GetBytes(response, "object").String() == "chat.completion" {
+			// the graph answers in the format of the API already
+			w.Header().Set("Content-Type", "application/json")
+			if _, err := w.Write(response); err != nil {
+				log.Error(err, "failed to write chatCompletionsHandler response")
+			}
+			return
+		}
+		text = completionText(response)
+	}
+	if err != nil {
+		log.Error(err, "failed to read from response body")
+		writeOpenAIError(w, http.StatusBadGateway, openAIAPIError, "failed to read the response of the graph")
+		return
+	}
+
+	if stream {
+		// the whole text is sent as a single chunk
+		w.Header().Set("Content-Type", eventStreamContentType)
+		w.Header().Set("Cache-Control", "no-cache")
+		events := append(rewriter.rewrite(sseEvent{data: text}), rewriter.finish()...)
+		for _, e := range events {
+			if err := writeSSEEvent(w, e); err != nil {
+				log.Error(err, "failed to write chatCompletionsHandler response")
+				return
+			}
+		}
+		return
+	}
+	response, _ := json.Marshal(map[string]interface{}{
+		"id":      rewriter.id,
+		"object":  "chat.completion",
+		"created": rewriter.created,
+		"model":   rewriter.model,
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"message":       map[string]string{"role": "assistant", "content": text},
+			"finish_reason": "stop",
+		}},
+	})
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(response); err != nil {
+		log.Error(err, "failed to write chatCompletionsHandler response")
+	}
+	timeToFirstByte.Observe(time.Since(start).Seconds())
+}
+
+// embeddingsHandler serves the OpenAI embeddings API with the Embedding step of the graph, preferably the one
+// of the node of the entrypoint declared for the path or of the root node. Each input is embedded by a call to the step.
+func embeddingsHandler(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeOpenAIError(w, http.StatusMethodNotAllowed, openAIInvalidRequest, "only POST is supported")
+		return
+	}
+	graph := mcGraph.Load()
+	if graph == nil {
+		writeOpenAIError(w, http.StatusServiceUnavailable, openAIAPIError, "the graph is not loaded")
+		return
+	}
+	nodeName, status := entrypointNode(graph, req.URL.Path, req.Method)
+	if status == http.StatusNotFound {
+		writeOpenAIError(w, status, openAIInvalidRequest, "no node of the graph serves the path")
+		return
+	}
+	if status != http.StatusOK {
+		writeOpenAIError(w, status, openAIInvalidRequest, "the method is not allowed for the path")
+		return
+	}
+	ctx, span, cancel := startOpenAIRequest(req, graph)
+	defer span.End()
+	defer cancel()
+
+	var request struct {
+		Input          json.RawMessage `json:"input"`
+		Model          string          `json:"model"`
+		EncodingFormat string          `json:"encoding_format"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, openAIInvalidRequest, "the request is not a JSON object")
+		return
+	}
+	var inputs []string
+	var input string
+	if err := json.Unmarshal(request.Input, &input); err == nil {
+		inputs = []string{input}
+	} else if err := json.Unmarshal(request.Input, &inputs); err != nil || len(inputs) == 0 {
+		writeOpenAIError(w, http.StatusBadRequest, openAIInvalidRequest, "input must be a string or a non-empty array of strings")
+		return
+	}
+	if request.EncodingFormat != "" && request.EncodingFormat != "float" {
+		writeOpenAIError(w, http.StatusBadRequest, openAIInvalidRequest, "only the float encoding format is supported")
+		return
+	}
+	embeddingStep := findEmbeddingStep(graph, nodeName)
+	if embeddingStep == nil {
+		writeOpenAIError(w, http.StatusNotFound, openAIInvalidRequest,
+			fmt.Sprintf("the graph has no %s step", mcv1alpha3.EmbeddingStep))
+		return
+	}
+
+	data := make([]map[string]interface{}, 0, len(inputs))
+	for i, text := range inputs {
+		stepInput, _ := json.Marshal(map[string]string{EmbeddingKeyword: text})
+		vector, _, err := embed(ctx, nodeName, embeddingStep, embeddingStep, *graph, stepInput, stepInput, req.Header)
+		if err != nil {
+			writeGraphError(ctx, w, span, http.StatusBadGateway, err)
+			return
+		}
+		data = append(data, map[string]interface{}{"object": "embedding", "index": i, "embedding": vector})
+	}
+	model := request.Model
+	if model == "" {
+		model = "gmc"
+	}
+	response, _ := json.Marshal(map[string]interface{}{"object": "list", "data": data, "model": model})
+	copyResponseHeaders(ctx, w.Header())
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(response); err != nil {
+		log.Error(err, "failed to write embeddingsHandler response")
+	}
+}
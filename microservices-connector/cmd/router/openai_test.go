@@ -0,0 +1,298 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestChatCompletionInput(t *testing.T) {
+	llmGraph := streamingGraph("http://llm", nil)
+	embeddingGraph := &mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps: []mcv1alpha3.Step{
+						{StepName: "TeiEmbedding", Executor: mcv1alpha3.Executor{
+							InternalService: mcv1alpha3.GMCTarget{IsDownstreamService: true},
+						}},
+						{StepName: "Embedding"},
+						{StepName: "Llm"},
+					},
+				},
+			},
+		},
+	}
+	tests := []struct {
+		name    string
+		graph   *mcv1alpha3.GMConnector
+		request string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "single message",
+			graph:   llmGraph,
+			request: `{"model":"m","messages":[{"role":"user","content":"What is OPEA?"}],"max_tokens":64}`,
+			want: `{"model":"m","messages":[{"role":"user","content":"What is OPEA?"}],"max_tokens":64,
+				"query":"What is OPEA?"}`,
+		},
+		{
+			name:  "conversation",
+			graph: llmGraph,
+			request: `{"messages":[
+				{"role":"system","content":"You are a helpful assistant."},
+				{"role":"user","content":[{"type":"text","text":"What is OPEA?"}]},
+				{"role":"assistant","content":"An open platform."},
+				{"role":"user","content":"For what?"}
+			],"stream":true}`,
+			want: `{"messages":[
+				{"role":"system","content":"You are a helpful assistant."},
+				{"role":"user","content":[{"type":"text","text":"What is OPEA?"}]},
+				{"role":"assistant","content":"An open platform."},
+				{"role":"user","content":"For what?"}
+			],"stream":true,"streaming":true,
+			"query":"You are a helpful assistant.\nuser: What is OPEA?\nassistant: An open platform.\nuser: For what?"}`,
+		},
+		{
+			name:  "embedding entry step",
+			graph: embeddingGraph,
+			request: `{"messages":[{"role":"user","content":"What is OPEA?"},{"role":"assistant","content":"An open platform."},
+				{"role":"user","content":"For what?"}]}`,
+			want: `{"messages":[{"role":"user","content":"What is OPEA?"},{"role":"assistant","content":"An open platform."},
+				{"role":"user","content":"For what?"}],"text":"For what?"}`,
+		},
+		{
+			name:    "no messages",
+			graph:   llmGraph,
+			request: `{"messages":[]}`,
+			wantErr: true,
+		},
+		{
+			name:    "no user message",
+			graph:   llmGraph,
+			request: `{"messages":[{"role":"system","content":"You are a helpful assistant."}]}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid content",
+			graph:   llmGraph,
+			request: `{"messages":[{"role":"user","content":1}]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request map[string]json.RawMessage
+			assert.NoError(t, json.Unmarshal([]byte(tt.request), &request))
+			input, err := chatCompletionInput(tt.graph, "root", request)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(input))
+		})
+	}
+}
+
+func TestChatCompletions(t *testing.T) {
+	var llmRequest []byte
+	llm := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		llmRequest, _ = io.ReadAll(req.Body)
+		_, _ = rw.Write([]byte(`{"id":"1","text":"OPEA is an open platform.","prompt":"What is OPEA?"}`))
+	}))
+	defer llm.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(streamingGraph(llm.URL, nil))
+
+	req := httptest.NewRequest(http.MethodPost, chatCompletionsPath,
+		strings.NewReader(`{"model":"neural-chat","messages":[{"role":"user","content":"What is OPEA?"}]}`))
+	rr := httptest.NewRecorder()
+	chatCompletionsHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "What is OPEA?", gjson.GetBytes(llmRequest, "query").String())
+	// no default parameters are added
+	assert.False(t, gjson.GetBytes(llmRequest, "max_tokens").Exists())
+
+	response := rr.Body.String()
+	assert.Equal(t, "chat.completion", gjson.Get(response, "object").String())
+	assert.Equal(t, "neural-chat", gjson.Get(response, "model").String())
+	assert.True(t, strings.HasPrefix(gjson.Get(response, "id").String(), "chatcmpl-"))
+	assert.Equal(t, "assistant", gjson.Get(response, "choices.0.message.role").String())
+	assert.Equal(t, "OPEA is an open platform.", gjson.Get(response, "choices.0.message.content").String())
+	assert.Equal(t, "stop", gjson.Get(response, "choices.0.finish_reason").String())
+
+	// the whole response is sent as a single chunk to a streamed request
+	req = httptest.NewRequest(http.MethodPost, chatCompletionsPath,
+		strings.NewReader(`{"messages":[{"role":"user","content":"What is OPEA?"}],"stream":true}`))
+	rr = httptest.NewRecorder()
+	chatCompletionsHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, eventStreamContentType, rr.Header().Get("Content-Type"))
+	events := readEvents(t, rr.Body.String())
+	assert.Len(t, events, 3)
+	assert.Equal(t, "OPEA is an open platform.", gjson.Get(events[0], "choices.0.delta.content").String())
+	assert.Equal(t, "stop", gjson.Get(events[1], "choices.0.finish_reason").String())
+	assert.Equal(t, sseDone, events[2])
+}
+
+func TestChatCompletionsStreamedResponse(t *testing.T) {
+	llm := newStreamingService([]string{"data: b' OPEA'\n\n", "data: b' is'\n\n", "data: [DONE]\n\n"}, 0, false)
+	defer llm.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(streamingGraph(llm.URL, nil))
+
+	req := httptest.NewRequest(http.MethodPost, chatCompletionsPath,
+		strings.NewReader(`{"messages":[{"role":"user","content":"What is OPEA?"}],"stream":true}`))
+	rr := httptest.NewRecorder()
+	chatCompletionsHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	events := readEvents(t, rr.Body.String())
+	assert.Len(t, events, 4)
+	assert.Equal(t, " OPEA", gjson.Get(events[0], "choices.0.delta.content").String())
+	assert.Equal(t, " is", gjson.Get(events[1], "choices.0.delta.content").String())
+	assert.Equal(t, sseDone, events[3])
+
+	// the events are put together for a request which is not streamed
+	req = httptest.NewRequest(http.MethodPost, chatCompletionsPath,
+		strings.NewReader(`{"messages":[{"role":"user","content":"What is OPEA?"}]}`))
+	rr = httptest.NewRecorder()
+	chatCompletionsHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, " OPEA is", gjson.Get(rr.Body.String(), "choices.0.message.content").String())
+}
+
+func TestChatCompletionsErrors(t *testing.T) {
+	llm := newStatusService(http.StatusServiceUnavailable, `model is loading`)
+	defer llm.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(streamingGraph(llm.URL, nil))
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		statusCode int
+		message    string
+	}{
+		{name: "method", method: http.MethodGet, statusCode: http.StatusMethodNotAllowed},
+		{name: "not JSON", method: http.MethodPost, body: `messages`, statusCode: http.StatusBadRequest},
+		{name: "no messages", method: http.MethodPost, body: `{}`, statusCode: http.StatusBadRequest},
+		{
+			name:       "service error",
+			method:     http.MethodPost,
+			body:       `{"messages":[{"role":"user","content":"What is OPEA?"}]}`,
+			statusCode: http.StatusServiceUnavailable,
+			message:    "model is loading",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, chatCompletionsPath, strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			chatCompletionsHandler(rr, req)
+			assert.Equal(t, tt.statusCode, rr.Code)
+			assert.True(t, gjson.Get(rr.Body.String(), "error.type").Exists())
+			if tt.message != "" {
+				assert.Equal(t, tt.message, gjson.Get(rr.Body.String(), "error.message").String())
+			}
+		})
+	}
+}
+
+func TestEmbeddings(t *testing.T) {
+	embedding := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		var request struct {
+			Text string `json:"text"`
+		}
+		_ = json.NewDecoder(req.Body).Decode(&request)
+		_ = json.NewEncoder(rw).Encode(map[string]interface{}{"text": request.Text, "embedding": []float32{float32(len(request.Text)), 1}})
+	}))
+	defer embedding.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(&mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps: []mcv1alpha3.Step{
+						{StepName: "Embedding", ServiceURL: embedding.URL},
+						{StepName: "Llm", ServiceURL: "http://llm"},
+					},
+				},
+			},
+		},
+	})
+
+	req := httptest.NewRequest(http.MethodPost, embeddingsPath, strings.NewReader(`{"model":"bge","input":["OPEA","GMC router"]}`))
+	rr := httptest.NewRecorder()
+	embeddingsHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"object":"list","model":"bge","data":[
+		{"object":"embedding","index":0,"embedding":[4,1]},
+		{"object":"embedding","index":1,"embedding":[10,1]}
+	]}`, rr.Body.String())
+
+	req = httptest.NewRequest(http.MethodPost, embeddingsPath, strings.NewReader(`{"input":"OPEA"}`))
+	rr = httptest.NewRecorder()
+	embeddingsHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 1, len(gjson.Get(rr.Body.String(), "data").Array()))
+
+	req = httptest.NewRequest(http.MethodPost, embeddingsPath, strings.NewReader(`{"input":[]}`))
+	rr = httptest.NewRecorder()
+	embeddingsHandler(rr, req)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// a graph without an Embedding step cannot serve embeddings
+	mcGraph.Store(streamingGraph("http://llm", nil))
+	req = httptest.NewRequest(http.MethodPost, embeddingsPath, strings.NewReader(`{"input":"OPEA"}`))
+	rr = httptest.NewRecorder()
+	embeddingsHandler(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// the entrypoints of the graph decide which requests are served
+	mcGraph.Store(&mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Entrypoints: []mcv1alpha3.Entrypoint{{Name: "embeddings", Path: embeddingsPath, Method: http.MethodPut, NodeName: "embed"}},
+			Nodes: map[string]mcv1alpha3.Router{
+				"embed": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps:      []mcv1alpha3.Step{{StepName: "Embedding", ServiceURL: embedding.URL}},
+				},
+			},
+		},
+	})
+	req = httptest.NewRequest(http.MethodPost, embeddingsPath, strings.NewReader(`{"input":"OPEA"}`))
+	rr = httptest.NewRecorder()
+	embeddingsHandler(rr, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	req = httptest.NewRequest(http.MethodPost, "/v2/embeddings", strings.NewReader(`{"input":"OPEA"}`))
+	rr = httptest.NewRecorder()
+	embeddingsHandler(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "no node of the graph serves the path", gjson.Get(rr.Body.String(), "error.message").String())
+}
@@ -0,0 +1,58 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// responseHeaders collects the headers the nodes add to the response of the router,
+// the steps of Ensemble and DAG nodes add them concurrently
+type responseHeaders struct {
+	mu     sync.Mutex
+	header http.Header
+}
+
+type responseHeadersKey struct{}
+
+func withResponseHeaders(ctx context.Context) context.Context {
+	return context.WithValue(ctx, responseHeadersKey{}, &responseHeaders{header: http.Header{}})
+}
+
+// addResponseHeader adds a header to the response of the router, if the context collects them
+func addResponseHeader(ctx context.Context, key, value string) {
+	if rh, ok := ctx.Value(responseHeadersKey{}).(*responseHeaders); ok {
+		rh.mu.Lock()
+		rh.header.Add(key, value)
+		rh.mu.Unlock()
+	}
+}
+
+// setResponseHeader replaces the values of a header of the response of the router, if the context collects them
+func setResponseHeader(ctx context.Context, key, value string) {
+	if rh, ok := ctx.Value(responseHeadersKey{}).(*responseHeaders); ok {
+		rh.mu.Lock()
+		rh.header.Set(key, value)
+		rh.mu.Unlock()
+	}
+}
+
+// copyResponseHeaders copies the headers added for the request to the header of the response writer
+func copyResponseHeaders(ctx context.Context, header http.Header) {
+	rh, ok := ctx.Value(responseHeadersKey{}).(*responseHeaders)
+	if !ok {
+		return
+	}
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	for key, values := range rh.header {
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/opea-project/GenAIInfra/microservices-connector/internal/cache"
+	"github.com/tidwall/gjson"
+)
+
+const defaultSemanticCacheThreshold = 0.95
+
+// embeddingPaths are the paths of the vector in the response of the Embedding step,
+// for the OPEA embedding microservice and for the OpenAI embeddings API
+var embeddingPaths = []string{"embedding", "data.0.embedding"}
+
+// newVectorStore creates the store of a semantic cache, a shared vector database can be plugged in here
+var newVectorStore = func(maxEntries int) cache.VectorStore {
+	return cache.NewMemoryVectorStore(maxEntries)
+}
+
+// semanticCache is the store of the responses of a node by the embedding of their request
+type semanticCache struct {
+	maxEntries int
+	store      cache.VectorStore
+}
+
+// semanticCaches holds the semantic caches by node, they outlive the graph reloads
+var semanticCaches sync.Map
+
+func semanticCacheFor(target string, spec *mcv1alpha3.SemanticCache) cache.VectorStore {
+	maxEntries := int(spec.MaxEntries)
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheMaxEntries
+	}
+	if cached, ok := semanticCaches.Load(target); ok && cached.(*semanticCache).maxEntries == maxEntries {
+		return cached.(*semanticCache).store
+	}
+	// the size changed with a graph reload, start over with an empty cache
+	sc := &semanticCache{maxEntries: maxEntries, store: newVectorStore(maxEntries)}
+	semanticCaches.Store(target, sc)
+	return sc.store
+}
+
+func semanticCacheThreshold(spec *mcv1alpha3.SemanticCache) float64 {
+	if spec.Threshold == "" {
+		return defaultSemanticCacheThreshold
+	}
+	threshold, err := strconv.ParseFloat(spec.Threshold, 64)
+	if err != nil {
+		log.Error(err, "Invalid semantic cache threshold, use the default", "threshold", spec.Threshold)
+		return defaultSemanticCacheThreshold
+	}
+	return threshold
+}
+
+// semanticCacheMiss is a semantic cache lookup without a similar request,
+// the response of the node to the request is stored under its embedding
+type semanticCacheMiss struct {
+	stepName  string
+	store     cache.VectorStore
+	namespace string
+	vector    []float32
+	ttl       time.Duration
+	// the response of the Embedding step to the input of the lookup, until a step of the sequence reuses it
+	embeddingStep *mcv1alpha3.Step
+	input         []byte
+	embedding     []byte
+}
+
+// reuseEmbedding returns the response of the lookup when the step is the Embedding step called with
+// the same input, so the sequence does not embed the request a second time
+func (m *semanticCacheMiss) reuseEmbedding(step *mcv1alpha3.Step, input []byte) []byte {
+	if m == nil || m.embedding == nil || step != m.embeddingStep || !bytes.Equal(input, m.input) {
+		return nil
+	}
+	embedding := m.embedding
+	m.embedding = nil
+	return embedding
+}
+
+// findEmbeddingStep returns the Embedding step of the node, or else of the other nodes by name
+func findEmbeddingStep(graph *mcv1alpha3.GMConnector, nodeName string) *mcv1alpha3.Step {
+	nodeNames := []string{nodeName}
+	for name := range graph.Spec.Nodes {
+		if name != nodeName {
+			nodeNames = append(nodeNames, name)
+		}
+	}
+	sort.Strings(nodeNames[1:])
+	for _, name := range nodeNames {
+		steps := graph.Spec.Nodes[name].Steps
+		for i := range steps {
+			if steps[i].StepName == mcv1alpha3.EmbeddingStep {
+				return &steps[i]
+			}
+		}
+	}
+	return nil
+}
+
+// lookupSemanticCache embeds the request of a SemanticCache step with the Embedding step of the graph
+// and returns the cached response of the node to the most similar earlier request within the threshold.
+// On a miss the response of the node is cached by the returned semanticCacheMiss, the embedding
+// is recorded as the response of the step, so the next steps can refer to it with $steps, and
+// the Embedding step following in the sequence gets it instead of calling its service again.
+func lookupSemanticCache(ctx context.Context,
+	nodeName string,
+	step *mcv1alpha3.Step,
+	graph mcv1alpha3.GMConnector,
+	initInput []byte,
+	input []byte,
+	headers http.Header,
+) (io.ReadCloser, *semanticCacheMiss, error) {
+	embeddingStep := findEmbeddingStep(&graph, nodeName)
+	if embeddingStep == nil {
+		return nil, nil, fmt.Errorf("the graph has no %s step for the %s step", mcv1alpha3.EmbeddingStep, step.StepName)
+	}
+	spec := step.SemanticCache
+	if spec == nil {
+		spec = &mcv1alpha3.SemanticCache{}
+	}
+
+	vector, response, err := embed(ctx, nodeName, step, embeddingStep, graph, initInput, input, headers)
+	if err != nil {
+		return nil, nil, err
+	}
+	recordStepOutput(ctx, step.StepName, response)
+
+	target := graph.Namespace + "/" + graph.Name + "/" + nodeName
+	store := semanticCacheFor(target, spec)
+	namespace := semanticCacheNamespace(ctx, target, &graph, nodeName, headers)
+	cached, similarity, ok, err := store.Search(ctx, namespace, vector, semanticCacheThreshold(spec))
+	if err != nil {
+		log.Error(err, "Failed to search the semantic cache, execute the node", "stepName", step.StepName)
+	}
+	if ok {
+		log.Info("Use the cached response of a similar request", "stepName", step.StepName, "similarity", similarity)
+		observeCache(ctx, step.StepName, cacheHit)
+		return NewReadCloser(cached), nil, nil
+	}
+	observeCache(ctx, step.StepName, cacheMiss)
+	return nil, &semanticCacheMiss{
+		stepName:  step.StepName,
+		store:     store,
+		namespace: namespace,
+		vector:    vector,
+		ttl:       cacheTTL(&mcv1alpha3.ResponseCache{TTL: spec.TTL}),
+
+		embeddingStep: embeddingStep,
+		input:         input,
+		embedding:     response,
+	}, nil
+}
+
+// semanticCacheNamespace separates the cached responses of a node by the headers its steps forward,
+// like the response cache of a step, so the answers are only served to the callers sending the same headers
+func semanticCacheNamespace(ctx context.Context, target string, graph *mcv1alpha3.GMConnector, nodeName string, headers http.Header) string {
+	forwarding := &mcv1alpha3.Step{Headers: &mcv1alpha3.HeaderPolicy{}}
+	for _, step := range graph.Spec.Nodes[nodeName].Steps {
+		if step.Headers != nil {
+			forwarding.Headers.Forward = append(forwarding.Headers.Forward, step.Headers.Forward...)
+		}
+	}
+	return cacheNamespace(ctx, target, forwarding, headers)
+}
+
+// embed calls the Embedding step with the input and returns the vector and the response of the step,
+// the call is observed as the given step of the node
+func embed(ctx context.Context,
+	nodeName string,
+	step *mcv1alpha3.Step,
+	embeddingStep *mcv1alpha3.Step,
+	graph mcv1alpha3.GMConnector,
+	initInput []byte,
+	input []byte,
+	headers http.Header,
+) ([]float32, []byte, error) {
+	stepStart := time.Now()
+	stepCtx, span := startStepSpan(ctx, nodeName, step, input)
+	responseBody, statusCode, err := executeStep(stepCtx, embeddingStep, graph, initInput, input, headers)
+	var response []byte
+	if err == nil {
+		response, err = io.ReadAll(responseBody)
+		if cerr := responseBody.Close(); cerr != nil {
+			log.Error(cerr, "Error while trying to close the responseBody in embed")
+		}
+	}
+	endSpan(span, statusCode, err)
+	observeStep(nodeName, step.StepName, statusCode, err, stepStart)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !isSuccessFul(statusCode) {
+		return nil, nil, fmt.Errorf("the %s step returned status code %d", mcv1alpha3.EmbeddingStep, statusCode)
+	}
+	vector, err := parseEmbedding(response)
+	if err != nil {
+		return nil, nil, err
+	}
+	return vector, response, nil
+}
+
+// cacheResponse stores the response of the node once it is completely read, it is still streamed to the caller
+func (m *semanticCacheMiss) cacheResponse(ctx context.Context, responseBody io.ReadCloser) io.ReadCloser {
+	storeCtx := context.WithoutCancel(ctx)
+	return &cachingReadCloser{
+		ReadCloser: responseBody,
+		store: func(response []byte) {
+			if err := m.store.Add(storeCtx, m.namespace, m.vector, response, m.ttl); err != nil {
+				log.Error(err, "Failed to cache the response", "stepName", m.stepName)
+			}
+		},
+	}
+}
+
+// parseEmbedding reads the vector of the response of the Embedding step
+func parseEmbedding(response []byte) ([]float32, error) {
+	if !gjson.ValidBytes(response) {
+		return nil, fmt.Errorf("invalid response of the %s step", mcv1alpha3.EmbeddingStep)
+	}
+	for _, path := range embeddingPaths {
+		result := gjson.GetBytes(response, path)
+		if !result.IsArray() {
+			continue
+		}
+		values := result.Array()
+		vector := make([]float32, len(values))
+		for i, value := range values {
+			if value.Type != gjson.Number {
+				return nil, fmt.Errorf("the embedding of the %s step is not a vector of numbers", mcv1alpha3.EmbeddingStep)
+			}
+			vector[i] = float32(value.Float())
+		}
+		return vector, nil
+	}
+	return nil, fmt.Errorf("the response of the %s step has no embedding", mcv1alpha3.EmbeddingStep)
+}
@@ -0,0 +1,171 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSemanticCache(t *testing.T) {
+	vectors := map[string][]float32{
+		"What is OPEA?":      {1, 0, 0.1},
+		"What's OPEA?":       {0.98, 0.05, 0.12},
+		"How to deploy GMC?": {0.1, 1, 0},
+	}
+	var embeddingCalls atomic.Int32
+	embedding := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		embeddingCalls.Add(1)
+		var request struct {
+			Text string `json:"text"`
+		}
+		_ = json.NewDecoder(req.Body).Decode(&request)
+		_ = json.NewEncoder(rw).Encode(map[string]interface{}{"text": request.Text, "embedding": vectors[request.Text]})
+	}))
+	defer embedding.Close()
+	var llmCalls atomic.Int32
+	llm := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		llmCalls.Add(1)
+		_, _ = rw.Write([]byte(`{"answer":` + string(body) + `}`))
+	}))
+	defer llm.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(&mcv1alpha3.GMConnector{
+		ObjectMeta: metav1.ObjectMeta{Name: "semantic-cache", Namespace: "test"},
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps: []mcv1alpha3.Step{
+						{
+							StepName:      "SemanticCache",
+							Data:          `{"text":"$request.query"}`,
+							SemanticCache: &mcv1alpha3.SemanticCache{Threshold: "0.99"},
+						},
+						{StepName: "Embedding", ServiceURL: embedding.URL, Data: `{"text":"$request.query"}`},
+						{StepName: "Llm", ServiceURL: llm.URL, Data: `{"query":"$request.query"}`},
+					},
+				},
+			},
+		},
+	})
+
+	tests := []struct {
+		query     string
+		want      string
+		wantCache string
+		llmCalls  int32
+	}{
+		{query: "What is OPEA?", want: `{"answer":{"query":"What is OPEA?"}}`, wantCache: "SemanticCache=miss", llmCalls: 1},
+		// a similar query gets the answer of the first one without calling the Llm
+		{query: "What's OPEA?", want: `{"answer":{"query":"What is OPEA?"}}`, wantCache: "SemanticCache=hit", llmCalls: 1},
+		{query: "How to deploy GMC?", want: `{"answer":{"query":"How to deploy GMC?"}}`, wantCache: "SemanticCache=miss", llmCalls: 2},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query":"`+tt.query+`"}`))
+		rr := httptest.NewRecorder()
+		mcGraphHandler(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, tt.want, rr.Body.String())
+		assert.Equal(t, tt.wantCache, rr.Header().Get(cacheHeader))
+		assert.Equal(t, tt.llmCalls, llmCalls.Load())
+	}
+	// the Embedding step of the sequence reuses the embedding of the lookup
+	assert.Equal(t, int32(3), embeddingCalls.Load())
+}
+
+func TestSemanticCacheTenants(t *testing.T) {
+	embedding := newEchoService(`{"embedding":[1,0,0]}`)
+	defer embedding.Close()
+	var llmCalls atomic.Int32
+	llm := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.ReadAll(req.Body)
+		llmCalls.Add(1)
+		_, _ = rw.Write([]byte(`{"answer":"for ` + req.Header.Get("X-Tenant") + `"}`))
+	}))
+	defer llm.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(&mcv1alpha3.GMConnector{
+		ObjectMeta: metav1.ObjectMeta{Name: "semantic-cache-tenants", Namespace: "test"},
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps: []mcv1alpha3.Step{
+						{StepName: "SemanticCache", Data: `{"text":"$request.query"}`},
+						{StepName: "Embedding", ServiceURL: embedding.URL, Data: `{"text":"$request.query"}`},
+						{
+							StepName:   "Llm",
+							ServiceURL: llm.URL,
+							Data:       `{"query":"$request.query"}`,
+							Headers:    &mcv1alpha3.HeaderPolicy{Forward: []string{"X-Tenant"}},
+						},
+					},
+				},
+			},
+		},
+	})
+
+	// the answers of a tenant are not served to the other tenants
+	for _, tt := range []struct {
+		tenant    string
+		wantCache string
+		llmCalls  int32
+	}{
+		{tenant: "a", wantCache: "SemanticCache=miss", llmCalls: 1},
+		{tenant: "b", wantCache: "SemanticCache=miss", llmCalls: 2},
+		{tenant: "a", wantCache: "SemanticCache=hit", llmCalls: 2},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"query":"What is OPEA?"}`))
+		req.Header.Set("X-Tenant", tt.tenant)
+		rr := httptest.NewRecorder()
+		mcGraphHandler(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, `{"answer":"for `+tt.tenant+`"}`, rr.Body.String())
+		assert.Equal(t, tt.wantCache, rr.Header().Get(cacheHeader))
+		assert.Equal(t, tt.llmCalls, llmCalls.Load())
+	}
+}
+
+func TestParseEmbedding(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     []float32
+		wantErr  bool
+	}{
+		{name: "OPEA embedding", response: `{"text":"OPEA","embedding":[0.5,-1]}`, want: []float32{0.5, -1}},
+		{name: "OpenAI embeddings", response: `{"data":[{"embedding":[1,2,3],"index":0}]}`, want: []float32{1, 2, 3}},
+		{name: "no embedding", response: `{"text":"OPEA"}`, wantErr: true},
+		{name: "not numbers", response: `{"embedding":["a"]}`, wantErr: true},
+		{name: "not JSON", response: `embedding`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vector, err := parseEmbedding([]byte(tt.response))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, vector)
+		})
+	}
+}
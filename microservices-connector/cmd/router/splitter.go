@@ -0,0 +1,125 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/tidwall/gjson"
+)
+
+// variantHeader names the steps the Splitter nodes picked for the request, one value per node
+const variantHeader = "X-Gmc-Variant"
+
+// handleSplitterPipeline sends the request to one of the steps picked by their weights
+func handleSplitterPipeline(ctx context.Context,
+	nodeName string,
+	graph mcv1alpha3.GMConnector,
+	initInput []byte,
+	input []byte,
+	headers http.Header,
+) (io.ReadCloser, int, error) {
+	currentNode := graph.Spec.Nodes[nodeName]
+	index, err := pickSplitterStep(&currentNode, initInput, headers)
+	if err != nil {
+		return nil, 500, fmt.Errorf("failed to pick a step of node %s: %v", nodeName, err)
+	}
+	step := &currentNode.Steps[index]
+	variant := variantName(step)
+	log.Info("Picked the step of the splitter", "node", nodeName, "stepName", step.StepName, "variant", variant)
+	addResponseHeader(ctx, variantHeader, variant)
+
+	request := input
+	if isDataTemplate(step.Data) {
+		if request, err = renderStepData(ctx, step, initInput, nil); err != nil {
+			return nil, 500, err
+		}
+	}
+	stepStart := time.Now()
+	stepCtx, span := startStepSpan(ctx, nodeName, step, request)
+	responseBody, statusCode, err := executeStep(stepCtx, step, graph, initInput, request, headers)
+	endSpan(span, statusCode, err)
+	observeStep(nodeName, step.StepName, statusCode, err, stepStart)
+	if err != nil {
+		return nil, 500, err
+	}
+	return responseBody, statusCode, nil
+}
+
+// variantName identifies the step picked by a Splitter node, the steps usually share the step name
+// and differ by their service
+func variantName(step *mcv1alpha3.Step) string {
+	switch {
+	case step.InternalService.ServiceName != "":
+		return step.InternalService.ServiceName
+	case step.NodeName != "":
+		return step.NodeName
+	default:
+		return step.StepName
+	}
+}
+
+func splitterWeight(step *mcv1alpha3.Step) int64 {
+	if step.InternalService.IsDownstreamService || step.Weight < 0 {
+		return 0
+	}
+	return int64(step.Weight)
+}
+
+// totalSplitterWeight sums the weights of the steps a Splitter node can pick
+func totalSplitterWeight(node *mcv1alpha3.Router) int64 {
+	var total int64
+	for i := range node.Steps {
+		total += splitterWeight(&node.Steps[i])
+	}
+	return total
+}
+
+// pickSplitterStep picks the index of a step with a probability proportional to its weight.
+// The requests with the same sticky key get the same step as long as the weights do not change.
+func pickSplitterStep(node *mcv1alpha3.Router, initInput []byte, headers http.Header) (int, error) {
+	total := totalSplitterWeight(node)
+	if total <= 0 {
+		return -1, fmt.Errorf("no step has a positive weight")
+	}
+	var point int64
+	if key := stickyKey(node.Sticky, initInput, headers); key != "" {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		point = int64(h.Sum64() % uint64(total))
+	} else {
+		point = rand.Int63n(total)
+	}
+	for i := range node.Steps {
+		weight := splitterWeight(&node.Steps[i])
+		if point < weight {
+			return i, nil
+		}
+		point -= weight
+	}
+	return -1, fmt.Errorf("no step has a positive weight")
+}
+
+// stickyKey reads the key of the sticky assignment, it is empty when the request has none
+func stickyKey(sticky *mcv1alpha3.Sticky, initInput []byte, headers http.Header) string {
+	switch {
+	case sticky == nil:
+		return ""
+	case sticky.Header != "":
+		return headers.Get(sticky.Header)
+	case sticky.Field != "":
+		return gjson.GetBytes(initInput, sticky.Field).String()
+	default:
+		return ""
+	}
+}
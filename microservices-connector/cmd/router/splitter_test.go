@@ -0,0 +1,111 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPickSplitterStep(t *testing.T) {
+	node := &mcv1alpha3.Router{
+		RouterType: mcv1alpha3.Splitter,
+		Steps: []mcv1alpha3.Step{
+			{StepName: "Llm", Weight: 0},
+			{StepName: "Llm", Weight: 50, Executor: mcv1alpha3.Executor{
+				InternalService: mcv1alpha3.GMCTarget{IsDownstreamService: true},
+			}},
+			{StepName: "Llm", Weight: 50},
+			{StepName: "Llm", Weight: 50},
+		},
+	}
+	// without a key, only the steps with a weight which are not downstream services are picked
+	picked := map[int]int{}
+	for i := 0; i < 200; i++ {
+		index, err := pickSplitterStep(node, []byte(`{}`), http.Header{})
+		assert.NoError(t, err)
+		picked[index]++
+	}
+	assert.Equal(t, 0, picked[0])
+	assert.Equal(t, 0, picked[1])
+	assert.Greater(t, picked[2], 0)
+	assert.Greater(t, picked[3], 0)
+
+	// a sticky key always gets the same step, the keys are spread over the steps
+	node.Sticky = &mcv1alpha3.Sticky{Field: "user"}
+	picked = map[int]int{}
+	for user := 0; user < 50; user++ {
+		input := []byte(fmt.Sprintf(`{"user":"user-%d"}`, user))
+		first, err := pickSplitterStep(node, input, http.Header{})
+		assert.NoError(t, err)
+		for i := 0; i < 5; i++ {
+			index, _ := pickSplitterStep(node, input, http.Header{})
+			assert.Equal(t, first, index)
+		}
+		picked[first]++
+	}
+	assert.Greater(t, picked[2], 0)
+	assert.Greater(t, picked[3], 0)
+
+	node.Steps[2].Weight, node.Steps[3].Weight = 0, 0
+	_, err := pickSplitterStep(node, []byte(`{}`), http.Header{})
+	assert.Error(t, err)
+}
+
+func TestSplitterVariantHeader(t *testing.T) {
+	stable := newEchoService(`{"text":"stable"}`)
+	defer stable.Close()
+	canary := newEchoService(`{"text":"canary"}`)
+	defer canary.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(&mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Splitter,
+					Sticky:     &mcv1alpha3.Sticky{Header: "X-User-Id"},
+					Steps: []mcv1alpha3.Step{
+						{
+							StepName:   "Llm",
+							ServiceURL: stable.URL,
+							Weight:     90,
+							Executor:   mcv1alpha3.Executor{InternalService: mcv1alpha3.GMCTarget{ServiceName: "llm-stable"}},
+						},
+						{
+							StepName:   "Llm",
+							ServiceURL: canary.URL,
+							Weight:     10,
+							Executor:   mcv1alpha3.Executor{InternalService: mcv1alpha3.GMCTarget{ServiceName: "llm-canary"}},
+						},
+					},
+				},
+			},
+		},
+	})
+
+	variants := map[string]int{}
+	for user := 0; user < 100; user++ {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+		req.Header.Set("X-User-Id", fmt.Sprintf("user-%d", user))
+		rr := httptest.NewRecorder()
+		mcGraphHandler(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+		variant := rr.Header().Get(variantHeader)
+		// the header names the variant which served the request
+		assert.Equal(t, `{"text":"`+strings.TrimPrefix(variant, "llm-")+`"}`, rr.Body.String())
+		variants[variant]++
+	}
+	assert.Greater(t, variants["llm-stable"], variants["llm-canary"])
+	assert.Greater(t, variants["llm-canary"], 0)
+}
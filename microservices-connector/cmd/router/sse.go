@@ -0,0 +1,366 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// streamFormatKey set to "openai" rewrites the data of the events of a streamed response
+	// to the chunks of the OpenAI chat completions API
+	streamFormatKey    = "streamFormat"
+	streamFormatOpenAI = "openai"
+	// sseHeartbeatIntervalKey is the interval of the comments sent while a stream has no event,
+	// "0s" turns them off
+	sseHeartbeatIntervalKey     = "sseHeartbeatInterval"
+	defaultSSEHeartbeatInterval = 15 * time.Second
+
+	eventStreamContentType = "text/event-stream"
+	sseDone                = "[DONE]"
+	sseErrorEvent          = "error"
+)
+
+// sseEvent is an event of a text/event-stream response
+type sseEvent struct {
+	event string
+	id    string
+	data  string
+}
+
+func streamFormat(graph *mcv1alpha3.GMConnector) string {
+	return graph.Spec.RouterConfig.Config[streamFormatKey]
+}
+
+func sseHeartbeatInterval(graph *mcv1alpha3.GMConnector) time.Duration {
+	if value, ok := graph.Spec.RouterConfig.Config[sseHeartbeatIntervalKey]; ok {
+		interval, err := time.ParseDuration(value)
+		if err == nil && interval >= 0 {
+			return interval
+		}
+		log.Info("Invalid SSE heartbeat interval in router config, use the default one", "interval", value)
+	}
+	return defaultSSEHeartbeatInterval
+}
+
+// isEventStream tells if a response starts with a field of the text/event-stream format,
+// the content type of the responses is not passed on by the steps
+func isEventStream(body *bufio.Reader) bool {
+	prefix, _ := body.Peek(len("data:"))
+	for _, field := range []string{"data:", "event", "id:", "retry", ":"} {
+		if bytes.HasPrefix(prefix, []byte(field)) {
+			return true
+		}
+	}
+	return false
+}
+
+// readSSEEvent reads the next event of the stream, the comments and the events without data are skipped.
+// An event cut off by the end of the stream is still returned, as some services do not end their last event.
+func readSSEEvent(body *bufio.Reader) (sseEvent, error) {
+	var e sseEvent
+	var data []string
+	hasData := false
+	for {
+		line, err := body.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			if err == io.EOF && hasData {
+				e.data = strings.Join(data, "\n")
+				return e, nil
+			}
+			return sseEvent{}, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == "" {
+			if hasData {
+				e.data = strings.Join(data, "\n")
+				return e, nil
+			}
+			e = sseEvent{}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		name, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch name {
+		case "data":
+			data = append(data, value)
+			hasData = true
+		case "event":
+			e.event = value
+		case "id":
+			e.id = value
+		}
+	}
+}
+
+func writeSSEEvent(w io.Writer, e sseEvent) error {
+	var buf strings.Builder
+	if e.event != "" {
+		buf.WriteString("event: " + e.event + "\n")
+	}
+	if e.id != "" {
+		buf.WriteString("id: " + e.id + "\n")
+	}
+	for _, line := range strings.Split(e.data, "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteString("\n")
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+// sseErrorData is the data of the error event ending a stream which failed midway
+func sseErrorData(message string) string {
+	data, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]string{"message": message, "type": "stream_error"},
+	})
+	return string(data)
+}
+
+// streamRewriter returns the rewriter of the stream format of the router config, nil to pass on the events
+func streamRewriter(graph *mcv1alpha3.GMConnector, input []byte) *openAIChunkRewriter {
+	switch format := streamFormat(graph); format {
+	case "":
+		return nil
+	case streamFormatOpenAI:
+		return newOpenAIChunkRewriter(input)
+	default:
+		log.Info("Unknown stream format in router config, the events are passed on", "format", format)
+		return nil
+	}
+}
+
+// streamEvents forwards the events of a text/event-stream response one by one, rewriting their data
+// when there is a rewriter. Heartbeat comments keep the connection alive while the service
+// is silent, a failure of the stream is sent as an error event.
+func streamEvents(ctx context.Context,
+	w http.ResponseWriter,
+	body *bufio.Reader,
+	graph *mcv1alpha3.GMConnector,
+	rewriter *openAIChunkRewriter,
+	start time.Time,
+) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		log.Error(errors.New("unable to flush data"), "ResponseWriter does not support flushing")
+		return
+	}
+	w.Header().Set("Content-Type", eventStreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	type readResult struct {
+		event sseEvent
+		err   error
+	}
+	results := make(chan readResult)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			e, err := readSSEEvent(body)
+			select {
+			case results <- readResult{event: e, err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var heartbeat <-chan time.Time
+	if interval := sseHeartbeatInterval(graph); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	firstByte := true
+	for {
+		var events []sseEvent
+		select {
+		case result := <-results:
+			switch {
+			case result.err == io.EOF:
+				if rewriter != nil {
+					events = rewriter.finish()
+				}
+			case result.err != nil:
+				log.Error(result.err, "failed to read from the event stream")
+				events = []sseEvent{{event: sseErrorEvent, data: sseErrorData("failed to read the response stream")}}
+			case rewriter != nil && result.event.event == "":
+				events = rewriter.rewrite(result.event)
+			default:
+				events = []sseEvent{result.event}
+			}
+		case <-heartbeat:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				log.Error(err, "failed to write to ResponseWriter")
+				return
+			}
+			flusher.Flush()
+			continue
+		case <-ctx.Done():
+			message := "the request is cancelled"
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				message = "request timed out"
+			}
+			log.Info("The event stream is interrupted", "reason", message)
+			if err := writeSSEEvent(w, sseEvent{event: sseErrorEvent, data: sseErrorData(message)}); err == nil {
+				flusher.Flush()
+			}
+			return
+		}
+
+		for _, e := range events {
+			if err := writeSSEEvent(w, e); err != nil {
+				log.Error(err, "failed to write to ResponseWriter")
+				return
+			}
+		}
+		flusher.Flush()
+		if firstByte && len(events) > 0 {
+			timeToFirstByte.Observe(time.Since(start).Seconds())
+			firstByte = false
+		}
+		if len(events) == 0 || events[len(events)-1].event == sseErrorEvent || events[len(events)-1].data == sseDone {
+			return
+		}
+	}
+}
+
+// openAIChunkRewriter turns the events of a text generation stream into chat completion chunks
+type openAIChunkRewriter struct {
+	id       string
+	created  int64
+	model    string
+	finished bool
+}
+
+func newOpenAIChunkRewriter(input []byte) *openAIChunkRewriter {
+	id := make([]byte, 12)
+	_, _ = rand.Read(id)
+	model := gjson.GetBytes(input, "model").String()
+	if model == "" {
+		model = "gmc"
+	}
+	return &openAIChunkRewriter{
+		id:      "chatcmpl-" + hex.EncodeToString(id),
+		created: time.Now().Unix(),
+		model:   model,
+	}
+}
+
+func (r *openAIChunkRewriter) chunk(delta map[string]string, finishReason interface{}) sseEvent {
+	data, _ := json.Marshal(map[string]interface{}{
+		"id":      r.id,
+		"object":  "chat.completion.chunk",
+		"created": r.created,
+		"model":   r.model,
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"delta":         delta,
+			"finish_reason": finishReason,
+		}},
+	})
+	return sseEvent{data: string(data)}
+}
+
+// rewrite returns the chunks replacing the event, the chunks of an OpenAI compatible service are kept
+func (r *openAIChunkRewriter) rewrite(e sseEvent) []sseEvent {
+	if e.data == sseDone {
+		return r.finish()
+	}
+	if gjson.Valid(e.data) && gjson.Get(e.data, "choices").Exists() {
+		if reason := gjson.Get(e.data, "choices.0.finish_reason"); reason.Exists() && reason.Type != gjson.Null {
+			r.finished = true
+		}
+		return []sseEvent{e}
+	}
+	return []sseEvent{r.chunk(map[string]string{"role": "assistant", "content": chunkText(e.data)}, nil)}
+}
+
+// finish ends the stream with the stop chunk, unless the service sent its own
+func (r *openAIChunkRewriter) finish() []sseEvent {
+	var events []sseEvent
+	if !r.finished {
+		r.finished = true
+		events = append(events, r.chunk(map[string]string{}, "stop"))
+	}
+	return append(events, sseEvent{data: sseDone})
+}
+
+// chunkText extracts the generated text from the data of an event, the data is either the JSON
+// of a text generation service, the repr of python bytes like b' OPEA' or the plain text
+func chunkText(data string) string {
+	if gjson.Valid(data) {
+		for _, path := range []string{"token.text", "text", "content"} {
+			if text := gjson.Get(data, path); text.Type == gjson.String {
+				return text.String()
+			}
+		}
+		return data
+	}
+	if len(data) >= 3 && data[0] == 'b' && (data[1] == '\'' || data[1] == '"') && data[len(data)-1] == data[1] {
+		return unquotePythonBytes(data[2 : len(data)-1])
+	}
+	return data
+}
+
+// unquotePythonBytes resolves the escape sequences of the repr of python bytes,
+// the escaped bytes of a multi-byte character are put together again
+func unquotePythonBytes(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			buf.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			buf.WriteByte('\n')
+		case 't':
+			buf.WriteByte('\t')
+		case 'r':
+			buf.WriteByte('\r')
+		case '\\', '\'', '"':
+			buf.WriteByte(s[i])
+		case 'x':
+			if i+2 < len(s) {
+				if b, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+					buf.WriteByte(byte(b))
+					i += 2
+					continue
+				}
+			}
+			buf.WriteString(`\x`)
+		default:
+			buf.WriteByte('\\')
+			buf.WriteByte(s[i])
+		}
+	}
+	return buf.String()
+}
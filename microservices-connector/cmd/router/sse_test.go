@@ -0,0 +1,178 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+// newStreamingService sends the events one by one, waiting for the delay after each of them,
+// and aborts the stream at the end when abort is set
+func newStreamingService(events []string, delay time.Duration, abort bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = io.ReadAll(req.Body)
+		rw.Header().Set("Content-Type", eventStreamContentType)
+		for _, e := range events {
+			_, _ = io.WriteString(rw, e)
+			rw.(http.Flusher).Flush()
+			time.Sleep(delay)
+		}
+		if abort {
+			panic(http.ErrAbortHandler)
+		}
+	}))
+}
+
+func streamingGraph(serviceURL string, config map[string]string) *mcv1alpha3.GMConnector {
+	return &mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps:      []mcv1alpha3.Step{{StepName: "Llm", ServiceURL: serviceURL}},
+				},
+			},
+			RouterConfig: mcv1alpha3.RouterConfig{Config: config},
+		},
+	}
+}
+
+// readEvents returns the data of the events of a stream, the heartbeats are returned as ":"
+func readEvents(t *testing.T, body string) []string {
+	t.Helper()
+	var events []string
+	for _, block := range strings.Split(strings.TrimSuffix(body, "\n\n"), "\n\n") {
+		if block == ": heartbeat" {
+			events = append(events, ":")
+			continue
+		}
+		e, err := readSSEEvent(bufio.NewReader(strings.NewReader(block)))
+		assert.NoError(t, err)
+		if e.event != "" {
+			events = append(events, e.event+" "+e.data)
+		} else {
+			events = append(events, e.data)
+		}
+	}
+	return events
+}
+
+func TestReadSSEEvent(t *testing.T) {
+	body := bufio.NewReader(strings.NewReader(
+		": comment\r\n" +
+			"event: token\r\nid: 1\r\ndata: first line\r\ndata:second line\r\n\r\n" +
+			"\n\n" +
+			"retry: 100\ndata: {\"text\":\"OPEA\"}\n\n" +
+			"data: unterminated"))
+	want := []sseEvent{
+		{event: "token", id: "1", data: "first line\nsecond line"},
+		{data: `{"text":"OPEA"}`},
+		{data: "unterminated"},
+	}
+	for _, w := range want {
+		e, err := readSSEEvent(body)
+		assert.NoError(t, err)
+		assert.Equal(t, w, e)
+	}
+	_, err := readSSEEvent(body)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestStreamEventsOpenAI(t *testing.T) {
+	llm := newStreamingService([]string{
+		"data: b' OPEA'\n\n",
+		"data: b' is \\xe5\\xbc\\x80\\xe6\\x94\\xbe'\n\n",
+		"data: [DONE]\n\n",
+	}, 0, false)
+	defer llm.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(streamingGraph(llm.URL, map[string]string{streamFormatKey: streamFormatOpenAI}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"model":"Intel/neural-chat-7b-v3-3","stream":true}`))
+	rr := httptest.NewRecorder()
+	mcGraphHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, eventStreamContentType, rr.Header().Get("Content-Type"))
+
+	events := readEvents(t, rr.Body.String())
+	assert.Len(t, events, 4)
+	assert.Equal(t, " OPEA", gjson.Get(events[0], "choices.0.delta.content").String())
+	assert.Equal(t, "Intel/neural-chat-7b-v3-3", gjson.Get(events[0], "model").String())
+	assert.Equal(t, "chat.completion.chunk", gjson.Get(events[0], "object").String())
+	assert.Equal(t, " is 开放", gjson.Get(events[1], "choices.0.delta.content").String())
+	assert.Equal(t, gjson.Get(events[0], "id").String(), gjson.Get(events[1], "id").String())
+	assert.Equal(t, "stop", gjson.Get(events[2], "choices.0.finish_reason").String())
+	assert.Equal(t, sseDone, events[3])
+}
+
+func TestStreamEventsHeartbeat(t *testing.T) {
+	llm := newStreamingService([]string{"data: OPEA\n\n", "data: is\n\n"}, 100*time.Millisecond, false)
+	defer llm.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(streamingGraph(llm.URL, map[string]string{sseHeartbeatIntervalKey: "30ms"}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+	rr := httptest.NewRecorder()
+	mcGraphHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	events := readEvents(t, rr.Body.String())
+	// the events are passed on without a stream format, with heartbeats in between
+	assert.Equal(t, "OPEA", events[0])
+	assert.Equal(t, ":", events[1])
+	assert.Contains(t, events, "is")
+}
+
+func TestStreamEventsError(t *testing.T) {
+	llm := newStreamingService([]string{"data: OPEA\n\n"}, 0, true)
+	defer llm.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(streamingGraph(llm.URL, map[string]string{sseHeartbeatIntervalKey: "0s"}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{}`))
+	rr := httptest.NewRecorder()
+	mcGraphHandler(rr, req)
+
+	events := readEvents(t, rr.Body.String())
+	assert.Len(t, events, 2)
+	assert.Equal(t, "OPEA", events[0])
+	assert.True(t, strings.HasPrefix(events[1], sseErrorEvent+" "))
+	assert.Equal(t, "stream_error", gjson.Get(strings.TrimPrefix(events[1], sseErrorEvent+" "), "error.type").String())
+}
+
+func TestChunkText(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{data: `b' OPEA'`, want: " OPEA"},
+		{data: `b"it's"`, want: "it's"},
+		{data: `b'line\n\'quoted\' \\'`, want: "line\n'quoted' \\"},
+		{data: `b'\xe4\xbd\xa0'`, want: "你"},
+		{data: `{"token":{"id":1,"text":" OPEA","special":false}}`, want: " OPEA"},
+		{data: `{"text":" OPEA"}`, want: " OPEA"},
+		{data: `plain text`, want: "plain text"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, chunkText(tt.data), tt.data)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"sync"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/opea-project/GenAIInfra/microservices-connector/internal/payload"
+)
+
+// the legacy Data, which sends the previous response merged with the parameters of the request
+const responseData = "$response"
+
+// compiledTemplates caches the parsed Data templates by their source,
+// it only keeps the templates of the current graph once it is reloaded
+var compiledTemplates sync.Map
+
+func compileTemplate(data string) (*payload.Template, error) {
+	if cached, ok := compiledTemplates.Load(data); ok {
+		return cached.(*payload.Template), nil
+	}
+	tmpl, err := payload.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	compiledTemplates.Store(data, tmpl)
+	return tmpl, nil
+}
+
+// pruneCompiledTemplates drops the compiled templates no step of the graph has
+func pruneCompiledTemplates(graph *mcv1alpha3.GMConnector) {
+	used := map[string]bool{}
+	for _, node := range graph.Spec.Nodes {
+		for _, step := range node.Steps {
+			used[step.Data] = true
+		}
+	}
+	compiledTemplates.Range(func(key, _ any) bool {
+		if !used[key.(string)] {
+			compiledTemplates.Delete(key)
+		}
+		return true
+	})
+}
+
+// isDataTemplate tells if the Data of a step is a template rather than the legacy $response
+func isDataTemplate(data string) bool {
+	return data != "" && data != responseData
+}
+
+// stepOutputs keeps the responses of the steps executed for a request,
+// so the Data of a later step can refer to them with $steps.<name>.response
+type stepOutputs struct {
+	mu      sync.Mutex
+	outputs map[string][]byte
+}
+
+type stepOutputsKey struct{}
+
+// withStepOutputs returns a context recording the step outputs, unless the context already does
+func withStepOutputs(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(stepOutputsKey{}).(*stepOutputs); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, stepOutputsKey{}, &stepOutputs{outputs: map[string][]byte{}})
+}
+
+// recordStepOutput saves the response of a step, the latest execution of a step name wins
+func recordStepOutput(ctx context.Context, stepName string, output []byte) {
+	if so, ok := ctx.Value(stepOutputsKey{}).(*stepOutputs); ok {
+		so.mu.Lock()
+		so.outputs[stepName] = output
+		so.mu.Unlock()
+	}
+}
+
+func stepOutputsFrom(ctx context.Context) map[string][]byte {
+	so, ok := ctx.Value(stepOutputsKey{}).(*stepOutputs)
+	if !ok {
+		return nil
+	}
+	so.mu.Lock()
+	defer so.mu.Unlock()
+	outputs := make(map[string][]byte, len(so.outputs))
+	for name, output := range so.outputs {
+		outputs[name] = output
+	}
+	return outputs
+}
+
+// renderStepData builds the request of a step from the template in its Data
+func renderStepData(ctx context.Context, step *mcv1alpha3.Step, initInput []byte, response []byte) ([]byte, error) {
+	tmpl, err := compileTemplate(step.Data)
+	if err != nil {
+		return nil, err
+	}
+	return tmpl.Render(payload.Env{
+		Request:  initInput,
+		Response: response,
+		Steps:    stepOutputsFrom(ctx),
+	}), nil
+}
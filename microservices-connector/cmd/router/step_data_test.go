@@ -0,0 +1,98 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSequenceWithDataTemplates(t *testing.T) {
+	var llmRequest []byte
+	embedding := newEchoService(`{"embedding":[0.1,0.2]}`)
+	defer embedding.Close()
+	retriever := newEchoService(`{"retrieved_docs":[{"text":"OPEA is an open platform"},{"text":"for enterprise AI"}]}`)
+	defer retriever.Close()
+	llm := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		llmRequest, _ = io.ReadAll(req.Body)
+		_, _ = rw.Write([]byte(`{"text":"answer"}`))
+	}))
+	defer llm.Close()
+
+	gmcGraph := mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps: []mcv1alpha3.Step{
+						{StepName: "Embedding", ServiceURL: embedding.URL, Data: `{"text":"$request.query"}`},
+						{StepName: "Retriever", ServiceURL: retriever.URL, Data: `$response`},
+						{
+							StepName:   "Llm",
+							ServiceURL: llm.URL,
+							Data: `{
+								"query": "$request.query",
+								"docs": "$response.retrieved_docs.#.text",
+								"embedding": "$steps.Embedding.response.embedding",
+								"prompt": "Answer ${$request.query} with ${$response.retrieved_docs.0.text}",
+								"max_tokens": 64
+							}`,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	input := []byte(`{"query":"What is OPEA?"}`)
+	res, _, err := routeStep(context.Background(), "root", gmcGraph, input, input, http.Header{})
+	assert.NoError(t, err)
+	body, err := io.ReadAll(res)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"text":"answer"}`, string(body))
+	assert.JSONEq(t, `{
+		"query": "What is OPEA?",
+		"docs": ["OPEA is an open platform", "for enterprise AI"],
+		"embedding": [0.1, 0.2],
+		"prompt": "Answer What is OPEA? with OPEA is an open platform",
+		"max_tokens": 64
+	}`, string(llmRequest))
+}
+
+func TestStepOutputs(t *testing.T) {
+	ctx := withStepOutputs(context.Background())
+	// a nested node shares the outputs of the request
+	assert.Equal(t, ctx, withStepOutputs(ctx))
+	recordStepOutput(ctx, "Embedding", []byte(`{"embedding":[0.1]}`))
+	recordStepOutput(ctx, "Embedding", []byte(`{"embedding":[0.2]}`))
+	assert.Equal(t, map[string][]byte{"Embedding": []byte(`{"embedding":[0.2]}`)}, stepOutputsFrom(ctx))
+
+	// without the outputs in the context nothing is recorded
+	recordStepOutput(context.Background(), "Embedding", []byte(`{}`))
+	assert.Nil(t, stepOutputsFrom(context.Background()))
+}
+
+func TestPruneCompiledTemplates(t *testing.T) {
+	kept, removed := `{"text":"$request.query"}`, `{"input":"$request.query"}`
+	for _, data := range []string{kept, removed} {
+		_, err := compileTemplate(data)
+		assert.NoError(t, err)
+	}
+
+	pruneCompiledTemplates(&mcv1alpha3.GMConnector{Spec: mcv1alpha3.GMConnectorSpec{Nodes: map[string]mcv1alpha3.Router{
+		"root": {RouterType: mcv1alpha3.Sequence, Steps: []mcv1alpha3.Step{{StepName: "Embedding", Data: kept}}},
+	}}})
+	_, ok := compiledTemplates.Load(kept)
+	assert.True(t, ok)
+	_, ok = compiledTemplates.Load(removed)
+	assert.False(t, ok)
+}
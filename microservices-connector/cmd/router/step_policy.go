@@ -0,0 +1,112 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+)
+
+const (
+	defaultRequestTimeout = time.Minute
+	defaultRetryBackoff   = 500 * time.Millisecond
+	// upper bound of the doubled wait time between the attempts of a step
+	maxRetryBackoff = 30 * time.Second
+	// RouterConfig.Config key for the timeout of a whole request through the graph
+	requestTimeoutKey = "timeout"
+)
+
+// the failures retried when a step has retries but no retryOn
+var defaultRetryOn = []string{mcv1alpha3.RetryOnConnectFailure, "502", "503", "504"}
+
+// requestTimeout returns the deadline for a request through the whole graph
+func requestTimeout(graph *mcv1alpha3.GMConnector) time.Duration {
+	if value, ok := graph.Spec.RouterConfig.Config[requestTimeoutKey]; ok {
+		timeout, err := time.ParseDuration(value)
+		if err == nil && timeout > 0 {
+			return timeout
+		}
+		log.Info("Invalid request timeout in router config, use the default one", "timeout", value)
+	}
+	return defaultRequestTimeout
+}
+
+// stepClient returns the http client with the transport of the step honoring its timeout
+func stepClient(step *mcv1alpha3.Step, transport http.RoundTripper) *http.Client {
+	hasTimeout := step.Timeout != nil && step.Timeout.Duration > 0
+	if !hasTimeout && transport == callClient.Transport {
+		return callClient
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   callClient.Timeout,
+	}
+	if hasTimeout {
+		client.Timeout = step.Timeout.Duration
+	}
+	return client
+}
+
+// shouldRetry tells if the failed attempt of a step is going to be retried
+func shouldRetry(step *mcv1alpha3.Step, attempts int, statusCode int, err error) bool {
+	if attempts > int(step.Retries) {
+		return false
+	}
+	if err == nil && isSuccessFul(statusCode) {
+		return false
+	}
+	retryOn := step.RetryOn
+	if len(retryOn) == 0 {
+		retryOn = defaultRetryOn
+	}
+	for _, cond := range retryOn {
+		if err != nil {
+			if cond == mcv1alpha3.RetryOnConnectFailure && isConnectFailure(err) {
+				return true
+			}
+			continue
+		}
+		if len(cond) == 3 && cond[1:] == "xx" {
+			if strconv.Itoa(statusCode/100) == cond[:1] {
+				return true
+			}
+			continue
+		}
+		if cond == strconv.Itoa(statusCode) {
+			return true
+		}
+	}
+	return false
+}
+
+// isConnectFailure tells if the call failed before reaching the service, the
+// timeouts are left out as the service may have received the request already
+func isConnectFailure(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// retryBackoff returns the wait time after the given number of attempts,
+// it is doubled for each attempt up to maxRetryBackoff
+func retryBackoff(step *mcv1alpha3.Step, attempts int) time.Duration {
+	backoff := defaultRetryBackoff
+	if step.RetryBackoff != nil {
+		backoff = step.RetryBackoff.Duration
+	}
+	for i := 1; i < attempts && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxRetryBackoff)
+}
@@ -0,0 +1,180 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestShouldRetry(t *testing.T) {
+	connErr := &url.Error{Op: "Post", URL: "http://svc", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}
+	timeoutErr := &url.Error{Op: "Post", URL: "http://svc", Err: context.DeadlineExceeded}
+	resetErr := &url.Error{Op: "Post", URL: "http://svc", Err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}}
+	tests := []struct {
+		name       string
+		step       mcv1alpha3.Step
+		attempts   int
+		statusCode int
+		err        error
+		want       bool
+	}{
+		{
+			name:       "no retries configured",
+			step:       mcv1alpha3.Step{},
+			attempts:   1,
+			statusCode: 503,
+			want:       false,
+		},
+		{
+			name:       "successful attempt",
+			step:       mcv1alpha3.Step{Retries: 2},
+			attempts:   1,
+			statusCode: 200,
+			want:       false,
+		},
+		{
+			name:       "default retry on 503",
+			step:       mcv1alpha3.Step{Retries: 2},
+			attempts:   1,
+			statusCode: 503,
+			want:       true,
+		},
+		{
+			name:       "default no retry on 500",
+			step:       mcv1alpha3.Step{Retries: 2},
+			attempts:   1,
+			statusCode: 500,
+			want:       false,
+		},
+		{
+			name:     "default retry on connection error",
+			step:     mcv1alpha3.Step{Retries: 2},
+			attempts: 1,
+			err:      connErr,
+			want:     true,
+		},
+		{
+			name:       "retries exhausted",
+			step:       mcv1alpha3.Step{Retries: 2},
+			attempts:   3,
+			statusCode: 503,
+			want:       false,
+		},
+		{
+			name:       "status class",
+			step:       mcv1alpha3.Step{Retries: 1, RetryOn: []string{"5xx"}},
+			attempts:   1,
+			statusCode: 500,
+			want:       true,
+		},
+		{
+			name:       "explicit status code",
+			step:       mcv1alpha3.Step{Retries: 1, RetryOn: []string{"429"}},
+			attempts:   1,
+			statusCode: 429,
+			want:       true,
+		},
+		{
+			name:     "no retry on timeout",
+			step:     mcv1alpha3.Step{Retries: 2, RetryOn: []string{mcv1alpha3.RetryOnConnectFailure}},
+			attempts: 1,
+			err:      timeoutErr,
+			want:     false,
+		},
+		{
+			name:     "no retry on error after the connection",
+			step:     mcv1alpha3.Step{Retries: 2},
+			attempts: 1,
+			err:      resetErr,
+			want:     false,
+		},
+		{
+			name:     "connection error not listed",
+			step:     mcv1alpha3.Step{Retries: 1, RetryOn: []string{"5xx"}},
+			attempts: 1,
+			err:      connErr,
+			want:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, shouldRetry(&tt.step, tt.attempts, tt.statusCode, tt.err))
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	step := &mcv1alpha3.Step{RetryBackoff: &metav1.Duration{Duration: 100 * time.Millisecond}}
+	assert.Equal(t, 100*time.Millisecond, retryBackoff(step, 1))
+	assert.Equal(t, 200*time.Millisecond, retryBackoff(step, 2))
+	assert.Equal(t, 400*time.Millisecond, retryBackoff(step, 3))
+	assert.Equal(t, defaultRetryBackoff, retryBackoff(&mcv1alpha3.Step{}, 1))
+	assert.Equal(t, maxRetryBackoff, retryBackoff(step, 20))
+	assert.Equal(t, maxRetryBackoff, retryBackoff(step, 100))
+}
+
+func TestRequestTimeout(t *testing.T) {
+	graph := &mcv1alpha3.GMConnector{}
+	assert.Equal(t, defaultRequestTimeout, requestTimeout(graph))
+	graph.Spec.RouterConfig.Config = map[string]string{requestTimeoutKey: "5m"}
+	assert.Equal(t, 5*time.Minute, requestTimeout(graph))
+	graph.Spec.RouterConfig.Config[requestTimeoutKey] = "invalid"
+	assert.Equal(t, defaultRequestTimeout, requestTimeout(graph))
+}
+
+func TestCallServiceWithRetries(t *testing.T) {
+	var calls atomic.Int32
+	service := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if calls.Add(1) < 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = rw.Write([]byte(`{"predictions":"1"}`))
+	}))
+	defer service.Close()
+
+	step := &mcv1alpha3.Step{
+		StepName:     "service1",
+		Retries:      2,
+		RetryBackoff: &metav1.Duration{Duration: time.Millisecond},
+	}
+	res, statusCode, err := callService(context.Background(), step, service.URL, []byte(`{}`), http.Header{})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, statusCode)
+	body, err := io.ReadAll(res)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"predictions":"1"}`, string(body))
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestCallServiceWithTimeout(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer service.Close()
+
+	step := &mcv1alpha3.Step{
+		StepName: "service1",
+		Timeout:  &metav1.Duration{Duration: 20 * time.Millisecond},
+	}
+	_, statusCode, err := callService(context.Background(), step, service.URL, []byte(`{}`), http.Header{})
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, statusCode)
+}
@@ -0,0 +1,78 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// newTLSProxy returns the server of the sidecar the controller adds to the internal services for mutual TLS.
+// It serves the upstream service over HTTPS with the certificate of the service, and only accepts the
+// clients with a certificate signed by the CA of the graph for the allowed name, i.e. the router.
+func newTLSProxy(listen string, upstream string, certDir string, allowedClient string) (*http.Server, error) {
+	target, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	keyPair := newKeyPair(filepath.Join(certDir, corev1.TLSCertKey), filepath.Join(certDir, corev1.TLSPrivateKeyKey))
+	if _, err := keyPair.get(); err != nil {
+		return nil, fmt.Errorf("failed to load the certificate: %v", err)
+	}
+	clientCAs := newCertPool(filepath.Join(certDir, corev1.ServiceAccountRootCAKey))
+	if _, err := clientCAs.get(); err != nil {
+		return nil, fmt.Errorf("failed to read the CA bundle: %v", err)
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.Out.Host = pr.In.Host
+		},
+		Transport: transport,
+		// stream the responses of the LLM services as they come
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Error(err, "failed to proxy the request", "upstream", upstream)
+			http.Error(w, "Failed to send request to backend", http.StatusBadGateway)
+		},
+	}
+	return &http.Server{
+		Addr:    listen,
+		Handler: proxy,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return keyPair.get()
+			},
+			// the chain is verified by VerifyConnection with the current bundle
+			ClientAuth: tls.RequireAnyClientCert,
+			VerifyConnection: func(cs tls.ConnectionState) error {
+				return verifyPeer(cs, clientCAs, allowedClient, x509.ExtKeyUsageClientAuth)
+			},
+		},
+		ReadHeaderTimeout: time.Minute,
+		IdleTimeout:       3 * time.Minute,
+	}, nil
+}
+
+// runTLSProxy serves the proxy sidecar until it fails
+func runTLSProxy() error {
+	server, err := newTLSProxy(*tlsProxyListen, *tlsProxyUpstream, *tlsDir, *tlsProxyClient)
+	if err != nil {
+		return err
+	}
+	log.Info("Serving the service over mutual TLS", "listen", *tlsProxyListen, "upstream", *tlsProxyUpstream)
+	return server.ListenAndServeTLS("", "")
+}
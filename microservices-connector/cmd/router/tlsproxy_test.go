@@ -0,0 +1,121 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestTLSProxy(t *testing.T) {
+	oldSecretsDir := *secretsDir
+	defer func() { *secretsDir = oldSecretsDir }()
+	*secretsDir = t.TempDir()
+
+	ca := testCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "chatqa.default"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	issue := func(name string, ips ...net.IP) tls.Certificate {
+		return testCertificate(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: name},
+			DNSNames:    []string{name},
+			IPAddresses: ips,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		}, &ca)
+	}
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]})
+	writeSecret(t, "router-service-gmc-tls", map[string][]byte{corev1.ServiceAccountRootCAKey: caBundle})
+	for secret, cert := range map[string]tls.Certificate{
+		"router-service-gmc-tls": issue("router-service.default.svc"),
+		"other-gmc-tls":          issue("other.default.svc"),
+		"llm-gmc-tls":            issue("llm.default.svc", net.ParseIP("127.0.0.1")),
+	} {
+		certPEM, keyPEM := encodeKeyPair(t, cert)
+		writeSecret(t, secret, map[string][]byte{
+			corev1.TLSCertKey:              certPEM,
+			corev1.TLSPrivateKeyKey:        keyPEM,
+			corev1.ServiceAccountRootCAKey: caBundle,
+		})
+	}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(r.URL.Path + " " + string(body)))
+	}))
+	defer upstream.Close()
+
+	certDir := filepath.Join(*secretsDir, "llm-gmc-tls")
+	server, err := newTLSProxy("127.0.0.1:0", upstream.URL, certDir, "router-service.default.svc")
+	if err != nil {
+		t.Fatalf("failed to create the proxy: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go func() { _ = server.ServeTLS(listener, "", "") }()
+	defer server.Close()
+	proxyURL := "https://" + listener.Addr().String() + "/v1/chat/completions"
+
+	call := func(clientSecret string) (*http.Response, error) {
+		config, err := transportTLSConfig(&mcv1alpha3.TransportTLS{
+			CASecretRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "router-service-gmc-tls"},
+				Key:                  corev1.ServiceAccountRootCAKey,
+			},
+			ClientCertSecretName: clientSecret,
+		})
+		if err != nil {
+			t.Fatalf("failed to create the TLS config: %v", err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}}
+		return client.Post(proxyURL, "application/json", strings.NewReader(`{"text":"OPEA"}`))
+	}
+
+	resp, err := call("router-service-gmc-tls")
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, `/v1/chat/completions {"text":"OPEA"}`, string(body))
+	}
+
+	// the certificates of the other services are not accepted
+	_, err = call("other-gmc-tls")
+	assert.Error(t, err)
+
+	// the rotated certificate is served to the new connections
+	rotated := issue("llm.default.svc", net.ParseIP("127.0.0.1"))
+	certPEM, keyPEM := encodeKeyPair(t, rotated)
+	writeSecret(t, "llm-gmc-tls", map[string][]byte{corev1.TLSCertKey: certPEM, corev1.TLSPrivateKeyKey: keyPEM})
+	later := time.Now().Add(time.Minute)
+	for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey} {
+		_ = os.Chtimes(filepath.Join(certDir, key), later, later)
+	}
+	resp, err = call("router-service-gmc-tls")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, rotated.Leaf.SerialNumber, resp.TLS.PeerCertificates[0].SerialNumber)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	tracerName         = "github.com/opea-project/GenAIInfra/microservices-connector/cmd/router"
+	tracingServiceName = "gmc-router"
+	// RouterConfig.Config key of the OTLP/HTTP endpoint, e.g. http://otel-collector:4318,
+	// the spans are not exported without it
+	otlpEndpointKey = "otlpEndpoint"
+
+	attrNode               = attribute.Key("gmc.node")
+	attrRouterType         = attribute.Key("gmc.router_type")
+	attrStep               = attribute.Key("gmc.step")
+	attrAttempts           = attribute.Key("gmc.attempts")
+	attrURL                = attribute.Key("http.url")
+	attrStatusCode         = attribute.Key("http.status_code")
+	attrRequestBodySize    = attribute.Key("http.request_content_length")
+	attrResponseBodySize   = attribute.Key("http.response_content_length")
+	tracingShutdownTimeout = 5 * time.Second
+)
+
+// propagator reads and writes the W3C traceparent and tracestate headers
+var propagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// tracingState keeps the tracer provider in line with the exporter endpoint of the graph
+type tracingState struct {
+	mu       sync.Mutex
+	endpoint string
+	provider *sdktrace.TracerProvider
+}
+
+var tracing = &tracingState{}
+
+// configureTracing installs a tracer provider exporting to the OTLP endpoint of the graph,
+// it is called again on every graph reload and only acts when the endpoint changes
+func configureTracing(graph *mcv1alpha3.GMConnector) {
+	endpoint := graph.Spec.RouterConfig.Config[otlpEndpointKey]
+
+	tracing.mu.Lock()
+	defer tracing.mu.Unlock()
+	if endpoint == tracing.endpoint {
+		return
+	}
+
+	var provider *sdktrace.TracerProvider
+	if endpoint != "" {
+		exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+		if err != nil {
+			log.Error(err, "failed to create the OTLP exporter, keep the current tracing setup", "endpoint", endpoint)
+			return
+		}
+		provider = sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", tracingServiceName))),
+		)
+		otel.SetTracerProvider(provider)
+		log.Info("Export traces", "endpoint", endpoint)
+	} else {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		log.Info("Tracing is disabled")
+	}
+
+	if old := tracing.provider; old != nil {
+		// flush the spans of the old exporter without holding up the reload
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+			defer cancel()
+			if err := old.Shutdown(ctx); err != nil {
+				log.Error(err, "failed to shut down the tracer provider")
+			}
+		}()
+	}
+	tracing.provider, tracing.endpoint = provider, endpoint
+}
+
+// startSpan starts a span with the current global tracer provider,
+// so a provider swapped in by a graph reload is picked up right away
+func startSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, opts...)
+}
+
+// startStepSpan starts the span of a step executed by a pipeline handler
+func startStepSpan(ctx context.Context, nodeName string, step *mcv1alpha3.Step, input []byte) (context.Context, trace.Span) {
+	return startSpan(ctx, "step "+step.StepName, trace.WithAttributes(
+		attrNode.String(nodeName),
+		attrStep.String(step.StepName),
+		attrRequestBodySize.Int(len(input)),
+	))
+}
+
+// endSpan records the result of a node, step or service call and ends its span
+func endSpan(span trace.Span, statusCode int, err error) {
+	if statusCode != 0 {
+		span.SetAttributes(attrStatusCode.Int(statusCode))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else if statusCode >= 500 {
+		span.SetStatus(codes.Error, "")
+	}
+	span.End()
+}
@@ -0,0 +1,154 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// useInMemoryTracing records the spans of the test in memory
+func useInMemoryTracing(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	oldProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(oldProvider) })
+	return exporter
+}
+
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) (attribute.Value, bool) {
+	for _, attr := range span.Attributes {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracingSequence(t *testing.T) {
+	exporter := useInMemoryTracing(t)
+
+	var traceparents []string
+	newService := func(response string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			traceparents = append(traceparents, req.Header.Get("traceparent"))
+			_, _ = io.ReadAll(req.Body)
+			_, _ = rw.Write([]byte(response))
+		}))
+	}
+	service1 := newService(`{"text":"embedding"}`)
+	defer service1.Close()
+	service2 := newService(`{"text":"answer"}`)
+	defer service2.Close()
+
+	oldGraph := mcGraph.Load()
+	defer mcGraph.Store(oldGraph)
+	mcGraph.Store(&mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps: []mcv1alpha3.Step{
+						{StepName: "Embedding", ServiceURL: service1.URL},
+						{StepName: "Llm", ServiceURL: service2.URL},
+					},
+				},
+			},
+		},
+	})
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"text":"question"}`))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	mcGraphHandler(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	spans := exporter.GetSpans()
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name)
+		// every span continues the incoming trace
+		assert.Equal(t, traceID, span.SpanContext.TraceID().String())
+	}
+	assert.ElementsMatch(t, []string{
+		"call Embedding", "step Embedding", "call Llm", "step Llm", "node root", "gmc-router POST /",
+	}, names)
+
+	for _, span := range spans {
+		if span.Name != "call Llm" {
+			continue
+		}
+		assert.Equal(t, trace.SpanKindClient, span.SpanKind)
+		statusCode, ok := spanAttribute(span, attrStatusCode)
+		assert.True(t, ok)
+		assert.Equal(t, int64(http.StatusOK), statusCode.AsInt64())
+		size, ok := spanAttribute(span, attrRequestBodySize)
+		assert.True(t, ok)
+		assert.Positive(t, size.AsInt64())
+	}
+
+	// the trace context is injected into the calls to the microservices
+	assert.Len(t, traceparents, 2)
+	for _, traceparent := range traceparents {
+		assert.Contains(t, traceparent, traceID)
+	}
+}
+
+func TestTracingFailedStep(t *testing.T) {
+	exporter := useInMemoryTracing(t)
+
+	gmcGraph := mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps:      []mcv1alpha3.Step{{StepName: "Llm", ServiceURL: "http://127.0.0.1:0"}},
+				},
+			},
+		},
+	}
+	_, _, err := routeStep(context.Background(), "root", gmcGraph, []byte(`{}`), []byte(`{}`), http.Header{})
+	assert.Error(t, err)
+
+	spans := exporter.GetSpans()
+	assert.NotEmpty(t, spans)
+	for _, span := range spans {
+		assert.Equal(t, "Error", span.Status.Code.String(), span.Name)
+	}
+}
+
+func TestConfigureTracing(t *testing.T) {
+	oldProvider := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(oldProvider)
+
+	graph := &mcv1alpha3.GMConnector{}
+	graph.Spec.RouterConfig.Config = map[string]string{otlpEndpointKey: "http://otel-collector:4318"}
+	configureTracing(graph)
+	assert.NotNil(t, tracing.provider)
+	assert.Equal(t, "http://otel-collector:4318", tracing.endpoint)
+
+	// the same endpoint keeps the provider
+	provider := tracing.provider
+	configureTracing(graph)
+	assert.Same(t, provider, tracing.provider)
+
+	configureTracing(&mcv1alpha3.GMConnector{})
+	assert.Nil(t, tracing.provider)
+	assert.Equal(t, "", tracing.endpoint)
+}
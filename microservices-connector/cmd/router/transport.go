@@ -0,0 +1,207 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"golang.org/x/net/http/httpproxy"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// key of the step config with the hosts reached without the proxy,
+	// kept for the graphs written before the transport of the steps
+	noProxyConfigKey = "no_proxy"
+
+	defaultMaxIdleConnsPerService = 100
+)
+
+var transports = &stepTransports{transports: map[string]*http.Transport{}}
+
+// stepTransports holds the transports of the steps with their own connection settings,
+// by service URL and settings as steps calling the same service may set different ones
+type stepTransports struct {
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+// transportSettings are the connection settings of a step, nil when it shares the transport of the router
+func transportSettings(step *mcv1alpha3.Step) *mcv1alpha3.Transport {
+	noProxy, hasNoProxy := step.InternalService.Config[noProxyConfigKey]
+	if step.Transport == nil && !hasNoProxy {
+		return nil
+	}
+	settings := &mcv1alpha3.Transport{NoProxy: noProxy}
+	if step.Transport != nil {
+		settings = step.Transport.DeepCopy()
+		if settings.NoProxy == "" {
+			settings.NoProxy = noProxy
+		}
+	}
+	return settings
+}
+
+// transportKey identifies the transport of the service with the given settings
+func transportKey(serviceURL string, settings *mcv1alpha3.Transport) (string, error) {
+	key, err := json.Marshal(settings)
+	if err != nil {
+		return "", err
+	}
+	return serviceURL + " " + string(key), nil
+}
+
+// get returns the transport for the calls to the service of the step. A new transport is built
+// when the settings of the step change with a reload of the graph.
+func (s *stepTransports) get(step *mcv1alpha3.Step, serviceURL string) (*http.Transport, error) {
+	settings := transportSettings(step)
+	if settings == nil {
+		return transport, nil
+	}
+	key, err := transportKey(serviceURL, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.transports[key]; ok {
+		return t, nil
+	}
+	t, err := newStepTransport(settings)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Created the transport of the service", "stepName", step.StepName, "serviceURL", serviceURL)
+	s.transports[key] = t
+	return t, nil
+}
+
+// prune drops the transports no step of the reloaded graph uses anymore, the transports of the
+// fallback services and of the DataPrep steps included. The calls in flight keep their connections.
+func (s *stepTransports) prune(graph *mcv1alpha3.GMConnector) {
+	used := map[string]bool{}
+	markUsed := func(step *mcv1alpha3.Step, serviceURL string) {
+		if settings := transportSettings(step); settings != nil {
+			if key, err := transportKey(serviceURL, settings); err == nil {
+				used[key] = true
+			}
+		}
+	}
+	for _, node := range graph.Spec.Nodes {
+		for i := range node.Steps {
+			step := &node.Steps[i]
+			// the DataPrep steps proxy to the same service URL as the other steps
+			markUsed(step, getServiceURLByStepTarget(step, graph.Namespace))
+			if fallbackStep := fallbackServiceStep(step); fallbackStep != nil {
+				markUsed(fallbackStep, fallbackStep.ServiceURL)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, t := range s.transports {
+		if !used[key] {
+			t.CloseIdleConnections()
+			delete(s.transports, key)
+		}
+	}
+}
+
+// newStepTransport builds a transport from the connection settings of a step
+func newStepTransport(settings *mcv1alpha3.Transport) (*http.Transport, error) {
+	t := transport.Clone()
+	t.Proxy = proxyFunc(settings)
+	t.MaxIdleConnsPerHost = defaultMaxIdleConnsPerService
+	if settings.MaxIdleConns > 0 {
+		t.MaxIdleConnsPerHost = int(settings.MaxIdleConns)
+	}
+	t.MaxIdleConns = t.MaxIdleConnsPerHost
+	t.MaxConnsPerHost = int(settings.MaxConns)
+	if settings.IdleConnTimeout != nil && settings.IdleConnTimeout.Duration > 0 {
+		t.IdleConnTimeout = settings.IdleConnTimeout.Duration
+	}
+
+	tlsConfig, err := transportTLSConfig(settings.TLS)
+	if err != nil {
+		return nil, err
+	}
+	t.TLSClientConfig = tlsConfig
+	// the transports with their own TLS config negotiate HTTP/2 unless it is disabled
+	t.ForceAttemptHTTP2 = !settings.DisableHTTP2
+	if settings.DisableHTTP2 {
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t, nil
+}
+
+// proxyFunc returns the proxy of the step, based on the proxy environment of the router
+func proxyFunc(settings *mcv1alpha3.Transport) func(*http.Request) (*url.URL, error) {
+	config := httpproxy.FromEnvironment()
+	if settings.ProxyURL != "" {
+		config.HTTPProxy = settings.ProxyURL
+		config.HTTPSProxy = settings.ProxyURL
+	}
+	if settings.NoProxy != "" {
+		config.NoProxy = settings.NoProxy
+	}
+	proxy := config.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+}
+
+// transportTLSConfig verifies the service with the CA bundle from the Secret mounted into the router.
+// The files are read again once they changed, so the rotated certificates are picked up.
+func transportTLSConfig(settings *mcv1alpha3.TransportTLS) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if settings == nil {
+		return config, nil
+	}
+	config.ServerName = settings.ServerName
+	if settings.CASecretRef != nil {
+		path, err := secretPath(settings.CASecretRef.Name, settings.CASecretRef.Key)
+		if err != nil {
+			return nil, err
+		}
+		pool := newCertPool(path)
+		// fail early on a missing or invalid bundle
+		if _, err := pool.get(); err != nil {
+			return nil, fmt.Errorf("failed to read the CA bundle: %v", err)
+		}
+		// the chain is verified by VerifyConnection with the current bundle
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPeer(cs, pool, cs.ServerName, x509.ExtKeyUsageServerAuth)
+		}
+	}
+	if name := settings.ClientCertSecretName; name != "" {
+		certFile, err := secretPath(name, corev1.TLSCertKey)
+		if err != nil {
+			return nil, err
+		}
+		keyFile, err := secretPath(name, corev1.TLSPrivateKeyKey)
+		if err != nil {
+			return nil, err
+		}
+		keyPair := newKeyPair(certFile, keyFile)
+		if _, err := keyPair.get(); err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %v", err)
+		}
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return keyPair.get()
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,273 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// testCertificate signs a certificate with the parent, a self-signed CA when the parent is nil
+func testCertificate(t *testing.T, template *x509.Certificate, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate the key: %v", err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Minute)
+	template.NotAfter = time.Now().Add(time.Hour)
+	signer, signerKey := template, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("failed to create the certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse the certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// encodeKeyPair returns the PEM files of the certificate and its key
+func encodeKeyPair(t *testing.T, cert tls.Certificate) ([]byte, []byte) {
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("failed to marshal the key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+}
+
+// writeSecret writes the keys of a Secret the way it is mounted into the router
+func writeSecret(t *testing.T, name string, data map[string][]byte) {
+	dir := filepath.Join(*secretsDir, name)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		t.Fatalf("failed to create the secret directory: %v", err)
+	}
+	for key, value := range data {
+		if err := os.WriteFile(filepath.Join(dir, key), value, 0o600); err != nil {
+			t.Fatalf("failed to write the secret: %v", err)
+		}
+	}
+}
+
+func TestTransportSettings(t *testing.T) {
+	assert.Nil(t, transportSettings(&mcv1alpha3.Step{StepName: "Llm"}))
+
+	step := &mcv1alpha3.Step{StepName: "Llm"}
+	step.InternalService.Config = map[string]string{"no_proxy": ".svc.cluster.local"}
+	assert.Equal(t, &mcv1alpha3.Transport{NoProxy: ".svc.cluster.local"}, transportSettings(step))
+
+	step.Transport = &mcv1alpha3.Transport{ProxyURL: "http://proxy:912"}
+	assert.Equal(t, &mcv1alpha3.Transport{ProxyURL: "http://proxy:912", NoProxy: ".svc.cluster.local"}, transportSettings(step))
+	step.Transport.NoProxy = "example.com"
+	assert.Equal(t, "example.com", transportSettings(step).NoProxy)
+	assert.Equal(t, "http://proxy:912", step.Transport.ProxyURL)
+}
+
+func TestProxyFunc(t *testing.T) {
+	proxy := proxyFunc(&mcv1alpha3.Transport{ProxyURL: "http://proxy:912", NoProxy: ".svc.cluster.local"})
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "https://api.example.com/v1/chat", want: "http://proxy:912"},
+		{url: "http://tgi.default.svc.cluster.local/generate", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.url, nil)
+			proxyURL, err := proxy(req)
+			assert.NoError(t, err)
+			if tt.want == "" {
+				assert.Nil(t, proxyURL)
+				return
+			}
+			assert.Equal(t, tt.want, proxyURL.String())
+		})
+	}
+}
+
+func TestStepTransports(t *testing.T) {
+	cache := &stepTransports{transports: map[string]*http.Transport{}}
+	step := &mcv1alpha3.Step{StepName: "Llm"}
+	shared, err := cache.get(step, "http://llm")
+	assert.NoError(t, err)
+	assert.Same(t, transport, shared)
+
+	step.Transport = &mcv1alpha3.Transport{MaxIdleConns: 10, MaxConns: 20, DisableHTTP2: true}
+	first, err := cache.get(step, "http://llm")
+	assert.NoError(t, err)
+	assert.NotSame(t, transport, first)
+	assert.Equal(t, 10, first.MaxIdleConnsPerHost)
+	assert.Equal(t, 20, first.MaxConnsPerHost)
+	again, _ := cache.get(step.DeepCopy(), "http://llm")
+	assert.Same(t, first, again)
+
+	// another step calling the same service keeps its own settings
+	other := &mcv1alpha3.Step{StepName: "Llm", ServiceURL: "http://llm", Transport: &mcv1alpha3.Transport{MaxConns: 30}}
+	changed, err := cache.get(other, "http://llm")
+	assert.NoError(t, err)
+	assert.NotSame(t, first, changed)
+	assert.Equal(t, 30, changed.MaxConnsPerHost)
+	again, _ = cache.get(step, "http://llm")
+	assert.Same(t, first, again)
+
+	// the reloaded graph only keeps the transport of the other step
+	cache.prune(&mcv1alpha3.GMConnector{Spec: mcv1alpha3.GMConnectorSpec{Nodes: map[string]mcv1alpha3.Router{
+		"root": {Steps: []mcv1alpha3.Step{*other, {StepName: "Embedding"}}},
+	}}})
+	assert.Len(t, cache.transports, 1)
+	again, _ = cache.get(other, "http://llm")
+	assert.Same(t, changed, again)
+
+	// the transports of the fallback services and of the DataPrep steps are kept too
+	withFallback := &mcv1alpha3.Step{
+		StepName:   "Llm",
+		ServiceURL: "http://llm",
+		Transport:  &mcv1alpha3.Transport{MaxConns: 30},
+		Fallback:   &mcv1alpha3.Fallback{Executor: mcv1alpha3.Executor{ExternalService: "http://fallback"}},
+	}
+	fallbackStep := fallbackServiceStep(withFallback)
+	fallback, err := cache.get(fallbackStep, fallbackStep.ServiceURL)
+	assert.NoError(t, err)
+	dataPrepStep := &mcv1alpha3.Step{StepName: DataPrep, ServiceURL: "http://dataprep", Transport: &mcv1alpha3.Transport{MaxConns: 5}}
+	dataPrep, err := cache.get(dataPrepStep, "http://dataprep")
+	assert.NoError(t, err)
+	cache.prune(&mcv1alpha3.GMConnector{Spec: mcv1alpha3.GMConnectorSpec{Nodes: map[string]mcv1alpha3.Router{
+		"root":     {Steps: []mcv1alpha3.Step{*withFallback}},
+		"dataprep": {Steps: []mcv1alpha3.Step{*dataPrepStep}},
+	}}})
+	assert.Len(t, cache.transports, 3)
+	again, _ = cache.get(fallbackServiceStep(withFallback), "http://fallback")
+	assert.Same(t, fallback, again)
+	again, _ = cache.get(dataPrepStep, "http://dataprep")
+	assert.Same(t, dataPrep, again)
+
+	step.Transport.TLS = &mcv1alpha3.TransportTLS{ClientCertSecretName: "missing"}
+	_, err = cache.get(step, "http://llm")
+	assert.Error(t, err)
+}
+
+func TestMutualTLS(t *testing.T) {
+	oldSecretsDir := *secretsDir
+	defer func() { *secretsDir = oldSecretsDir }()
+	*secretsDir = t.TempDir()
+
+	ca := testCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "gmc-ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	serverCert := testCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "llm"},
+		DNSNames:    []string{"llm.default.svc.cluster.local"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+	clientCert := testCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "router"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]})
+	writeSecret(t, "llm-ca", map[string][]byte{"ca.crt": caBundle})
+	clientCertPEM, clientKeyPEM := encodeKeyPair(t, clientCert)
+	writeSecret(t, "router-client", map[string][]byte{corev1.TLSCertKey: clientCertPEM, corev1.TLSPrivateKeyKey: clientKeyPEM})
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.Leaf)
+	service := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto + " " + r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	service.EnableHTTP2 = true
+	service.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	service.StartTLS()
+	defer service.Close()
+
+	oldTransports := transports
+	defer func() { transports = oldTransports }()
+	tests := []struct {
+		name       string
+		transport  *mcv1alpha3.Transport
+		statusCode int
+		want       string
+	}{
+		{
+			name: "mutual TLS",
+			transport: &mcv1alpha3.Transport{TLS: &mcv1alpha3.TransportTLS{
+				CASecretRef:          &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "llm-ca"}, Key: "ca.crt"},
+				ClientCertSecretName: "router-client",
+			}},
+			statusCode: http.StatusOK,
+			want:       "HTTP/2.0 router",
+		},
+		{
+			name: "HTTP/1.1",
+			transport: &mcv1alpha3.Transport{DisableHTTP2: true, TLS: &mcv1alpha3.TransportTLS{
+				CASecretRef:          &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "llm-ca"}, Key: "ca.crt"},
+				ClientCertSecretName: "router-client",
+				ServerName:           "llm.default.svc.cluster.local",
+			}},
+			statusCode: http.StatusOK,
+			want:       "HTTP/1.1 router",
+		},
+		{
+			name: "no client certificate",
+			transport: &mcv1alpha3.Transport{TLS: &mcv1alpha3.TransportTLS{
+				CASecretRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "llm-ca"}, Key: "ca.crt"},
+			}},
+			statusCode: http.StatusInternalServerError,
+		},
+		{
+			name:       "unknown CA",
+			transport:  &mcv1alpha3.Transport{TLS: &mcv1alpha3.TransportTLS{ClientCertSecretName: "router-client"}},
+			statusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transports = &stepTransports{transports: map[string]*http.Transport{}}
+			step := &mcv1alpha3.Step{StepName: "Llm", ServiceURL: service.URL, Transport: tt.transport}
+			body, statusCode, err := doCallService(context.Background(), step, service.URL, []byte(`{"text":"OPEA"}`), http.Header{})
+			assert.Equal(t, tt.statusCode, statusCode)
+			if tt.statusCode != http.StatusOK {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			defer body.Close()
+			response, _ := io.ReadAll(body)
+			assert.Equal(t, tt.want, string(response))
+		})
+	}
+}
@@ -0,0 +1,144 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package cache stores the responses of the steps by the hash of their normalized request,
+// or by the embedding vector of their request for the semantic cache.
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache stores values by key for a limited time, the implementations are safe for concurrent use.
+// The in-memory LRU is local to a router replica, a shared store like Redis can implement the
+// interface to share the cached responses among the replicas.
+type Cache interface {
+	// Get returns the value of the key, false when it is missing or expired
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores the value of the key for the ttl
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// Key hashes a request body after removing the ignored fields and sorting the keys of its objects,
+// so the requests only differing by the order of their fields get the same key.
+// The ignored fields are dotted paths like "parameters.streaming", namespace separates the keys
+// of different services.
+func Key(namespace string, body []byte, ignoreFields []string) string {
+	h := sha256.New()
+	h.Write([]byte(namespace))
+	h.Write([]byte{0})
+	h.Write(normalize(body, ignoreFields))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func normalize(body []byte, ignoreFields []string) []byte {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil || decoder.More() {
+		// not a JSON document, the body is hashed as it is
+		return body
+	}
+	for _, path := range ignoreFields {
+		removeField(doc, strings.Split(path, "."))
+	}
+	// the keys of the maps are sorted by json.Marshal
+	normalized, err := json.Marshal(doc)
+	if err != nil {
+		return body
+	}
+	return normalized
+}
+
+func removeField(doc interface{}, keys []string) {
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if len(keys) == 1 {
+		delete(obj, keys[0])
+		return
+	}
+	removeField(obj[keys[0]], keys[1:])
+}
+
+// LRU is an in-memory Cache evicting the least recently used entries beyond its size
+type LRU struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    *list.List
+	items      map[string]*list.Element
+	// now is replaced by the tests
+	now func() time.Time
+}
+
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRU creates an in-memory cache holding up to maxEntries values
+func NewLRU(maxEntries int) *LRU {
+	return &LRU{
+		maxEntries: maxEntries,
+		entries:    list.New(),
+		items:      map[string]*list.Element{},
+		now:        time.Now,
+	}
+}
+
+// Get implements Cache
+func (c *LRU) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e := elem.Value.(*entry)
+	if !c.now().Before(e.expires) {
+		c.entries.Remove(elem)
+		delete(c.items, key)
+		return nil, false, nil
+	}
+	c.entries.MoveToFront(elem)
+	return e.value, true, nil
+}
+
+// Set implements Cache
+func (c *LRU) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := c.now().Add(ttl)
+	if elem, ok := c.items[key]; ok {
+		e := elem.Value.(*entry)
+		e.value, e.expires = value, expires
+		c.entries.MoveToFront(elem)
+		return nil
+	}
+	c.items[key] = c.entries.PushFront(&entry{key: key, value: value, expires: expires})
+	for c.entries.Len() > c.maxEntries {
+		oldest := c.entries.Back()
+		c.entries.Remove(oldest)
+		delete(c.items, oldest.Value.(*entry).key)
+	}
+	return nil
+}
+
+// Len returns the number of entries, the expired ones included until they are evicted
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries.Len()
+}
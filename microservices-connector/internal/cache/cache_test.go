@@ -0,0 +1,77 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKey(t *testing.T) {
+	ignore := []string{"streaming", "parameters.seed"}
+	key := Key("http://llm", []byte(`{"query":"What is OPEA?","parameters":{"max_tokens":128,"seed":1},"streaming":true}`), ignore)
+
+	same := []string{
+		`{"streaming":false,"parameters":{"seed":2,"max_tokens":128},"query":"What is OPEA?"}`,
+		`{ "query": "What is OPEA?", "parameters": {"max_tokens": 128} }`,
+	}
+	for _, body := range same {
+		if got := Key("http://llm", []byte(body), ignore); got != key {
+			t.Errorf("Expected the same key for %s", body)
+		}
+	}
+
+	different := []struct {
+		namespace string
+		body      string
+	}{
+		{namespace: "http://llm", body: `{"query":"What is OPEA?","parameters":{"max_tokens":64}}`},
+		{namespace: "http://llm", body: `{"query":"what is OPEA?","parameters":{"max_tokens":128}}`},
+		{namespace: "http://other-llm", body: `{"query":"What is OPEA?","parameters":{"max_tokens":128}}`},
+		{namespace: "http://llm", body: `not json`},
+	}
+	for _, d := range different {
+		if got := Key(d.namespace, []byte(d.body), ignore); got == key {
+			t.Errorf("Expected a different key for %s of %s", d.body, d.namespace)
+		}
+	}
+}
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	c := NewLRU(2)
+	c.now = func() time.Time { return now }
+
+	_ = c.Set(ctx, "a", []byte("1"), time.Minute)
+	_ = c.Set(ctx, "b", []byte("2"), time.Minute)
+	// reading a makes b the least recently used entry
+	if value, ok, _ := c.Get(ctx, "a"); !ok || string(value) != "1" {
+		t.Errorf("Expected a hit for a, but got %q %v", value, ok)
+	}
+	_ = c.Set(ctx, "c", []byte("3"), time.Second)
+	if _, ok, _ := c.Get(ctx, "b"); ok {
+		t.Errorf("Expected b to be evicted")
+	}
+	if c.Len() != 2 {
+		t.Errorf("Expected 2 entries, but got %d", c.Len())
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, ok, _ := c.Get(ctx, "c"); ok {
+		t.Errorf("Expected c to be expired")
+	}
+	if value, ok, _ := c.Get(ctx, "a"); !ok || string(value) != "1" {
+		t.Errorf("Expected a hit for a, but got %q %v", value, ok)
+	}
+
+	// setting an existing key replaces its value
+	_ = c.Set(ctx, "a", []byte("4"), time.Minute)
+	if value, _, _ := c.Get(ctx, "a"); string(value) != "4" {
+		t.Errorf("Expected the new value of a, but got %q", value)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package cache
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// VectorStore stores values by the embedding vector of their request and finds the value of the
+// most similar vector, the implementations are safe for concurrent use. The in-memory store is local
+// to a router replica, a vector database can implement the interface to share the entries among the replicas.
+// The entries are partitioned by namespace, a search only matches the entries added with the same namespace.
+type VectorStore interface {
+	// Search returns the value of the stored vector of the namespace with the highest cosine similarity
+	// to vector and the similarity, false when no vector reaches the threshold
+	Search(ctx context.Context, namespace string, vector []float32, threshold float64) ([]byte, float64, bool, error)
+	// Add stores the value of the vector in the namespace for the ttl
+	Add(ctx context.Context, namespace string, vector []float32, value []byte, ttl time.Duration) error
+}
+
+// MemoryVectorStore is an in-memory VectorStore comparing the vector with every entry,
+// the oldest entries are evicted beyond its size
+type MemoryVectorStore struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    []vectorEntry
+	// now is replaced by the tests
+	now func() time.Time
+}
+
+type vectorEntry struct {
+	namespace string
+	vector    []float32
+	norm      float64
+	value     []byte
+	expires   time.Time
+}
+
+// NewMemoryVectorStore creates an in-memory vector store holding up to maxEntries values
+func NewMemoryVectorStore(maxEntries int) *MemoryVectorStore {
+	return &MemoryVectorStore{
+		maxEntries: maxEntries,
+		now:        time.Now,
+	}
+}
+
+// Search implements VectorStore
+func (s *MemoryVectorStore) Search(_ context.Context, namespace string, vector []float32, threshold float64) ([]byte, float64, bool, error) {
+	norm := vectorNorm(vector)
+	if norm == 0 {
+		return nil, 0, false, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	var best []byte
+	bestSimilarity := math.Inf(-1)
+	live := s.entries[:0]
+	for _, e := range s.entries {
+		if !now.Before(e.expires) {
+			continue
+		}
+		live = append(live, e)
+		if e.namespace != namespace || len(e.vector) != len(vector) {
+			// embedded by another model
+			continue
+		}
+		if similarity := dot(vector, e.vector) / (norm * e.norm); similarity > bestSimilarity {
+			best, bestSimilarity = e.value, similarity
+		}
+	}
+	// drop the expired entries, clearing the tail so their values can be collected
+	for i := len(live); i < len(s.entries); i++ {
+		s.entries[i] = vectorEntry{}
+	}
+	s.entries = live
+	if best == nil || bestSimilarity < threshold {
+		return nil, 0, false, nil
+	}
+	return best, bestSimilarity, true, nil
+}
+
+// Add implements VectorStore
+func (s *MemoryVectorStore) Add(_ context.Context, namespace string, vector []float32, value []byte, ttl time.Duration) error {
+	norm := vectorNorm(vector)
+	if norm == 0 {
+		// a zero vector is not similar to anything
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, vectorEntry{
+		namespace: namespace,
+		vector:    vector,
+		norm:      norm,
+		value:     value,
+		expires:   s.now().Add(ttl),
+	})
+	if overflow := len(s.entries) - s.maxEntries; overflow > 0 {
+		s.entries = append(s.entries[:0:0], s.entries[overflow:]...)
+	}
+	return nil
+}
+
+// Len returns the number of entries, the expired ones included until they are evicted
+func (s *MemoryVectorStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+func dot(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+func vectorNorm(v []float32) float64 {
+	return math.Sqrt(dot(v, v))
+}
@@ -0,0 +1,64 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryVectorStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	s := NewMemoryVectorStore(2)
+	s.now = func() time.Time { return now }
+
+	_ = s.Add(ctx, "a", []float32{1, 0, 0}, []byte("x"), time.Minute)
+	_ = s.Add(ctx, "a", []float32{0, 1, 0}, []byte("y"), time.Second)
+
+	// the length of the vector does not matter, only its direction
+	value, similarity, ok, _ := s.Search(ctx, "a", []float32{2, 0.1, 0}, 0.99)
+	if !ok || string(value) != "x" || similarity < 0.99 {
+		t.Errorf("Expected a hit for x, but got %q %v %v", value, similarity, ok)
+	}
+	if value, _, ok, _ := s.Search(ctx, "a", []float32{1, 1, 0}, 0.9); ok {
+		t.Errorf("Expected no vector within the threshold, but got %q", value)
+	}
+	// the best match is returned
+	if value, _, ok, _ := s.Search(ctx, "a", []float32{0.2, 1, 0}, 0.5); !ok || string(value) != "y" {
+		t.Errorf("Expected a hit for y, but got %q %v", value, ok)
+	}
+	// the entries of another namespace never match
+	if value, _, ok, _ := s.Search(ctx, "b", []float32{1, 0, 0}, 0.9); ok {
+		t.Errorf("Expected no hit in another namespace, but got %q", value)
+	}
+	// vectors of another dimension and zero vectors never match
+	if _, _, ok, _ := s.Search(ctx, "a", []float32{1, 0}, 0); ok {
+		t.Errorf("Expected no hit for a vector of another dimension")
+	}
+	if _, _, ok, _ := s.Search(ctx, "a", []float32{0, 0, 0}, 0); ok {
+		t.Errorf("Expected no hit for a zero vector")
+	}
+
+	now = now.Add(2 * time.Second)
+	if _, _, ok, _ := s.Search(ctx, "a", []float32{0, 1, 0}, 0.9); ok {
+		t.Errorf("Expected y to be expired")
+	}
+	if s.Len() != 1 {
+		t.Errorf("Expected 1 entry, but got %d", s.Len())
+	}
+
+	// the oldest entry is evicted beyond the size
+	_ = s.Add(ctx, "a", []float32{0, 0, 1}, []byte("z"), time.Minute)
+	_ = s.Add(ctx, "a", []float32{0, 1, 1}, []byte("w"), time.Minute)
+	if _, _, ok, _ := s.Search(ctx, "a", []float32{1, 0, 0}, 0.9); ok {
+		t.Errorf("Expected x to be evicted")
+	}
+	if s.Len() != 2 {
+		t.Errorf("Expected 2 entries, but got %d", s.Len())
+	}
+}
@@ -0,0 +1,349 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package condition implements the expression language of the step conditions.
+//
+// An expression refers to the request received by the router with $request, to the
+// response of the previous step with $response and to the request headers with $headers:
+//
+//	$request.model == "llama" && len($request.messages.0.content) > 2000
+//	$headers.X-Tenant in ["gold", "silver"] || $response.score >= 0.8
+//	$request.query =~ '(?i)^translate' && !$request.stream
+//
+// The supported operators are ==, !=, <, <=, >, >=, in, =~ (regular expression match),
+// && and || and !, the len() function returns the length of a string, array or object.
+// A reference used as a boolean is true if the value exists and is neither false nor null.
+// The part of a reference after $request. or $response. is a gjson path.
+//
+// Conditions without any $ reference are plain gjson paths, checked as before by the router.
+package condition
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	RequestRef  = "$request"
+	ResponseRef = "$response"
+	HeadersRef  = "$headers"
+)
+
+// Env holds the values a condition is evaluated against
+type Env struct {
+	// Request is the body of the request received by the router
+	Request []byte
+	// Response is the body of the response of the previous step
+	Response []byte
+	// Headers are the headers of the request received by the router
+	Headers http.Header
+}
+
+// Condition is a parsed and type-checked expression, safe for concurrent use
+type Condition struct {
+	expr string
+	root node
+	refs map[string]bool
+}
+
+// IsExpression tells if the condition uses the expression language rather than a plain gjson path
+func IsExpression(condition string) bool {
+	return strings.Contains(condition, "$")
+}
+
+// Parse parses and type-checks an expression
+func Parse(expr string) (*Condition, error) {
+	tokens, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, refs: map[string]bool{}}
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &Condition{expr: expr, root: root, refs: p.refs}, nil
+}
+
+// String returns the source of the expression
+func (c *Condition) String() string {
+	return c.expr
+}
+
+// References tells if the expression refers to $request, $response or $headers
+func (c *Condition) References(ref string) bool {
+	return c.refs[ref]
+}
+
+// Eval evaluates the condition
+func (c *Condition) Eval(env Env) bool {
+	return c.root.eval(&env).truthy()
+}
+
+// kind is the type of a value, kindAny is the static type of the values only known at runtime
+type kind int
+
+const (
+	kindAny kind = iota
+	kindNull
+	kindBool
+	kindNumber
+	kindString
+	kindList
+	kindObject
+)
+
+func (k kind) String() string {
+	switch k {
+	case kindNull:
+		return "null"
+	case kindBool:
+		return "boolean"
+	case kindNumber:
+		return "number"
+	case kindString:
+		return "string"
+	case kindList:
+		return "array"
+	case kindObject:
+		return "object"
+	default:
+		return "any"
+	}
+}
+
+type value struct {
+	kind kind
+	b    bool
+	n    float64
+	s    string
+	list []value
+	// the raw json of an object
+	raw gjson.Result
+}
+
+var null = value{kind: kindNull}
+
+func fromJSON(r gjson.Result) value {
+	switch r.Type {
+	case gjson.False, gjson.True:
+		return value{kind: kindBool, b: r.Bool()}
+	case gjson.Number:
+		return value{kind: kindNumber, n: r.Num}
+	case gjson.String:
+		return value{kind: kindString, s: r.Str}
+	case gjson.JSON:
+		if r.IsArray() {
+			items := r.Array()
+			list := make([]value, 0, len(items))
+			for _, item := range items {
+				list = append(list, fromJSON(item))
+			}
+			return value{kind: kindList, list: list}
+		}
+		return value{kind: kindObject, raw: r}
+	default:
+		return null
+	}
+}
+
+func (v value) truthy() bool {
+	switch v.kind {
+	case kindNull:
+		return false
+	case kindBool:
+		return v.b
+	default:
+		return true
+	}
+}
+
+func (v value) equal(o value) bool {
+	if v.kind != o.kind {
+		return false
+	}
+	switch v.kind {
+	case kindBool:
+		return v.b == o.b
+	case kindNumber:
+		return v.n == o.n
+	case kindString:
+		return v.s == o.s
+	case kindList:
+		if len(v.list) != len(o.list) {
+			return false
+		}
+		for i := range v.list {
+			if !v.list[i].equal(o.list[i]) {
+				return false
+			}
+		}
+		return true
+	case kindObject:
+		return v.raw.Raw == o.raw.Raw
+	default:
+		return true
+	}
+}
+
+func (v value) length() int {
+	switch v.kind {
+	case kindString:
+		return utf8.RuneCountInString(v.s)
+	case kindList:
+		return len(v.list)
+	case kindObject:
+		count := 0
+		v.raw.ForEach(func(_, _ gjson.Result) bool {
+			count++
+			return true
+		})
+		return count
+	default:
+		return 0
+	}
+}
+
+// node is a compiled part of an expression
+type node interface {
+	eval(env *Env) value
+	// kind is the static type of the node
+	kind() kind
+}
+
+type literalNode struct {
+	v value
+}
+
+func (n *literalNode) eval(*Env) value { return n.v }
+func (n *literalNode) kind() kind      { return n.v.kind }
+
+type bodyNode struct {
+	response bool
+	path     string
+}
+
+func (n *bodyNode) eval(env *Env) value {
+	body := env.Request
+	if n.response {
+		body = env.Response
+	}
+	if n.path == "" {
+		if !gjson.ValidBytes(body) {
+			return null
+		}
+		return fromJSON(gjson.ParseBytes(body))
+	}
+	return fromJSON(gjson.GetBytes(body, n.path))
+}
+func (n *bodyNode) kind() kind { return kindAny }
+
+type headerNode struct {
+	name string
+}
+
+func (n *headerNode) eval(env *Env) value {
+	values := env.Headers.Values(n.name)
+	if len(values) == 0 {
+		return null
+	}
+	return value{kind: kindString, s: values[0]}
+}
+func (n *headerNode) kind() kind { return kindString }
+
+type listNode struct {
+	items []node
+}
+
+func (n *listNode) eval(env *Env) value {
+	list := make([]value, 0, len(n.items))
+	for _, item := range n.items {
+		list = append(list, item.eval(env))
+	}
+	return value{kind: kindList, list: list}
+}
+func (n *listNode) kind() kind { return kindList }
+
+type lenNode struct {
+	arg node
+}
+
+func (n *lenNode) eval(env *Env) value {
+	return value{kind: kindNumber, n: float64(n.arg.eval(env).length())}
+}
+func (n *lenNode) kind() kind { return kindNumber }
+
+type notNode struct {
+	arg node
+}
+
+func (n *notNode) eval(env *Env) value {
+	return value{kind: kindBool, b: !n.arg.eval(env).truthy()}
+}
+func (n *notNode) kind() kind { return kindBool }
+
+type logicalNode struct {
+	and         bool
+	left, right node
+}
+
+func (n *logicalNode) eval(env *Env) value {
+	left := n.left.eval(env).truthy()
+	if n.and != left {
+		// short circuit: false && ..., true || ...
+		return value{kind: kindBool, b: left}
+	}
+	return value{kind: kindBool, b: n.right.eval(env).truthy()}
+}
+func (n *logicalNode) kind() kind { return kindBool }
+
+type compareNode struct {
+	op          string
+	left, right node
+	// the compiled pattern of =~
+	re *regexp.Regexp
+}
+
+func (n *compareNode) eval(env *Env) value {
+	left := n.left.eval(env)
+	var result bool
+	switch n.op {
+	case "=~":
+		result = left.kind == kindString && n.re.MatchString(left.s)
+	case "in":
+		right := n.right.eval(env)
+		for _, item := range right.list {
+			if left.equal(item) {
+				result = true
+				break
+			}
+		}
+	case "==":
+		result = left.equal(n.right.eval(env))
+	case "!=":
+		result = !left.equal(n.right.eval(env))
+	default:
+		right := n.right.eval(env)
+		if left.kind != kindNumber || right.kind != kindNumber {
+			break
+		}
+		switch n.op {
+		case "<":
+			result = left.n < right.n
+		case "<=":
+			result = left.n <= right.n
+		case ">":
+			result = left.n > right.n
+		case ">=":
+			result = left.n >= right.n
+		}
+	}
+	return value{kind: kindBool, b: result}
+}
+func (n *compareNode) kind() kind { return kindBool }
@@ -0,0 +1,138 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package condition
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIsExpression(t *testing.T) {
+	if IsExpression(`instances.#(modelId=="1")`) {
+		t.Errorf("a gjson path should not be an expression")
+	}
+	if IsExpression(`modelId=="1"`) {
+		t.Errorf("a flat key==value condition should not be an expression")
+	}
+	if !IsExpression(`$request.modelId == "1"`) {
+		t.Errorf("a condition with a reference should be an expression")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{name: "unknown reference", expr: `$body.model == "llama"`},
+		{name: "unterminated string", expr: `$request.model == "llama`},
+		{name: "missing operand", expr: `$request.model ==`},
+		{name: "trailing token", expr: `$request.model == "a" "b"`},
+		{name: "unbalanced parenthesis", expr: `($request.a && $request.b`},
+		{name: "chained comparison", expr: `1 < $request.n < 5`},
+		{name: "ordering strings", expr: `$request.model > "llama"`},
+		{name: "comparing a string with a number", expr: `"1" == 1`},
+		{name: "comparing a header with a number", expr: `$headers.X-Priority == 1`},
+		{name: "number as boolean", expr: `$request.stream && 1`},
+		{name: "string condition", expr: `"always"`},
+		{name: "regex on a number", expr: `len($request.prompt) =~ "1"`},
+		{name: "regex from a reference", expr: `$request.prompt =~ $request.pattern`},
+		{name: "invalid regex", expr: `$request.prompt =~ "(unclosed"`},
+		{name: "in a string", expr: `$request.model in "llama"`},
+		{name: "len of a number", expr: `len(5) > 1`},
+		{name: "header without name", expr: `$headers == "a"`},
+		{name: "unknown function", expr: `size($request.prompt) > 1`},
+		{name: "unexpected character", expr: `$request.a == 1 ; $request.b`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.expr); err == nil {
+				t.Errorf("Expected an error for %s", tt.expr)
+			}
+		})
+	}
+}
+
+func TestEval(t *testing.T) {
+	env := Env{
+		Request: []byte(`{
+			"model": "llama",
+			"max_tokens": 512,
+			"stream": false,
+			"temperature": 0.7,
+			"messages": [{"role": "user", "content": "Translate this text into French"}],
+			"tags": ["chat", "fr"],
+			"options": {"a": 1, "b": 2}
+		}`),
+		Response: []byte(`{"label": "cat", "score": 0.91}`),
+		Headers:  http.Header{"X-Tenant": {"gold"}},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{expr: `$request.model == "llama"`, want: true},
+		{expr: `$request.model != "llama"`, want: false},
+		{expr: `$request.max_tokens > 256`, want: true},
+		{expr: `$request.max_tokens <= 256`, want: false},
+		{expr: `$request.temperature >= 0.7 && $request.temperature < 1`, want: true},
+		{expr: `$request.model in ["mistral", "llama"]`, want: true},
+		{expr: `"fr" in $request.tags`, want: true},
+		{expr: `"de" in $request.tags`, want: false},
+		{expr: `$request.messages.0.content =~ '(?i)^translate'`, want: true},
+		{expr: `$request.messages.0.content =~ "^\\d+$"`, want: false},
+		{expr: `len($request.messages.0.content) > 20`, want: true},
+		{expr: `len($request.tags) == 2 && len($request.options) == 2`, want: true},
+		{expr: `$request.messages.# == 1`, want: true},
+		{expr: `$request.stream`, want: false},
+		{expr: `!$request.stream`, want: true},
+		{expr: `$request.missing`, want: false},
+		{expr: `$request.missing == null`, want: true},
+		{expr: `$request.model`, want: true},
+		{expr: `$headers.X-Tenant == "gold"`, want: true},
+		{expr: `$headers.x-tenant in ["gold", "silver"]`, want: true},
+		{expr: `$headers.X-Debug`, want: false},
+		{expr: `$response.label == "cat" && $response.score > 0.9`, want: true},
+		{expr: `$response.label == "dog" || $request.model == "llama"`, want: true},
+		{expr: `$response.label == "dog" || ($request.model == "llama" && $request.stream)`, want: false},
+		{expr: `!($response.label == "dog")`, want: true},
+		{expr: `$request.max_tokens > -1e3`, want: true},
+		// the comparison of different types at runtime is false rather than an error
+		{expr: `$request.model > 1`, want: false},
+		{expr: `$request.model == 1`, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			cond, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Failed to parse %s: %v", tt.expr, err)
+			}
+			if got := cond.Eval(env); got != tt.want {
+				t.Errorf("Expected %v for %s, but got %v", tt.want, tt.expr, got)
+			}
+		})
+	}
+}
+
+func TestEvalWithoutValues(t *testing.T) {
+	cond, err := Parse(`$response.score > 0.5 || $headers.X-Tenant == "gold"`)
+	if err != nil {
+		t.Fatalf("Failed to parse the condition: %v", err)
+	}
+	if cond.Eval(Env{}) {
+		t.Errorf("Expected the condition to be false without any value")
+	}
+}
+
+func TestReferences(t *testing.T) {
+	cond, err := Parse(`$response.label == "cat" && $headers.X-Tenant == "gold"`)
+	if err != nil {
+		t.Fatalf("Failed to parse the condition: %v", err)
+	}
+	if !cond.References(ResponseRef) || !cond.References(HeadersRef) || cond.References(RequestRef) {
+		t.Errorf("Unexpected references of %s", cond)
+	}
+}
@@ -0,0 +1,390 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package condition
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokString
+	tokNumber
+	tokIdent
+	tokRef
+	tokOp
+)
+
+type token struct {
+	typ  tokenType
+	text string
+	pos  int
+	// the unquoted string or the parsed number
+	str string
+	num float64
+}
+
+// the operators and punctuation, the two-character ones first
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "<", ">", "!", "(", ")", "[", "]", ","}
+
+var comparisonOperators = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "=~": true}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// isPathChar tells if a character can be part of the gjson path of a reference,
+// gjson queries and modifiers are not supported in expressions
+func isPathChar(c byte) bool {
+	return isIdentChar(c) || c == '-' || c == '.' || c == '#' || c == '*' || c == '?'
+}
+
+func lex(expr string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(expr) && expr[end] != c {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			text := expr[i : end+1]
+			var str string
+			if c == '"' {
+				var err error
+				if str, err = strconv.Unquote(text); err != nil {
+					return nil, fmt.Errorf("invalid string %s at position %d", text, i)
+				}
+			} else {
+				// single quoted strings are raw, which suits regular expressions
+				str = strings.ReplaceAll(text[1:len(text)-1], `\'`, `'`)
+			}
+			tokens = append(tokens, token{typ: tokString, text: text, pos: i, str: str})
+			i = end + 1
+		case c == '$':
+			end := i + 1
+			for end < len(expr) && isIdentChar(expr[end]) {
+				end++
+			}
+			ref := expr[i:end]
+			if ref != RequestRef && ref != ResponseRef && ref != HeadersRef {
+				return nil, fmt.Errorf("unknown reference %s at position %d, expected %s, %s or %s",
+					ref, i, RequestRef, ResponseRef, HeadersRef)
+			}
+			if end < len(expr) && expr[end] == '.' {
+				end++
+				for end < len(expr) && isPathChar(expr[end]) {
+					end++
+				}
+			}
+			tokens = append(tokens, token{typ: tokRef, text: expr[i:end], pos: i})
+			i = end
+		case c == '-' || c == '.' || ('0' <= c && c <= '9'):
+			end := i + 1
+			for end < len(expr) && (('0' <= expr[end] && expr[end] <= '9') || strings.IndexByte(".eE", expr[end]) >= 0 ||
+				((expr[end] == '+' || expr[end] == '-') && (expr[end-1] == 'e' || expr[end-1] == 'E'))) {
+				end++
+			}
+			num, err := strconv.ParseFloat(expr[i:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %s at position %d", expr[i:end], i)
+			}
+			tokens = append(tokens, token{typ: tokNumber, text: expr[i:end], pos: i, num: num})
+			i = end
+		case isIdentChar(c):
+			end := i + 1
+			for end < len(expr) && isIdentChar(expr[end]) {
+				end++
+			}
+			tokens = append(tokens, token{typ: tokIdent, text: expr[i:end], pos: i})
+			i = end
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(expr[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at position %d", c, i)
+			}
+			tokens = append(tokens, token{typ: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{typ: tokEOF, pos: len(expr)}), nil
+}
+
+// parser is a recursive descent parser, from the lowest precedence:
+//
+//	or         := and ("||" and)*
+//	and        := unary ("&&" unary)*
+//	unary      := "!" unary | comparison
+//	comparison := operand [("==" | "!=" | "<" | "<=" | ">" | ">=" | "=~" | "in") operand]
+//	operand    := literal | reference | "len" "(" or ")" | "[" [operand ("," operand)*] "]" | "(" or ")"
+type parser struct {
+	tokens []token
+	pos    int
+	refs   map[string]bool
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOp(text string) bool {
+	t := p.peek()
+	return t.typ == tokOp && t.text == text
+}
+
+func (p *parser) expect(text string) error {
+	if !p.isOp(text) {
+		return p.errorf(p.peek(), "expected %q", text)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	found := t.text
+	if t.typ == tokEOF {
+		found = "end of expression"
+	}
+	return fmt.Errorf("%s, found %s at position %d", fmt.Sprintf(format, args...), found, t.pos)
+}
+
+func (p *parser) parse() (node, error) {
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokEOF {
+		return nil, p.errorf(t, "unexpected token")
+	}
+	if err := p.checkBool(root, p.tokens[0]); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// checkBool makes sure the node can be used as a boolean,
+// literals other than true and false are most likely a mistake
+func (p *parser) checkBool(n node, t token) error {
+	switch n.kind() {
+	case kindBool, kindAny:
+		return nil
+	}
+	if _, ok := n.(*headerNode); ok {
+		return nil
+	}
+	return fmt.Errorf("a %s cannot be used as a boolean at position %d", n.kind(), t.pos)
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.parseLogical("||", p.parseAnd)
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.parseLogical("&&", p.parseUnary)
+}
+
+func (p *parser) parseLogical(op string, operand func() (node, error)) (node, error) {
+	start := p.peek()
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(op) {
+		p.next()
+		if err := p.checkBool(left, start); err != nil {
+			return nil, err
+		}
+		start = p.peek()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkBool(right, start); err != nil {
+			return nil, err
+		}
+		left = &logicalNode{and: op == "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if !p.isOp("!") {
+		return p.parseComparison()
+	}
+	p.next()
+	start := p.peek()
+	arg, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkBool(arg, start); err != nil {
+		return nil, err
+	}
+	return &notNode{arg: arg}, nil
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	var op string
+	switch {
+	case t.typ == tokOp && comparisonOperators[t.text]:
+		op = t.text
+	case t.typ == tokIdent && t.text == "in":
+		op = "in"
+	default:
+		return left, nil
+	}
+	p.next()
+	rightToken := p.peek()
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	n := &compareNode{op: op, left: left, right: right}
+	lk, rk := left.kind(), right.kind()
+	switch op {
+	case "<", "<=", ">", ">=":
+		if (lk != kindAny && lk != kindNumber) || (rk != kindAny && rk != kindNumber) {
+			return nil, fmt.Errorf("operator %s compares numbers, not a %s and a %s at position %d", op, lk, rk, t.pos)
+		}
+	case "==", "!=":
+		if lk != kindAny && rk != kindAny && lk != kindNull && rk != kindNull && lk != rk {
+			return nil, fmt.Errorf("operator %s compares a %s with a %s, which is never equal at position %d", op, lk, rk, t.pos)
+		}
+	case "=~":
+		if lk != kindAny && lk != kindString {
+			return nil, fmt.Errorf("operator =~ matches a string, not a %s at position %d", lk, t.pos)
+		}
+		pattern, ok := right.(*literalNode)
+		if !ok || rk != kindString {
+			return nil, p.errorf(rightToken, "operator =~ expects a regular expression string")
+		}
+		if n.re, err = regexp.Compile(pattern.v.s); err != nil {
+			return nil, fmt.Errorf("invalid regular expression at position %d: %v", rightToken.pos, err)
+		}
+	case "in":
+		if rk != kindAny && rk != kindList {
+			return nil, fmt.Errorf("operator in looks up an array, not a %s at position %d", rk, t.pos)
+		}
+	}
+	if next := p.peek(); (next.typ == tokOp && comparisonOperators[next.text]) || (next.typ == tokIdent && next.text == "in") {
+		return nil, p.errorf(next, "comparisons cannot be chained, use && instead")
+	}
+	return n, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	t := p.next()
+	switch t.typ {
+	case tokString:
+		return &literalNode{v: value{kind: kindString, s: t.str}}, nil
+	case tokNumber:
+		return &literalNode{v: value{kind: kindNumber, n: t.num}}, nil
+	case tokRef:
+		return p.parseRef(t)
+	case tokIdent:
+		switch t.text {
+		case "true", "false":
+			return &literalNode{v: value{kind: kindBool, b: t.text == "true"}}, nil
+		case "null":
+			return &literalNode{v: null}, nil
+		case "len":
+			if err := p.expect("("); err != nil {
+				return nil, err
+			}
+			argToken := p.peek()
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			switch arg.kind() {
+			case kindAny, kindString, kindList, kindObject:
+			default:
+				return nil, fmt.Errorf("len() takes a string, an array or an object, not a %s at position %d", arg.kind(), argToken.pos)
+			}
+			return &lenNode{arg: arg}, nil
+		}
+	case tokOp:
+		switch t.text {
+		case "(":
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			list := &listNode{}
+			for !p.isOp("]") {
+				if len(list.items) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				item, err := p.parseOperand()
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, item)
+			}
+			p.next()
+			return list, nil
+		}
+	}
+	return nil, p.errorf(t, "expected a value")
+}
+
+func (p *parser) parseRef(t token) (node, error) {
+	ref, path, _ := strings.Cut(t.text, ".")
+	p.refs[ref] = true
+	switch ref {
+	case HeadersRef:
+		if path == "" || strings.ContainsAny(path, ".#*?") {
+			return nil, fmt.Errorf("%s needs a header name, e.g. %s.X-Model at position %d", HeadersRef, HeadersRef, t.pos)
+		}
+		return &headerNode{name: path}, nil
+	case ResponseRef:
+		return &bodyNode{response: true, path: path}, nil
+	default:
+		return &bodyNode{path: path}, nil
+	}
+}
@@ -7,11 +7,13 @@ package controller
 
 import (
 	"bytes"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func parsePEM(pemBytes []byte) (*x509.Certificate, error) {
@@ -205,3 +207,46 @@ func Test_composeDNSNames(t *testing.T) {
 		})
 	}
 }
+
+func Test_certificateAuthority(t *testing.T) {
+	now := time.Now()
+	ca, err := newCertificateAuthority("chatqa.default", 24*time.Hour, now)
+	if err != nil {
+		t.Fatalf("newCertificateAuthority() error = %v", err)
+	}
+	loaded, err := parseCertificateAuthority(ca.certPEM, ca.keyPEM)
+	if err != nil {
+		t.Fatalf("parseCertificateAuthority() error = %v", err)
+	}
+
+	dnsNames := serviceDNSNames("llm", "default")
+	certPEM, keyPEM, err := loaded.issue("llm.default.svc", dnsNames, 48*time.Hour, now)
+	if err != nil {
+		t.Fatalf("issue() error = %v", err)
+	}
+	if err := verifyCert(ca.certPEM, certPEM); err != nil {
+		t.Errorf("issue() error = %v", err)
+	}
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		t.Errorf("issue() returned an invalid key pair: %v", err)
+	}
+
+	// the certificate expires with the CA at the latest
+	renewAt := certRenewalTime(certPEM, ca.cert, dnsNames, time.Hour)
+	if want := ca.cert.NotAfter.Add(-time.Hour); !renewAt.Equal(want) {
+		t.Errorf("certRenewalTime() = %v, want %v", renewAt, want)
+	}
+	if renewAt := certRenewalTime(certPEM, ca.cert, serviceDNSNames("tgi", "default"), time.Hour); !renewAt.IsZero() {
+		t.Errorf("certRenewalTime() = %v for other names, want the zero time", renewAt)
+	}
+	other, err := newCertificateAuthority("chatqa.default", 24*time.Hour, now)
+	if err != nil {
+		t.Fatalf("newCertificateAuthority() error = %v", err)
+	}
+	if renewAt := certRenewalTime(certPEM, other.cert, dnsNames, time.Hour); !renewAt.IsZero() {
+		t.Errorf("certRenewalTime() = %v for another CA, want the zero time", renewAt)
+	}
+	if _, err := parseCertificateAuthority(certPEM, keyPEM); err == nil {
+		t.Errorf("parseCertificateAuthority() accepted a certificate which is not a CA")
+	}
+}
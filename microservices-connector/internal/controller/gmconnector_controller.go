@@ -12,6 +12,7 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
@@ -62,9 +63,13 @@ const (
 	yaml_dir                 = "/tmp/microservices/yamls/"
 	Service                  = "Service"
 	Deployment               = "Deployment"
+	ConfigMap                = "ConfigMap"
+	routerGraphKey           = "graph.json"
+	routerMetricsPort        = "8080"
+	routerMetricsPath        = "/metrics"
 	dplymtSubfix             = "-deployment"
 	METADATA_PLATFORM        = "gmc/platform"
-	DefaultRouterServiceName = "router-service"
+	DefaultRouterServiceName = mcv1alpha3.DefaultRouterServiceName
 	ASR                      = "Asr"
 	TTS                      = "Tts"
 	SpeechT5                 = "SpeechT5"
@@ -116,7 +121,6 @@ type RouterCfg struct {
 	NoProxy     string
 	HttpProxy   string
 	HttpsProxy  string
-	GRAPH_JSON  string
 }
 
 func lookupManifestDir(step string) string {
@@ -128,7 +132,9 @@ func lookupManifestDir(step string) string {
 	}
 }
 
-func (r *GMConnectorReconciler) reconcileResource(ctx context.Context, graphNs string, stepCfg *mcv1alpha3.Step, nodeCfg *mcv1alpha3.Router, graph *mcv1alpha3.GMConnector) ([]*unstructured.Unstructured, error) {
+// reconcileResource applies the resources of the service of a step, the issuer is set when the router
+// reaches the service over mutual TLS
+func (r *GMConnectorReconciler) reconcileResource(ctx context.Context, graphNs string, stepCfg *mcv1alpha3.Step, nodeCfg *mcv1alpha3.Router, graph *mcv1alpha3.GMConnector, issuer *certIssuer) ([]*unstructured.Unstructured, error) {
 	if stepCfg == nil || nodeCfg == nil {
 		return nil, errors.New("invalid svc config")
 	}
@@ -151,6 +157,16 @@ func (r *GMConnectorReconciler) reconcileResource(ctx context.Context, graphNs s
 		return nil, err
 	}
 
+	// name of the Service the router calls
+	serviceName := svc
+	if issuer != nil && serviceName == "" {
+		serviceName, _, err = getServiceDetailsFromManifests(lookupManifestDir(stepCfg.StepName))
+		if err != nil {
+			_log.Error(err, "Failed to find the service of", "step", stepCfg.StepName)
+			return nil, err
+		}
+	}
+
 	resources := strings.Split(string(yamlFile), "---")
 	for _, res := range resources {
 		if res == "" || !strings.Contains(res, "kind:") {
@@ -235,6 +251,13 @@ func (r *GMConnectorReconciler) reconcileResource(ctx context.Context, graphNs s
 			}
 		}
 
+		if issuer != nil {
+			if err = issuer.secure(ctx, obj, serviceName); err != nil {
+				_log.Error(err, "Failed to serve the service over mutual TLS", "name", obj.GetName())
+				return nil, err
+			}
+		}
+
 		err = r.applyResourceToK8s(graph, ctx, obj)
 		if err != nil {
 			_log.Error(err, "Failed to reconcile resource", "name", obj.GetName())
@@ -304,6 +327,12 @@ func getDownstreamSvcEndpoint(graphNs string, dsName string, stepCfg *mcv1alpha3
 func getServiceURL(service *corev1.Service) string {
 	switch service.Spec.Type {
 	case corev1.ServiceTypeClusterIP:
+		// the services with the port of the mTLS proxy sidecar are served over HTTPS
+		for _, port := range service.Spec.Ports {
+			if port.Name == mtlsPortName {
+				return fmt.Sprintf("https://%s.%s.svc.cluster.local:%d", service.Name, service.Namespace, port.Port)
+			}
+		}
 		// For ClusterIP, return the cluster IP and port
 		if len(service.Spec.Ports) > 0 {
 			return fmt.Sprintf("http://%s.%s.svc.cluster.local:%d", service.Name, service.Namespace, service.Spec.Ports[0].Port)
@@ -382,18 +411,36 @@ func (r *GMConnectorReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		graph.Status.Annotations = make(map[string]string)
 	}
 
+	var issuer *certIssuer
+	if graph.Spec.MTLS != nil {
+		var err error
+		issuer, err = r.newCertIssuer(ctx, graph, time.Now())
+		if err != nil {
+			return reconcile.Result{Requeue: true}, errors.Wrapf(err, "Failed to load the certificate authority of %s", graph.Name)
+		}
+	}
+
 	for nodeName, node := range graph.Spec.Nodes {
 		for i, step := range node.Steps {
 			if step.NodeName != "" {
 				_log.Info("This is a nested step", "step", step.StepName)
 				continue
 			}
+			if step.StepName == mcv1alpha3.SemanticCacheStep {
+				_log.Info("This step is executed by the router", "step", step.StepName)
+				continue
+			}
 			_log.Info("Reconcile step", "graph", graph.Name, "name", step.StepName)
 			totalService += 1
 			if step.Executor.ExternalService == "" {
 				_log.Info("Trying to reconcile internal service", " service", step.Executor.InternalService.ServiceName)
 
-				objs, err := r.reconcileResource(ctx, graph.Namespace, &step, &node, graph)
+				// the router does not call the downstream services
+				stepIssuer := issuer
+				if step.InternalService.IsDownstreamService {
+					stepIssuer = nil
+				}
+				objs, err := r.reconcileResource(ctx, graph.Namespace, &step, &node, graph, stepIssuer)
 				if err != nil {
 					return reconcile.Result{Requeue: true}, errors.Wrapf(err, "Failed to reconcile service for %s", step.StepName)
 				}
@@ -410,13 +457,36 @@ func (r *GMConnectorReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 				graph.Spec.Nodes[nodeName].Steps[i].ServiceURL = step.ExternalService
 				externalService += 1
 			}
+
+			if step.Fallback != nil && step.Fallback.NodeName == "" {
+				if step.Fallback.ExternalService == "" {
+					// the fallback service is deployed from the same template as the step
+					fallbackStep := step.DeepCopy()
+					fallbackStep.InternalService = *step.Fallback.InternalService.DeepCopy()
+					_log.Info("Trying to reconcile fallback service", " service", fallbackStep.InternalService.ServiceName)
+					objs, err := r.reconcileResource(ctx, graph.Namespace, fallbackStep, &node, graph, issuer)
+					if err != nil {
+						return reconcile.Result{Requeue: true}, errors.Wrapf(err, "Failed to reconcile fallback service for %s", step.StepName)
+					}
+					for _, obj := range objs {
+						err := recordFallbackResource(graph, nodeName, i, obj)
+						if err != nil {
+							return reconcile.Result{Requeue: true}, errors.Wrapf(err, "Fallback resource created with failure %s", step.StepName)
+						}
+					}
+				} else {
+					_log.Info("External fallback service is found", "name", step.Fallback.ExternalService)
+					graph.Spec.Nodes[nodeName].Steps[i].Fallback.ServiceURL = step.Fallback.ExternalService
+					externalService += 1
+				}
+			}
 		}
 	}
 
 	//to start a router service
 	//in case the graph changes, we need to apply the changes to router service
 	//so we need to apply the router config every time
-	err := r.reconcileRouterService(ctx, graph)
+	err := r.reconcileRouterService(ctx, graph, issuer)
 	if err != nil {
 		return reconcile.Result{Requeue: true}, errors.Wrapf(err, "Failed to reconcile router service")
 	}
@@ -437,6 +507,10 @@ func (r *GMConnectorReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return reconcile.Result{Requeue: true}, errors.Wrapf(err, "Failed to collect service status")
 	}
 
+	if issuer != nil {
+		// come back to renew the certificates before they expire
+		return ctrl.Result{RequeueAfter: issuer.requeueAfter()}, nil
+	}
 	return ctrl.Result{}, nil
 }
 
@@ -569,6 +643,26 @@ func recordResource(graph *mcv1alpha3.GMConnector, nodeName string, stepIdx int,
 	return nil
 }
 
+func recordFallbackResource(graph *mcv1alpha3.GMConnector, nodeName string, stepIdx int, obj *unstructured.Unstructured) error {
+	key := fmt.Sprintf("%s:%s:%s:%s", obj.GetKind(), obj.GetAPIVersion(), obj.GetName(), obj.GetNamespace())
+	graph.Status.Annotations[key] = "provisioned"
+
+	if obj.GetKind() == Service {
+		service := &corev1.Service{}
+		err := scheme.Scheme.Convert(obj, service, nil)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to convert service %s", obj.GetName())
+		}
+		fallback := graph.Spec.Nodes[nodeName].Steps[stepIdx].Fallback
+		url := getServiceURL(service) + fallback.InternalService.Config["endpoint"]
+		//set this for router
+		fallback.ServiceURL = url
+		graph.Status.Annotations[key] = url
+		_log.Info("Fallback service URL is: ", "URL", url)
+	}
+	return nil
+}
+
 func getTemplateBytes(resourceType string) ([]byte, error) {
 	tmpltFile := lookupManifestDir(resourceType)
 	if tmpltFile == "" {
@@ -581,40 +675,31 @@ func getTemplateBytes(resourceType string) ([]byte, error) {
 	return yamlBytes, nil
 }
 
-func (r *GMConnectorReconciler) reconcileRouterService(ctx context.Context, graph *mcv1alpha3.GMConnector) error {
+func (r *GMConnectorReconciler) reconcileRouterService(ctx context.Context, graph *mcv1alpha3.GMConnector, issuer *certIssuer) error {
 	configForRouter := make(map[string]string)
 
-	var routerNs string
-	var routerServiceName string
-	var routerDeploymentName string
 	var graphJson mcv1alpha3.GMConnector
 	graphJson.Spec = *graph.Spec.DeepCopy()
 
+	routerServiceName, routerNs := routerServiceOf(graph)
+	routerDeploymentName := routerServiceName + dplymtSubfix
+	configForRouter["namespace"] = routerNs
+	configForRouter["svcName"] = routerServiceName
+	configForRouter["dplymntName"] = routerDeploymentName
+
+	if issuer != nil {
+		if err := issuer.secureRouter(ctx, &graphJson, routerServiceName, routerNs); err != nil {
+			return errors.Wrapf(err, "Failed to issue the certificate of router %s", routerServiceName)
+		}
+	}
 	jsonBytes, err := json.Marshal(graphJson)
 	if err != nil {
 		// handle error
 		return errors.Wrapf(err, "Failed to Marshal routes for %s", graph.Spec.RouterConfig.Name)
 	}
+	// the graph is handed to the router through a ConfigMap which the router watches,
+	// so a change of the graph does not restart the router pod
 	jsonString := string(jsonBytes)
-	escapedString := strings.ReplaceAll(jsonString, "'", "\\'")
-	configForRouter["nodes"] = "'" + escapedString + "'"
-
-	if graph.Spec.RouterConfig.NameSpace != "" {
-		routerNs = graph.Spec.RouterConfig.NameSpace
-	} else {
-		routerNs = graph.Namespace
-	}
-	configForRouter["namespace"] = routerNs
-
-	if graph.Spec.RouterConfig.ServiceName != "" {
-		routerServiceName = graph.Spec.RouterConfig.ServiceName
-		routerDeploymentName = graph.Spec.RouterConfig.ServiceName + dplymtSubfix
-	} else {
-		routerServiceName = DefaultRouterServiceName
-		routerDeploymentName = DefaultRouterServiceName + dplymtSubfix
-	}
-	configForRouter["svcName"] = routerServiceName
-	configForRouter["dplymntName"] = routerDeploymentName
 
 	templateBytes, err := getTemplateBytes(Router)
 	if err != nil {
@@ -640,6 +725,21 @@ func (r *GMConnectorReconciler) reconcileRouterService(ctx context.Context, grap
 			return err
 		}
 
+		if obj.GetKind() == ConfigMap {
+			if err = unstructured.SetNestedField(obj.Object, jsonString, "data", routerGraphKey); err != nil {
+				_log.Error(err, "Failed to set the graph for router", "name", obj.GetName())
+				return err
+			}
+		}
+		if err = addScrapeAnnotations(obj); err != nil {
+			_log.Error(err, "Failed to set the scrape annotations for router", "name", obj.GetName())
+			return err
+		}
+		if err = addRouterSecretVolumes(obj, &graphJson); err != nil {
+			_log.Error(err, "Failed to mount the secrets into router", "name", obj.GetName())
+			return err
+		}
+
 		err = r.applyResourceToK8s(graph, ctx, obj)
 		if err != nil {
 			_log.Error(err, "Failed to reconcile resource", "name", obj.GetName())
@@ -658,6 +758,133 @@ func (r *GMConnectorReconciler) reconcileRouterService(ctx context.Context, grap
 	return nil
 }
 
+// addScrapeAnnotations lets Prometheus discover the metrics of the router
+// through its Service and the pods of its Deployment
+func addScrapeAnnotations(obj *unstructured.Unstructured) error {
+	scrapeAnnotations := map[string]string{
+		"prometheus.io/scrape": "true",
+		"prometheus.io/port":   routerMetricsPort,
+		"prometheus.io/path":   routerMetricsPath,
+	}
+	var fields []string
+	switch obj.GetKind() {
+	case Service:
+		fields = []string{"metadata", "annotations"}
+	case Deployment:
+		fields = []string{"spec", "template", "metadata", "annotations"}
+	default:
+		return nil
+	}
+	annotations, _, err := unstructured.NestedStringMap(obj.Object, fields...)
+	if err != nil {
+		return err
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	for key, value := range scrapeAnnotations {
+		annotations[key] = value
+	}
+	return unstructured.SetNestedStringMap(obj.Object, annotations, fields...)
+}
+
+// routerSecretsDir is where the Secrets referenced by the steps are mounted in the router, a directory per Secret
+const routerSecretsDir = "/etc/gmc-secrets"
+
+// routerSecrets returns the Secrets the header policies and the transports of the steps
+// read from, a Secret is optional when all the references to it are
+func routerSecrets(graph *mcv1alpha3.GMConnector) map[string]bool {
+	secrets := map[string]bool{}
+	add := func(name string, optional bool) {
+		if current, ok := secrets[name]; ok {
+			optional = optional && current
+		}
+		secrets[name] = optional
+	}
+	for _, node := range graph.Spec.Nodes {
+		for _, step := range node.Steps {
+			if step.Headers != nil {
+				for _, header := range step.Headers.Set {
+					if header.SecretKeyRef != nil {
+						add(header.SecretKeyRef.Name, header.SecretKeyRef.Optional != nil && *header.SecretKeyRef.Optional)
+					}
+				}
+			}
+			if step.Transport != nil && step.Transport.TLS != nil {
+				tls := step.Transport.TLS
+				if tls.CASecretRef != nil {
+					add(tls.CASecretRef.Name, tls.CASecretRef.Optional != nil && *tls.CASecretRef.Optional)
+				}
+				if tls.ClientCertSecretName != "" {
+					add(tls.ClientCertSecretName, false)
+				}
+			}
+		}
+	}
+	return secrets
+}
+
+// addRouterSecretVolumes mounts the Secrets referenced by the steps into the router container,
+// so the router reads the values without access to the Kubernetes API
+func addRouterSecretVolumes(obj *unstructured.Unstructured, graph *mcv1alpha3.GMConnector) error {
+	if obj.GetKind() != Deployment {
+		return nil
+	}
+	secrets := routerSecrets(graph)
+	if len(secrets) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	podSpec := []string{"spec", "template", "spec"}
+	volumes, _, err := unstructured.NestedSlice(obj.Object, append(podSpec, "volumes")...)
+	if err != nil {
+		return err
+	}
+	containers, _, err := unstructured.NestedSlice(obj.Object, append(podSpec, "containers")...)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("the router deployment %s has no container", obj.GetName())
+	}
+	container, ok := containers[0].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid container in the router deployment %s", obj.GetName())
+	}
+	mounts, _, err := unstructured.NestedSlice(container, "volumeMounts")
+	if err != nil {
+		return err
+	}
+	for i, name := range names {
+		volumeName := fmt.Sprintf("router-secret-%d", i)
+		volumes = append(volumes, map[string]interface{}{
+			"name": volumeName,
+			"secret": map[string]interface{}{
+				"secretName": name,
+				"optional":   secrets[name],
+			},
+		})
+		mounts = append(mounts, map[string]interface{}{
+			"name":      volumeName,
+			"mountPath": routerSecretsDir + "/" + name,
+			"readOnly":  true,
+		})
+	}
+	if err := unstructured.SetNestedSlice(container, mounts, "volumeMounts"); err != nil {
+		return err
+	}
+	containers[0] = container
+	if err := unstructured.SetNestedSlice(obj.Object, containers, append(podSpec, "containers")...); err != nil {
+		return err
+	}
+	return unstructured.SetNestedSlice(obj.Object, volumes, append(podSpec, "volumes")...)
+}
+
 func applyRouterConfigToTemplates(step string, svcCfg *map[string]string, yamlFile []byte) (string, error) {
 	var userDefinedCfg RouterCfg
 	if step == "router" {
@@ -667,8 +894,7 @@ func applyRouterConfigToTemplates(step string, svcCfg *map[string]string, yamlFi
 			DplymntName: (*svcCfg)["dplymntName"],
 			NoProxy:     (*svcCfg)["no_proxy"],
 			HttpProxy:   (*svcCfg)["http_proxy"],
-			HttpsProxy:  (*svcCfg)["https_proxy"],
-			GRAPH_JSON:  (*svcCfg)["nodes"]}
+			HttpsProxy:  (*svcCfg)["https_proxy"]}
 		_log.V(1).Info("Apply the config to router", "content", userDefinedCfg)
 
 		tmpl, err := template.New("yamlTemplate").Parse(string(yamlFile))
@@ -7,6 +7,8 @@ package controller
 
 import (
 	"context"
+	"path/filepath"
+	"reflect"
 	"testing"
 	"time"
 
@@ -20,6 +22,8 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 
 	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
 )
@@ -325,10 +329,17 @@ var _ = Describe("GMConnector Controller", func() {
 				Namespace: "default",
 			}, &appsv1.Deployment{})).To(Succeed())
 
+			routerGraph := &corev1.ConfigMap{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{
+				Name:      "router-service-graph",
+				Namespace: "default",
+			}, routerGraph)).To(Succeed())
+			Expect(routerGraph.Data[routerGraphKey]).To(ContainSubstring("embedding-service"))
+
 			pipeline := &mcv1alpha3.GMConnector{}
 			Expect(k8sClient.Get(ctx, typeNamespacedName, pipeline)).To(Succeed())
 			Expect(pipeline.Status.Status).To(Equal("0/0/9"))
-			Expect(len(pipeline.Status.Annotations)).To(Equal(25))
+			Expect(len(pipeline.Status.Annotations)).To(Equal(26))
 
 		})
 
@@ -592,7 +603,7 @@ var _ = Describe("GMConnector Controller", func() {
 			pipeline := &mcv1alpha3.GMConnector{}
 			Expect(k8sClient.Get(ctx, typeNamespacedName, pipeline)).To(Succeed())
 			Expect(pipeline.Status.Status).To(Equal("0/0/5"))
-			Expect(len(pipeline.Status.Annotations)).To(Equal(13))
+			Expect(len(pipeline.Status.Annotations)).To(Equal(14))
 		})
 	})
 })
@@ -854,6 +865,13 @@ func TestGetServiceURL(t *testing.T) {
 	if actualURL != expectedURL {
 		t.Errorf("Expected URL: %s, but got: %s", expectedURL, actualURL)
 	}
+
+	// the router reaches the services with the mTLS proxy sidecar over HTTPS
+	service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{Name: mtlsPortName, Port: mtlsPort})
+	expectedURL = "https://test-service.default.svc.cluster.local:8443"
+	if actualURL := getServiceURL(service); actualURL != expectedURL {
+		t.Errorf("Expected URL: %s, but got: %s", expectedURL, actualURL)
+	}
 }
 func TestIsMetadataChanged(t *testing.T) {
 	oldObject := &metav1.ObjectMeta{
@@ -991,3 +1009,191 @@ func TestIsMetadataChanged(t *testing.T) {
 		t.Errorf("Expected metadata changes to not be detected, but got true")
 	}
 }
+
+func TestRecordFallbackResource(t *testing.T) {
+	graph := &mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {
+					RouterType: mcv1alpha3.Sequence,
+					Steps: []mcv1alpha3.Step{
+						{
+							StepName: Llm,
+							Fallback: &mcv1alpha3.Fallback{
+								Executor: mcv1alpha3.Executor{
+									InternalService: mcv1alpha3.GMCTarget{
+										ServiceName: "llm-fallback",
+										Config: map[string]string{
+											"endpoint": "/v1/chat/completions",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		Status: mcv1alpha3.GMConnectorStatus{
+			Annotations: map[string]string{},
+		},
+	}
+	service := &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "v1",
+			Kind:       Service,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "llm-fallback",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:  corev1.ServiceTypeClusterIP,
+			Ports: []corev1.ServicePort{{Port: 9000}},
+		},
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(service)
+	if err != nil {
+		t.Fatalf("failed to convert service: %v", err)
+	}
+
+	if err := recordFallbackResource(graph, "root", 0, &unstructured.Unstructured{Object: obj}); err != nil {
+		t.Fatalf("failed to record fallback resource: %v", err)
+	}
+
+	expectedURL := "http://llm-fallback.default.svc.cluster.local:9000/v1/chat/completions"
+	if url := graph.Spec.Nodes["root"].Steps[0].Fallback.ServiceURL; url != expectedURL {
+		t.Errorf("Expected fallback URL: %s, but got: %s", expectedURL, url)
+	}
+	if graph.Spec.Nodes["root"].Steps[0].ServiceURL != "" {
+		t.Errorf("The service URL of the step should not be changed")
+	}
+	if annotation := graph.Status.Annotations["Service:v1:llm-fallback:default"]; annotation != expectedURL {
+		t.Errorf("Expected annotation: %s, but got: %s", expectedURL, annotation)
+	}
+}
+
+func TestAddScrapeAnnotations(t *testing.T) {
+	service := &unstructured.Unstructured{Object: map[string]interface{}{
+		"kind": Service,
+		"metadata": map[string]interface{}{
+			"name":        "router-service",
+			"annotations": map[string]interface{}{"owner": "gmc"},
+		},
+	}}
+	if err := addScrapeAnnotations(service); err != nil {
+		t.Fatalf("failed to add scrape annotations: %v", err)
+	}
+	annotations := service.GetAnnotations()
+	if annotations["prometheus.io/scrape"] != "true" || annotations["prometheus.io/port"] != "8080" ||
+		annotations["prometheus.io/path"] != "/metrics" {
+		t.Errorf("Expected scrape annotations on the service, but got: %v", annotations)
+	}
+	if annotations["owner"] != "gmc" {
+		t.Errorf("The existing annotations of the service should be kept, but got: %v", annotations)
+	}
+
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"kind":     Deployment,
+		"metadata": map[string]interface{}{"name": "router-service-deployment"},
+	}}
+	if err := addScrapeAnnotations(deployment); err != nil {
+		t.Fatalf("failed to add scrape annotations: %v", err)
+	}
+	podAnnotations, _, _ := unstructured.NestedStringMap(deployment.Object, "spec", "template", "metadata", "annotations")
+	if podAnnotations["prometheus.io/scrape"] != "true" {
+		t.Errorf("Expected scrape annotations on the pod template, but got: %v", podAnnotations)
+	}
+
+	configMap := &unstructured.Unstructured{Object: map[string]interface{}{
+		"kind":     ConfigMap,
+		"metadata": map[string]interface{}{"name": "router-service-graph"},
+	}}
+	if err := addScrapeAnnotations(configMap); err != nil {
+		t.Fatalf("failed to add scrape annotations: %v", err)
+	}
+	if len(configMap.GetAnnotations()) != 0 {
+		t.Errorf("The config map should not be annotated, but got: %v", configMap.GetAnnotations())
+	}
+}
+
+func TestAddRouterSecretVolumes(t *testing.T) {
+	optional := true
+	secretRef := func(name string, optional *bool) *corev1.SecretKeySelector {
+		return &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: name}, Key: "key", Optional: optional}
+	}
+	graph := &mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {Steps: []mcv1alpha3.Step{
+					{StepName: "Llm", Headers: &mcv1alpha3.HeaderPolicy{Set: []mcv1alpha3.HeaderValue{
+						{Name: "Authorization", SecretKeyRef: secretRef("llm-api", nil)},
+						{Name: "X-Org", SecretKeyRef: secretRef("org", &optional)},
+					}}},
+					{StepName: "Embedding", Headers: &mcv1alpha3.HeaderPolicy{Set: []mcv1alpha3.HeaderValue{
+						{Name: "Api-Key", SecretKeyRef: secretRef("llm-api", &optional)},
+						{Name: "X-Tenant", Value: "opea"},
+					}}},
+					{StepName: "Reranking", Transport: &mcv1alpha3.Transport{TLS: &mcv1alpha3.TransportTLS{
+						CASecretRef:          secretRef("reranking-ca", &optional),
+						ClientCertSecretName: "router-client",
+					}}},
+				}},
+			},
+		},
+	}
+	deployment := &unstructured.Unstructured{Object: map[string]interface{}{
+		"kind":     Deployment,
+		"metadata": map[string]interface{}{"name": "router-service-deployment"},
+		"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{
+			"containers": []interface{}{map[string]interface{}{
+				"name":         "router-server",
+				"volumeMounts": []interface{}{map[string]interface{}{"name": "graph", "mountPath": "/etc/gmc"}},
+			}},
+			"volumes": []interface{}{map[string]interface{}{"name": "graph"}},
+		}}},
+	}}
+	if err := addRouterSecretVolumes(deployment, graph); err != nil {
+		t.Fatalf("failed to add the router secret volumes: %v", err)
+	}
+
+	volumes, _, _ := unstructured.NestedSlice(deployment.Object, "spec", "template", "spec", "volumes")
+	expectedVolumes := []interface{}{
+		map[string]interface{}{"name": "graph"},
+		map[string]interface{}{"name": "router-secret-0", "secret": map[string]interface{}{"secretName": "llm-api", "optional": false}},
+		map[string]interface{}{"name": "router-secret-1", "secret": map[string]interface{}{"secretName": "org", "optional": true}},
+		map[string]interface{}{"name": "router-secret-2", "secret": map[string]interface{}{"secretName": "reranking-ca", "optional": true}},
+		map[string]interface{}{"name": "router-secret-3", "secret": map[string]interface{}{"secretName": "router-client", "optional": false}},
+	}
+	if !reflect.DeepEqual(volumes, expectedVolumes) {
+		t.Errorf("Expected volumes: %v, but got: %v", expectedVolumes, volumes)
+	}
+	containers, _, _ := unstructured.NestedSlice(deployment.Object, "spec", "template", "spec", "containers")
+	mounts, _, _ := unstructured.NestedSlice(containers[0].(map[string]interface{}), "volumeMounts")
+	expectedMounts := []interface{}{
+		map[string]interface{}{"name": "graph", "mountPath": "/etc/gmc"},
+		map[string]interface{}{"name": "router-secret-0", "mountPath": "/etc/gmc-secrets/llm-api", "readOnly": true},
+		map[string]interface{}{"name": "router-secret-1", "mountPath": "/etc/gmc-secrets/org", "readOnly": true},
+		map[string]interface{}{"name": "router-secret-2", "mountPath": "/etc/gmc-secrets/reranking-ca", "readOnly": true},
+		map[string]interface{}{"name": "router-secret-3", "mountPath": "/etc/gmc-secrets/router-client", "readOnly": true},
+	}
+	if !reflect.DeepEqual(mounts, expectedMounts) {
+		t.Errorf("Expected volume mounts: %v, but got: %v", expectedMounts, mounts)
+	}
+}
+
+func TestManifestServiceNames(t *testing.T) {
+	// the defaulting webhook names the services of the steps like their manifests
+	for step, file := range yamlDict {
+		if step == Router {
+			continue
+		}
+		svcName, _, err := getServiceDetailsFromManifests(filepath.Join("../../config/manifests", filepath.Base(file)))
+		if err != nil {
+			t.Fatalf("failed to get the service of step %s: %v", step, err)
+		}
+		if mcv1alpha3.ManifestServiceNames[step] != svcName {
+			t.Errorf("Expected the service name %s for step %s, but got: %s", svcName, step, mcv1alpha3.ManifestServiceNames[step])
+		}
+	}
+}
@@ -0,0 +1,469 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+)
+
+const (
+	// the services serve HTTPS for the router on this port of their proxy sidecar
+	mtlsPort           = 8443
+	mtlsPortName       = "gmc-mtls"
+	mtlsProxyContainer = "gmc-tls-proxy"
+	mtlsVolume         = "gmc-tls"
+	mtlsCertDir        = "/etc/gmc-tls"
+	mtlsSecretSuffix   = "-gmc-tls"
+	caSecretSuffix     = "-gmc-ca"
+	// suffix of the NetworkPolicy closing the plain port of a secured Deployment
+	mtlsNetworkPolicySuffix = "-gmc-mtls"
+	// the CA is replaced once the certificates it signs would outlive it
+	caCertDuration = 365 * 24 * time.Hour
+	// a new CA is staged in the CA Secret next to the current one, it only signs the certificates once
+	// the services had this long to load the bundle trusting it from their mounted Secrets
+	caRolloutDelay       = 5 * time.Minute
+	caNextCertKey        = "next.crt"
+	caNextKeyKey         = "next.key"
+	caStagedAtAnnotation = "gmc.opea.io/ca-staged-at"
+)
+
+// certIssuer issues the certificates of the router and the internal services of a GMConnector
+type certIssuer struct {
+	r     *GMConnectorReconciler
+	graph *mcv1alpha3.GMConnector
+	ca    *certificateAuthority
+	// PEM bundle of the current CA and of the previous one, trusted by the router and the services
+	bundle      []byte
+	duration    time.Duration
+	renewBefore time.Duration
+	now         time.Time
+	// earliest time one of the certificates has to be issued again
+	renewAt time.Time
+	// image of the proxy sidecar of the services
+	routerImage string
+	// DNS name in the certificate of the router, the only client the services accept
+	routerIdentity string
+}
+
+// certDurations returns the lifetime of the certificates and the time before their expiration they are renewed
+func certDurations(mtls *mcv1alpha3.MTLS) (time.Duration, time.Duration) {
+	duration := mcv1alpha3.DefaultMTLSCertDuration
+	if mtls.CertDuration != nil && mtls.CertDuration.Duration >= mcv1alpha3.MinMTLSCertDuration {
+		duration = mtls.CertDuration.Duration
+	}
+	renewBefore := duration / 3
+	if mtls.RenewBefore != nil && mtls.RenewBefore.Duration > 0 && mtls.RenewBefore.Duration < duration {
+		renewBefore = mtls.RenewBefore.Duration
+	}
+	return duration, renewBefore
+}
+
+// serviceDNSNames returns the names of a Service the certificate of its pods is valid for
+func serviceDNSNames(name, namespace string) []string {
+	return []string{
+		name,
+		fmt.Sprintf("%s.%s", name, namespace),
+		fmt.Sprintf("%s.%s.svc", name, namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", name, namespace),
+	}
+}
+
+// routerServiceOf returns the name and the namespace of the router Service of the graph
+func routerServiceOf(graph *mcv1alpha3.GMConnector) (string, string) {
+	name := DefaultRouterServiceName
+	if graph.Spec.RouterConfig.ServiceName != "" {
+		name = graph.Spec.RouterConfig.ServiceName
+	}
+	namespace := graph.Namespace
+	if graph.Spec.RouterConfig.NameSpace != "" {
+		namespace = graph.Spec.RouterConfig.NameSpace
+	}
+	return name, namespace
+}
+
+// newCertIssuer loads the CA of the graph from its Secret, the CA is generated
+// when there is none yet and replaced before the certificates outlive it
+func (r *GMConnectorReconciler) newCertIssuer(ctx context.Context, graph *mcv1alpha3.GMConnector, now time.Time) (*certIssuer, error) {
+	duration, renewBefore := certDurations(graph.Spec.MTLS)
+	image, err := routerImage()
+	if err != nil {
+		return nil, err
+	}
+	routerName, routerNs := routerServiceOf(graph)
+	issuer := &certIssuer{
+		r:              r,
+		graph:          graph,
+		duration:       duration,
+		renewBefore:    renewBefore,
+		now:            now,
+		renewAt:        now.Add(caCertDuration),
+		routerImage:    image,
+		routerIdentity: fmt.Sprintf("%s.%s.svc", routerName, routerNs),
+	}
+
+	secret := &corev1.Secret{}
+	name := graph.Name + caSecretSuffix
+	err = r.Get(ctx, client.ObjectKey{Namespace: graph.Namespace, Name: name}, secret)
+	if err != nil && !apierr.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		issuer.ca, err = parseCertificateAuthority(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+		if err != nil {
+			_log.Info("Invalid certificate authority, generate a new one", "name", name, "error", err)
+		}
+	}
+	if issuer.ca != nil {
+		if next, err := parseCertificateAuthority(secret.Data[caNextCertKey], secret.Data[caNextKeyKey]); err == nil {
+			return issuer, issuer.rolloutCA(ctx, name, secret, next)
+		}
+	}
+	if issuer.ca != nil && now.Add(duration).Before(issuer.ca.cert.NotAfter) {
+		issuer.bundle = secret.Data[corev1.ServiceAccountRootCAKey]
+		issuer.updateRenewAt(issuer.ca.cert.NotAfter.Add(-duration))
+		issuer.recordSecret(name, graph.Namespace)
+		return issuer, nil
+	}
+
+	ca, err := newCertificateAuthority(fmt.Sprintf("%s.%s", graph.Name, graph.Namespace), caCertDuration, now)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to generate the certificate authority of %s", graph.Name)
+	}
+	_log.Info("Issued the certificate authority", "graph", graph.Name, "notAfter", ca.cert.NotAfter)
+	if issuer.ca != nil && now.Before(issuer.ca.cert.NotAfter) {
+		// the services keep trusting the current CA, which signs the certificates until the new one is rolled out
+		issuer.bundle = append(bytes.Clone(ca.certPEM), issuer.ca.certPEM...)
+		err = issuer.applySecret(ctx, name, graph.Namespace, corev1.SecretTypeOpaque, map[string][]byte{
+			corev1.TLSCertKey:              issuer.ca.certPEM,
+			corev1.TLSPrivateKeyKey:        issuer.ca.keyPEM,
+			caNextCertKey:                  ca.certPEM,
+			caNextKeyKey:                   ca.keyPEM,
+			corev1.ServiceAccountRootCAKey: issuer.bundle,
+		}, map[string]string{caStagedAtAnnotation: now.UTC().Format(time.RFC3339)})
+		if err != nil {
+			return nil, err
+		}
+		issuer.updateRenewAt(now.Add(caRolloutDelay))
+		return issuer, nil
+	}
+	issuer.bundle = ca.certPEM
+	issuer.ca = ca
+	err = issuer.applySecret(ctx, name, graph.Namespace, corev1.SecretTypeOpaque, map[string][]byte{
+		corev1.TLSCertKey:              ca.certPEM,
+		corev1.TLSPrivateKeyKey:        ca.keyPEM,
+		corev1.ServiceAccountRootCAKey: issuer.bundle,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	issuer.updateRenewAt(ca.cert.NotAfter.Add(-duration))
+	return issuer, nil
+}
+
+// rolloutCA keeps signing with the current CA while the bundle with the staged CA reaches the services,
+// the staged CA replaces it on a later reconcile and the certificates of the services are issued again
+func (c *certIssuer) rolloutCA(ctx context.Context, name string, secret *corev1.Secret, next *certificateAuthority) error {
+	c.bundle = secret.Data[corev1.ServiceAccountRootCAKey]
+	stagedAt, err := time.Parse(time.RFC3339, secret.Annotations[caStagedAtAnnotation])
+	if err != nil {
+		stagedAt = time.Time{}
+	}
+	if rolloutAt := stagedAt.Add(caRolloutDelay); c.now.Before(rolloutAt) && c.now.Before(c.ca.cert.NotAfter) {
+		c.updateRenewAt(rolloutAt)
+		c.recordSecret(name, secret.Namespace)
+		return nil
+	}
+
+	c.ca = next
+	_log.Info("Sign the certificates with the new certificate authority", "graph", c.graph.Name, "notAfter", next.cert.NotAfter)
+	err = c.applySecret(ctx, name, secret.Namespace, corev1.SecretTypeOpaque, map[string][]byte{
+		corev1.TLSCertKey:              next.certPEM,
+		corev1.TLSPrivateKeyKey:        next.keyPEM,
+		corev1.ServiceAccountRootCAKey: c.bundle,
+	}, nil)
+	if err != nil {
+		return err
+	}
+	c.updateRenewAt(next.cert.NotAfter.Add(-c.duration))
+	return nil
+}
+
+func (c *certIssuer) updateRenewAt(renewAt time.Time) {
+	if renewAt.Before(c.renewAt) {
+		c.renewAt = renewAt
+	}
+}
+
+// requeueAfter returns when the graph has to be reconciled again to renew the certificates
+func (c *certIssuer) requeueAfter() time.Duration {
+	return max(c.renewAt.Sub(time.Now()), time.Minute)
+}
+
+// recordSecret saves the Secret into the annotations, so it is deleted with the step or once mtls is turned off
+func (c *certIssuer) recordSecret(name, namespace string) {
+	c.graph.Status.Annotations[fmt.Sprintf("Secret:v1:%s:%s", name, namespace)] = "provisioned"
+}
+
+// applyNetworkPolicy keeps the NetworkPolicy of a secured Deployment, it is recorded like the Secrets
+func (c *certIssuer) applyNetworkPolicy(ctx context.Context, policy *networkingv1.NetworkPolicy) error {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(policy)
+	if err != nil {
+		return err
+	}
+	if err = c.r.applyResourceToK8s(c.graph, ctx, &unstructured.Unstructured{Object: content}); err != nil {
+		return errors.Wrapf(err, "Failed to apply network policy %s", policy.Name)
+	}
+	c.graph.Status.Annotations[fmt.Sprintf("NetworkPolicy:networking.k8s.io/v1:%s:%s", policy.Name, policy.Namespace)] = "provisioned"
+	return nil
+}
+
+func (c *certIssuer) applySecret(ctx context.Context, name, namespace string, secretType corev1.SecretType,
+	data map[string][]byte, annotations map[string]string) error {
+	secret := &corev1.Secret{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Annotations: annotations},
+		Type:       secretType,
+		Data:       data,
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(secret)
+	if err != nil {
+		return err
+	}
+	if err = c.r.applyResourceToK8s(c.graph, ctx, &unstructured.Unstructured{Object: content}); err != nil {
+		return errors.Wrapf(err, "Failed to apply secret %s", name)
+	}
+	c.recordSecret(name, namespace)
+	return nil
+}
+
+// issueCert keeps the certificate Secret of a Service, the certificate is issued again when it
+// expires soon, when another CA signs the certificates or when the Service got another name.
+// A new CA bundle is published with the certificate the Secret already has.
+func (c *certIssuer) issueCert(ctx context.Context, serviceName, namespace string) (string, error) {
+	name := serviceName + mtlsSecretSuffix
+	dnsNames := serviceDNSNames(serviceName, namespace)
+
+	secret := &corev1.Secret{}
+	err := c.r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, secret)
+	if err != nil && !apierr.IsNotFound(err) {
+		return "", err
+	}
+	if err == nil {
+		renewAt := certRenewalTime(secret.Data[corev1.TLSCertKey], c.ca.cert, dnsNames, c.renewBefore)
+		if renewAt.After(c.now) {
+			if !bytes.Equal(secret.Data[corev1.ServiceAccountRootCAKey], c.bundle) {
+				_log.Info("Update the CA bundle", "service", serviceName, "namespace", namespace)
+				err = c.applySecret(ctx, name, namespace, corev1.SecretTypeTLS, map[string][]byte{
+					corev1.TLSCertKey:              secret.Data[corev1.TLSCertKey],
+					corev1.TLSPrivateKeyKey:        secret.Data[corev1.TLSPrivateKeyKey],
+					corev1.ServiceAccountRootCAKey: c.bundle,
+				}, nil)
+				if err != nil {
+					return "", err
+				}
+			}
+			c.updateRenewAt(renewAt)
+			c.recordSecret(name, namespace)
+			return name, nil
+		}
+	}
+
+	certPEM, keyPEM, err := c.ca.issue(dnsNames[2], dnsNames, c.duration, c.now)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to issue the certificate of %s", serviceName)
+	}
+	_log.Info("Issued the certificate", "service", serviceName, "namespace", namespace)
+	err = c.applySecret(ctx, name, namespace, corev1.SecretTypeTLS, map[string][]byte{
+		corev1.TLSCertKey:              certPEM,
+		corev1.TLSPrivateKeyKey:        keyPEM,
+		corev1.ServiceAccountRootCAKey: c.bundle,
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	c.updateRenewAt(certRenewalTime(certPEM, c.ca.cert, dnsNames, c.renewBefore))
+	return name, nil
+}
+
+// secure lets the router reach the service over HTTPS: the Service gets the port of the proxy sidecar
+// and the Deployment the sidecar terminating the TLS connections with the certificate of the Service.
+// The service container still listens on its plain port, a NetworkPolicy only admits the connections
+// to the port of the sidecar, so the other pods cannot bypass the verification of the client certificate.
+func (c *certIssuer) secure(ctx context.Context, obj *unstructured.Unstructured, serviceName string) error {
+	switch obj.GetKind() {
+	case Service:
+		if obj.GetName() != serviceName {
+			return nil
+		}
+		service := &corev1.Service{}
+		if err := scheme.Scheme.Convert(obj, service, nil); err != nil {
+			return err
+		}
+		if _, err := c.issueCert(ctx, serviceName, obj.GetNamespace()); err != nil {
+			return err
+		}
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{
+			Name:       mtlsPortName,
+			Protocol:   corev1.ProtocolTCP,
+			Port:       mtlsPort,
+			TargetPort: intstr.FromInt32(mtlsPort),
+		})
+		return scheme.Scheme.Convert(service, obj, nil)
+	case Deployment:
+		deployment := &appsv1.Deployment{}
+		if err := scheme.Scheme.Convert(obj, deployment, nil); err != nil {
+			return err
+		}
+		if err := addMTLSProxy(deployment, serviceName+mtlsSecretSuffix, c.routerImage, c.routerIdentity); err != nil {
+			return err
+		}
+		if err := c.applyNetworkPolicy(ctx, mtlsNetworkPolicy(deployment)); err != nil {
+			return err
+		}
+		return scheme.Scheme.Convert(deployment, obj, nil)
+	}
+	return nil
+}
+
+// addMTLSProxy adds the sidecar serving the first port of the service container over HTTPS
+// to the clients with a certificate for the allowed name
+func addMTLSProxy(deployment *appsv1.Deployment, secretName, image, allowedClient string) error {
+	spec := &deployment.Spec.Template.Spec
+	if len(spec.Containers) == 0 || len(spec.Containers[0].Ports) == 0 {
+		return fmt.Errorf("deployment %s exposes no container port", deployment.Name)
+	}
+	upstream := fmt.Sprintf("http://127.0.0.1:%d", spec.Containers[0].Ports[0].ContainerPort)
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name: mtlsVolume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: secretName},
+		},
+	})
+	spec.Containers = append(spec.Containers, corev1.Container{
+		Name:            mtlsProxyContainer,
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Args: []string{
+			"--tls-proxy-listen", fmt.Sprintf(":%d", mtlsPort),
+			"--tls-proxy-upstream", upstream,
+			"--tls-proxy-client", allowedClient,
+			"--tls-dir", mtlsCertDir,
+		},
+		Ports: []corev1.ContainerPort{{Name: mtlsPortName, ContainerPort: mtlsPort, Protocol: corev1.ProtocolTCP}},
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      mtlsVolume,
+			MountPath: mtlsCertDir,
+			ReadOnly:  true,
+		}},
+	})
+	return nil
+}
+
+// mtlsNetworkPolicy returns the NetworkPolicy admitting the connections to the pods of the Deployment
+// on the port of the proxy sidecar only. The kubelet probes on the plain port are still allowed.
+func mtlsNetworkPolicy(deployment *appsv1.Deployment) *networkingv1.NetworkPolicy {
+	podSelector := metav1.LabelSelector{MatchLabels: deployment.Spec.Template.Labels}
+	if deployment.Spec.Selector != nil {
+		podSelector = *deployment.Spec.Selector.DeepCopy()
+	}
+	protocol := corev1.ProtocolTCP
+	port := intstr.FromInt32(mtlsPort)
+	return &networkingv1.NetworkPolicy{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "NetworkPolicy"},
+		ObjectMeta: metav1.ObjectMeta{Name: deployment.Name + mtlsNetworkPolicySuffix, Namespace: deployment.Namespace},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: podSelector,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{{
+				Ports: []networkingv1.NetworkPolicyPort{{Protocol: &protocol, Port: &port}},
+			}},
+		},
+	}
+}
+
+// secureRouter issues the certificate of the router, the router presents it to the services served over HTTPS
+// and verifies them with the CA bundle of the Secret. The transports set in the spec are kept.
+func (c *certIssuer) secureRouter(ctx context.Context, graph *mcv1alpha3.GMConnector, routerName, routerNs string) error {
+	secretName, err := c.issueCert(ctx, routerName, routerNs)
+	if err != nil {
+		return err
+	}
+	for _, node := range graph.Spec.Nodes {
+		for i := range node.Steps {
+			step := &node.Steps[i]
+			// the internal services are only reached over HTTPS through their proxy sidecar
+			secured := strings.HasPrefix(step.ServiceURL, "https://") ||
+				step.Fallback != nil && step.Fallback.ExternalService == "" && strings.HasPrefix(step.Fallback.ServiceURL, "https://")
+			if step.ExternalService != "" || !secured {
+				continue
+			}
+			if step.Transport == nil {
+				step.Transport = &mcv1alpha3.Transport{}
+			}
+			if step.Transport.TLS == nil {
+				step.Transport.TLS = &mcv1alpha3.TransportTLS{
+					CASecretRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: secretName},
+						Key:                  corev1.ServiceAccountRootCAKey,
+					},
+					ClientCertSecretName: secretName,
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// routerImage returns the image of the router, which also runs the proxy sidecars of the services
+func routerImage() (string, error) {
+	templateBytes, err := getTemplateBytes(Router)
+	if err != nil {
+		return "", errors.Wrapf(err, "Failed to get template bytes for %s", Router)
+	}
+	appliedCfg, err := applyRouterConfigToTemplates(Router, &map[string]string{}, templateBytes)
+	if err != nil {
+		return "", err
+	}
+	for _, res := range strings.Split(appliedCfg, "---") {
+		if !strings.Contains(res, "kind: Deployment") {
+			continue
+		}
+		decUnstructured := yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme)
+		obj := &unstructured.Unstructured{}
+		if _, _, err := decUnstructured.Decode([]byte(res), nil, obj); err != nil {
+			return "", err
+		}
+		containers, _, err := unstructured.NestedSlice(obj.Object, "spec", "template", "spec", "containers")
+		if err != nil || len(containers) == 0 {
+			return "", fmt.Errorf("the router template has no container")
+		}
+		if container, ok := containers[0].(map[string]interface{}); ok {
+			if image, ok := container["image"].(string); ok && image != "" {
+				return image, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("the router template has no image")
+}
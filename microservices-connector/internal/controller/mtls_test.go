@@ -0,0 +1,334 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	mcv1alpha3 "github.com/opea-project/GenAIInfra/microservices-connector/api/v1alpha3"
+)
+
+func TestCertDurations(t *testing.T) {
+	tests := []struct {
+		name            string
+		mtls            *mcv1alpha3.MTLS
+		wantDuration    time.Duration
+		wantRenewBefore time.Duration
+	}{
+		{name: "defaults", mtls: &mcv1alpha3.MTLS{}, wantDuration: 90 * 24 * time.Hour, wantRenewBefore: 30 * 24 * time.Hour},
+		{
+			name: "custom",
+			mtls: &mcv1alpha3.MTLS{
+				CertDuration: &metav1.Duration{Duration: 24 * time.Hour},
+				RenewBefore:  &metav1.Duration{Duration: 6 * time.Hour},
+			},
+			wantDuration:    24 * time.Hour,
+			wantRenewBefore: 6 * time.Hour,
+		},
+		{
+			name:            "renewal after the expiration",
+			mtls:            &mcv1alpha3.MTLS{CertDuration: &metav1.Duration{Duration: 3 * time.Hour}, RenewBefore: &metav1.Duration{Duration: 4 * time.Hour}},
+			wantDuration:    3 * time.Hour,
+			wantRenewBefore: time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			duration, renewBefore := certDurations(tt.mtls)
+			if duration != tt.wantDuration || renewBefore != tt.wantRenewBefore {
+				t.Errorf("certDurations() = %v, %v, want %v, %v", duration, renewBefore, tt.wantDuration, tt.wantRenewBefore)
+			}
+		})
+	}
+}
+
+func TestAddMTLSProxy(t *testing.T) {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "llm-uservice"},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "llm-uservice", Ports: []corev1.ContainerPort{{ContainerPort: 9000}}}},
+		}}},
+	}
+	if err := addMTLSProxy(deployment, "llm-uservice-gmc-tls", "opea/gmcrouter:latest", "router-service.default.svc"); err != nil {
+		t.Fatalf("addMTLSProxy() error = %v", err)
+	}
+	spec := deployment.Spec.Template.Spec
+	if len(spec.Containers) != 2 || len(spec.Volumes) != 1 {
+		t.Fatalf("Expected the proxy container and its volume, but got: %v", spec)
+	}
+	proxy := spec.Containers[1]
+	expectedArgs := []string{
+		"--tls-proxy-listen", ":8443",
+		"--tls-proxy-upstream", "http://127.0.0.1:9000",
+		"--tls-proxy-client", "router-service.default.svc",
+		"--tls-dir", "/etc/gmc-tls",
+	}
+	if proxy.Image != "opea/gmcrouter:latest" || !reflect.DeepEqual(proxy.Args, expectedArgs) {
+		t.Errorf("Expected the proxy %v with args %v, but got: %v", "opea/gmcrouter:latest", expectedArgs, proxy)
+	}
+	if spec.Volumes[0].Secret == nil || spec.Volumes[0].Secret.SecretName != "llm-uservice-gmc-tls" {
+		t.Errorf("Expected the volume of secret llm-uservice-gmc-tls, but got: %v", spec.Volumes[0])
+	}
+
+	noPort := &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+		Containers: []corev1.Container{{Name: "redis"}},
+	}}}}
+	if err := addMTLSProxy(noPort, "redis-gmc-tls", "opea/gmcrouter:latest", ""); err == nil {
+		t.Errorf("Expected an error for a deployment without container port")
+	}
+}
+
+func TestSecureRouter(t *testing.T) {
+	graph := &mcv1alpha3.GMConnector{
+		Spec: mcv1alpha3.GMConnectorSpec{
+			Nodes: map[string]mcv1alpha3.Router{
+				"root": {Steps: []mcv1alpha3.Step{
+					{StepName: "Llm", ServiceURL: "https://llm-uservice.default.svc.cluster.local:8443/v1/chat/completions"},
+					{StepName: "Embedding", ServiceURL: "https://embedding.default.svc.cluster.local:8443", Transport: &mcv1alpha3.Transport{
+						TLS: &mcv1alpha3.TransportTLS{ClientCertSecretName: "custom"},
+					}},
+					{StepName: "Retriever", ServiceURL: "http://retriever.default.svc.cluster.local:7000"},
+					{StepName: "Reranking", Executor: mcv1alpha3.Executor{ExternalService: "https://rerank.example.com"}, ServiceURL: "https://rerank.example.com"},
+				}},
+			},
+		},
+	}
+	issuer := newTestCertIssuer(t, time.Now())
+	if err := issuer.secureRouter(context.Background(), graph, "router-service", "default"); err != nil {
+		t.Fatalf("secureRouter() error = %v", err)
+	}
+	steps := graph.Spec.Nodes["root"].Steps
+	expectedTLS := &mcv1alpha3.TransportTLS{
+		CASecretRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "router-service-gmc-tls"},
+			Key:                  "ca.crt",
+		},
+		ClientCertSecretName: "router-service-gmc-tls",
+	}
+	if steps[0].Transport == nil || !reflect.DeepEqual(steps[0].Transport.TLS, expectedTLS) {
+		t.Errorf("Expected TLS: %v, but got: %v", expectedTLS, steps[0].Transport)
+	}
+	if steps[1].Transport.TLS.ClientCertSecretName != "custom" {
+		t.Errorf("Expected the TLS of the spec to be kept, but got: %v", steps[1].Transport.TLS)
+	}
+	if steps[2].Transport != nil || steps[3].Transport != nil {
+		t.Errorf("Expected no transport for the plain HTTP and the external services, but got: %v, %v", steps[2].Transport, steps[3].Transport)
+	}
+}
+
+// newTestCertIssuer returns the issuer of a graph in a fake cluster with the router template in place
+func newTestCertIssuer(t *testing.T, now time.Time) *certIssuer {
+	if err := os.MkdirAll(yaml_dir, os.ModePerm); err != nil {
+		t.Fatalf("failed to create the template dir: %v", err)
+	}
+	template, err := os.ReadFile("../../config/gmcrouter/gmc-router.yaml")
+	if err != nil {
+		t.Fatalf("failed to read the router template: %v", err)
+	}
+	if err := os.WriteFile(yamlDict[Router], template, 0o644); err != nil {
+		t.Fatalf("failed to write the router template: %v", err)
+	}
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to create the scheme: %v", err)
+	}
+	if err := mcv1alpha3.AddToScheme(scheme); err != nil {
+		t.Fatalf("failed to create the scheme: %v", err)
+	}
+	r := &GMConnectorReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).Build(), Scheme: scheme}
+	graph := &mcv1alpha3.GMConnector{
+		ObjectMeta: metav1.ObjectMeta{Name: "chatqa", Namespace: "default", UID: "chatqa-uid"},
+		Spec:       mcv1alpha3.GMConnectorSpec{MTLS: &mcv1alpha3.MTLS{}},
+		Status:     mcv1alpha3.GMConnectorStatus{Annotations: map[string]string{}},
+	}
+	issuer, err := r.newCertIssuer(context.Background(), graph, now)
+	if err != nil {
+		t.Fatalf("newCertIssuer() error = %v", err)
+	}
+	return issuer
+}
+
+func TestCertIssuer(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	issuer := newTestCertIssuer(t, now)
+	if issuer.routerImage != "opea/gmcrouter:latest" || issuer.routerIdentity != "router-service.default.svc" {
+		t.Errorf("Expected the router image and identity, but got: %v, %v", issuer.routerImage, issuer.routerIdentity)
+	}
+	getSecret := func(name string) *corev1.Secret {
+		secret := &corev1.Secret{}
+		if err := issuer.r.Get(ctx, client.ObjectKey{Namespace: "default", Name: name}, secret); err != nil {
+			t.Fatalf("failed to get secret %s: %v", name, err)
+		}
+		return secret
+	}
+
+	name, err := issuer.issueCert(ctx, "llm-uservice", "default")
+	if err != nil {
+		t.Fatalf("issueCert() error = %v", err)
+	}
+	issued := getSecret(name)
+	if err := verifyCert(issuer.bundle, issued.Data["tls.crt"]); err != nil {
+		t.Errorf("issueCert() issued an invalid certificate: %v", err)
+	}
+	if _, ok := issuer.graph.Status.Annotations["Secret:v1:llm-uservice-gmc-tls:default"]; !ok {
+		t.Errorf("Expected the secret to be recorded, but got: %v", issuer.graph.Status.Annotations)
+	}
+	if want := now.Add(60 * 24 * time.Hour); issuer.renewAt.Sub(want).Abs() > 2*time.Minute {
+		t.Errorf("Expected to renew the certificates at %v, but got: %v", want, issuer.renewAt)
+	}
+
+	// the certificate is kept while it is valid for long enough
+	issuer, err = issuer.r.newCertIssuer(ctx, issuer.graph, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("newCertIssuer() error = %v", err)
+	}
+	if _, err := issuer.issueCert(ctx, "llm-uservice", "default"); err != nil {
+		t.Fatalf("issueCert() error = %v", err)
+	}
+	if !bytes.Equal(getSecret(name).Data["tls.crt"], issued.Data["tls.crt"]) {
+		t.Errorf("Expected the certificate to be kept")
+	}
+
+	// and renewed once it expires soon
+	issuer, err = issuer.r.newCertIssuer(ctx, issuer.graph, now.Add(61*24*time.Hour))
+	if err != nil {
+		t.Fatalf("newCertIssuer() error = %v", err)
+	}
+	if _, err := issuer.issueCert(ctx, "llm-uservice", "default"); err != nil {
+		t.Fatalf("issueCert() error = %v", err)
+	}
+	renewed := getSecret(name)
+	if bytes.Equal(renewed.Data["tls.crt"], issued.Data["tls.crt"]) {
+		t.Errorf("Expected the certificate to be renewed")
+	}
+
+	// the CA is replaced before the certificates outlive it, the services trust both CAs meanwhile
+	oldCA := getSecret("chatqa-gmc-ca")
+	issuer, err = issuer.r.newCertIssuer(ctx, issuer.graph, now.Add(300*24*time.Hour))
+	if err != nil {
+		t.Fatalf("newCertIssuer() error = %v", err)
+	}
+	stagedCA := getSecret("chatqa-gmc-ca")
+	if !bytes.Equal(stagedCA.Data["tls.crt"], oldCA.Data["tls.crt"]) || len(stagedCA.Data["next.crt"]) == 0 {
+		t.Fatalf("Expected the new CA to be staged next to the current one")
+	}
+	if !bytes.Equal(stagedCA.Data["ca.crt"], append(bytes.Clone(stagedCA.Data["next.crt"]), oldCA.Data["tls.crt"]...)) {
+		t.Errorf("Expected the bundle of the new and the old CA")
+	}
+	if want := now.Add(300*24*time.Hour + caRolloutDelay); !issuer.renewAt.Equal(want) {
+		t.Errorf("Expected to roll out the new CA at %v, but got: %v", want, issuer.renewAt)
+	}
+	// the current CA signs the certificates until the services trust the new one
+	if _, err := issuer.issueCert(ctx, "llm-uservice", "default"); err != nil {
+		t.Fatalf("issueCert() error = %v", err)
+	}
+	staged := getSecret(name)
+	if err := checkSignedBy(oldCA.Data["tls.crt"], staged.Data["tls.crt"]); err != nil {
+		t.Errorf("Expected a certificate of the current CA: %v", err)
+	}
+	if !bytes.Equal(staged.Data["ca.crt"], stagedCA.Data["ca.crt"]) {
+		t.Errorf("Expected the bundle with the new CA in the certificate secret")
+	}
+
+	// the certificates are issued by the new CA on a later reconcile
+	issuer, err = issuer.r.newCertIssuer(ctx, issuer.graph, now.Add(300*24*time.Hour+caRolloutDelay))
+	if err != nil {
+		t.Fatalf("newCertIssuer() error = %v", err)
+	}
+	newCA := getSecret("chatqa-gmc-ca")
+	if !bytes.Equal(newCA.Data["tls.crt"], stagedCA.Data["next.crt"]) || len(newCA.Data["next.crt"]) != 0 {
+		t.Fatalf("Expected the staged CA to replace the current one")
+	}
+	if !bytes.Equal(newCA.Data["ca.crt"], stagedCA.Data["ca.crt"]) {
+		t.Errorf("Expected the bundle to keep the old CA")
+	}
+	if _, err := issuer.issueCert(ctx, "llm-uservice", "default"); err != nil {
+		t.Fatalf("issueCert() error = %v", err)
+	}
+	if err := checkSignedBy(newCA.Data["tls.crt"], getSecret(name).Data["tls.crt"]); err != nil {
+		t.Errorf("Expected a certificate of the new CA: %v", err)
+	}
+}
+
+func TestSecureDeployment(t *testing.T) {
+	ctx := context.Background()
+	issuer := newTestCertIssuer(t, time.Now())
+	labels := map[string]string{"app": "llm-uservice"}
+	deployment := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: Deployment},
+		ObjectMeta: metav1.ObjectMeta{Name: "llm-uservice", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "llm-uservice", Ports: []corev1.ContainerPort{{ContainerPort: 9000}}}},
+				},
+			},
+		},
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(deployment)
+	if err != nil {
+		t.Fatalf("failed to convert the deployment: %v", err)
+	}
+	if err := issuer.secure(ctx, &unstructured.Unstructured{Object: content}, "llm-uservice"); err != nil {
+		t.Fatalf("secure() error = %v", err)
+	}
+
+	// the pods only admit the connections to the proxy sidecar
+	policy := &networkingv1.NetworkPolicy{}
+	if err := issuer.r.Get(ctx, client.ObjectKey{Namespace: "default", Name: "llm-uservice-gmc-mtls"}, policy); err != nil {
+		t.Fatalf("Expected the network policy of the deployment, but got: %v", err)
+	}
+	port := intstr.FromInt32(mtlsPort)
+	if !reflect.DeepEqual(policy.Spec.PodSelector.MatchLabels, labels) || len(policy.Spec.Ingress) != 1 ||
+		len(policy.Spec.Ingress[0].Ports) != 1 || !reflect.DeepEqual(policy.Spec.Ingress[0].Ports[0].Port, &port) ||
+		len(policy.Spec.Ingress[0].From) != 0 {
+		t.Errorf("Expected a policy admitting the pods of the deployment on port %d only, but got: %v", mtlsPort, policy.Spec)
+	}
+	if _, ok := issuer.graph.Status.Annotations["NetworkPolicy:networking.k8s.io/v1:llm-uservice-gmc-mtls:default"]; !ok {
+		t.Errorf("Expected the network policy to be recorded, but got: %v", issuer.graph.Status.Annotations)
+	}
+}
+
+// checkSignedBy checks the signature only, the certificates issued in the future are not valid yet
+func checkSignedBy(caPEM, certPEM []byte) error {
+	caBlock, _ := pem.Decode(caPEM)
+	certBlock, _ := pem.Decode(certPEM)
+	if caBlock == nil || certBlock == nil {
+		return fmt.Errorf("failed to parse certificate PEM")
+	}
+	ca, err := x509.ParseCertificate(caBlock.Bytes)
+	if err != nil {
+		return err
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return err
+	}
+	return cert.CheckSignatureFrom(ca)
+}
@@ -0,0 +1,269 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+const (
+	// keys of the CA signing the webhook certificate in the Secret, next to the
+	// serving certificate and the bundle trusted by the API server in ca.crt
+	webhookSignerCertKey = "signer.crt"
+	webhookSignerKeyKey  = "signer.key"
+
+	webhookCACertDuration = 3 * 365 * 24 * time.Hour
+	webhookCertDuration   = 365 * 24 * time.Hour
+	// the serving certificate is renewed this long before it expires
+	webhookCertRenewBefore = 30 * 24 * time.Hour
+	// how often the replicas check whether the certificate has to be rotated
+	webhookCertResyncPeriod = 10 * time.Minute
+)
+
+var certificateGVR = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "certificates"}
+
+// WebhookCert serves the certificate of the validating webhook. The certificate is kept in a Secret,
+// so all the replicas of the manager serve the same one, and it is rotated before it expires.
+// When a cert-manager Certificate is given, the certificate is read from its Secret instead.
+type WebhookCert struct {
+	clientset       kubernetes.Interface
+	dynamicClient   dynamic.Interface
+	secretName      string
+	certificateName string
+	serviceName     string
+	namespace       string
+	port            int32
+	now             func() time.Time
+
+	// serializes the syncs of the watcher
+	syncMu sync.Mutex
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	bundle []byte
+}
+
+func NewWebhookCert(clientset kubernetes.Interface, dynamicClient dynamic.Interface, serviceName, namespace, secretName, certificateName string, port int32) *WebhookCert {
+	return &WebhookCert{
+		clientset:       clientset,
+		dynamicClient:   dynamicClient,
+		secretName:      secretName,
+		certificateName: certificateName,
+		serviceName:     serviceName,
+		namespace:       namespace,
+		port:            port,
+		now:             time.Now,
+	}
+}
+
+// GetCertificate returns the current serving certificate, it is meant for tls.Config.GetCertificate
+func (w *WebhookCert) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.cert == nil {
+		return nil, fmt.Errorf("the webhook certificate is not loaded yet")
+	}
+	return w.cert, nil
+}
+
+// Sync loads the certificate from the Secret, after creating or rotating it when needed,
+// and updates the CA bundle of the webhook configurations when it changed
+func (w *WebhookCert) Sync(ctx context.Context) error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
+	var secret *corev1.Secret
+	var err error
+	if w.certificateName != "" {
+		secret, err = w.certificateSecret(ctx)
+	} else {
+		secret, err = w.ensureSecret(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		return fmt.Errorf("failed to load the webhook certificate from secret %s: %v", secret.Name, err)
+	}
+	bundle := secret.Data[corev1.ServiceAccountRootCAKey]
+	if len(bundle) == 0 {
+		return fmt.Errorf("no CA bundle in the %s key of secret %s", corev1.ServiceAccountRootCAKey, secret.Name)
+	}
+
+	w.mu.RLock()
+	bundleChanged := !bytes.Equal(w.bundle, bundle)
+	w.mu.RUnlock()
+	// the API server trusts the new bundle before the new certificate is served
+	if bundleChanged {
+		if err := CreateOrUpdateValidatingWebhookConfiguration(w.clientset, bytes.NewBuffer(bundle), w.port, w.serviceName, w.namespace); err != nil {
+			return err
+		}
+		if err := CreateOrUpdateMutatingWebhookConfiguration(w.clientset, bytes.NewBuffer(bundle), w.port, w.serviceName, w.namespace); err != nil {
+			return err
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cert == nil || !bytes.Equal(w.cert.Certificate[0], cert.Certificate[0]) {
+		logw.Info("Loaded the webhook certificate", "secret", secret.Name)
+	}
+	w.cert = &cert
+	w.bundle = bundle
+	return nil
+}
+
+// certificateSecret returns the Secret cert-manager writes the certificate of the webhook into
+func (w *WebhookCert) certificateSecret(ctx context.Context) (*corev1.Secret, error) {
+	certificate, err := w.dynamicClient.Resource(certificateGVR).Namespace(w.namespace).Get(ctx, w.certificateName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the certificate %s: %v", w.certificateName, err)
+	}
+	secretName, found, err := unstructured.NestedString(certificate.Object, "spec", "secretName")
+	if err != nil || !found || secretName == "" {
+		return nil, fmt.Errorf("no secretName in the certificate %s", w.certificateName)
+	}
+	w.secretName = secretName
+	return w.clientset.CoreV1().Secrets(w.namespace).Get(ctx, secretName, metav1.GetOptions{})
+}
+
+// ensureSecret returns the Secret of the webhook certificate. The first replica creates it, and the
+// one noticing first that the certificate expires soon rotates it, the other replicas load it then.
+func (w *WebhookCert) ensureSecret(ctx context.Context) (*corev1.Secret, error) {
+	secrets := w.clientset.CoreV1().Secrets(w.namespace)
+	for {
+		secret, err := secrets.Get(ctx, w.secretName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			secret = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: w.secretName, Namespace: w.namespace},
+				Type:       corev1.SecretTypeTLS,
+			}
+			if _, err := w.rotate(secret); err != nil {
+				return nil, err
+			}
+			created, err := secrets.Create(ctx, secret, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to create the webhook certificate secret %s: %v", w.secretName, err)
+			}
+			logw.Info("Created the webhook certificate secret", "secret", w.secretName)
+			return created, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the webhook certificate secret %s: %v", w.secretName, err)
+		}
+
+		rotated, err := w.rotate(secret)
+		if err != nil || !rotated {
+			return secret, err
+		}
+		updated, err := secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		if apierrors.IsConflict(err) {
+			// another replica rotated it first
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to update the webhook certificate secret %s: %v", w.secretName, err)
+		}
+		logw.Info("Rotated the webhook certificate", "secret", w.secretName)
+		return updated, nil
+	}
+}
+
+// rotate issues the certificate into the Secret when it is missing or expires soon, the CA is
+// replaced once the certificate would outlive it and the bundle keeps the previous CA meanwhile
+func (w *WebhookCert) rotate(secret *corev1.Secret) (bool, error) {
+	dnsNames, commonName, err := composeDNSNames(w.serviceName, w.namespace)
+	if err != nil {
+		return false, err
+	}
+	now := w.now()
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+
+	ca, err := parseCertificateAuthority(secret.Data[webhookSignerCertKey], secret.Data[webhookSignerKeyKey])
+	bundle := secret.Data[corev1.ServiceAccountRootCAKey]
+	if err != nil || !now.Add(webhookCertDuration).Before(ca.cert.NotAfter) {
+		previous := ca
+		ca, err = newCertificateAuthority(commonName, webhookCACertDuration, now)
+		if err != nil {
+			return false, fmt.Errorf("failed to generate the webhook certificate authority: %v", err)
+		}
+		bundle = ca.certPEM
+		if previous != nil && now.Before(previous.cert.NotAfter) {
+			bundle = append(bytes.Clone(ca.certPEM), previous.certPEM...)
+		}
+	} else {
+		_, err := tls.X509KeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+		renewAt := certRenewalTime(secret.Data[corev1.TLSCertKey], ca.cert, dnsNames, webhookCertRenewBefore)
+		if err == nil && now.Before(renewAt) {
+			return false, nil
+		}
+	}
+
+	certPEM, keyPEM, err := ca.issue(commonName, dnsNames, webhookCertDuration, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to issue the webhook certificate: %v", err)
+	}
+	secret.Data[corev1.TLSCertKey] = certPEM
+	secret.Data[corev1.TLSPrivateKeyKey] = keyPEM
+	secret.Data[corev1.ServiceAccountRootCAKey] = bundle
+	secret.Data[webhookSignerCertKey] = ca.certPEM
+	secret.Data[webhookSignerKeyKey] = ca.keyPEM
+	return true, nil
+}
+
+// Start watches the Secret of the certificate until the context is done, so the replicas serve the
+// rotated certificate without a restart. The periodic resync rotates the certificate ahead of its expiry.
+func (w *WebhookCert) Start(ctx context.Context) error {
+	factory := informers.NewSharedInformerFactoryWithOptions(w.clientset, webhookCertResyncPeriod,
+		informers.WithNamespace(w.namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", w.secretName).String()
+		}))
+	reload := func() {
+		if err := w.Sync(ctx); err != nil {
+			logw.Error(err, "Failed to sync the webhook certificate")
+		}
+	}
+	_, err := factory.Core().V1().Secrets().Informer().AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { reload() },
+		UpdateFunc: func(interface{}, interface{}) { reload() },
+		DeleteFunc: func(interface{}) { reload() },
+	})
+	if err != nil {
+		return err
+	}
+	factory.Start(ctx.Done())
+	<-ctx.Done()
+	factory.Shutdown()
+	return nil
+}
+
+// NeedLeaderElection returns false, every replica serves the webhook
+func (w *WebhookCert) NeedLeaderElection() bool {
+	return false
+}
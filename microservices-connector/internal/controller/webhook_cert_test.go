@@ -0,0 +1,191 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func webhookCABundle(t *testing.T, client *fake.Clientset) []byte {
+	config, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), webhookConfigName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get the webhook configuration: %v", err)
+	}
+	return config.Webhooks[0].ClientConfig.CABundle
+}
+
+func servedCert(t *testing.T, w *WebhookCert) []byte {
+	cert, err := w.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate() error = %v", err)
+	}
+	return cert.Certificate[0]
+}
+
+func TestWebhookCertSync(t *testing.T) {
+	ctx := context.TODO()
+	client := fake.NewSimpleClientset()
+	now := time.Now()
+	newReplica := func() *WebhookCert {
+		w := NewWebhookCert(client, nil, "test-service", "default", "test-webhook-cert", "", 9443)
+		w.now = func() time.Time { return now }
+		return w
+	}
+
+	first := newReplica()
+	if _, err := first.GetCertificate(nil); err == nil {
+		t.Errorf("Expected no certificate before the first sync")
+	}
+	if err := first.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	secret, err := client.CoreV1().Secrets("default").Get(ctx, "test-webhook-cert", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the certificate secret, but got: %v", err)
+	}
+	bundle := secret.Data[corev1.ServiceAccountRootCAKey]
+	if !bytes.Equal(webhookCABundle(t, client), bundle) {
+		t.Errorf("Expected the CA bundle of the secret in the webhook configuration")
+	}
+	if err := verifyCert(bundle, secret.Data[corev1.TLSCertKey]); err != nil {
+		t.Errorf("Expected a certificate signed by the CA bundle: %v", err)
+	}
+
+	// the other replicas serve the same certificate
+	second := newReplica()
+	if err := second.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	served := servedCert(t, first)
+	if !bytes.Equal(served, servedCert(t, second)) {
+		t.Errorf("Expected the replicas to serve the same certificate")
+	}
+
+	// the certificate is renewed before it expires, with the same CA
+	now = now.Add(webhookCertDuration - webhookCertRenewBefore + time.Hour)
+	if err := second.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	renewed := servedCert(t, second)
+	if bytes.Equal(renewed, served) {
+		t.Errorf("Expected the certificate to be renewed")
+	}
+	if err := first.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if !bytes.Equal(servedCert(t, first), renewed) {
+		t.Errorf("Expected the replicas to load the renewed certificate")
+	}
+	if !bytes.Equal(webhookCABundle(t, client), bundle) {
+		t.Errorf("Expected the CA bundle to be kept")
+	}
+
+	// the CA is replaced before the certificate outlives it, and the API server trusts both meanwhile
+	now = now.Add(webhookCACertDuration - webhookCertDuration)
+	if err := first.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	secret, _ = client.CoreV1().Secrets("default").Get(ctx, "test-webhook-cert", metav1.GetOptions{})
+	if !bytes.Equal(secret.Data[corev1.ServiceAccountRootCAKey], append(bytes.Clone(secret.Data[webhookSignerCertKey]), bundle...)) {
+		t.Errorf("Expected the bundle of the new and the old CA")
+	}
+	if !bytes.Equal(webhookCABundle(t, client), secret.Data[corev1.ServiceAccountRootCAKey]) {
+		t.Errorf("Expected the new CA bundle in the webhook configuration")
+	}
+	mutating, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, mutatingWebhookConfigName, metav1.GetOptions{})
+	if err != nil || !bytes.Equal(mutating.Webhooks[0].ClientConfig.CABundle, secret.Data[corev1.ServiceAccountRootCAKey]) {
+		t.Errorf("Expected the new CA bundle in the mutating webhook configuration")
+	}
+}
+
+func TestWebhookCertCertManager(t *testing.T) {
+	ctx := context.TODO()
+	ca, err := newCertificateAuthority("test-ca", time.Hour, time.Now())
+	if err != nil {
+		t.Fatalf("failed to generate the CA: %v", err)
+	}
+	dnsNames, commonName, _ := composeDNSNames("test-service", "default")
+	certPEM, keyPEM, err := ca.issue(commonName, dnsNames, time.Hour, time.Now())
+	if err != nil {
+		t.Fatalf("failed to issue the certificate: %v", err)
+	}
+	client := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "issued-webhook-cert", Namespace: "default"},
+		Data: map[string][]byte{
+			corev1.TLSCertKey:              certPEM,
+			corev1.TLSPrivateKeyKey:        keyPEM,
+			corev1.ServiceAccountRootCAKey: ca.certPEM,
+		},
+	})
+	certificate := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       "Certificate",
+		"metadata":   map[string]interface{}{"name": "test-webhook", "namespace": "default"},
+		"spec":       map[string]interface{}{"secretName": "issued-webhook-cert"},
+	}}
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{certificateGVR: "CertificateList"}, certificate)
+
+	w := NewWebhookCert(client, dynamicClient, "test-service", "default", "test-webhook-cert", "test-webhook", 9443)
+	if err := w.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if !bytes.Equal(webhookCABundle(t, client), ca.certPEM) {
+		t.Errorf("Expected the CA of the certificate secret in the webhook configuration")
+	}
+	if _, err := client.CoreV1().Secrets("default").Get(ctx, "test-webhook-cert", metav1.GetOptions{}); err == nil {
+		t.Errorf("Expected no certificate issued by the manager")
+	}
+
+	w = NewWebhookCert(client, dynamicClient, "test-service", "default", "test-webhook-cert", "missing", 9443)
+	if err := w.Sync(ctx); err == nil {
+		t.Errorf("Expected an error for a missing certificate")
+	}
+}
+
+func TestWebhookCertStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	client := fake.NewSimpleClientset()
+	w := NewWebhookCert(client, nil, "test-service", "default", "test-webhook-cert", "", 9443)
+	if err := w.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	served := servedCert(t, w)
+	done := make(chan error)
+	go func() { done <- w.Start(ctx) }()
+
+	// another replica rotates the certificate
+	other := NewWebhookCert(client, nil, "test-service", "default", "test-webhook-cert", "", 9443)
+	other.now = func() time.Time { return time.Now().Add(webhookCertDuration) }
+	if err := other.Sync(ctx); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	rotated := servedCert(t, other)
+	deadline := time.Now().Add(10 * time.Second)
+	for bytes.Equal(servedCert(t, w), served) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !bytes.Equal(servedCert(t, w), rotated) {
+		t.Errorf("Expected the watcher to load the rotated certificate")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Start() error = %v", err)
+	}
+}
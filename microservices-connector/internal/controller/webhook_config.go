@@ -26,11 +26,14 @@ const (
 	apiVersion        = "v1alpha3"
 	resource          = "gmconnector"
 	webhookConfigName = "validating-webhook-configuration"
+	// the defaulting webhook is registered next to the validating one, with the same CA bundle
+	mutatingWebhookConfigName = "mutating-webhook-configuration"
 )
 
 var (
 	logw           = logf.Log.WithName("WebhookConfig")
 	validatingPath = fmt.Sprintf("/validate-%s-%s-%s", strings.Replace(apiGroup, ".", "-", 2), apiVersion, resource)
+	mutatingPath   = fmt.Sprintf("/mutate-%s-%s-%s", strings.Replace(apiGroup, ".", "-", 2), apiVersion, resource)
 )
 
 func GetEnvWithDefault(key, defaultValue string) string {
@@ -124,3 +127,74 @@ func CreateOrUpdateValidatingWebhookConfiguration(clientset kubernetes.Interface
 
 	return nil
 }
+
+func CreateOrUpdateMutatingWebhookConfiguration(clientset kubernetes.Interface, caPEM *bytes.Buffer, port int32, webhookService, webhookNamespace string) error {
+	mutatingWebhookConfigV1Client := clientset.AdmissionregistrationV1()
+
+	logw.Info("Creating or updating the mutatingwebhookconfiguration", "webhook name", mutatingWebhookConfigName)
+	sideEffect := admissionregistrationv1.SideEffectClassNone
+	fail := admissionregistrationv1.Fail
+	mutatingWHConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: mutatingWebhookConfigName,
+		},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name: fmt.Sprintf("m%s.%s", resource, apiGroup),
+			ClientConfig: admissionregistrationv1.WebhookClientConfig{
+				CABundle: caPEM.Bytes(), // self-generated CA for the webhook
+				Service: &admissionregistrationv1.ServiceReference{
+					Name:      webhookService,
+					Namespace: webhookNamespace,
+					Path:      &mutatingPath,
+					Port:      &port,
+				},
+			},
+			AdmissionReviewVersions: []string{"v1"},
+			SideEffects:             &sideEffect,
+			Rules: []admissionregistrationv1.RuleWithOperations{
+				{
+					Operations: []admissionregistrationv1.OperationType{
+						admissionregistrationv1.Create,
+						admissionregistrationv1.Update,
+					},
+					Rule: admissionregistrationv1.Rule{
+						APIGroups:   []string{apiGroup},
+						APIVersions: []string{apiVersion},
+						Resources:   []string{fmt.Sprintf("%ss", resource)},
+					},
+				},
+			},
+			FailurePolicy: &fail,
+		}},
+	}
+
+	foundWebhookConfig, err := mutatingWebhookConfigV1Client.MutatingWebhookConfigurations().Get(context.TODO(), mutatingWebhookConfigName, metav1.GetOptions{})
+	if err != nil && apierrors.IsNotFound(err) {
+		if _, err := mutatingWebhookConfigV1Client.MutatingWebhookConfigurations().Create(context.TODO(), mutatingWHConfig, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create the mutatingWebhookConfiguration(%s): %v", mutatingWebhookConfigName, err)
+		}
+		logw.Info("Created mutatingWebhookConfiguration", "webhookConfigName", mutatingWebhookConfigName)
+	} else if err != nil {
+		return fmt.Errorf("failed to check the mutatingWebhookConfiguration(%s): %v", mutatingWebhookConfigName, err)
+	} else {
+		// there is an existing mutatingWebhookConfiguration
+		if len(foundWebhookConfig.Webhooks) != len(mutatingWHConfig.Webhooks) ||
+			!(foundWebhookConfig.Webhooks[0].Name == mutatingWHConfig.Webhooks[0].Name &&
+				reflect.DeepEqual(foundWebhookConfig.Webhooks[0].AdmissionReviewVersions, mutatingWHConfig.Webhooks[0].AdmissionReviewVersions) &&
+				reflect.DeepEqual(foundWebhookConfig.Webhooks[0].SideEffects, mutatingWHConfig.Webhooks[0].SideEffects) &&
+				reflect.DeepEqual(foundWebhookConfig.Webhooks[0].FailurePolicy, mutatingWHConfig.Webhooks[0].FailurePolicy) &&
+				reflect.DeepEqual(foundWebhookConfig.Webhooks[0].Rules, mutatingWHConfig.Webhooks[0].Rules) &&
+				reflect.DeepEqual(foundWebhookConfig.Webhooks[0].ClientConfig.CABundle, mutatingWHConfig.Webhooks[0].ClientConfig.CABundle) &&
+				reflect.DeepEqual(foundWebhookConfig.Webhooks[0].ClientConfig.Service, mutatingWHConfig.Webhooks[0].ClientConfig.Service)) {
+			mutatingWHConfig.ObjectMeta.ResourceVersion = foundWebhookConfig.ObjectMeta.ResourceVersion
+			if _, err := mutatingWebhookConfigV1Client.MutatingWebhookConfigurations().Update(context.TODO(), mutatingWHConfig, metav1.UpdateOptions{}); err != nil {
+				return fmt.Errorf("failed to update the mutatingWebhookConfiguration(%s): %v", mutatingWebhookConfigName, err)
+			}
+			logw.Info("Updated the mutatingWebhookConfiguration", "webhookConfigName", mutatingWebhookConfigName)
+		} else {
+			logw.Info("The mutatingWebhookConfiguration already exists and has no change", "webhookConfigName", mutatingWebhookConfigName)
+		}
+	}
+
+	return nil
+}
@@ -72,3 +72,29 @@ func TestCreateOrUpdateValidatingWebhookConfiguration(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateOrUpdateMutatingWebhookConfiguration(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	for _, caPEM := range []string{"test", "rotated"} {
+		if err := CreateOrUpdateMutatingWebhookConfiguration(
+			client,
+			bytes.NewBufferString(caPEM),
+			9443,
+			"test-service",
+			"default"); err != nil {
+			t.Errorf("CreateOrUpdateMutatingWebhookConfiguration() error = %v", err)
+		}
+		config, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(),
+			mutatingWebhookConfigName,
+			metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("Webhook %s is not found", mutatingWebhookConfigName)
+		}
+		if string(config.Webhooks[0].ClientConfig.CABundle) != caPEM {
+			t.Errorf("Expected the CA bundle %s, but got: %s", caPEM, config.Webhooks[0].ClientConfig.CABundle)
+		}
+		if *config.Webhooks[0].ClientConfig.Service.Path != "/mutate-gmc-opea-io-v1alpha3-gmconnector" {
+			t.Errorf("Expected the path of the defaulting webhook, but got: %s", *config.Webhooks[0].ClientConfig.Service.Path)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+// Package payload builds the request of a step from the template in its Data.
+//
+// A template is a JSON document whose string values can refer to the request received by
+// the router with $request, to the response of the previous step with $response and to the
+// response of an earlier step with $steps.<name>.response. The part of a reference after the
+// root is a gjson path:
+//
+//	{
+//	  "model": "llama",
+//	  "messages": "$request.messages",
+//	  "context": "$steps.Retriever.response.retrieved_docs.#.text",
+//	  "prompt": "Answer with the context: ${$response.text}"
+//	}
+//
+// A string which is a single reference is replaced by the referenced JSON value, or null if
+// it does not exist. References inside a longer string are written as ${...} and replaced by
+// their text. A string starting with $$ is kept as it is, without the first $.
+// Data can also be a single reference, then the referenced value is the whole request.
+package payload
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	RequestRef  = "$request"
+	ResponseRef = "$response"
+	StepsRef    = "$steps"
+)
+
+// Env holds the values a template refers to
+type Env struct {
+	// Request is the body of the request received by the router
+	Request []byte
+	// Response is the body of the response of the previous step
+	Response []byte
+	// Steps are the response bodies of the steps executed so far, by step name
+	Steps map[string][]byte
+}
+
+// Template is a parsed Data template, safe for concurrent use
+type Template struct {
+	root  part
+	steps []string
+}
+
+// Parse parses a Data template, either a JSON document or a single reference
+func Parse(data string) (*Template, error) {
+	t := &Template{}
+	trimmed := strings.TrimSpace(data)
+	if strings.HasPrefix(trimmed, "$") {
+		ref, err := t.parseRef(trimmed)
+		if err != nil {
+			return nil, err
+		}
+		t.root = &refPart{ref: ref}
+		return t, nil
+	}
+	if !gjson.Valid(trimmed) {
+		return nil, fmt.Errorf("the template is neither a reference nor valid JSON")
+	}
+	root, err := t.parse(gjson.Parse(trimmed))
+	if err != nil {
+		return nil, err
+	}
+	t.root = root
+	return t, nil
+}
+
+// StepNames returns the names of the steps the template refers to
+func (t *Template) StepNames() []string {
+	return t.steps
+}
+
+// Render builds the JSON payload
+func (t *Template) Render(env Env) []byte {
+	var buf bytes.Buffer
+	t.root.render(&buf, &env)
+	return buf.Bytes()
+}
+
+type reference struct {
+	// the body the reference points to: $request, $response or $steps
+	root string
+	step string
+	path string
+}
+
+func (r reference) resolve(env *Env) gjson.Result {
+	var body []byte
+	switch r.root {
+	case RequestRef:
+		body = env.Request
+	case ResponseRef:
+		body = env.Response
+	default:
+		body = env.Steps[r.step]
+	}
+	if r.path == "" {
+		if !gjson.ValidBytes(body) {
+			return gjson.Result{}
+		}
+		return gjson.ParseBytes(body)
+	}
+	return gjson.GetBytes(body, r.path)
+}
+
+func (t *Template) parseRef(s string) (reference, error) {
+	root, rest, _ := strings.Cut(s, ".")
+	switch root {
+	case RequestRef, ResponseRef:
+		return reference{root: root, path: rest}, nil
+	case StepsRef:
+		step, rest, _ := strings.Cut(rest, ".")
+		field, path, _ := strings.Cut(rest, ".")
+		if step == "" || field != "response" {
+			return reference{}, fmt.Errorf("invalid reference %s, expected %s.<name>.response", s, StepsRef)
+		}
+		t.steps = append(t.steps, step)
+		return reference{root: root, step: step, path: path}, nil
+	default:
+		return reference{}, fmt.Errorf("unknown reference %s, expected %s, %s or %s.<name>.response",
+			s, RequestRef, ResponseRef, StepsRef)
+	}
+}
+
+func (t *Template) parse(r gjson.Result) (part, error) {
+	switch {
+	case r.IsObject():
+		obj := &objectPart{}
+		var err error
+		r.ForEach(func(key, value gjson.Result) bool {
+			var p part
+			if p, err = t.parse(value); err != nil {
+				return false
+			}
+			obj.keys = append(obj.keys, key.Raw)
+			obj.values = append(obj.values, p)
+			return true
+		})
+		return obj, err
+	case r.IsArray():
+		arr := &arrayPart{}
+		for _, item := range r.Array() {
+			p, err := t.parse(item)
+			if err != nil {
+				return nil, err
+			}
+			arr.items = append(arr.items, p)
+		}
+		return arr, nil
+	case r.Type == gjson.String:
+		return t.parseString(r.Str)
+	default:
+		return &rawPart{raw: r.Raw}, nil
+	}
+}
+
+func (t *Template) parseString(s string) (part, error) {
+	if strings.HasPrefix(s, "$$") {
+		return literalString(s[1:]), nil
+	}
+	if strings.HasPrefix(s, "$") && !strings.HasPrefix(s, "${") {
+		ref, err := t.parseRef(s)
+		if err != nil {
+			return nil, err
+		}
+		return &refPart{ref: ref}, nil
+	}
+	if !strings.Contains(s, "${") {
+		return literalString(s), nil
+	}
+
+	interp := &interpolationPart{}
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			interp.texts = append(interp.texts, s)
+			return interp, nil
+		}
+		// the gjson path may contain braces, find the matching one
+		depth, end := 0, -1
+		for i := start + 2; i < len(s) && end < 0; i++ {
+			switch s[i] {
+			case '{':
+				depth++
+			case '}':
+				if depth == 0 {
+					end = i
+				}
+				depth--
+			}
+		}
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated ${ in %q", s)
+		}
+		ref, err := t.parseRef(s[start+2 : end])
+		if err != nil {
+			return nil, err
+		}
+		interp.texts = append(interp.texts, s[:start])
+		interp.refs = append(interp.refs, ref)
+		s = s[end+1:]
+	}
+}
+
+func literalString(s string) part {
+	raw, _ := json.Marshal(s)
+	return &rawPart{raw: string(raw)}
+}
+
+// part is a compiled part of a template
+type part interface {
+	render(buf *bytes.Buffer, env *Env)
+}
+
+type rawPart struct {
+	raw string
+}
+
+func (p *rawPart) render(buf *bytes.Buffer, _ *Env) {
+	buf.WriteString(p.raw)
+}
+
+type refPart struct {
+	ref reference
+}
+
+func (p *refPart) render(buf *bytes.Buffer, env *Env) {
+	r := p.ref.resolve(env)
+	if !r.Exists() {
+		buf.WriteString("null")
+		return
+	}
+	buf.WriteString(r.Raw)
+}
+
+// interpolationPart is a string with references, texts has one element more than refs
+type interpolationPart struct {
+	texts []string
+	refs  []reference
+}
+
+func (p *interpolationPart) render(buf *bytes.Buffer, env *Env) {
+	var sb strings.Builder
+	for i, text := range p.texts {
+		sb.WriteString(text)
+		if i < len(p.refs) {
+			sb.WriteString(p.refs[i].resolve(env).String())
+		}
+	}
+	raw, _ := json.Marshal(sb.String())
+	buf.Write(raw)
+}
+
+type objectPart struct {
+	// the raw JSON of the keys
+	keys   []string
+	values []part
+}
+
+func (p *objectPart) render(buf *bytes.Buffer, env *Env) {
+	buf.WriteByte('{')
+	for i, key := range p.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(key)
+		buf.WriteByte(':')
+		p.values[i].render(buf, env)
+	}
+	buf.WriteByte('}')
+}
+
+type arrayPart struct {
+	items []part
+}
+
+func (p *arrayPart) render(buf *bytes.Buffer, env *Env) {
+	buf.WriteByte('[')
+	for i, item := range p.items {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		item.render(buf, env)
+	}
+	buf.WriteByte(']')
+}
@@ -0,0 +1,124 @@
+/*
+* Copyright (C) 2024 Intel Corporation
+* SPDX-License-Identifier: Apache-2.0
+ */
+
+package payload
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, data := range []string{
+		`$body.text`,
+		`$steps.Retriever`,
+		`$steps.Retriever.request`,
+		`{"text": "$unknown.text"}`,
+		`{"text": "prefix ${$request.text"}`,
+		`{"text": "prefix ${$body.text}"}`,
+		`{"text": `,
+		`plain text`,
+	} {
+		if _, err := Parse(data); err == nil {
+			t.Errorf("Expected an error for %s", data)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	env := Env{
+		Request:  []byte(`{"messages":[{"role":"user","content":"What is OPEA?"}],"max_tokens":128,"stream":true}`),
+		Response: []byte(`{"reranked_docs":[{"text":"OPEA is an open platform"},{"text":"for enterprise AI"}]}`),
+		Steps: map[string][]byte{
+			"Embedding": []byte(`{"embedding":[0.1,0.2]}`),
+		},
+	}
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "single reference",
+			data: `$request.messages`,
+			want: `[{"role":"user","content":"What is OPEA?"}]`,
+		},
+		{
+			name: "whole response",
+			data: `$response`,
+			want: `{"reranked_docs":[{"text":"OPEA is an open platform"},{"text":"for enterprise AI"}]}`,
+		},
+		{
+			name: "object template",
+			data: `{
+				"query": "$request.messages.0.content",
+				"embedding": "$steps.Embedding.response.embedding",
+				"docs": "$response.reranked_docs.#.text",
+				"max_tokens": "$request.max_tokens",
+				"streaming": "$request.stream",
+				"temperature": 0.1,
+				"model": "llama",
+				"missing": "$request.missing",
+				"price": "$$5"
+			}`,
+			want: `{
+				"query": "What is OPEA?",
+				"embedding": [0.1, 0.2],
+				"docs": ["OPEA is an open platform", "for enterprise AI"],
+				"max_tokens": 128,
+				"streaming": true,
+				"temperature": 0.1,
+				"model": "llama",
+				"missing": null,
+				"price": "$5"
+			}`,
+		},
+		{
+			name: "interpolation",
+			data: `{"prompt": "Question: ${$request.messages.0.content} Context: ${$response.reranked_docs.0.text}"}`,
+			want: `{"prompt": "Question: What is OPEA? Context: OPEA is an open platform"}`,
+		},
+		{
+			name: "gjson query in interpolation",
+			data: `{"prompt": "${$request.messages.#(role==\"user\").content}"}`,
+			want: `{"prompt": "What is OPEA?"}`,
+		},
+		{
+			name: "array template",
+			data: `["$request.max_tokens", "$steps.Unknown.response"]`,
+			want: `[128, null]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse(tt.data)
+			if err != nil {
+				t.Fatalf("Failed to parse the template: %v", err)
+			}
+			got := tmpl.Render(env)
+			var gotValue, wantValue interface{}
+			if err := json.Unmarshal(got, &gotValue); err != nil {
+				t.Fatalf("The rendered payload is invalid JSON: %s", got)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &wantValue); err != nil {
+				t.Fatalf("Invalid expected JSON: %v", err)
+			}
+			if !reflect.DeepEqual(gotValue, wantValue) {
+				t.Errorf("Expected %s, but got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestStepNames(t *testing.T) {
+	tmpl, err := Parse(`{"a": "$steps.Embedding.response", "b": "${$steps.Retriever.response.docs}"}`)
+	if err != nil {
+		t.Fatalf("Failed to parse the template: %v", err)
+	}
+	if names := tmpl.StepNames(); !reflect.DeepEqual(names, []string{"Embedding", "Retriever"}) {
+		t.Errorf("Unexpected step names: %v", names)
+	}
+}